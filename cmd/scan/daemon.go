@@ -0,0 +1,247 @@
+package scan
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"slices"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"scanexpress/pkg/config"
+	"scanexpress/pkg/delivery"
+	"scanexpress/pkg/scanner"
+	"scanexpress/pkg/session"
+)
+
+// queueInterval is how often the daemon retries the queued deliveries
+const queueInterval = time.Minute
+
+// newDaemonCommand creates the command scanning when a button of the
+// scanner is pressed
+func newDaemonCommand(cm *config.ConfigManager) *cobra.Command {
+	settings := cm.GetDaemonConfig()
+	var device string
+	var maxPages int
+
+	cmd := &cobra.Command{
+		Use:   "daemon",
+		Short: "Scan when a button of the scanner is pressed",
+		Long: "Watches the buttons of the saved scanner, exposed as sensor options, and runs a\n" +
+			"profile without the terminal UI when one is pressed: the sheets in the document\n" +
+			"feeder are scanned, the PDF is generated, the hooks are run and the document is\n" +
+			"delivered.",
+		Args:         cobra.NoArgs,
+		SilenceUsage: true,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			saved := cm.GetConfig()
+			if device == "" {
+				device = saved.ScannerDevice
+			}
+			if device == "" {
+				return fmt.Errorf("no scanner is saved, select one with scan --select or pass --device")
+			}
+			saveFolder := saved.SaveFolder
+			if saveFolder == "" {
+				home, err := os.UserHomeDir()
+				if err != nil {
+					return err
+				}
+				saveFolder = home
+			}
+
+			profile, err := cm.GetProfile(settings.Profile)
+			if err != nil {
+				return err
+			}
+			if err := session.Check(profile); err != nil {
+				return err
+			}
+			if err := checkDependencies(scanner.IsNetworkDevice(device)); err != nil {
+				return err
+			}
+			if settings.Interval <= 0 {
+				return fmt.Errorf("the polling interval must be positive")
+			}
+
+			sane := cm.GetSANEConfig()
+			d := &daemon{
+				cm:       cm,
+				queue:    delivery.DefaultQueue(),
+				settings: settings,
+				options: session.Options{
+					Device: device,
+					Network: scanner.Network{
+						Hosts:    sane.Hosts,
+						Username: sane.Username,
+						Password: sane.Password,
+						ESCL:     cm.GetESCLScanners(),
+					},
+					SaveFolder: saveFolder,
+					Duplex:     settings.Duplex,
+					MaxPages:   maxPages,
+					AutoRotate: saved.AutoRotate,
+					Profile:    profile,
+					Recipients: settings.Recipients,
+				},
+			}
+
+			ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+			defer stop()
+			return d.run(ctx)
+		},
+	}
+
+	cmd.Flags().StringVarP(&settings.Profile, "profile", "p", settings.Profile, "Scan with the named profile (daemon.profile)")
+	cmd.Flags().StringVarP(&settings.Button, "button", "b", settings.Button, "Sensor option of the button to watch, any button when empty (daemon.button)")
+	cmd.Flags().Float64Var(&settings.Interval, "interval", settings.Interval, "Seconds between two readings of the buttons (daemon.interval)")
+	cmd.Flags().BoolVar(&settings.Duplex, "duplex", settings.Duplex, "Scan both sides of the sheets (daemon.duplex)")
+	cmd.Flags().StringSliceVar(&settings.Recipients, "email-to", settings.Recipients, "Email the documents to these addresses (daemon.recipients)")
+	cmd.Flags().StringVarP(&device, "device", "d", "", "Scanner to watch instead of the saved one")
+	cmd.Flags().IntVar(&maxPages, "max-pages", 0, "Sheets to scan at most, 0 to empty the feeder")
+	return cmd
+}
+
+// daemon watches the buttons of a scanner
+type daemon struct {
+	cm       *config.ConfigManager
+	queue    *delivery.Queue
+	settings config.DaemonConfig
+	options  session.Options
+}
+
+// run polls the buttons until the context is done, scanning on each press.
+// Read errors are logged and polling goes on, as the scanner may be asleep
+// or unplugged for a while.
+func (d *daemon) run(ctx context.Context) error {
+	sensors, err := d.options.Network.Sensors(d.options.Device)
+	if err != nil {
+		return err
+	}
+	if len(sensors) == 0 {
+		return fmt.Errorf("the scanner exposes no buttons")
+	}
+	if d.settings.Button != "" {
+		if _, ok := sensors[d.settings.Button]; !ok {
+			return fmt.Errorf("the scanner has no button %q (available: %s)", d.settings.Button, strings.Join(sortedKeys(sensors), ", "))
+		}
+	}
+	log.Printf("Watching %s (buttons: %s), press Ctrl+C to stop", d.options.Device, strings.Join(sortedKeys(sensors), ", "))
+
+	poll := time.NewTicker(time.Duration(d.settings.Interval * float64(time.Second)))
+	defer poll.Stop()
+	retry := time.NewTicker(queueInterval)
+	defer retry.Stop()
+
+	pressed := d.pressed(sensors)
+	var readErr string
+	for {
+		select {
+		case <-ctx.Done():
+			log.Printf("Stopped")
+			return nil
+
+		case <-retry.C:
+			deliverQueued(ctx, d.cm, d.queue)
+
+		case <-poll.C:
+			sensors, err := d.options.Network.Sensors(d.options.Device)
+			if err != nil {
+				// Only log when the error changes, not on every poll
+				if err.Error() != readErr {
+					log.Printf("Reading the buttons failed: %v", err)
+					readErr = err.Error()
+				}
+				continue
+			}
+			if readErr != "" {
+				log.Printf("The scanner is back")
+				readErr = ""
+			}
+
+			// Scan when a button goes down, not while it is held
+			button := d.pressed(sensors)
+			if button != "" && pressed == "" {
+				d.scan(ctx, button)
+			}
+			pressed = button
+		}
+	}
+}
+
+// pressed returns the button watched that is pressed, if any
+func (d *daemon) pressed(sensors map[string]bool) string {
+	for _, name := range sortedKeys(sensors) {
+		if sensors[name] && (d.settings.Button == "" || name == d.settings.Button) {
+			return name
+		}
+	}
+	return ""
+}
+
+// scan runs the profile and logs the outcome
+func (d *daemon) scan(ctx context.Context, button string) {
+	profile := d.options.Profile.Name
+	if profile == "" {
+		profile = "default"
+	}
+	log.Printf("Button %q pressed, scanning with the %s profile", button, profile)
+
+	result, err := session.Run(ctx, d.cm, d.queue, d.options, func(p session.Progress) {
+		log.Print(p.Message)
+	})
+	for _, page := range result.OrientationErrors {
+		log.Printf("Orientation of %s not corrected: %v", page.PageLabel(), page.Error)
+	}
+	for _, hook := range result.HookResults {
+		if hook.Error != nil {
+			log.Printf("Hook %s failed: %s: %v", hook.Label(), hook.Command, hook.Error)
+		} else if hook.ExitCode != 0 {
+			log.Printf("Hook %s failed: %s: exit %d", hook.Label(), hook.Command, hook.ExitCode)
+		}
+	}
+	if err != nil {
+		log.Printf("Scan failed: %v", err)
+		return
+	}
+
+	log.Printf("Created %s (%d pages)", result.PDF, result.PageCount)
+	if d.options.Profile.Output.SplitPhotos {
+		log.Printf("Saved %d photos split from the pages", len(result.Photos))
+	}
+	for _, r := range result.Deliveries {
+		if r.Error != nil {
+			log.Printf("%s failed: %v (see scan queue)", r.Target, r.Error)
+		} else {
+			log.Printf("%s: %s", r.Target, r.Message)
+		}
+	}
+}
+
+// deliverQueued retries the queued deliveries that are due, for the
+// commands running in the background
+func deliverQueued(ctx context.Context, cm *config.ConfigManager, queue *delivery.Queue) {
+	results := queue.Process(ctx, nil, func(job delivery.Job) (delivery.Target, error) {
+		return delivery.ResolveTarget(cm, job)
+	})
+	for _, r := range results {
+		if r.Error == nil {
+			log.Printf("%s: %s", r.Target, r.Message)
+		}
+	}
+}
+
+// sortedKeys returns the names of the sensors in order
+func sortedKeys(sensors map[string]bool) []string {
+	names := make([]string, 0, len(sensors))
+	for name := range sensors {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	return names
+}
@@ -0,0 +1,136 @@
+package scan
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"text/tabwriter"
+
+	"github.com/spf13/cobra"
+
+	"scanexpress/pkg/config"
+	"scanexpress/pkg/delivery"
+)
+
+// newQueueCommand creates the command inspecting and retrying queued deliveries
+func newQueueCommand(cm *config.ConfigManager) *cobra.Command {
+	queue := delivery.DefaultQueue()
+
+	cmd := &cobra.Command{
+		Use:   "queue",
+		Short: "List queued deliveries",
+		Long: fmt.Sprintf(
+			"Deliveries that could not be sent are kept in %s and retried with backoff.",
+			queue.Dir,
+		),
+		Args:         cobra.NoArgs,
+		SilenceUsage: true,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return listQueue(queue)
+		},
+	}
+
+	cmd.AddCommand(&cobra.Command{
+		Use:          "list",
+		Short:        "List queued deliveries",
+		Args:         cobra.NoArgs,
+		SilenceUsage: true,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return listQueue(queue)
+		},
+	})
+
+	cmd.AddCommand(&cobra.Command{
+		Use:          "retry [id]...",
+		Short:        "Send queued deliveries now, all of them when no IDs are given",
+		SilenceUsage: true,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			jobs, err := queue.List()
+			if len(args) > 0 {
+				jobs, err = queue.Find(args)
+			}
+			if err != nil {
+				return err
+			}
+			if len(jobs) == 0 {
+				fmt.Println("The delivery queue is empty.")
+				return nil
+			}
+
+			for i, job := range jobs {
+				if jobs[i], err = queue.Reset(job); err != nil {
+					return err
+				}
+			}
+
+			resolve := func(job delivery.Job) (delivery.Target, error) {
+				return delivery.ResolveTarget(cm, job)
+			}
+			failed := 0
+			for _, r := range queue.Process(context.Background(), jobs, resolve) {
+				mark := "✓"
+				if !r.Success() {
+					mark = "✗"
+					failed++
+				}
+				fmt.Printf("%s %s %s\n", mark, r.JobID, r)
+			}
+			if failed > 0 {
+				return fmt.Errorf("%d deliveries failed and stay queued", failed)
+			}
+			return nil
+		},
+	})
+
+	var all bool
+	purge := &cobra.Command{
+		Use:          "purge [id]...",
+		Short:        "Remove deliveries from the queue without sending them",
+		SilenceUsage: true,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if len(args) == 0 && !all {
+				return fmt.Errorf("name the deliveries to remove, or use --all")
+			}
+
+			jobs, err := queue.List()
+			if !all {
+				jobs, err = queue.Find(args)
+			}
+			if err != nil {
+				return err
+			}
+			for _, job := range jobs {
+				if err := queue.Remove(job.ID); err != nil {
+					return err
+				}
+				fmt.Printf("Removed %s (%s to %s)\n", job.ID, filepath.Base(job.Document.Path), job.Target)
+			}
+			return nil
+		},
+	}
+	purge.Flags().BoolVar(&all, "all", false, "Remove every queued delivery")
+	cmd.AddCommand(purge)
+
+	return cmd
+}
+
+// listQueue prints the queued deliveries
+func listQueue(queue *delivery.Queue) error {
+	jobs, err := queue.List()
+	if err != nil {
+		return err
+	}
+	if len(jobs) == 0 {
+		fmt.Println("The delivery queue is empty.")
+		return nil
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "ID\tTARGET\tDOCUMENT\tATTEMPTS\tSTATUS\tLAST ERROR")
+	for _, job := range jobs {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%d\t%s\t%s\n",
+			job.ID, job.Target, filepath.Base(job.Document.Path), job.Attempts, job.Status(), job.LastError)
+	}
+	return w.Flush()
+}
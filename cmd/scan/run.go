@@ -9,12 +9,17 @@ import (
 	"github.com/spf13/cobra"
 
 	"scanexpress/pkg/config"
+	"scanexpress/pkg/delivery"
 	"scanexpress/pkg/ui"
 )
 
 // checkDependencies verifies that all required external programs are available on PATH
-func checkDependencies() error {
-	requiredPrograms := []string{"scanimage", "img2pdf"}
+// scanimage is optional when scanners are reached over the network through saned or eSCL
+func checkDependencies(network bool) error {
+	requiredPrograms := []string{"img2pdf"}
+	if !network {
+		requiredPrograms = append(requiredPrograms, "scanimage")
+	}
 	missingPrograms := []string{}
 
 	for _, program := range requiredPrograms {
@@ -48,17 +53,75 @@ func Run() {
 	// Add flags
 	var forceSelection bool
 	rootCmd.Flags().BoolVarP(&forceSelection, "select", "s", false, "Force scanner selection even if one is already configured")
+	var profileName string
+	rootCmd.Flags().StringVarP(&profileName, "profile", "p", "", "Use the named profile from the configuration file")
+	var pdfa bool
+	rootCmd.Flags().BoolVar(&pdfa, "pdfa", false, "Write PDF/A-2b archival documents (overrides output.pdfa)")
 
 	// Run command
 	rootCmd.RunE = func(cmd *cobra.Command, args []string) error {
 		// Check for required dependencies first
-		if err := checkDependencies(); err != nil {
+		network := len(cm.GetSANEConfig().Hosts) > 0 || len(cm.GetESCLScanners()) > 0
+		if err := checkDependencies(network); err != nil {
 			fmt.Println(err)
 			return err
 		}
 
 		// Create and initialize the UI model
 		model := ui.NewModel(cm)
+		if cmd.Flags().Changed("profile") {
+			profile, err := cm.GetProfile(profileName)
+			if err != nil {
+				fmt.Println(err)
+				return err
+			}
+			model.ApplyProfile(profile)
+		}
+		if cmd.Flags().Changed("pdfa") {
+			model.PDFOptions.PDFA = pdfa
+		}
+		if model.PDFOptions.PDFA && model.PDFOptions.Encryption.Enabled {
+			err := fmt.Errorf("PDF/A documents cannot be encrypted, disable either output.pdfa or encryption.enabled")
+			fmt.Println(err)
+			return err
+		}
+		if model.PDFOptions.Signing.Enabled && model.PDFOptions.Signing.Certificate == "" {
+			err := fmt.Errorf("signing is enabled but no certificate is configured, set signing.certificate")
+			fmt.Println(err)
+			return err
+		}
+		for _, target := range model.Deliveries {
+			var err error
+			switch t := target.(type) {
+			case *delivery.Paperless:
+				if t.URL == "" || t.Token == "" {
+					err = fmt.Errorf("Paperless-ngx upload is enabled but paperless.url or the API token is missing")
+				}
+			case *delivery.WebDAV:
+				if t.URL == "" {
+					err = fmt.Errorf("WebDAV upload is enabled but webdav.url is missing")
+				} else if _, pathErr := delivery.ExpandPathTemplate(t.PathTemplate, delivery.Document{}); pathErr != nil {
+					err = pathErr
+				}
+			}
+			if err != nil {
+				fmt.Println(err)
+				return err
+			}
+		}
+		if email := model.Email; email != nil {
+			var err error
+			switch {
+			case email.Host == "" || email.From == "":
+				err = fmt.Errorf("email is enabled but email.host or email.from is missing")
+			case email.Security != delivery.SecurityStartTLS && email.Security != delivery.SecurityTLS && email.Security != delivery.SecurityNone:
+				err = fmt.Errorf("invalid email.security %q (valid: starttls, tls, none)", email.Security)
+			}
+			if err != nil {
+				fmt.Println(err)
+				return err
+			}
+		}
 
 		// If we have a saved config and not forcing selection, set initial state to page count
 		if !forceSelection && cm.HasValidSavedConfig() {
@@ -69,22 +132,34 @@ func Run() {
 			model.SelectedTitle = config.ScannerTitle
 			model.SaveFolder = config.SaveFolder
 
-			// Skip directly to page count state
+			// Skip directly to page count state, Esc still goes back to
+			// the save folder and scanner selection
 			model.State = ui.StateEnteringPageCount
+			model.History = []int{ui.StateSelectingScanner, ui.StateEnteringSaveFolder}
+			model.Discovering = false
 
 			fmt.Printf("Using saved scanner: %s\nSave folder: %s\n", config.ScannerTitle, config.SaveFolder)
 		}
 
 		// Start the UI program
 		p := tea.NewProgram(model)
-		if _, err := p.Run(); err != nil {
+		final, err := p.Run()
+		if err != nil {
 			fmt.Printf("Error: %v\n", err)
 			return err
 		}
+		if m, ok := final.(ui.Model); ok {
+			fmt.Print(m.SessionSummary())
+		}
 
 		return nil
 	}
 
+	rootCmd.AddCommand(newVerifyCommand())
+	rootCmd.AddCommand(newQueueCommand(cm))
+	rootCmd.AddCommand(newDaemonCommand(cm))
+	rootCmd.AddCommand(newServeCommand(cm))
+
 	if err := rootCmd.Execute(); err != nil {
 		os.Exit(1)
 	}
@@ -0,0 +1,98 @@
+package scan
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"scanexpress/pkg/config"
+	"scanexpress/pkg/delivery"
+	"scanexpress/pkg/server"
+)
+
+// newServeCommand creates the command serving the HTTP API
+func newServeCommand(cm *config.ConfigManager) *cobra.Command {
+	settings := cm.GetServerConfig()
+
+	cmd := &cobra.Command{
+		Use:   "serve",
+		Short: "Serve a REST API and web interface to scan from other machines",
+		Long: "Serves an HTTP API listing the scanners and their options and running scan jobs\n" +
+			"with a profile. Jobs on one scanner run one after the other. A web interface to\n" +
+			"scan from a browser is served at the root.",
+		Args:         cobra.NoArgs,
+		SilenceUsage: true,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			network := len(cm.GetSANEConfig().Hosts) > 0 || len(cm.GetESCLScanners()) > 0
+			if err := checkDependencies(network); err != nil {
+				return err
+			}
+
+			queue := delivery.DefaultQueue()
+			srv, err := server.New(cm, queue)
+			if err != nil {
+				return err
+			}
+			httpServer := &http.Server{
+				Addr:              settings.Listen,
+				Handler:           srv.Handler(),
+				ReadHeaderTimeout: 10 * time.Second,
+			}
+
+			listener, err := net.Listen("tcp", settings.Listen)
+			if err != nil {
+				return err
+			}
+			if settings.Token == "" && !isLoopback(listener.Addr()) {
+				log.Printf("Warning: no server.token is set, anyone on the network can scan")
+			}
+			log.Printf("Serving the API on http://%s", listener.Addr())
+
+			ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+			defer stop()
+			go func() {
+				retry := time.NewTicker(queueInterval)
+				defer retry.Stop()
+				for {
+					select {
+					case <-ctx.Done():
+						return
+					case <-retry.C:
+						deliverQueued(ctx, cm, queue)
+					}
+				}
+			}()
+			go func() {
+				<-ctx.Done()
+				shutdown, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+				defer cancel()
+				httpServer.Shutdown(shutdown)
+			}()
+
+			err = httpServer.Serve(listener)
+			srv.Close()
+			if errors.Is(err, http.ErrServerClosed) {
+				log.Printf("Stopped")
+				return nil
+			}
+			return err
+		},
+	}
+
+	cmd.Flags().StringVarP(&settings.Listen, "listen", "l", settings.Listen, "Address to listen on (server.listen)")
+	return cmd
+}
+
+// isLoopback reports whether the server only accepts local connections
+func isLoopback(addr net.Addr) bool {
+	tcp, ok := addr.(*net.TCPAddr)
+	return ok && tcp.IP.IsLoopback()
+}
@@ -0,0 +1,111 @@
+package scan
+
+import (
+	"crypto/x509"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"scanexpress/pkg/pdf"
+)
+
+// newVerifyCommand creates the command checking the signatures of PDFs
+func newVerifyCommand() *cobra.Command {
+	var caFile string
+
+	cmd := &cobra.Command{
+		Use:   "verify <file.pdf>...",
+		Short: "Verify the digital signatures of PDF documents",
+		Args:  cobra.MinimumNArgs(1),
+		// Errors are reported per file
+		SilenceUsage:  true,
+		SilenceErrors: true,
+	}
+	cmd.Flags().StringVar(&caFile, "ca", "", "Trust the certificates in this PEM file instead of the system roots")
+
+	cmd.RunE = func(cmd *cobra.Command, args []string) error {
+		var roots *x509.CertPool
+		if caFile != "" {
+			pem, err := os.ReadFile(caFile)
+			if err != nil {
+				return fmt.Errorf("failed to read CA file: %v", err)
+			}
+			roots = x509.NewCertPool()
+			if !roots.AppendCertsFromPEM(pem) {
+				return fmt.Errorf("no certificates found in %s", caFile)
+			}
+		}
+
+		failed := 0
+		for _, file := range args {
+			if !verifyFile(file, roots) {
+				failed++
+			}
+		}
+		if failed > 0 {
+			return fmt.Errorf("%d of %d documents failed verification", failed, len(args))
+		}
+		return nil
+	}
+
+	return cmd
+}
+
+// verifyFile prints the signatures of a document and reports whether they
+// are all valid and trusted
+func verifyFile(file string, roots *x509.CertPool) bool {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		fmt.Printf("%s: %v\n", file, err)
+		return false
+	}
+
+	reports, err := pdf.VerifySignatures(data, roots)
+	if err != nil {
+		fmt.Printf("%s: %v\n", file, err)
+		return false
+	}
+
+	ok := true
+	for i, r := range reports {
+		fmt.Printf("%s: signature %d\n", file, i+1)
+		if r.Error != nil {
+			fmt.Printf("  Status:   INVALID (%v)\n", r.Error)
+			ok = false
+			continue
+		}
+
+		switch {
+		case !r.CoversDocument:
+			fmt.Println("  Status:   INVALID (the document was changed after signing)")
+			ok = false
+		case r.TrustError != nil:
+			fmt.Printf("  Status:   valid, but the signer is not trusted (%v)\n", r.TrustError)
+			ok = false
+		default:
+			fmt.Println("  Status:   valid")
+		}
+
+		fmt.Printf("  Signer:   %s\n", r.Signer())
+		if !r.Time().IsZero() {
+			source := "claimed by the signer"
+			if !r.Timestamp.IsZero() {
+				source = "timestamped"
+			}
+			fmt.Printf("  Signed:   %s (%s)\n", r.Time().Local().Format(time.RFC1123), source)
+		}
+		if r.Reason != "" {
+			fmt.Printf("  Reason:   %s\n", r.Reason)
+		}
+		if r.Location != "" {
+			fmt.Printf("  Location: %s\n", r.Location)
+		}
+		if r.EncryptedFields {
+			fmt.Println("  (The reason and location are encrypted with the document)")
+		}
+	}
+
+	return ok
+}
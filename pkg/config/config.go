@@ -13,6 +13,44 @@ type Config struct {
 	ScannerDevice string
 	ScannerTitle  string
 	SaveFolder    string
+	RecentFolders []string // Save folders used before, the latest first
+	AutoRotate    bool     // Turn pages upright before generating the PDF
+	Discovery     bool     // Look for network scanners with mDNS
+	Preview       string   // Terminal graphics of page previews: "auto", "kitty", "sixel", "iterm", "blocks" or "off"
+	Profile       string   // Name of the profile used when none is given on the command line
+}
+
+// maxRecentFolders is how many save folders are remembered
+const maxRecentFolders = 5
+
+// SANEPasswordEnv names the environment variable holding the password of
+// saned servers, used instead of sane.password when set
+const SANEPasswordEnv = "SCANEXPRESS_SANE_PASSWORD"
+
+// SANEConfig holds the saned servers scanned from over the network
+type SANEConfig struct {
+	Hosts    []string // Servers as host or host:port
+	Username string   // Name for servers requiring authorization
+	Password string
+}
+
+// DaemonConfig holds the settings of the scanner button daemon
+type DaemonConfig struct {
+	Profile    string   // Profile the documents are scanned with
+	Button     string   // Sensor option triggering a scan, any sensor when empty
+	Interval   float64  // Seconds between two readings of the sensors
+	Duplex     bool     // Scan both sides of the sheets
+	Recipients []string // Addresses the documents are emailed to
+}
+
+// ServerTokenEnv names the environment variable holding the API token of
+// the server, used instead of server.token when set
+const ServerTokenEnv = "SCANEXPRESS_SERVER_TOKEN"
+
+// ServerConfig holds the settings of the HTTP API server
+type ServerConfig struct {
+	Listen string // Address to listen on, e.g. "127.0.0.1:8080"
+	Token  string // Bearer token required from clients, none when empty
 }
 
 // ConfigManager manages the application configuration
@@ -26,6 +64,17 @@ func NewConfigManager() (*ConfigManager, error) {
 	v := viper.New()
 	v.SetConfigName("config")
 	v.SetConfigType("yaml")
+	v.SetDefault("scan.auto_rotate", true)
+	v.SetDefault("scan.discovery", true)
+	v.SetDefault("scan.preview", "auto")
+	v.SetDefault("output.jpeg_quality", 85)
+	v.SetDefault("hooks.timeout", 60)
+	v.SetDefault("paperless.timeout", 300)
+	v.SetDefault("email.port", 587)
+	v.SetDefault("email.security", "starttls")
+	v.SetDefault("email.max_size", 18)
+	v.SetDefault("daemon.interval", 0.5)
+	v.SetDefault("server.listen", "127.0.0.1:8080")
 
 	configPath := path.Join(xdg.ConfigHome, "scanexpress")
 	v.AddConfigPath(configPath)
@@ -55,15 +104,64 @@ func (cm *ConfigManager) GetConfig() Config {
 		ScannerDevice: cm.viper.GetString("scanner.device"),
 		ScannerTitle:  cm.viper.GetString("scanner.title"),
 		SaveFolder:    cm.viper.GetString("save.folder"),
+		RecentFolders: cm.viper.GetStringSlice("save.recent"),
+		AutoRotate:    cm.viper.GetBool("scan.auto_rotate"),
+		Discovery:     cm.viper.GetBool("scan.discovery"),
+		Preview:       cm.viper.GetString("scan.preview"),
+		Profile:       cm.viper.GetString("profile"),
+	}
+}
+
+// GetSANEConfig returns the saned servers to look for scanners on
+func (cm *ConfigManager) GetSANEConfig() SANEConfig {
+	return SANEConfig{
+		Hosts:    cm.viper.GetStringSlice("sane.hosts"),
+		Username: cm.viper.GetString("sane.username"),
+		Password: cm.secret("sane.password", SANEPasswordEnv),
+	}
+}
+
+// GetESCLScanners returns the base URLs of the eSCL (AirScan) scanners
+func (cm *ConfigManager) GetESCLScanners() []string {
+	return cm.viper.GetStringSlice("escl.scanners")
+}
+
+// GetDaemonConfig returns the settings of the scanner button daemon
+func (cm *ConfigManager) GetDaemonConfig() DaemonConfig {
+	return DaemonConfig{
+		Profile:    cm.viper.GetString("daemon.profile"),
+		Button:     cm.viper.GetString("daemon.button"),
+		Interval:   cm.viper.GetFloat64("daemon.interval"),
+		Duplex:     cm.viper.GetBool("daemon.duplex"),
+		Recipients: cm.viper.GetStringSlice("daemon.recipients"),
+	}
+}
+
+// GetServerConfig returns the settings of the HTTP API server
+func (cm *ConfigManager) GetServerConfig() ServerConfig {
+	return ServerConfig{
+		Listen: cm.viper.GetString("server.listen"),
+		Token:  cm.secret("server.token", ServerTokenEnv),
 	}
 }
 
 // SaveConfig saves the configuration
+// Only the remembered scanner selection and save folder are written; other
+// settings are left as the user edited them in config.yaml
 func (cm *ConfigManager) SaveConfig(config Config) error {
 	cm.viper.Set("scanner.device", config.ScannerDevice)
 	cm.viper.Set("scanner.title", config.ScannerTitle)
 	cm.viper.Set("save.folder", config.SaveFolder)
 
+	// The save folder becomes the most recent one
+	recent := []string{config.SaveFolder}
+	for _, folder := range cm.viper.GetStringSlice("save.recent") {
+		if folder != config.SaveFolder && len(recent) < maxRecentFolders {
+			recent = append(recent, folder)
+		}
+	}
+	cm.viper.Set("save.recent", recent)
+
 	return cm.viper.WriteConfigAs(cm.path)
 }
 
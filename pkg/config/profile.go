@@ -0,0 +1,238 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// PaperlessTokenEnv names the environment variable holding the Paperless-ngx
+// API token, used instead of paperless.token when set
+const PaperlessTokenEnv = "SCANEXPRESS_PAPERLESS_TOKEN"
+
+// WebDAVPasswordEnv names the environment variable holding the WebDAV
+// password, used instead of webdav.password when set
+const WebDAVPasswordEnv = "SCANEXPRESS_WEBDAV_PASSWORD"
+
+// SMTPPasswordEnv names the environment variable holding the SMTP password,
+// used instead of email.password when set
+const SMTPPasswordEnv = "SCANEXPRESS_SMTP_PASSWORD"
+
+// SigningPasswordEnv names the environment variable holding the password of
+// the signing certificate. When it is unset the password is asked for.
+const SigningPasswordEnv = "SCANEXPRESS_SIGNING_PASSWORD"
+
+// Profile holds the document settings used for a scan. Settings missing
+// from a named profile fall back to the top-level settings of config.yaml.
+type Profile struct {
+	Name       string   // Profile name, empty for the top-level settings
+	Tags       []string // Tags describing the scanned documents
+	Output     OutputConfig
+	Encryption EncryptionConfig
+	Signing    SigningConfig
+	Hooks      HooksConfig
+	Paperless  PaperlessConfig
+	WebDAV     WebDAVConfig
+	Email      EmailConfig
+}
+
+// OutputConfig holds the policies used to keep generated PDFs small
+type OutputConfig struct {
+	PDFA        bool // Write PDF/A-2b archival documents
+	JPEG        bool // Encode pages as JPEG
+	JPEGQuality int  // JPEG quality (1-100)
+	Grayscale   bool // Convert pages without color to grayscale
+	Bilevel     bool // Convert text pages to 1-bit
+	SplitPhotos bool // Save each photo on the pages as its own image
+}
+
+// EncryptionConfig holds the PDF encryption settings
+// Passwords are never stored in the configuration, they are asked for when
+// the document is generated
+type EncryptionConfig struct {
+	Enabled     bool     // Encrypt documents with AES-256
+	Permissions []string // Operations allowed with the user password (e.g. "print", "copy")
+}
+
+// SigningConfig holds the digital signature settings
+// The certificate password is never stored in the configuration
+type SigningConfig struct {
+	Enabled      bool   // Sign documents
+	Certificate  string // Path to the PKCS#12 (.p12/.pfx) file holding the key and certificate
+	Name         string // Signer name, the certificate's common name when empty
+	Reason       string // Reason for signing (e.g. "Archived original")
+	Location     string // Where documents are signed
+	TimestampURL string // RFC 3161 timestamp authority, optional
+}
+
+// HooksConfig holds the shell commands run after scanning
+type HooksConfig struct {
+	AfterPage []string // Commands run after each scanned page
+	AfterPDF  []string // Commands run after the PDF is generated
+	Timeout   int      // Time limit of each command in seconds
+}
+
+// PaperlessConfig holds the Paperless-ngx upload settings
+type PaperlessConfig struct {
+	Enabled       bool     // Upload documents after scanning
+	URL           string   // Base URL of the server
+	Token         string   // API token
+	Correspondent string   // Correspondent name or ID
+	Tags          []string // Tags added to the profile's tags
+	CreateMissing bool     // Create tags and correspondents that don't exist yet
+	Timeout       int      // Seconds to wait for the document to be consumed
+}
+
+// WebDAVConfig holds the WebDAV (e.g. Nextcloud) upload settings
+type WebDAVConfig struct {
+	Enabled  bool   // Upload documents after scanning
+	URL      string // Base URL, e.g. "https://cloud.example.com/remote.php/dav/files/alice"
+	Path     string // Path template of uploaded documents, e.g. "Scans/{year}/{filename}"
+	Username string
+	Password string // Password or app password
+}
+
+// EmailConfig holds the SMTP settings and the address book recipients are
+// chosen from
+type EmailConfig struct {
+	Enabled     bool   // Offer to email documents after scanning
+	Host        string // SMTP server
+	Port        int
+	Security    string // "starttls", "tls" or "none"
+	Username    string
+	Password    string
+	From        string    // Sender address
+	MaxSize     int       // Largest attachment in MB
+	AddressBook []Contact // Recipients to choose from
+}
+
+// Contact is an address book entry
+type Contact struct {
+	Name  string `mapstructure:"name"`
+	Email string `mapstructure:"email"`
+}
+
+// ProfileNames returns the names of the profiles defined in the configuration
+func (cm *ConfigManager) ProfileNames() []string {
+	profiles := cm.viper.GetStringMap("profiles")
+	names := make([]string, 0, len(profiles))
+	for name := range profiles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// GetProfile returns the named profile, or the top-level settings when the
+// name is empty
+func (cm *ConfigManager) GetProfile(name string) (Profile, error) {
+	name = strings.ToLower(name)
+	if name != "" && !cm.viper.IsSet("profiles."+name) {
+		return Profile{}, fmt.Errorf("profile %q is not defined (available: %s)", name, strings.Join(cm.ProfileNames(), ", "))
+	}
+
+	return Profile{
+		Name: name,
+		Tags: cm.viper.GetStringSlice(cm.profileKey(name, "tags")),
+		Output: OutputConfig{
+			PDFA:        cm.viper.GetBool(cm.profileKey(name, "output.pdfa")),
+			JPEG:        cm.viper.GetBool(cm.profileKey(name, "output.jpeg")),
+			JPEGQuality: cm.viper.GetInt(cm.profileKey(name, "output.jpeg_quality")),
+			Grayscale:   cm.viper.GetBool(cm.profileKey(name, "output.grayscale")),
+			Bilevel:     cm.viper.GetBool(cm.profileKey(name, "output.bilevel")),
+			SplitPhotos: cm.viper.GetBool(cm.profileKey(name, "output.split_photos")),
+		},
+		Encryption: EncryptionConfig{
+			Enabled:     cm.viper.GetBool(cm.profileKey(name, "encryption.enabled")),
+			Permissions: cm.viper.GetStringSlice(cm.profileKey(name, "encryption.permissions")),
+		},
+		Signing: SigningConfig{
+			Enabled:      cm.viper.GetBool(cm.profileKey(name, "signing.enabled")),
+			Certificate:  expandHome(cm.viper.GetString(cm.profileKey(name, "signing.certificate"))),
+			Name:         cm.viper.GetString(cm.profileKey(name, "signing.name")),
+			Reason:       cm.viper.GetString(cm.profileKey(name, "signing.reason")),
+			Location:     cm.viper.GetString(cm.profileKey(name, "signing.location")),
+			TimestampURL: cm.viper.GetString(cm.profileKey(name, "signing.tsa_url")),
+		},
+		Hooks: HooksConfig{
+			AfterPage: cm.viper.GetStringSlice(cm.profileKey(name, "hooks.after_page")),
+			AfterPDF:  cm.viper.GetStringSlice(cm.profileKey(name, "hooks.after_pdf")),
+			Timeout:   cm.viper.GetInt(cm.profileKey(name, "hooks.timeout")),
+		},
+		Paperless: PaperlessConfig{
+			Enabled:       cm.viper.GetBool(cm.profileKey(name, "paperless.enabled")),
+			URL:           cm.viper.GetString(cm.profileKey(name, "paperless.url")),
+			Token:         cm.secret(cm.profileKey(name, "paperless.token"), PaperlessTokenEnv),
+			Correspondent: cm.viper.GetString(cm.profileKey(name, "paperless.correspondent")),
+			Tags:          cm.viper.GetStringSlice(cm.profileKey(name, "paperless.tags")),
+			CreateMissing: cm.viper.GetBool(cm.profileKey(name, "paperless.create_missing")),
+			Timeout:       cm.viper.GetInt(cm.profileKey(name, "paperless.timeout")),
+		},
+		WebDAV: WebDAVConfig{
+			Enabled:  cm.viper.GetBool(cm.profileKey(name, "webdav.enabled")),
+			URL:      cm.viper.GetString(cm.profileKey(name, "webdav.url")),
+			Path:     cm.viper.GetString(cm.profileKey(name, "webdav.path")),
+			Username: cm.viper.GetString(cm.profileKey(name, "webdav.username")),
+			Password: cm.secret(cm.profileKey(name, "webdav.password"), WebDAVPasswordEnv),
+		},
+		Email: EmailConfig{
+			Enabled:     cm.viper.GetBool(cm.profileKey(name, "email.enabled")),
+			Host:        cm.viper.GetString(cm.profileKey(name, "email.host")),
+			Port:        cm.viper.GetInt(cm.profileKey(name, "email.port")),
+			Security:    strings.ToLower(cm.viper.GetString(cm.profileKey(name, "email.security"))),
+			Username:    cm.viper.GetString(cm.profileKey(name, "email.username")),
+			Password:    cm.secret(cm.profileKey(name, "email.password"), SMTPPasswordEnv),
+			From:        cm.viper.GetString(cm.profileKey(name, "email.from")),
+			MaxSize:     cm.viper.GetInt(cm.profileKey(name, "email.max_size")),
+			AddressBook: cm.addressBook(cm.profileKey(name, "email.address_book")),
+		},
+	}, nil
+}
+
+// profileKey returns the key holding a setting for the profile, which is the
+// profile's own key when it is set and the top-level key otherwise
+func (cm *ConfigManager) profileKey(name, key string) string {
+	if name != "" && cm.viper.IsSet("profiles."+name+"."+key) {
+		return "profiles." + name + "." + key
+	}
+	return key
+}
+
+// addressBook reads a list of contacts, skipping entries without an address
+func (cm *ConfigManager) addressBook(key string) []Contact {
+	var contacts []Contact
+	if err := cm.viper.UnmarshalKey(key, &contacts); err != nil {
+		return nil
+	}
+
+	valid := contacts[:0]
+	for _, c := range contacts {
+		if c.Email != "" {
+			valid = append(valid, c)
+		}
+	}
+	return valid
+}
+
+// secret returns a secret setting, preferring the environment variable so
+// that it can be kept out of config.yaml
+func (cm *ConfigManager) secret(key, env string) string {
+	if value := os.Getenv(env); value != "" {
+		return value
+	}
+	return cm.viper.GetString(key)
+}
+
+// expandHome replaces a leading ~ in a path with the home directory
+func expandHome(path string) string {
+	if path != "~" && !strings.HasPrefix(path, "~/") {
+		return path
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return path
+	}
+	return filepath.Join(home, path[1:])
+}
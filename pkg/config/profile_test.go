@@ -0,0 +1,90 @@
+package config
+
+import (
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/spf13/viper"
+)
+
+const profilesConfig = `
+tags: [scan]
+output:
+  jpeg: true
+  jpeg_quality: 70
+encryption:
+  permissions: [print]
+paperless:
+  url: https://paperless.example.com
+  tags: [inbox]
+profiles:
+  Invoices:
+    tags: [invoice, finance]
+    output:
+      pdfa: true
+    paperless:
+      enabled: true
+  payroll:
+    encryption:
+      enabled: true
+`
+
+// testConfig returns a manager reading the YAML configuration
+func testConfig(t *testing.T, yaml string) *ConfigManager {
+	t.Helper()
+	v := viper.New()
+	v.SetConfigType("yaml")
+	if err := v.ReadConfig(strings.NewReader(yaml)); err != nil {
+		t.Fatal(err)
+	}
+	return &ConfigManager{viper: v}
+}
+
+func TestProfileFallback(t *testing.T) {
+	cm := testConfig(t, profilesConfig)
+
+	if names := cm.ProfileNames(); !slices.Equal(names, []string{"invoices", "payroll"}) {
+		t.Errorf("profiles %v", names)
+	}
+
+	invoices, err := cm.GetProfile("Invoices")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Settings of the profile replace the top-level ones
+	if !slices.Equal(invoices.Tags, []string{"invoice", "finance"}) || !invoices.Output.PDFA || !invoices.Paperless.Enabled {
+		t.Errorf("profile settings ignored: %+v", invoices)
+	}
+	// Missing ones, even within a section the profile sets, come from the
+	// top level
+	if !invoices.Output.JPEG || invoices.Output.JPEGQuality != 70 {
+		t.Errorf("output %+v, want the top-level JPEG settings", invoices.Output)
+	}
+	if invoices.Paperless.URL != "https://paperless.example.com" || !slices.Equal(invoices.Paperless.Tags, []string{"inbox"}) {
+		t.Errorf("paperless %+v, want the top-level server and tags", invoices.Paperless)
+	}
+	if invoices.Encryption.Enabled {
+		t.Error("encryption of another profile applied")
+	}
+
+	payroll, err := cm.GetProfile("payroll")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !payroll.Encryption.Enabled || !slices.Equal(payroll.Encryption.Permissions, []string{"print"}) || !slices.Equal(payroll.Tags, []string{"scan"}) {
+		t.Errorf("payroll %+v", payroll)
+	}
+
+	top, err := cm.GetProfile("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if top.Name != "" || top.Output.PDFA || top.Paperless.Enabled || !slices.Equal(top.Tags, []string{"scan"}) {
+		t.Errorf("top-level settings %+v", top)
+	}
+
+	if _, err := cm.GetProfile("receipts"); err == nil || !strings.Contains(err.Error(), "invoices, payroll") {
+		t.Errorf("unknown profile: %v", err)
+	}
+}
@@ -0,0 +1,72 @@
+package delivery
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// Document is a finished document to deliver
+type Document struct {
+	Path      string   // Path of the PDF
+	Title     string   // Document title
+	Tags      []string // Tags describing the document
+	Profile   string   // Profile the document was scanned with
+	PageCount int
+	Created   time.Time // When the document was scanned
+}
+
+// Result describes the outcome of a delivery
+type Result struct {
+	Target   string // Name of the target, e.g. "Paperless-ngx"
+	Message  string // What happened, e.g. "stored as document #12"
+	Location string // Where the document can be found, may be empty
+	JobID    string // Queue job the result belongs to, empty for direct deliveries
+	Error    error
+}
+
+// Success reports whether the document was delivered
+func (r Result) Success() bool {
+	return r.Error == nil
+}
+
+// String describes the result for display
+func (r Result) String() string {
+	if r.Error != nil {
+		return fmt.Sprintf("%s: failed (%v)", r.Target, r.Error)
+	}
+	s := fmt.Sprintf("%s: %s", r.Target, r.Message)
+	if r.Location != "" {
+		s += " " + r.Location
+	}
+	return s
+}
+
+// TemporaryError marks failures worth retrying later, such as an
+// unreachable server
+type TemporaryError struct {
+	Err error
+}
+
+func (e *TemporaryError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *TemporaryError) Unwrap() error {
+	return e.Err
+}
+
+// IsTemporary reports whether the delivery may succeed when retried
+func IsTemporary(err error) bool {
+	var temporary *TemporaryError
+	return errors.As(err, &temporary)
+}
+
+// Target delivers documents to a destination
+type Target interface {
+	// Name identifies the target in results
+	Name() string
+	// Deliver sends the document, stopping when the context is done
+	Deliver(ctx context.Context, doc Document) Result
+}
@@ -0,0 +1,256 @@
+package delivery
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"mime"
+	"net"
+	"net/mail"
+	"net/smtp"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// SMTP connection security modes
+const (
+	SecurityStartTLS = "starttls" // Plain connection upgraded with STARTTLS
+	SecurityTLS      = "tls"      // Implicit TLS, usually on port 465
+	SecurityNone     = "none"     // No encryption, for local relays only
+)
+
+// DefaultMaxAttachmentSize is the largest attachment sent by default; most
+// mail providers reject messages above 25 MB, and base64 adds a third
+const DefaultMaxAttachmentSize = 18 << 20
+
+// smtpTimeout limits how long connecting and sending may take
+const smtpTimeout = 2 * time.Minute
+
+// Email sends documents as attachments through an SMTP server
+type Email struct {
+	Host     string
+	Port     int
+	Security string // SecurityStartTLS, SecurityTLS or SecurityNone
+	Username string // Authenticates when set
+	Password string
+	From     string   // Sender address
+	To       []string // Recipient addresses
+	MaxSize  int64    // Largest attachment in bytes, DefaultMaxAttachmentSize when 0
+
+	TLSConfig *tls.Config // TLS settings, e.g. to trust a private CA; the system roots when nil
+}
+
+// Name identifies the target in results
+func (e *Email) Name() string {
+	return "Email"
+}
+
+// CheckSize returns an error when the file is too large to be attached
+func (e *Email) CheckSize(path string) error {
+	info, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+
+	limit := e.MaxSize
+	if limit <= 0 {
+		limit = DefaultMaxAttachmentSize
+	}
+	if info.Size() > limit {
+		return fmt.Errorf("%s is too large to attach (%.1f MB, limit %.1f MB)",
+			filepath.Base(path), float64(info.Size())/(1<<20), float64(limit)/(1<<20))
+	}
+	return nil
+}
+
+// Deliver emails the document to the recipients
+func (e *Email) Deliver(ctx context.Context, doc Document) Result {
+	result := Result{Target: e.Name()}
+	if len(e.To) == 0 {
+		result.Error = fmt.Errorf("no recipients")
+		return result
+	}
+	if err := e.CheckSize(doc.Path); err != nil {
+		result.Error = err
+		return result
+	}
+
+	message, err := e.message(doc)
+	if err != nil {
+		result.Error = err
+		return result
+	}
+	if err := e.send(ctx, message); err != nil {
+		result.Error = err
+		return result
+	}
+
+	result.Message = "sent to " + strings.Join(e.To, ", ")
+	return result
+}
+
+// message builds a MIME message with the document attached
+func (e *Email) message(doc Document) ([]byte, error) {
+	data, err := os.ReadFile(doc.Path)
+	if err != nil {
+		return nil, err
+	}
+
+	boundary := make([]byte, 16)
+	if _, err := rand.Read(boundary); err != nil {
+		return nil, err
+	}
+	b := hex.EncodeToString(boundary)
+	id := make([]byte, 12)
+	rand.Read(id)
+
+	from, err := mail.ParseAddress(e.From)
+	if err != nil {
+		return nil, fmt.Errorf("invalid sender address %q: %v", e.From, err)
+	}
+
+	title := doc.Title
+	if title == "" {
+		title = strings.TrimSuffix(filepath.Base(doc.Path), filepath.Ext(doc.Path))
+	}
+	filename := filepath.Base(doc.Path)
+
+	var m bytes.Buffer
+	fmt.Fprintf(&m, "From: %s\r\n", from.String())
+	to := make([]string, len(e.To))
+	for i, recipient := range e.To {
+		address, err := mail.ParseAddress(recipient)
+		if err != nil {
+			return nil, fmt.Errorf("invalid recipient %q: %v", recipient, err)
+		}
+		to[i] = address.String()
+	}
+	fmt.Fprintf(&m, "To: %s\r\n", strings.Join(to, ", "))
+	fmt.Fprintf(&m, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", title))
+	fmt.Fprintf(&m, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	fmt.Fprintf(&m, "Message-ID: <%s@%s>\r\n", hex.EncodeToString(id), domainOf(from.Address))
+	m.WriteString("MIME-Version: 1.0\r\n")
+	fmt.Fprintf(&m, "Content-Type: multipart/mixed; boundary=%q\r\n\r\n", b)
+
+	fmt.Fprintf(&m, "--%s\r\n", b)
+	m.WriteString("Content-Type: text/plain; charset=utf-8\r\n")
+	m.WriteString("Content-Transfer-Encoding: 8bit\r\n\r\n")
+	pages := ""
+	if doc.PageCount > 0 {
+		pages = fmt.Sprintf(" (%d pages)", doc.PageCount)
+	}
+	fmt.Fprintf(&m, "The scanned document %s%s is attached.\r\n\r\n", title, pages)
+
+	fmt.Fprintf(&m, "--%s\r\n", b)
+	fmt.Fprintf(&m, "Content-Type: application/pdf; name=%q\r\n", mime.QEncoding.Encode("utf-8", filename))
+	m.WriteString("Content-Transfer-Encoding: base64\r\n")
+	fmt.Fprintf(&m, "Content-Disposition: attachment; filename=%q\r\n\r\n", mime.QEncoding.Encode("utf-8", filename))
+	encoded := base64.StdEncoding.EncodeToString(data)
+	for len(encoded) > 76 {
+		m.WriteString(encoded[:76] + "\r\n")
+		encoded = encoded[76:]
+	}
+	m.WriteString(encoded + "\r\n")
+	fmt.Fprintf(&m, "--%s--\r\n", b)
+
+	return m.Bytes(), nil
+}
+
+// send delivers the message through the SMTP server
+func (e *Email) send(ctx context.Context, message []byte) error {
+	address := net.JoinHostPort(e.Host, strconv.Itoa(e.Port))
+	tlsConfig := &tls.Config{}
+	if e.TLSConfig != nil {
+		tlsConfig = e.TLSConfig.Clone()
+	}
+	if tlsConfig.ServerName == "" {
+		tlsConfig.ServerName = e.Host
+	}
+
+	dialer := &net.Dialer{Timeout: 30 * time.Second}
+	var conn net.Conn
+	var err error
+	if e.Security == SecurityTLS {
+		conn, err = (&tls.Dialer{NetDialer: dialer, Config: tlsConfig}).DialContext(ctx, "tcp", address)
+	} else {
+		conn, err = dialer.DialContext(ctx, "tcp", address)
+	}
+	if err != nil {
+		return &TemporaryError{Err: fmt.Errorf("failed to connect to %s: %v", address, err)}
+	}
+	conn.SetDeadline(time.Now().Add(smtpTimeout))
+
+	client, err := smtp.NewClient(conn, e.Host)
+	if err != nil {
+		conn.Close()
+		return &TemporaryError{Err: fmt.Errorf("SMTP handshake failed: %v", err)}
+	}
+	defer client.Close()
+
+	if e.Security == SecurityStartTLS || e.Security == "" {
+		if ok, _ := client.Extension("STARTTLS"); !ok {
+			return fmt.Errorf("%s does not support STARTTLS", e.Host)
+		}
+		if err := client.StartTLS(tlsConfig); err != nil {
+			return fmt.Errorf("STARTTLS failed: %v", err)
+		}
+	}
+
+	// Respect the size limit the server announces
+	if ok, param := client.Extension("SIZE"); ok {
+		if limit, err := strconv.ParseInt(param, 10, 64); err == nil && limit > 0 && int64(len(message)) > limit {
+			return fmt.Errorf("message is too large for %s (%.1f MB, limit %.1f MB)",
+				e.Host, float64(len(message))/(1<<20), float64(limit)/(1<<20))
+		}
+	}
+
+	if e.Username != "" {
+		if ok, _ := client.Extension("AUTH"); !ok {
+			return fmt.Errorf("%s does not support authentication", e.Host)
+		}
+		if err := client.Auth(smtp.PlainAuth("", e.Username, e.Password, e.Host)); err != nil {
+			return fmt.Errorf("authentication failed: %v", err)
+		}
+	}
+
+	from, _ := mail.ParseAddress(e.From)
+	if err := client.Mail(from.Address); err != nil {
+		return fmt.Errorf("sender rejected: %v", err)
+	}
+	for _, to := range e.To {
+		address, err := mail.ParseAddress(to)
+		if err != nil {
+			return fmt.Errorf("invalid recipient %q: %v", to, err)
+		}
+		if err := client.Rcpt(address.Address); err != nil {
+			return fmt.Errorf("recipient %s rejected: %v", to, err)
+		}
+	}
+
+	w, err := client.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(message); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return fmt.Errorf("message rejected: %v", err)
+	}
+	return client.Quit()
+}
+
+// domainOf returns the domain of an email address
+func domainOf(address string) string {
+	if i := strings.LastIndex(address, "@"); i >= 0 {
+		return address[i+1:]
+	}
+	return "localhost"
+}
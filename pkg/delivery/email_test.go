@@ -0,0 +1,259 @@
+package delivery
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net"
+	"net/http/httptest"
+	"net/mail"
+	"strings"
+	"testing"
+)
+
+// smtpSession is what an SMTP stand-in received in one session
+type smtpSession struct {
+	startTLS   bool   // Whether the session switched to TLS with STARTTLS
+	encrypted  bool   // Whether the credentials came over TLS
+	auth       string // Credentials of AUTH PLAIN, as "user:password"
+	recipients []string
+	message    []byte
+}
+
+// serveSMTP returns email settings for an SMTP stand-in with the security,
+// announcing the size limit when not 0, and the sessions it received
+func serveSMTP(t *testing.T, security string, size int64) (*Email, <-chan smtpSession) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { listener.Close() })
+
+	// A certificate for 127.0.0.1 and a client trusting it
+	https := httptest.NewTLSServer(nil)
+	https.Close()
+	serverTLS := &tls.Config{Certificates: https.TLS.Certificates}
+	roots := x509.NewCertPool()
+	roots.AddCert(https.Certificate())
+
+	sessions := make(chan smtpSession, 4)
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			if security == SecurityTLS {
+				conn = tls.Server(conn, serverTLS)
+			}
+			sessions <- smtpConversation(conn, serverTLS, security, size)
+		}
+	}()
+
+	return &Email{
+		Host:      "127.0.0.1",
+		Port:      listener.Addr().(*net.TCPAddr).Port,
+		Security:  security,
+		Username:  "scanner",
+		Password:  "hunter2",
+		From:      "Scanner <scanner@example.com>",
+		To:        []string{"alice@example.com", "Bob <bob@example.com>"},
+		TLSConfig: &tls.Config{RootCAs: roots},
+	}, sessions
+}
+
+// smtpConversation answers the commands of a client until it quits
+func smtpConversation(conn net.Conn, serverTLS *tls.Config, security string, size int64) smtpSession {
+	defer conn.Close()
+	session := smtpSession{}
+	encrypted := security == SecurityTLS
+	r := bufio.NewReader(conn)
+	reply := func(lines ...string) {
+		for i, line := range lines {
+			if i < len(lines)-1 {
+				line = line[:3] + "-" + line[4:]
+			}
+			fmt.Fprintf(conn, "%s\r\n", line)
+		}
+	}
+
+	reply("220 stand-in ESMTP")
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return session
+		}
+		verb, arg, _ := strings.Cut(strings.TrimRight(line, "\r\n"), " ")
+
+		switch verb {
+		case "EHLO":
+			lines := []string{"250 stand-in", "250 AUTH PLAIN"}
+			if security == SecurityStartTLS && !encrypted {
+				lines = append(lines, "250 STARTTLS")
+			}
+			if size > 0 {
+				lines = append(lines, fmt.Sprintf("250 SIZE %d", size))
+			}
+			reply(lines...)
+		case "STARTTLS":
+			reply("220 ready")
+			conn = tls.Server(conn, serverTLS)
+			r = bufio.NewReader(conn)
+			session.startTLS, encrypted = true, true
+		case "AUTH":
+			response, _ := base64.StdEncoding.DecodeString(strings.TrimPrefix(arg, "PLAIN "))
+			parts := strings.Split(string(response), "\x00")
+			if len(parts) != 3 {
+				reply("501 malformed")
+				continue
+			}
+			session.auth = parts[1] + ":" + parts[2]
+			session.encrypted = encrypted
+			reply("235 authenticated")
+		case "RCPT":
+			session.recipients = append(session.recipients, strings.Trim(strings.TrimPrefix(arg, "TO:"), "<>"))
+			reply("250 ok")
+		case "DATA":
+			reply("354 go ahead")
+			var message bytes.Buffer
+			for {
+				line, err := r.ReadString('\n')
+				if err != nil || line == ".\r\n" {
+					break
+				}
+				message.WriteString(strings.TrimPrefix(line, "."))
+			}
+			session.message = message.Bytes()
+			reply("250 queued")
+		case "QUIT":
+			reply("221 bye")
+			return session
+		default:
+			reply("250 ok")
+		}
+	}
+}
+
+// attachment returns the name and the decoded content of the message's
+// attachment
+func attachment(t *testing.T, message []byte) (string, []byte) {
+	msg, err := mail.ReadMessage(bytes.NewReader(message))
+	if err != nil {
+		t.Fatalf("malformed message: %v", err)
+	}
+	mediaType, params, err := mime.ParseMediaType(msg.Header.Get("Content-Type"))
+	if err != nil || mediaType != "multipart/mixed" {
+		t.Fatalf("content type %q: %v", msg.Header.Get("Content-Type"), err)
+	}
+
+	parts := multipart.NewReader(msg.Body, params["boundary"])
+	for {
+		part, err := parts.NextPart()
+		if err != nil {
+			t.Fatalf("no attachment: %v", err)
+		}
+		if part.FileName() == "" {
+			continue
+		}
+		if part.Header.Get("Content-Type") != `application/pdf; name="scan.pdf"` {
+			t.Errorf("attachment content type %q", part.Header.Get("Content-Type"))
+		}
+		data, err := io.ReadAll(base64.NewDecoder(base64.StdEncoding, part))
+		if err != nil {
+			t.Fatalf("attachment is not base64: %v", err)
+		}
+		return part.FileName(), data
+	}
+}
+
+func TestEmailStartTLS(t *testing.T) {
+	email, sessions := serveSMTP(t, SecurityStartTLS, 0)
+	doc, data := testDocument(t, 5000)
+	doc.PageCount = 2
+
+	if result := email.Deliver(context.Background(), doc); result.Error != nil {
+		t.Fatalf("Deliver: %v", result.Error)
+	}
+	session := <-sessions
+	if !session.startTLS || !session.encrypted {
+		t.Error("credentials were sent before STARTTLS")
+	}
+	if session.auth != "scanner:hunter2" {
+		t.Errorf("authenticated as %q", session.auth)
+	}
+	if got := strings.Join(session.recipients, ","); got != "alice@example.com,bob@example.com" {
+		t.Errorf("recipients %s", got)
+	}
+
+	name, content := attachment(t, session.message)
+	if name != "scan.pdf" || !bytes.Equal(content, data) {
+		t.Errorf("attachment %s differs from the document", name)
+	}
+	msg, _ := mail.ReadMessage(bytes.NewReader(session.message))
+	if msg.Header.Get("Subject") != "scan" {
+		t.Errorf("subject %q", msg.Header.Get("Subject"))
+	}
+}
+
+func TestEmailImplicitTLS(t *testing.T) {
+	email, sessions := serveSMTP(t, SecurityTLS, 0)
+	doc, data := testDocument(t, 100)
+
+	if result := email.Deliver(context.Background(), doc); result.Error != nil {
+		t.Fatalf("Deliver: %v", result.Error)
+	}
+	session := <-sessions
+	if session.startTLS || !session.encrypted {
+		t.Errorf("STARTTLS %v, encrypted %v, want a session encrypted from the start", session.startTLS, session.encrypted)
+	}
+	if _, content := attachment(t, session.message); !bytes.Equal(content, data) {
+		t.Error("attachment differs from the document")
+	}
+}
+
+func TestEmailRequiresStartTLS(t *testing.T) {
+	email, sessions := serveSMTP(t, SecurityNone, 0)
+	email.Security = SecurityStartTLS
+	doc, _ := testDocument(t, 100)
+
+	result := email.Deliver(context.Background(), doc)
+	if result.Error == nil || !strings.Contains(result.Error.Error(), "STARTTLS") {
+		t.Fatalf("error %v, want STARTTLS missing", result.Error)
+	}
+	if session := <-sessions; session.auth != "" || session.message != nil {
+		t.Error("credentials or message sent without encryption")
+	}
+}
+
+func TestEmailMaxSize(t *testing.T) {
+	email, sessions := serveSMTP(t, SecurityTLS, 1000)
+	email.MaxSize = 1000
+	doc, _ := testDocument(t, 2000)
+
+	result := email.Deliver(context.Background(), doc)
+	if result.Error == nil || !strings.Contains(result.Error.Error(), "too large to attach") {
+		t.Fatalf("error %v, want the attachment rejected", result.Error)
+	}
+	select {
+	case <-sessions:
+		t.Error("connected to the server for a document too large")
+	default:
+	}
+
+	// The limit the server announces applies as well
+	email.MaxSize = 0
+	result = email.Deliver(context.Background(), doc)
+	if result.Error == nil || !strings.Contains(result.Error.Error(), "too large for") {
+		t.Fatalf("error %v, want the server's limit", result.Error)
+	}
+	if session := <-sessions; session.message != nil {
+		t.Error("message sent above the server's limit")
+	}
+}
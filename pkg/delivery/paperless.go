@@ -0,0 +1,274 @@
+package delivery
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Paperless-ngx defaults
+const (
+	DefaultPaperlessTimeout = 5 * time.Minute // How long to wait for the document to be consumed
+	paperlessPollInterval   = 2 * time.Second // Delay between task status requests
+)
+
+// Paperless uploads documents to a Paperless-ngx server and waits until
+// they have been consumed
+type Paperless struct {
+	URL           string        // Base URL of the server, e.g. "https://paperless.example.com"
+	Token         string        // API token
+	Correspondent string        // Correspondent name or ID, optional
+	Tags          []string      // Tag names or IDs added to every document
+	CreateMissing bool          // Create tags and correspondents that don't exist yet
+	Timeout       time.Duration // How long to wait for consumption
+	Client        *http.Client  // HTTP client, http.DefaultClient when nil
+	PollInterval  time.Duration // Delay between status requests, for tests
+}
+
+// paperlessTask is the status of a consumption task
+type paperlessTask struct {
+	Status          string  `json:"status"`
+	Result          *string `json:"result"`
+	RelatedDocument *string `json:"related_document"`
+}
+
+// paperlessList is a page of API results
+type paperlessList struct {
+	Results []struct {
+		ID   int    `json:"id"`
+		Name string `json:"name"`
+	} `json:"results"`
+}
+
+// Name identifies the target in results
+func (p *Paperless) Name() string {
+	return "Paperless-ngx"
+}
+
+// Deliver uploads the document and polls its consumption task
+func (p *Paperless) Deliver(ctx context.Context, doc Document) Result {
+	result := Result{Target: p.Name()}
+
+	timeout := p.Timeout
+	if timeout <= 0 {
+		timeout = DefaultPaperlessTimeout
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	task, err := p.upload(ctx, doc)
+	if err != nil {
+		result.Error = err
+		return result
+	}
+
+	id, err := p.waitForTask(ctx, task)
+	if err != nil {
+		result.Error = err
+		return result
+	}
+
+	result.Message = "stored as document #" + id
+	result.Location = p.endpoint("/documents/" + id + "/details")
+	return result
+}
+
+// upload posts the document and returns the ID of its consumption task
+func (p *Paperless) upload(ctx context.Context, doc Document) (string, error) {
+	fields := url.Values{}
+	if doc.Title != "" {
+		fields.Set("title", doc.Title)
+	}
+	if p.Correspondent != "" {
+		id, err := p.resolve(ctx, "correspondents", p.Correspondent)
+		if err != nil {
+			return "", err
+		}
+		fields.Set("correspondent", id)
+	}
+	for _, tag := range uniqueTags(append(append([]string{}, doc.Tags...), p.Tags...)) {
+		id, err := p.resolve(ctx, "tags", tag)
+		if err != nil {
+			return "", err
+		}
+		fields.Add("tags", id)
+	}
+
+	file, err := os.Open(doc.Path)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	var body bytes.Buffer
+	form := multipart.NewWriter(&body)
+	part, err := form.CreateFormFile("document", filepath.Base(doc.Path))
+	if err != nil {
+		return "", err
+	}
+	if _, err := io.Copy(part, file); err != nil {
+		return "", err
+	}
+	for key, values := range fields {
+		for _, value := range values {
+			form.WriteField(key, value)
+		}
+	}
+	if err := form.Close(); err != nil {
+		return "", err
+	}
+
+	var task string
+	if err := p.request(ctx, http.MethodPost, "/api/documents/post_document/", form.FormDataContentType(), &body, &task); err != nil {
+		return "", fmt.Errorf("upload failed: %w", err)
+	}
+	if task == "" {
+		return "", fmt.Errorf("upload failed: no consumption task returned")
+	}
+	return task, nil
+}
+
+// waitForTask polls the consumption task until it finishes and returns the
+// ID of the new document. Errors are never temporary, as retrying would
+// upload the document again.
+func (p *Paperless) waitForTask(ctx context.Context, task string) (string, error) {
+	interval := p.PollInterval
+	if interval <= 0 {
+		interval = paperlessPollInterval
+	}
+
+	for {
+		var tasks []paperlessTask
+		if err := p.request(ctx, http.MethodGet, "/api/tasks/?task_id="+url.QueryEscape(task), "", nil, &tasks); err != nil {
+			return "", fmt.Errorf("failed to check consumption: %v", err)
+		}
+
+		if len(tasks) > 0 {
+			t := tasks[0]
+			switch t.Status {
+			case "SUCCESS":
+				if t.RelatedDocument == nil {
+					return "", fmt.Errorf("consumed, but no document was created")
+				}
+				return *t.RelatedDocument, nil
+			case "FAILURE", "REVOKED":
+				message := t.Status
+				if t.Result != nil {
+					message = *t.Result
+				}
+				return "", fmt.Errorf("consumption failed: %s", message)
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return "", fmt.Errorf("document was not consumed in time (task %s)", task)
+		case <-time.After(interval):
+		}
+	}
+}
+
+// resolve returns the ID of a tag or correspondent. Those that don't exist
+// yet are created when CreateMissing is set, and an error otherwise, so that
+// a typo doesn't add a tag. Numeric values are used as IDs directly.
+func (p *Paperless) resolve(ctx context.Context, kind, name string) (string, error) {
+	if _, err := strconv.Atoi(name); err == nil {
+		return name, nil
+	}
+
+	var list paperlessList
+	if err := p.request(ctx, http.MethodGet, "/api/"+kind+"/?name__iexact="+url.QueryEscape(name), "", nil, &list); err != nil {
+		return "", fmt.Errorf("failed to look up %q: %w", name, err)
+	}
+	for _, item := range list.Results {
+		if strings.EqualFold(item.Name, name) {
+			return strconv.Itoa(item.ID), nil
+		}
+	}
+	if !p.CreateMissing {
+		return "", fmt.Errorf("%s %q doesn't exist in Paperless-ngx", strings.TrimSuffix(kind, "s"), name)
+	}
+
+	body, _ := json.Marshal(map[string]string{"name": name})
+	var created struct {
+		ID int `json:"id"`
+	}
+	if err := p.request(ctx, http.MethodPost, "/api/"+kind+"/", "application/json", bytes.NewReader(body), &created); err != nil {
+		return "", fmt.Errorf("failed to create %q: %w", name, err)
+	}
+	return strconv.Itoa(created.ID), nil
+}
+
+// request calls the API and decodes the JSON response into out
+func (p *Paperless) request(ctx context.Context, method, path, contentType string, body io.Reader, out any) error {
+	req, err := http.NewRequestWithContext(ctx, method, p.endpoint(path), body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Token "+p.Token)
+	req.Header.Set("Accept", "application/json")
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+
+	client := p.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return &TemporaryError{Err: fmt.Errorf("server unreachable: %v", err)}
+	}
+	defer resp.Body.Close()
+
+	data, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		message := strings.TrimSpace(string(data))
+		if len(message) > 200 {
+			message = message[:200] + "..."
+		}
+		err := fmt.Errorf("%s: %s", resp.Status, message)
+		if resp.StatusCode >= 500 || resp.StatusCode == http.StatusTooManyRequests {
+			return &TemporaryError{Err: err}
+		}
+		return err
+	}
+	if err := json.Unmarshal(data, out); err != nil {
+		return fmt.Errorf("unexpected response: %v", err)
+	}
+	return nil
+}
+
+// endpoint returns the URL of an API path
+func (p *Paperless) endpoint(path string) string {
+	return strings.TrimRight(p.URL, "/") + path
+}
+
+// uniqueTags removes empty and duplicate tags, ignoring case
+func uniqueTags(tags []string) []string {
+	seen := make(map[string]bool, len(tags))
+	unique := make([]string, 0, len(tags))
+	for _, tag := range tags {
+		tag = strings.TrimSpace(tag)
+		key := strings.ToLower(tag)
+		if tag == "" || seen[key] {
+			continue
+		}
+		seen[key] = true
+		unique = append(unique, tag)
+	}
+	return unique
+}
@@ -0,0 +1,166 @@
+package delivery
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testDocument writes a document of the size and returns it
+func testDocument(t *testing.T, size int) (Document, []byte) {
+	data := make([]byte, size)
+	for i := range data {
+		data[i] = byte(i * 7)
+	}
+	file := filepath.Join(t.TempDir(), "scan.pdf")
+	if err := os.WriteFile(file, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	return Document{Path: file, Title: "scan"}, data
+}
+
+// testPaperless returns a target for a Paperless-ngx server answering task
+// polls with the tasks in turn, the last one repeated. Other requests are
+// left to the handler.
+func testPaperless(t *testing.T, handler http.HandlerFunc, tasks ...string) *Paperless {
+	polls := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.Header.Get("Authorization") != "Token secret":
+			http.Error(w, `{"detail":"Invalid token."}`, http.StatusUnauthorized)
+		case r.URL.Path == "/api/tasks/" && r.URL.Query().Get("task_id") == "task-1":
+			io.WriteString(w, "["+tasks[min(polls, len(tasks)-1)]+"]")
+			polls++
+		default:
+			handler(w, r)
+		}
+	}))
+	t.Cleanup(server.Close)
+	return &Paperless{URL: server.URL + "/", Token: "secret", PollInterval: time.Millisecond}
+}
+
+func TestPaperlessUploadAndPoll(t *testing.T) {
+	doc, data := testDocument(t, 2000)
+	var document []byte
+	var title, tags []string
+	paperless := testPaperless(t, func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method + " " + r.URL.Path {
+		case "POST /api/documents/post_document/":
+			file, _, err := r.FormFile("document")
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			document, _ = io.ReadAll(file)
+			title, tags = r.MultipartForm.Value["title"], r.MultipartForm.Value["tags"]
+			io.WriteString(w, `"task-1"`)
+		case "GET /api/tags/":
+			if r.URL.Query().Get("name__iexact") == "Invoices" {
+				io.WriteString(w, `{"results":[{"id":3,"name":"invoices"}]}`)
+			} else {
+				io.WriteString(w, `{"results":[]}`)
+			}
+		case "POST /api/tags/":
+			w.WriteHeader(http.StatusCreated)
+			io.WriteString(w, `{"id":11,"name":"scanner"}`)
+		default:
+			http.NotFound(w, r)
+		}
+	},
+		`{"status":"PENDING","result":null,"related_document":null}`,
+		`{"status":"STARTED","result":null,"related_document":null}`,
+		`{"status":"SUCCESS","result":"Success","related_document":"42"}`,
+	)
+	paperless.Tags = []string{"Invoices", "scanner", "7"}
+	paperless.CreateMissing = true
+
+	result := paperless.Deliver(context.Background(), doc)
+	if result.Error != nil {
+		t.Fatalf("Deliver: %v", result.Error)
+	}
+	if !bytes.Equal(document, data) {
+		t.Error("uploaded document differs")
+	}
+	if len(title) != 1 || title[0] != "scan" {
+		t.Errorf("title %v, want scan", title)
+	}
+	// The existing tag is looked up, the other one created, the ID used as is
+	if got := strings.Join(tags, ","); got != "3,11,7" {
+		t.Errorf("tags %s, want 3,11,7", got)
+	}
+	if result.Message != "stored as document #42" {
+		t.Errorf("message %q", result.Message)
+	}
+	if want := strings.TrimRight(paperless.URL, "/") + "/documents/42/details"; result.Location != want {
+		t.Errorf("location %q, want %q", result.Location, want)
+	}
+}
+
+func TestPaperlessUnknownTag(t *testing.T) {
+	doc, _ := testDocument(t, 100)
+	var requests []string
+	paperless := testPaperless(t, func(w http.ResponseWriter, r *http.Request) {
+		requests = append(requests, r.Method+" "+r.URL.Path)
+		switch r.Method + " " + r.URL.Path {
+		case "GET /api/tags/":
+			io.WriteString(w, `{"results":[{"id":3,"name":"invoices-2024"}]}`)
+		default:
+			http.Error(w, "unexpected", http.StatusBadRequest)
+		}
+	}, `{"status":"SUCCESS","result":null,"related_document":"42"}`)
+	paperless.Tags = []string{"Invoices"}
+
+	result := paperless.Deliver(context.Background(), doc)
+	if result.Error == nil || !strings.Contains(result.Error.Error(), `tag "Invoices" doesn't exist`) {
+		t.Errorf("error %v, want the tag missing", result.Error)
+	}
+	// Neither the tag nor the document is created
+	if len(requests) != 1 {
+		t.Errorf("requests %v, want only the tag looked up", requests)
+	}
+}
+
+func TestPaperlessTaskFailure(t *testing.T) {
+	doc, _ := testDocument(t, 100)
+	paperless := testPaperless(t, func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, `"task-1"`)
+	}, `{"status":"FAILURE","result":"scan.pdf: Not consuming scan.pdf: It is a duplicate.","related_document":null}`)
+
+	result := paperless.Deliver(context.Background(), doc)
+	if result.Error == nil {
+		t.Fatal("Deliver succeeded, want the task failure")
+	}
+	if !strings.Contains(result.Error.Error(), "It is a duplicate") {
+		t.Errorf("error %q doesn't give the task result", result.Error)
+	}
+	// Retrying would upload the document again
+	if IsTemporary(result.Error) {
+		t.Error("task failure marked temporary")
+	}
+}
+
+func TestPaperlessServerErrorIsTemporary(t *testing.T) {
+	doc, _ := testDocument(t, 100)
+	paperless := testPaperless(t, func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "unavailable", http.StatusBadGateway)
+	}, `{"status":"SUCCESS","result":null,"related_document":"42"}`)
+
+	result := paperless.Deliver(context.Background(), doc)
+	if !IsTemporary(result.Error) {
+		t.Fatalf("error %v is not temporary", result.Error)
+	}
+
+	// Rejected tokens are not worth retrying
+	paperless.Token = "wrong"
+	result = paperless.Deliver(context.Background(), doc)
+	if result.Error == nil || IsTemporary(result.Error) {
+		t.Errorf("error %v for a wrong token, want a permanent one", result.Error)
+	}
+}
@@ -0,0 +1,327 @@
+package delivery
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/adrg/xdg"
+)
+
+// Retry schedule of queued deliveries
+const (
+	MaxAttempts      = 10               // Attempts before a job needs a manual retry
+	initialBackoff   = 30 * time.Second // Delay after the first failure
+	maxBackoff       = 6 * time.Hour    // Longest delay between attempts
+	staleLockTimeout = time.Hour        // Locks older than this are taken over, even if their PID was reused
+)
+
+// Job is a delivery waiting in the queue
+type Job struct {
+	ID          string
+	Target      string   // Name of the target, e.g. "WebDAV"
+	Profile     string   // Profile the target settings are read from
+	Recipients  []string // Email recipients
+	Document    Document
+	Attempts    int
+	Created     time.Time
+	NextAttempt time.Time
+	LastError   string
+	Failed      bool // Gave up retrying, a manual retry is needed
+}
+
+// Status describes the state of the job for display
+func (j Job) Status() string {
+	switch {
+	case j.Failed:
+		return "failed"
+	case j.Attempts == 0:
+		return "pending"
+	case time.Now().Before(j.NextAttempt):
+		return "retry in " + time.Until(j.NextAttempt).Round(time.Second).String()
+	}
+	return "retry due"
+}
+
+// Due reports whether the job should be attempted now
+func (j Job) Due(now time.Time) bool {
+	return !j.Failed && !now.Before(j.NextAttempt)
+}
+
+// Queue is a durable queue of deliveries, one JSON file per job. Jobs stay
+// on disk until they are delivered or purged, so that nothing is lost when
+// the network is down or the program is stopped. Nothing retries them while
+// no process is running, they are attempted again by the next one.
+type Queue struct {
+	Dir string
+}
+
+// DefaultQueue returns the queue in the user's state directory
+func DefaultQueue() *Queue {
+	return &Queue{Dir: filepath.Join(xdg.StateHome, "scanexpress", "queue")}
+}
+
+// Enqueue adds a delivery of the document to the queue
+func (q *Queue) Enqueue(target Target, doc Document, profile string) (Job, error) {
+	id := make([]byte, 4)
+	if _, err := rand.Read(id); err != nil {
+		return Job{}, err
+	}
+
+	now := time.Now()
+	job := Job{
+		ID:          now.Format("20060102-150405") + "-" + hex.EncodeToString(id),
+		Target:      target.Name(),
+		Profile:     profile,
+		Document:    doc,
+		Created:     now,
+		NextAttempt: now,
+	}
+	if email, ok := target.(*Email); ok {
+		job.Recipients = email.To
+	}
+
+	if err := q.save(job); err != nil {
+		return Job{}, fmt.Errorf("failed to queue delivery: %v", err)
+	}
+	return job, nil
+}
+
+// List returns the queued jobs, oldest first
+func (q *Queue) List() ([]Job, error) {
+	files, err := filepath.Glob(filepath.Join(q.Dir, "*.json"))
+	if err != nil {
+		return nil, err
+	}
+
+	jobs := make([]Job, 0, len(files))
+	for _, file := range files {
+		job, err := q.load(file)
+		if err != nil {
+			continue // Half-written or foreign file
+		}
+		jobs = append(jobs, job)
+	}
+	sort.Slice(jobs, func(i, j int) bool { return jobs[i].ID < jobs[j].ID })
+	return jobs, nil
+}
+
+// Find returns the jobs matching the given IDs or ID prefixes
+func (q *Queue) Find(ids []string) ([]Job, error) {
+	jobs, err := q.List()
+	if err != nil {
+		return nil, err
+	}
+
+	var found []Job
+	for _, id := range ids {
+		var matches []Job
+		for _, job := range jobs {
+			if strings.HasPrefix(job.ID, id) {
+				matches = append(matches, job)
+			}
+		}
+		switch len(matches) {
+		case 0:
+			return nil, fmt.Errorf("no queued delivery %q", id)
+		case 1:
+			found = append(found, matches[0])
+		default:
+			return nil, fmt.Errorf("%q matches %d queued deliveries", id, len(matches))
+		}
+	}
+	return found, nil
+}
+
+// Remove deletes a job from the queue
+func (q *Queue) Remove(id string) error {
+	err := os.Remove(q.path(id))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	return err
+}
+
+// Process attempts the given jobs, or all due jobs when jobs is nil.
+// Delivered jobs are removed; failed ones are rescheduled with exponential
+// backoff, or marked failed when the error is permanent or too many
+// attempts were made. Jobs being processed by another process are skipped.
+func (q *Queue) Process(ctx context.Context, jobs []Job, resolve func(Job) (Target, error)) []Result {
+	if jobs == nil {
+		all, err := q.List()
+		if err != nil {
+			return []Result{{Target: "Queue", Error: err}}
+		}
+		now := time.Now()
+		for _, job := range all {
+			if job.Due(now) {
+				jobs = append(jobs, job)
+			}
+		}
+	}
+
+	results := make([]Result, 0, len(jobs))
+	for _, job := range jobs {
+		if ctx.Err() != nil {
+			break
+		}
+
+		unlock, ok := q.lock(job.ID)
+		if !ok {
+			continue
+		}
+		result := q.attempt(ctx, job, resolve)
+		unlock()
+		results = append(results, result)
+	}
+	return results
+}
+
+// attempt delivers a single job and updates the queue
+func (q *Queue) attempt(ctx context.Context, job Job, resolve func(Job) (Target, error)) Result {
+	var result Result
+	target, err := resolve(job)
+	if err != nil {
+		result = Result{Target: job.Target, Error: err}
+	} else if _, err := os.Stat(job.Document.Path); err != nil {
+		result = Result{Target: job.Target, Error: fmt.Errorf("document is gone: %v", err)}
+	} else {
+		result = target.Deliver(ctx, job.Document)
+	}
+
+	result.JobID = job.ID
+	if result.Success() {
+		if err := q.Remove(job.ID); err != nil {
+			result.Error = fmt.Errorf("delivered, but failed to remove it from the queue: %v", err)
+		}
+		return result
+	}
+
+	job.Attempts++
+	job.LastError = result.Error.Error()
+	if !IsTemporary(result.Error) || job.Attempts >= MaxAttempts {
+		job.Failed = true
+	} else {
+		job.NextAttempt = time.Now().Add(Backoff(job.Attempts))
+	}
+	if err := q.save(job); err != nil {
+		result.Error = fmt.Errorf("%v (and failed to update the queue: %v)", result.Error, err)
+	}
+	return result
+}
+
+// Reset clears the failed state of a job so that it is attempted again
+func (q *Queue) Reset(job Job) (Job, error) {
+	job.Failed = false
+	job.NextAttempt = time.Now()
+	return job, q.save(job)
+}
+
+// Backoff returns the delay before the next attempt after a number of
+// failed attempts
+func Backoff(attempts int) time.Duration {
+	delay := initialBackoff
+	for i := 1; i < attempts && delay < maxBackoff; i++ {
+		delay *= 2
+	}
+	if delay > maxBackoff {
+		delay = maxBackoff
+	}
+	return delay
+}
+
+// save writes a job atomically
+func (q *Queue) save(job Job) error {
+	if err := os.MkdirAll(q.Dir, 0700); err != nil {
+		return err
+	}
+
+	data, err := json.MarshalIndent(job, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := q.path(job.ID) + ".tmp"
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, q.path(job.ID))
+}
+
+// load reads a job file
+func (q *Queue) load(file string) (Job, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return Job{}, err
+	}
+	var job Job
+	if err := json.Unmarshal(data, &job); err != nil {
+		return Job{}, err
+	}
+	return job, nil
+}
+
+// lock marks a job as being processed, returning false when another
+// process is already working on it. The lock holds the PID of the process,
+// so that the lock of a process that quit or crashed mid-delivery is taken
+// over right away.
+func (q *Queue) lock(id string) (func(), bool) {
+	lockPath := q.path(id) + ".lock"
+	for {
+		f, err := os.OpenFile(lockPath, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0600)
+		if err == nil {
+			fmt.Fprintf(f, "%d\n", os.Getpid())
+			f.Close()
+			return func() { os.Remove(lockPath) }, true
+		}
+
+		if !staleLock(lockPath) || os.Remove(lockPath) != nil {
+			return nil, false
+		}
+	}
+}
+
+// staleLock reports whether a lock was left by a process that is gone. A
+// lock without a PID, being written or left by an older version, is only
+// stale once it is old.
+func staleLock(path string) bool {
+	info, err := os.Stat(path)
+	if err != nil {
+		return false
+	}
+	if time.Since(info.ModTime()) >= staleLockTimeout {
+		return true
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return false
+	}
+	pid, err := strconv.Atoi(strings.TrimSpace(string(data)))
+	return err == nil && pid > 0 && !processRunning(pid)
+}
+
+// processRunning reports whether a process with the PID exists
+func processRunning(pid int) bool {
+	p, err := os.FindProcess(pid)
+	if err != nil {
+		return false
+	}
+	// Signal 0 only checks the process, which may belong to another user
+	err = p.Signal(syscall.Signal(0))
+	return err == nil || errors.Is(err, syscall.EPERM)
+}
+
+// path returns the file of a job
+func (q *Queue) path(id string) string {
+	return filepath.Join(q.Dir, id+".json")
+}
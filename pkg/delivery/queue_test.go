@@ -0,0 +1,75 @@
+package delivery
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"testing"
+	"time"
+)
+
+// countingTarget delivers every document and counts the deliveries
+type countingTarget struct {
+	delivered int
+}
+
+func (c *countingTarget) Name() string { return "Test" }
+
+func (c *countingTarget) Deliver(ctx context.Context, doc Document) Result {
+	c.delivered++
+	return Result{Target: c.Name(), Message: "delivered"}
+}
+
+// exitedPID returns the PID of a process that has exited
+func exitedPID(t *testing.T) int {
+	t.Helper()
+	cmd := exec.Command("true")
+	if err := cmd.Run(); err != nil {
+		t.Fatal(err)
+	}
+	return cmd.Process.Pid
+}
+
+func TestStaleLocks(t *testing.T) {
+	doc, _ := testDocument(t, 100)
+	target := &countingTarget{}
+	resolve := func(Job) (Target, error) { return target, nil }
+
+	for _, test := range []struct {
+		name    string
+		content string
+		age     time.Duration
+		stale   bool
+	}{
+		{"running process", fmt.Sprintf("%d\n", os.Getpid()), 0, false},
+		{"exited process", fmt.Sprintf("%d\n", exitedPID(t)), 0, true},
+		{"being written", "", 0, false},
+		{"old lock", "", staleLockTimeout + time.Minute, true},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			queue := &Queue{Dir: t.TempDir()}
+			job, err := queue.Enqueue(target, doc, "")
+			if err != nil {
+				t.Fatal(err)
+			}
+			lock := queue.path(job.ID) + ".lock"
+			if err := os.WriteFile(lock, []byte(test.content), 0600); err != nil {
+				t.Fatal(err)
+			}
+			modified := time.Now().Add(-test.age)
+			if err := os.Chtimes(lock, modified, modified); err != nil {
+				t.Fatal(err)
+			}
+
+			target.delivered = 0
+			queue.Process(context.Background(), nil, resolve)
+			if delivered := target.delivered == 1; delivered != test.stale {
+				t.Errorf("delivered %v, want %v", delivered, test.stale)
+			}
+			if _, err := os.Stat(lock); test.stale != os.IsNotExist(err) {
+				t.Errorf("lock left: %v", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,75 @@
+package delivery
+
+import (
+	"fmt"
+	"time"
+
+	"scanexpress/pkg/config"
+)
+
+// Targets returns the upload targets enabled in a profile. Email is not
+// included as its recipients are chosen for each document, see NewEmail.
+func Targets(profile config.Profile) []Target {
+	var targets []Target
+	if profile.Paperless.Enabled {
+		targets = append(targets, &Paperless{
+			URL:           profile.Paperless.URL,
+			Token:         profile.Paperless.Token,
+			Correspondent: profile.Paperless.Correspondent,
+			Tags:          profile.Paperless.Tags,
+			CreateMissing: profile.Paperless.CreateMissing,
+			Timeout:       time.Duration(profile.Paperless.Timeout) * time.Second,
+		})
+	}
+	if profile.WebDAV.Enabled {
+		targets = append(targets, &WebDAV{
+			URL:          profile.WebDAV.URL,
+			PathTemplate: profile.WebDAV.Path,
+			Username:     profile.WebDAV.Username,
+			Password:     profile.WebDAV.Password,
+		})
+	}
+	return targets
+}
+
+// NewEmail returns the email settings of a profile, or nil when email is
+// disabled
+func NewEmail(profile config.Profile) *Email {
+	if !profile.Email.Enabled {
+		return nil
+	}
+	return &Email{
+		Host:     profile.Email.Host,
+		Port:     profile.Email.Port,
+		Security: profile.Email.Security,
+		Username: profile.Email.Username,
+		Password: profile.Email.Password,
+		From:     profile.Email.From,
+		MaxSize:  int64(profile.Email.MaxSize) << 20,
+	}
+}
+
+// ResolveTarget rebuilds the target of a queued job from the current
+// configuration of its profile, so that no credentials are kept in the queue
+func ResolveTarget(cm *config.ConfigManager, job Job) (Target, error) {
+	profile, err := cm.GetProfile(job.Profile)
+	if err != nil {
+		return nil, err
+	}
+
+	if job.Target == (&Email{}).Name() {
+		email := NewEmail(profile)
+		if email == nil {
+			return nil, fmt.Errorf("email is no longer enabled in the configuration")
+		}
+		email.To = job.Recipients
+		return email, nil
+	}
+
+	for _, target := range Targets(profile) {
+		if target.Name() == job.Target {
+			return target, nil
+		}
+	}
+	return nil, fmt.Errorf("%s is no longer enabled in the configuration", job.Target)
+}
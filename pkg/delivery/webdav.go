@@ -0,0 +1,447 @@
+package delivery
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// WebDAV upload settings
+const (
+	DefaultPathTemplate = "{filename}"
+	webdavChunkSize     = 4 << 20         // Bytes sent per request, so that failed uploads can resume
+	nextcloudChunkSize  = 10 << 20        // Bytes per chunk of Nextcloud chunked uploads, at least 5 MB
+	webdavAttempts      = 4               // Attempts while the server is unreachable
+	webdavRetryDelay    = 2 * time.Second // Delay before the first retry, doubled after each attempt
+	uploadSuffix        = ".uploading"    // Suffix of partial uploads
+)
+
+// WebDAV uploads documents to a WebDAV server such as Nextcloud. Uploads go
+// in chunks to a temporary file, or to an upload folder on Nextcloud, and
+// resume where they stopped after a failure; the file is moved into place
+// once complete.
+type WebDAV struct {
+	URL          string // Base URL, e.g. "https://cloud.example.com/remote.php/dav/files/alice"
+	PathTemplate string // Path of the document below the base URL, e.g. "Scans/{year}/{filename}"
+	Username     string
+	Password     string       // Password or app password
+	Client       *http.Client // HTTP client, http.DefaultClient when nil
+	RetryDelay   time.Duration
+}
+
+// Name identifies the target in results
+func (w *WebDAV) Name() string {
+	return "WebDAV"
+}
+
+// Deliver uploads the document, retrying while the server is unreachable
+func (w *WebDAV) Deliver(ctx context.Context, doc Document) Result {
+	result := Result{Target: w.Name()}
+
+	remotePath, err := ExpandPathTemplate(w.PathTemplate, doc)
+	if err != nil {
+		result.Error = err
+		return result
+	}
+
+	delay := w.RetryDelay
+	if delay <= 0 {
+		delay = webdavRetryDelay
+	}
+	for attempt := 1; ; attempt++ {
+		err = w.upload(ctx, doc.Path, remotePath)
+		if err == nil || !IsTemporary(err) || attempt == webdavAttempts {
+			break
+		}
+
+		select {
+		case <-ctx.Done():
+			result.Error = err
+			return result
+		case <-time.After(delay):
+		}
+		delay *= 2
+	}
+	if err != nil {
+		result.Error = err
+		return result
+	}
+
+	result.Message = "uploaded to"
+	result.Location = w.endpoint(remotePath)
+	return result
+}
+
+// ExpandPathTemplate fills in the placeholders of a path template: {title},
+// {filename}, {profile}, {date}, {year}, {month}, {day} and {time}
+func ExpandPathTemplate(template string, doc Document) (string, error) {
+	if template == "" {
+		template = DefaultPathTemplate
+	}
+
+	created := doc.Created
+	if created.IsZero() {
+		created = time.Now()
+	}
+	profile := doc.Profile
+	if profile == "" {
+		profile = "default"
+	}
+
+	// Values must not add path segments of their own
+	clean := strings.NewReplacer("/", "_", "\\", "_")
+	expanded := strings.NewReplacer(
+		"{title}", clean.Replace(doc.Title),
+		"{filename}", clean.Replace(filepath.Base(doc.Path)),
+		"{profile}", clean.Replace(profile),
+		"{date}", created.Format("2006-01-02"),
+		"{year}", created.Format("2006"),
+		"{month}", created.Format("01"),
+		"{day}", created.Format("02"),
+		"{time}", created.Format("150405"),
+	).Replace(template)
+
+	expanded = path.Clean("/" + expanded)
+	if expanded == "/" || strings.HasSuffix(template, "/") {
+		return "", fmt.Errorf("path template %q does not name a file", template)
+	}
+	return expanded, nil
+}
+
+// upload sends the file to the remote path, resuming a previous partial
+// upload. Nextcloud gets the file in chunks through its chunked upload, other
+// servers through partial PUT requests.
+func (w *WebDAV) upload(ctx context.Context, localPath, remotePath string) error {
+	data, err := os.ReadFile(localPath)
+	if err != nil {
+		return err
+	}
+	total := int64(len(data))
+
+	if err := w.makeCollections(ctx, path.Dir(remotePath)); err != nil {
+		return err
+	}
+
+	if uploads, ok := w.nextcloudUploads(); ok {
+		err = w.uploadChunks(ctx, uploads, data, remotePath)
+	} else {
+		err = w.uploadPart(ctx, data, remotePath)
+	}
+	if err != nil {
+		return err
+	}
+
+	size, exists, err := w.size(ctx, w.endpoint(remotePath))
+	if err != nil {
+		return err
+	}
+	if !exists || size != total {
+		return fmt.Errorf("uploaded file has %d bytes, expected %d", size, total)
+	}
+	return nil
+}
+
+// uploadPart sends the file to a temporary file next to the remote path with
+// partial PUT requests, then moves it into place
+func (w *WebDAV) uploadPart(ctx context.Context, data []byte, remotePath string) error {
+	total := int64(len(data))
+	partURL := w.endpoint(remotePath + uploadSuffix)
+	offset, exists, err := w.size(ctx, partURL)
+	if err != nil {
+		return err
+	}
+	if !exists || offset > total {
+		offset = 0
+	}
+
+	for offset < total || total == 0 {
+		end := min(offset+webdavChunkSize, total)
+
+		header := http.Header{}
+		if offset > 0 {
+			header.Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", offset, end-1, total))
+		}
+		resp, err := w.request(ctx, http.MethodPut, partURL, header, data[offset:end])
+		if err != nil {
+			return err
+		}
+		io.Copy(io.Discard, io.LimitReader(resp.Body, 1<<20))
+		resp.Body.Close()
+
+		switch resp.StatusCode {
+		case http.StatusOK, http.StatusCreated, http.StatusNoContent:
+		case http.StatusBadRequest, http.StatusNotImplemented:
+			// RFC 7231 lets servers refuse a PUT with a Content-Range, the
+			// file is then sent in one piece
+			if offset == 0 {
+				return statusError(resp)
+			}
+			return w.putWhole(ctx, partURL, data, remotePath)
+		default:
+			return statusError(resp)
+		}
+		if total == 0 {
+			break
+		}
+
+		// Servers that ignore Content-Range replace the file with the chunk,
+		// in which case the file is sent in one piece too
+		if offset > 0 {
+			size, _, err := w.size(ctx, partURL)
+			if err != nil {
+				return err
+			}
+			if size != end {
+				return w.putWhole(ctx, partURL, data, remotePath)
+			}
+		}
+		offset = end
+	}
+	return w.moveIntoPlace(ctx, partURL, remotePath, nil)
+}
+
+// putWhole sends the whole file to the temporary file and moves it into place
+func (w *WebDAV) putWhole(ctx context.Context, partURL string, data []byte, remotePath string) error {
+	if err := w.do(ctx, http.MethodPut, partURL, nil, data, http.StatusOK, http.StatusCreated, http.StatusNoContent); err != nil {
+		return err
+	}
+	return w.moveIntoPlace(ctx, partURL, remotePath, nil)
+}
+
+// nextcloudUploads returns the URL of the chunked upload folders of the
+// user when the base URL is the files folder of a Nextcloud user, e.g.
+// https://cloud.example.com/remote.php/dav/files/alice
+func (w *WebDAV) nextcloudUploads() (string, bool) {
+	const files = "/remote.php/dav/files/"
+	base := strings.TrimRight(w.URL, "/")
+	i := strings.Index(base, files)
+	if i < 0 {
+		return "", false
+	}
+	user := base[i+len(files):]
+	if user == "" || strings.Contains(user, "/") {
+		return "", false
+	}
+	return base[:i] + "/remote.php/dav/uploads/" + user, true
+}
+
+// uploadChunks sends the file with Nextcloud's chunked upload (v2): numbered
+// chunks go to an upload folder, then its .file is moved to the remote path,
+// which assembles them. The folder is named after the remote path and the
+// content, so that a later attempt only sends the chunks that are missing.
+func (w *WebDAV) uploadChunks(ctx context.Context, uploads string, data []byte, remotePath string) error {
+	sum := sha256.Sum256(append([]byte(remotePath+"\x00"), data...))
+	folder := uploads + "/scanexpress-" + hex.EncodeToString(sum[:16])
+	header := http.Header{}
+	header.Set("Destination", w.endpoint(remotePath))
+
+	sent, exists, err := w.list(ctx, folder+"/")
+	if err != nil {
+		return err
+	}
+	if !exists {
+		// 405 means the folder was created in the meantime
+		if err := w.do(ctx, "MKCOL", folder+"/", header, nil, http.StatusCreated, http.StatusMethodNotAllowed); err != nil {
+			return err
+		}
+	}
+
+	total := int64(len(data))
+	chunks := max((total+nextcloudChunkSize-1)/nextcloudChunkSize, 1)
+	for i := int64(0); i < chunks; i++ {
+		start, end := i*nextcloudChunkSize, min((i+1)*nextcloudChunkSize, total)
+		name := fmt.Sprintf("%05d", i+1)
+		if size, ok := sent[name]; ok && size == end-start {
+			continue
+		}
+		if err := w.do(ctx, http.MethodPut, folder+"/"+name, header, data[start:end], http.StatusCreated, http.StatusNoContent); err != nil {
+			return err
+		}
+	}
+
+	move := http.Header{}
+	move.Set("OC-Total-Length", strconv.FormatInt(total, 10))
+	return w.moveIntoPlace(ctx, folder+"/.file", remotePath, move)
+}
+
+// moveIntoPlace moves an uploaded file to the remote path
+func (w *WebDAV) moveIntoPlace(ctx context.Context, from, remotePath string, header http.Header) error {
+	if header == nil {
+		header = http.Header{}
+	}
+	header.Set("Destination", w.endpoint(remotePath))
+	header.Set("Overwrite", "T")
+	if err := w.do(ctx, "MOVE", from, header, nil, http.StatusCreated, http.StatusNoContent); err != nil {
+		return fmt.Errorf("failed to move the upload into place: %v", err)
+	}
+	return nil
+}
+
+// makeCollections creates the collections of a directory path that don't exist yet
+func (w *WebDAV) makeCollections(ctx context.Context, dir string) error {
+	if dir == "/" || dir == "." {
+		return nil
+	}
+	if _, exists, err := w.size(ctx, w.endpoint(dir+"/")); err != nil || exists {
+		return err
+	}
+	if err := w.makeCollections(ctx, path.Dir(dir)); err != nil {
+		return err
+	}
+
+	// 405 means the collection was created in the meantime
+	return w.do(ctx, "MKCOL", w.endpoint(dir+"/"), nil, nil, http.StatusCreated, http.StatusMethodNotAllowed)
+}
+
+// propfindResponse is the part of a PROPFIND multistatus response we use
+type propfindResponse struct {
+	Responses []struct {
+		Href          string `xml:"href"`
+		ContentLength string `xml:"propstat>prop>getcontentlength"`
+	} `xml:"response"`
+}
+
+// propfind returns the sizes of a resource, and of its members with depth
+// 1, and whether it exists
+func (w *WebDAV) propfind(ctx context.Context, target, depth string) (propfindResponse, bool, error) {
+	body := []byte(`<?xml version="1.0" encoding="utf-8"?><d:propfind xmlns:d="DAV:"><d:prop><d:getcontentlength/></d:prop></d:propfind>`)
+	header := http.Header{}
+	header.Set("Depth", depth)
+	header.Set("Content-Type", "application/xml")
+
+	var multistatus propfindResponse
+	resp, err := w.request(ctx, "PROPFIND", target, header, body)
+	if err != nil {
+		return multistatus, false, err
+	}
+	defer resp.Body.Close()
+
+	switch {
+	case resp.StatusCode == http.StatusNotFound:
+		return multistatus, false, nil
+	case resp.StatusCode != http.StatusMultiStatus:
+		return multistatus, false, statusError(resp)
+	}
+
+	if err := xml.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(&multistatus); err != nil {
+		return multistatus, false, fmt.Errorf("malformed PROPFIND response: %v", err)
+	}
+	return multistatus, true, nil
+}
+
+// size returns the size of a remote file and whether it exists
+func (w *WebDAV) size(ctx context.Context, target string) (int64, bool, error) {
+	multistatus, exists, err := w.propfind(ctx, target, "0")
+	if err != nil || !exists {
+		return 0, false, err
+	}
+	var size int64
+	if len(multistatus.Responses) > 0 {
+		size, _ = strconv.ParseInt(multistatus.Responses[0].ContentLength, 10, 64)
+	}
+	return size, true, nil
+}
+
+// list returns the sizes of the files in a remote folder by name, and
+// whether the folder exists
+func (w *WebDAV) list(ctx context.Context, folder string) (map[string]int64, bool, error) {
+	multistatus, exists, err := w.propfind(ctx, folder, "1")
+	if err != nil || !exists {
+		return nil, false, err
+	}
+	files := make(map[string]int64)
+	for _, r := range multistatus.Responses {
+		if strings.HasSuffix(r.Href, "/") {
+			continue // The folder itself
+		}
+		name := path.Base(r.Href)
+		if unescaped, err := url.PathUnescape(name); err == nil {
+			name = unescaped
+		}
+		files[name], _ = strconv.ParseInt(r.ContentLength, 10, 64)
+	}
+	return files, true, nil
+}
+
+// do sends a request and checks that the response has one of the expected statuses
+func (w *WebDAV) do(ctx context.Context, method, target string, header http.Header, body []byte, expected ...int) error {
+	resp, err := w.request(ctx, method, target, header, body)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 1<<20))
+
+	for _, status := range expected {
+		if resp.StatusCode == status {
+			return nil
+		}
+	}
+	return statusError(resp)
+}
+
+// request sends an authenticated request to a URL. Network failures and
+// server errors are temporary.
+func (w *WebDAV) request(ctx context.Context, method, target string, header http.Header, body []byte) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, method, target, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	for key, values := range header {
+		req.Header[key] = values
+	}
+	if w.Username != "" || w.Password != "" {
+		req.SetBasicAuth(w.Username, w.Password)
+	}
+
+	client := w.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		var netErr net.Error
+		var urlErr *url.Error
+		if errors.As(err, &netErr) || errors.As(err, &urlErr) {
+			return nil, &TemporaryError{Err: fmt.Errorf("server unreachable: %v", err)}
+		}
+		return nil, err
+	}
+	return resp, nil
+}
+
+// endpoint returns the URL of a remote path
+func (w *WebDAV) endpoint(remotePath string) string {
+	segments := strings.Split(remotePath, "/")
+	for i, segment := range segments {
+		segments[i] = url.PathEscape(segment)
+	}
+	return strings.TrimRight(w.URL, "/") + strings.Join(segments, "/")
+}
+
+// statusError describes an unexpected response, marking server errors as
+// temporary
+func statusError(resp *http.Response) error {
+	err := fmt.Errorf("%s %s: %s", resp.Request.Method, resp.Request.URL.Path, resp.Status)
+	switch {
+	case resp.StatusCode == http.StatusUnauthorized || resp.StatusCode == http.StatusForbidden:
+		return fmt.Errorf("%v (check the username and password)", err)
+	case resp.StatusCode >= 500 || resp.StatusCode == http.StatusTooManyRequests:
+		return &TemporaryError{Err: err}
+	}
+	return err
+}
@@ -0,0 +1,241 @@
+package delivery
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"maps"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+// davServer is an in-memory WebDAV server with Nextcloud's chunked uploads
+type davServer struct {
+	files     map[string][]byte // Content of the files by path
+	dirs      map[string]bool   // Collections, without a trailing slash
+	noRanges  bool              // Whether PUT requests with a Content-Range are refused
+	failChunk string            // Nextcloud chunk whose next PUT fails with 503
+	requests  []string          // Method, path and Content-Range of each request
+}
+
+func (d *davServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	p := strings.TrimSuffix(r.URL.Path, "/")
+	d.requests = append(d.requests, strings.TrimSpace(r.Method+" "+p+" "+r.Header.Get("Content-Range")))
+	body, _ := io.ReadAll(r.Body)
+
+	switch r.Method {
+	case "PROPFIND":
+		if !d.dirs[p] && d.files[p] == nil {
+			http.NotFound(w, r)
+			return
+		}
+		w.WriteHeader(http.StatusMultiStatus)
+		io.WriteString(w, `<?xml version="1.0"?><d:multistatus xmlns:d="DAV:">`)
+		entry := func(href string, size int) {
+			fmt.Fprintf(w, `<d:response><d:href>%s</d:href><d:propstat><d:prop><d:getcontentlength>%d</d:getcontentlength></d:prop></d:propstat></d:response>`, href, size)
+		}
+		entry(r.URL.Path, len(d.files[p]))
+		if d.dirs[p] && r.Header.Get("Depth") == "1" {
+			for name, content := range d.files {
+				if path.Dir(name) == p {
+					entry(name, len(content))
+				}
+			}
+		}
+		io.WriteString(w, `</d:multistatus>`)
+
+	case "MKCOL":
+		switch {
+		case d.dirs[p] || d.files[p] != nil:
+			w.WriteHeader(http.StatusMethodNotAllowed)
+		case !d.dirs[path.Dir(p)]:
+			w.WriteHeader(http.StatusConflict)
+		default:
+			d.dirs[p] = true
+			w.WriteHeader(http.StatusCreated)
+		}
+
+	case http.MethodPut:
+		if !d.dirs[path.Dir(p)] {
+			w.WriteHeader(http.StatusConflict)
+			return
+		}
+		if path.Base(p) == d.failChunk {
+			d.failChunk = ""
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		if d.noRanges && r.Header.Get("Content-Range") != "" {
+			// As SabreDAV does, following RFC 7231
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		var start int
+		if _, err := fmt.Sscanf(r.Header.Get("Content-Range"), "bytes %d-", &start); err != nil {
+			d.files[p] = body
+		} else if start != len(d.files[p]) {
+			w.WriteHeader(http.StatusRequestedRangeNotSatisfiable)
+			return
+		} else {
+			d.files[p] = append(d.files[p], body...)
+		}
+		w.WriteHeader(http.StatusCreated)
+
+	case "MOVE":
+		destination, err := url.Parse(r.Header.Get("Destination"))
+		if err != nil || !d.dirs[path.Dir(destination.Path)] {
+			w.WriteHeader(http.StatusConflict)
+			return
+		}
+		if path.Base(p) == ".file" {
+			// Assemble the chunks of a Nextcloud upload folder
+			folder := path.Dir(p)
+			var names []string
+			for name := range d.files {
+				if path.Dir(name) == folder {
+					names = append(names, name)
+				}
+			}
+			sort.Strings(names)
+			var content []byte
+			for _, name := range names {
+				content = append(content, d.files[name]...)
+				delete(d.files, name)
+			}
+			delete(d.dirs, folder)
+			p = folder
+			d.files[p] = content
+		}
+		if d.files[p] == nil {
+			w.WriteHeader(http.StatusConflict)
+			return
+		}
+		d.files[destination.Path] = d.files[p]
+		delete(d.files, p)
+		w.WriteHeader(http.StatusCreated)
+
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+// count returns how many requests were the request given
+func (d *davServer) count(request string) int {
+	n := 0
+	for _, r := range d.requests {
+		if r == request {
+			n++
+		}
+	}
+	return n
+}
+
+func TestWebDAVCreatesCollections(t *testing.T) {
+	dav := &davServer{files: map[string][]byte{}, dirs: map[string]bool{"": true, "/dav": true}}
+	server := httptest.NewServer(dav)
+	defer server.Close()
+	doc, data := testDocument(t, 1000)
+	doc.Created = time.Date(2025, 3, 14, 9, 26, 53, 0, time.UTC)
+	w := &WebDAV{URL: server.URL + "/dav", PathTemplate: "Scans/{year}/{month}/{filename}"}
+
+	result := w.Deliver(context.Background(), doc)
+	if result.Error != nil {
+		t.Fatalf("Deliver: %v", result.Error)
+	}
+	for _, dir := range []string{"/dav/Scans", "/dav/Scans/2025", "/dav/Scans/2025/03"} {
+		if dav.count("MKCOL "+dir) != 1 {
+			t.Errorf("%s was not created once: %v", dir, dav.requests)
+		}
+	}
+	if !bytes.Equal(dav.files["/dav/Scans/2025/03/scan.pdf"], data) {
+		t.Error("uploaded file differs from the document")
+	}
+	if dav.count("MOVE /dav/Scans/2025/03/scan.pdf"+uploadSuffix) != 1 {
+		t.Errorf("the partial upload was not moved into place: %v", dav.requests)
+	}
+	if want := server.URL + "/dav/Scans/2025/03/scan.pdf"; result.Location != want {
+		t.Errorf("location %q, want %q", result.Location, want)
+	}
+}
+
+func TestWebDAVResumesPartialUpload(t *testing.T) {
+	doc, data := testDocument(t, webdavChunkSize+1000)
+	part := "/dav/scan.pdf" + uploadSuffix
+	dav := &davServer{
+		files: map[string][]byte{part: bytes.Clone(data[:webdavChunkSize])},
+		dirs:  map[string]bool{"": true, "/dav": true},
+	}
+	server := httptest.NewServer(dav)
+	defer server.Close()
+	w := &WebDAV{URL: server.URL + "/dav"}
+
+	if result := w.Deliver(context.Background(), doc); result.Error != nil {
+		t.Fatalf("Deliver: %v", result.Error)
+	}
+	// Only the rest of the file is sent
+	resumed := fmt.Sprintf("PUT %s bytes %d-%d/%d", part, webdavChunkSize, len(data)-1, len(data))
+	if dav.count(resumed) != 1 || dav.count("PUT "+part) != 0 {
+		t.Errorf("requests %v, want only %q", dav.requests, resumed)
+	}
+	if !bytes.Equal(dav.files["/dav/scan.pdf"], data) {
+		t.Error("uploaded file differs from the document")
+	}
+}
+
+func TestWebDAVContentRangeRefused(t *testing.T) {
+	dav := &davServer{files: map[string][]byte{}, dirs: map[string]bool{"": true, "/dav": true}, noRanges: true}
+	server := httptest.NewServer(dav)
+	defer server.Close()
+	doc, data := testDocument(t, webdavChunkSize+1000)
+	w := &WebDAV{URL: server.URL + "/dav"}
+
+	if result := w.Deliver(context.Background(), doc); result.Error != nil {
+		t.Fatalf("Deliver: %v", result.Error)
+	}
+	// The first chunk, then the whole file
+	if n := dav.count("PUT /dav/scan.pdf" + uploadSuffix); n != 2 {
+		t.Errorf("%d PUTs without Content-Range, want 2: %v", n, dav.requests)
+	}
+	if !bytes.Equal(dav.files["/dav/scan.pdf"], data) {
+		t.Error("uploaded file differs from the document")
+	}
+}
+
+func TestWebDAVNextcloudChunkedUpload(t *testing.T) {
+	files := "/remote.php/dav/files/alice"
+	dav := &davServer{files: map[string][]byte{}, dirs: map[string]bool{}}
+	for dir := files; dir != "/"; dir = path.Dir(dir) {
+		dav.dirs[dir] = true
+	}
+	dav.dirs["/remote.php/dav/uploads"] = true
+	dav.dirs["/remote.php/dav/uploads/alice"] = true
+	server := httptest.NewServer(dav)
+	defer server.Close()
+	doc, data := testDocument(t, 2*nextcloudChunkSize+1000)
+	w := &WebDAV{URL: server.URL + files + "/", PathTemplate: "Scans/{filename}", RetryDelay: time.Millisecond}
+
+	// The second chunk fails once, the retry only sends the missing chunks
+	dav.failChunk = "00002"
+	if result := w.Deliver(context.Background(), doc); result.Error != nil {
+		t.Fatalf("Deliver: %v", result.Error)
+	}
+	puts := map[string]int{}
+	for _, request := range dav.requests {
+		if strings.HasPrefix(request, "PUT ") {
+			puts[path.Base(request)]++
+		}
+	}
+	if want := map[string]int{"00001": 1, "00002": 2, "00003": 1}; !maps.Equal(puts, want) {
+		t.Errorf("PUTs %v, want %v", puts, want)
+	}
+	if !bytes.Equal(dav.files[files+"/Scans/scan.pdf"], data) {
+		t.Error("assembled file differs from the document")
+	}
+}
@@ -0,0 +1,321 @@
+// Package escl implements a client of eSCL (AirScan), the driverless
+// scanning protocol of network scanners and multifunction printers
+package escl
+
+import (
+	"bytes"
+	"crypto/tls"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+)
+
+// XML namespaces of eSCL documents
+const (
+	namespaceScan = "http://schemas.hp.com/imaging/escl/2011/05/03"
+	namespacePWG  = "http://www.pwg.org/schemas/2010/12/sm"
+)
+
+// Input sources of a scan job
+const (
+	SourcePlaten = "Platen"
+	SourceFeeder = "Feeder"
+)
+
+// Color modes of a scan job
+const (
+	ColorModeRGB       = "RGB24"
+	ColorModeGray      = "Grayscale8"
+	ColorModeBlackDots = "BlackAndWhite1"
+)
+
+// ErrNoMoreDocuments is returned by NextDocument once every page of a job
+// has been read
+var ErrNoMoreDocuments = errors.New("no more documents")
+
+// busyRetries and busyDelay bound how long a busy scanner is waited for
+const busyRetries = 60
+
+var busyDelay = time.Second
+
+// Client talks to the eSCL service of a scanner
+type Client struct {
+	BaseURL string // Root of the service, e.g. "http://printer.local/eSCL"
+	HTTP    *http.Client
+}
+
+// NewClient returns a client of the eSCL service at the base URL. Scanners
+// serve HTTPS with self-signed certificates, so these are not verified.
+func NewClient(baseURL string) *Client {
+	return &Client{
+		BaseURL: strings.TrimSuffix(baseURL, "/"),
+		HTTP: &http.Client{
+			Timeout: 2 * time.Minute,
+			Transport: &http.Transport{
+				Proxy:           http.ProxyFromEnvironment,
+				TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
+			},
+		},
+	}
+}
+
+// InputCaps describes what an input source supports
+type InputCaps struct {
+	MinWidth    int // In 1/300 inch
+	MaxWidth    int
+	MinHeight   int
+	MaxHeight   int
+	ColorModes  []string
+	Formats     []string // Document formats, e.g. "image/jpeg"
+	Resolutions []int    // Discrete resolutions in DPI
+	MinRes      int      // Resolution range, when resolutions are not discrete
+	MaxRes      int
+}
+
+// Capabilities describes the scanner
+type Capabilities struct {
+	Version      string
+	MakeAndModel string
+	Platen       *InputCaps
+	Feeder       *InputCaps // Single-sided feeder input
+	Duplex       *InputCaps // Double-sided feeder input
+}
+
+// xmlInputCaps is the XML form of InputCaps
+type xmlInputCaps struct {
+	MinWidth        int `xml:"MinWidth"`
+	MaxWidth        int `xml:"MaxWidth"`
+	MinHeight       int `xml:"MinHeight"`
+	MaxHeight       int `xml:"MaxHeight"`
+	SettingProfiles []struct {
+		ColorModes  []string `xml:"ColorModes>ColorMode"`
+		Formats     []string `xml:"DocumentFormats>DocumentFormat"`
+		FormatsExt  []string `xml:"DocumentFormats>DocumentFormatExt"`
+		Resolutions []struct {
+			X int `xml:"XResolution"`
+		} `xml:"SupportedResolutions>DiscreteResolutions>DiscreteResolution"`
+		Range struct {
+			Min int `xml:"Min"`
+			Max int `xml:"Max"`
+		} `xml:"SupportedResolutions>XResolutionRange"`
+	} `xml:"SettingProfiles>SettingProfile"`
+}
+
+// convert merges the setting profiles of the input
+func (x *xmlInputCaps) convert() *InputCaps {
+	if x == nil {
+		return nil
+	}
+
+	caps := &InputCaps{
+		MinWidth:  x.MinWidth,
+		MaxWidth:  x.MaxWidth,
+		MinHeight: x.MinHeight,
+		MaxHeight: x.MaxHeight,
+	}
+	for _, p := range x.SettingProfiles {
+		caps.ColorModes = appendUnique(caps.ColorModes, p.ColorModes...)
+		caps.Formats = appendUnique(caps.Formats, p.Formats...)
+		caps.Formats = appendUnique(caps.Formats, p.FormatsExt...)
+		for _, r := range p.Resolutions {
+			if !slices.Contains(caps.Resolutions, r.X) {
+				caps.Resolutions = append(caps.Resolutions, r.X)
+			}
+		}
+		if p.Range.Max > caps.MaxRes {
+			caps.MinRes, caps.MaxRes = p.Range.Min, p.Range.Max
+		}
+	}
+	return caps
+}
+
+// Capabilities reads the capabilities of the scanner
+func (c *Client) Capabilities() (Capabilities, error) {
+	resp, err := c.HTTP.Get(c.BaseURL + "/ScannerCapabilities")
+	if err != nil {
+		return Capabilities{}, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return Capabilities{}, statusError("reading the scanner capabilities", resp)
+	}
+
+	var doc struct {
+		Version      string        `xml:"Version"`
+		MakeAndModel string        `xml:"MakeAndModel"`
+		Platen       *xmlInputCaps `xml:"Platen>PlatenInputCaps"`
+		Feeder       *xmlInputCaps `xml:"Adf>AdfSimplexInputCaps"`
+		Duplex       *xmlInputCaps `xml:"Adf>AdfDuplexInputCaps"`
+	}
+	if err := xml.NewDecoder(resp.Body).Decode(&doc); err != nil {
+		return Capabilities{}, fmt.Errorf("invalid scanner capabilities: %v", err)
+	}
+
+	return Capabilities{
+		Version:      doc.Version,
+		MakeAndModel: doc.MakeAndModel,
+		Platen:       doc.Platen.convert(),
+		Feeder:       doc.Feeder.convert(),
+		Duplex:       doc.Duplex.convert(),
+	}, nil
+}
+
+// Settings are the settings of a scan job
+type Settings struct {
+	Version    string // eSCL version from the capabilities, "2.0" when empty
+	Source     string // SourcePlaten or SourceFeeder
+	Duplex     bool
+	ColorMode  string
+	Resolution int
+	Format     string // Document format, e.g. "image/png"
+	Width      int    // Scan region in 1/300 inch
+	Height     int
+	XOffset    int // Position of the scan region from the top-left corner, in 1/300 inch
+	YOffset    int
+}
+
+// Job is a scan job on the scanner
+type Job struct {
+	URL    string
+	client *Client
+}
+
+// Scan creates a scan job. Its pages are read with NextDocument.
+func (c *Client) Scan(s Settings) (*Job, error) {
+	version := s.Version
+	if version == "" {
+		version = "2.0"
+	}
+
+	var b bytes.Buffer
+	b.WriteString(xml.Header)
+	fmt.Fprintf(&b, `<scan:ScanSettings xmlns:scan="%s" xmlns:pwg="%s">`, namespaceScan, namespacePWG)
+	fmt.Fprintf(&b, "<pwg:Version>%s</pwg:Version>", escape(version))
+	b.WriteString("<scan:Intent>Document</scan:Intent>")
+	fmt.Fprintf(&b,
+		"<pwg:ScanRegions><pwg:ScanRegion><pwg:Height>%d</pwg:Height><pwg:ContentRegionUnits>escl:ThreeHundredthsOfInches</pwg:ContentRegionUnits><pwg:Width>%d</pwg:Width><pwg:XOffset>%d</pwg:XOffset><pwg:YOffset>%d</pwg:YOffset></pwg:ScanRegion></pwg:ScanRegions>",
+		s.Height, s.Width, s.XOffset, s.YOffset,
+	)
+	fmt.Fprintf(&b, "<pwg:InputSource>%s</pwg:InputSource>", escape(s.Source))
+	if s.Source == SourceFeeder {
+		fmt.Fprintf(&b, "<scan:Duplex>%t</scan:Duplex>", s.Duplex)
+	}
+	fmt.Fprintf(&b, "<scan:ColorMode>%s</scan:ColorMode>", escape(s.ColorMode))
+	fmt.Fprintf(&b, "<scan:XResolution>%d</scan:XResolution><scan:YResolution>%d</scan:YResolution>", s.Resolution, s.Resolution)
+	fmt.Fprintf(&b, "<pwg:DocumentFormat>%s</pwg:DocumentFormat>", escape(s.Format))
+	fmt.Fprintf(&b, "<scan:DocumentFormatExt>%s</scan:DocumentFormatExt>", escape(s.Format))
+	b.WriteString("</scan:ScanSettings>")
+
+	resp, err := c.retryBusy(func() (*http.Response, error) {
+		return c.HTTP.Post(c.BaseURL+"/ScanJobs", "text/xml", bytes.NewReader(b.Bytes()))
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusCreated {
+		return nil, statusError("creating the scan job", resp)
+	}
+
+	// The job location may be relative to the service
+	location, err := resp.Location()
+	if err != nil {
+		return nil, fmt.Errorf("the scanner did not return the scan job: %v", err)
+	}
+	return &Job{URL: strings.TrimSuffix(location.String(), "/"), client: c}, nil
+}
+
+// NextDocument returns the next scanned page of the job, or
+// ErrNoMoreDocuments once all pages were read
+func (j *Job) NextDocument() ([]byte, error) {
+	resp, err := j.client.retryBusy(func() (*http.Response, error) {
+		return j.client.HTTP.Get(j.URL + "/NextDocument")
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+		data, err := io.ReadAll(resp.Body)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read the scanned page: %v", err)
+		}
+		return data, nil
+	case http.StatusNotFound:
+		return nil, ErrNoMoreDocuments
+	}
+	return nil, statusError("reading the scanned page", resp)
+}
+
+// Cancel deletes the job, stopping the scanner when pages are left
+func (j *Job) Cancel() error {
+	req, err := http.NewRequest(http.MethodDelete, j.URL, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := j.client.HTTP.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+// retryBusy sends a request again while the scanner answers that it is busy,
+// as it does while warming up or moving paper
+func (c *Client) retryBusy(send func() (*http.Response, error)) (*http.Response, error) {
+	for attempt := 0; ; attempt++ {
+		resp, err := send()
+		if err != nil {
+			return nil, err
+		}
+		if resp.StatusCode != http.StatusServiceUnavailable || attempt == busyRetries {
+			return resp, nil
+		}
+		resp.Body.Close()
+		time.Sleep(busyDelay)
+	}
+}
+
+// statusError describes an unexpected HTTP status
+func statusError(action string, resp *http.Response) error {
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+	message := strings.TrimSpace(string(body))
+	if message == "" || strings.HasPrefix(message, "<") {
+		return fmt.Errorf("%s failed: %s", action, resp.Status)
+	}
+	return fmt.Errorf("%s failed: %s: %s", action, resp.Status, message)
+}
+
+// Host returns the host of a service URL, for display
+func Host(baseURL string) string {
+	u, err := url.Parse(baseURL)
+	if err != nil || u.Host == "" {
+		return baseURL
+	}
+	return u.Hostname()
+}
+
+// escape escapes text for use in XML
+func escape(s string) string {
+	var b strings.Builder
+	xml.EscapeText(&b, []byte(s))
+	return b.String()
+}
+
+func appendUnique(list []string, values ...string) []string {
+	for _, v := range values {
+		if v != "" && !slices.Contains(list, v) {
+			list = append(list, v)
+		}
+	}
+	return list
+}
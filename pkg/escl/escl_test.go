@@ -0,0 +1,228 @@
+package escl
+
+import (
+	"bytes"
+	"encoding/xml"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+const testCapabilities = `<?xml version="1.0" encoding="UTF-8"?>
+<scan:ScannerCapabilities xmlns:scan="http://schemas.hp.com/imaging/escl/2011/05/03" xmlns:pwg="http://www.pwg.org/schemas/2010/12/sm">
+  <pwg:Version>2.63</pwg:Version>
+  <pwg:MakeAndModel>Acme Frobnicator 3000</pwg:MakeAndModel>
+  <scan:Platen>
+    <scan:PlatenInputCaps>
+      <scan:MinWidth>16</scan:MinWidth>
+      <scan:MaxWidth>2550</scan:MaxWidth>
+      <scan:MinHeight>16</scan:MinHeight>
+      <scan:MaxHeight>3508</scan:MaxHeight>
+      <scan:SettingProfiles>
+        <scan:SettingProfile>
+          <scan:ColorModes>
+            <scan:ColorMode>RGB24</scan:ColorMode>
+            <scan:ColorMode>Grayscale8</scan:ColorMode>
+          </scan:ColorModes>
+          <scan:DocumentFormats>
+            <pwg:DocumentFormat>image/jpeg</pwg:DocumentFormat>
+            <scan:DocumentFormatExt>application/pdf</scan:DocumentFormatExt>
+          </scan:DocumentFormats>
+          <scan:SupportedResolutions>
+            <scan:DiscreteResolutions>
+              <scan:DiscreteResolution><scan:XResolution>150</scan:XResolution><scan:YResolution>150</scan:YResolution></scan:DiscreteResolution>
+              <scan:DiscreteResolution><scan:XResolution>300</scan:XResolution><scan:YResolution>300</scan:YResolution></scan:DiscreteResolution>
+            </scan:DiscreteResolutions>
+          </scan:SupportedResolutions>
+        </scan:SettingProfile>
+        <scan:SettingProfile>
+          <scan:ColorModes><scan:ColorMode>BlackAndWhite1</scan:ColorMode></scan:ColorModes>
+          <scan:DocumentFormats><pwg:DocumentFormat>image/jpeg</pwg:DocumentFormat></scan:DocumentFormats>
+          <scan:SupportedResolutions>
+            <scan:DiscreteResolutions>
+              <scan:DiscreteResolution><scan:XResolution>600</scan:XResolution><scan:YResolution>600</scan:YResolution></scan:DiscreteResolution>
+            </scan:DiscreteResolutions>
+          </scan:SupportedResolutions>
+        </scan:SettingProfile>
+      </scan:SettingProfiles>
+    </scan:PlatenInputCaps>
+  </scan:Platen>
+  <scan:Adf>
+    <scan:AdfDuplexInputCaps>
+      <scan:MaxWidth>2550</scan:MaxWidth>
+      <scan:MaxHeight>4200</scan:MaxHeight>
+      <scan:SettingProfiles>
+        <scan:SettingProfile>
+          <scan:ColorModes><scan:ColorMode>RGB24</scan:ColorMode></scan:ColorModes>
+          <scan:DocumentFormats><pwg:DocumentFormat>image/png</pwg:DocumentFormat></scan:DocumentFormats>
+          <scan:SupportedResolutions>
+            <scan:XResolutionRange><scan:Min>75</scan:Min><scan:Max>600</scan:Max><scan:Step>1</scan:Step></scan:XResolutionRange>
+          </scan:SupportedResolutions>
+        </scan:SettingProfile>
+      </scan:SettingProfiles>
+    </scan:AdfDuplexInputCaps>
+  </scan:Adf>
+</scan:ScannerCapabilities>`
+
+// testClient returns a client for an eSCL service served by the handler
+func testClient(t *testing.T, handler http.HandlerFunc) *Client {
+	server := httptest.NewServer(handler)
+	t.Cleanup(server.Close)
+	return NewClient(server.URL + "/eSCL/")
+}
+
+func TestCapabilities(t *testing.T) {
+	client := testClient(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/eSCL/ScannerCapabilities" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", "text/xml")
+		io.WriteString(w, testCapabilities)
+	})
+
+	caps, err := client.Capabilities()
+	if err != nil {
+		t.Fatalf("Capabilities: %v", err)
+	}
+	if caps.Version != "2.63" || caps.MakeAndModel != "Acme Frobnicator 3000" {
+		t.Errorf("version %q, model %q", caps.Version, caps.MakeAndModel)
+	}
+	if caps.Feeder != nil {
+		t.Error("simplex feeder reported for a scanner without one")
+	}
+
+	// The setting profiles of the platen are merged
+	want := &InputCaps{
+		MinWidth:    16,
+		MaxWidth:    2550,
+		MinHeight:   16,
+		MaxHeight:   3508,
+		ColorModes:  []string{ColorModeRGB, ColorModeGray, ColorModeBlackDots},
+		Formats:     []string{"image/jpeg", "application/pdf"},
+		Resolutions: []int{150, 300, 600},
+	}
+	if !reflect.DeepEqual(caps.Platen, want) {
+		t.Errorf("platen %+v, want %+v", caps.Platen, want)
+	}
+
+	duplex := caps.Duplex
+	if duplex == nil {
+		t.Fatal("no duplex feeder")
+	}
+	if duplex.Resolutions != nil || duplex.MinRes != 75 || duplex.MaxRes != 600 {
+		t.Errorf("duplex resolutions %v, range %d-%d, want the range 75-600", duplex.Resolutions, duplex.MinRes, duplex.MaxRes)
+	}
+	if !reflect.DeepEqual(duplex.Formats, []string{"image/png"}) {
+		t.Errorf("duplex formats %v", duplex.Formats)
+	}
+}
+
+func TestScanJob(t *testing.T) {
+	pages := []string{"page 1", "page 2"}
+	var settings []byte
+	var requests []string
+	client := testClient(t, func(w http.ResponseWriter, r *http.Request) {
+		requests = append(requests, r.Method+" "+r.URL.Path)
+		switch r.Method + " " + r.URL.Path {
+		case "POST /eSCL/ScanJobs":
+			settings, _ = io.ReadAll(r.Body)
+			// Scanners often give the job relative to the service
+			w.Header().Set("Location", "/eSCL/ScanJobs/42/")
+			w.WriteHeader(http.StatusCreated)
+		case "GET /eSCL/ScanJobs/42/NextDocument":
+			if len(pages) == 0 {
+				http.NotFound(w, r)
+				return
+			}
+			w.Header().Set("Content-Type", "image/png")
+			io.WriteString(w, pages[0])
+			pages = pages[1:]
+		case "DELETE /eSCL/ScanJobs/42":
+		default:
+			http.NotFound(w, r)
+		}
+	})
+
+	job, err := client.Scan(Settings{
+		Source:     SourceFeeder,
+		Duplex:     true,
+		ColorMode:  ColorModeRGB,
+		Resolution: 300,
+		Format:     "image/png",
+		Width:      2550,
+		Height:     3508,
+	})
+	if err != nil {
+		t.Fatalf("Scan: %v", err)
+	}
+	// The relative location is resolved against the service
+	if want := strings.TrimSuffix(client.BaseURL, "/eSCL") + "/eSCL/ScanJobs/42"; job.URL != want {
+		t.Errorf("job %q, want %q", job.URL, want)
+	}
+
+	type scanSettings struct {
+		Version     string `xml:"Version"`
+		InputSource string `xml:"InputSource"`
+		Duplex      bool   `xml:"Duplex"`
+		ColorMode   string `xml:"ColorMode"`
+		XResolution int    `xml:"XResolution"`
+		Width       int    `xml:"ScanRegions>ScanRegion>Width"`
+		Format      string `xml:"DocumentFormatExt"`
+	}
+	var got scanSettings
+	if err := xml.Unmarshal(settings, &got); err != nil {
+		t.Fatalf("invalid scan settings: %v", err)
+	}
+	want := scanSettings{"2.0", SourceFeeder, true, ColorModeRGB, 300, 2550, "image/png"}
+	if got != want {
+		t.Errorf("settings %+v, want %+v", got, want)
+	}
+
+	for _, page := range []string{"page 1", "page 2"} {
+		data, err := job.NextDocument()
+		if err != nil {
+			t.Fatalf("NextDocument: %v", err)
+		}
+		if !bytes.Equal(data, []byte(page)) {
+			t.Errorf("document %q, want %q", data, page)
+		}
+	}
+	if _, err := job.NextDocument(); !errors.Is(err, ErrNoMoreDocuments) {
+		t.Errorf("error %v after the last page, want ErrNoMoreDocuments", err)
+	}
+	if err := job.Cancel(); err != nil {
+		t.Errorf("Cancel: %v", err)
+	}
+	if last := requests[len(requests)-1]; last != "DELETE /eSCL/ScanJobs/42" {
+		t.Errorf("last request %s, want the job deleted", last)
+	}
+}
+
+func TestScanRetriesWhileBusy(t *testing.T) {
+	defer func(delay time.Duration) { busyDelay = delay }(busyDelay)
+	busyDelay = time.Millisecond
+
+	attempts := 0
+	client := testClient(t, func(w http.ResponseWriter, r *http.Request) {
+		attempts++
+		if attempts <= 3 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.Header().Set("Location", "/eSCL/ScanJobs/42")
+		w.WriteHeader(http.StatusCreated)
+	})
+
+	if _, err := client.Scan(Settings{Source: SourcePlaten, Format: "image/jpeg"}); err != nil {
+		t.Fatalf("Scan: %v", err)
+	}
+	if attempts != 4 {
+		t.Errorf("%d attempts, want three busy answers and the job", attempts)
+	}
+}
@@ -0,0 +1,141 @@
+package hooks
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Events that trigger hooks
+const (
+	EventPage = "page" // A page has been scanned
+	EventPDF  = "pdf"  // The PDF document has been generated
+)
+
+// DefaultTimeout is how long a hook may run before it is stopped
+const DefaultTimeout = 60 * time.Second
+
+// Config holds the commands run for each event
+type Config struct {
+	AfterPage []string      // Commands run after each scanned page
+	AfterPDF  []string      // Commands run after the PDF is generated
+	Timeout   time.Duration // Time limit of each command
+}
+
+// Payload describes the event, it is passed to hooks as JSON on stdin and as
+// SCANEXPRESS_* environment variables
+type Payload struct {
+	Event     string   `json:"event"`
+	Output    string   `json:"output"`         // Generated PDF, or scanned page image
+	Page      int      `json:"page,omitempty"` // Page number of page events
+	PageCount int      `json:"page_count"`     // Pages in the PDF, or pages scanned so far
+	Title     string   `json:"title"`
+	Tags      []string `json:"tags"`
+	Profile   string   `json:"profile,omitempty"`
+}
+
+// Result holds the outcome of a single hook command
+type Result struct {
+	Event    string
+	Page     int
+	Command  string
+	Output   string // Combined stdout and stderr
+	ExitCode int    // -1 when the command could not be run
+	Error    error
+}
+
+// Success reports whether the hook ran and exited with status 0
+func (r Result) Success() bool {
+	return r.Error == nil && r.ExitCode == 0
+}
+
+// Label describes when the hook ran
+func (r Result) Label() string {
+	if r.Event == EventPage {
+		return fmt.Sprintf("after page %d", r.Page)
+	}
+	return "after PDF"
+}
+
+// Commands returns the commands configured for an event
+func (c Config) Commands(event string) []string {
+	if event == EventPage {
+		return c.AfterPage
+	}
+	return c.AfterPDF
+}
+
+// Run runs the commands of the payload's event one after the other with the
+// shell. A failing hook does not stop the following ones.
+func Run(config Config, payload Payload) []Result {
+	commands := config.Commands(payload.Event)
+	if len(commands) == 0 {
+		return nil
+	}
+
+	if payload.Tags == nil {
+		payload.Tags = []string{}
+	}
+	input, err := json.Marshal(payload)
+	if err != nil {
+		return []Result{{Event: payload.Event, Page: payload.Page, ExitCode: -1, Error: err}}
+	}
+
+	timeout := config.Timeout
+	if timeout <= 0 {
+		timeout = DefaultTimeout
+	}
+
+	results := make([]Result, 0, len(commands))
+	for _, command := range commands {
+		results = append(results, runCommand(command, payload, input, timeout))
+	}
+	return results
+}
+
+// runCommand runs a single hook command
+func runCommand(command string, payload Payload, input []byte, timeout time.Duration) Result {
+	result := Result{Event: payload.Event, Page: payload.Page, Command: command}
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	cmd := exec.CommandContext(ctx, "sh", "-c", command)
+	// Don't wait for background processes the hook left holding its output
+	cmd.WaitDelay = time.Second
+	cmd.Dir = filepath.Dir(payload.Output)
+	cmd.Stdin = bytes.NewReader(input)
+	cmd.Env = append(os.Environ(),
+		"SCANEXPRESS_EVENT="+payload.Event,
+		"SCANEXPRESS_OUTPUT="+payload.Output,
+		"SCANEXPRESS_PAGE="+strconv.Itoa(payload.Page),
+		"SCANEXPRESS_PAGE_COUNT="+strconv.Itoa(payload.PageCount),
+		"SCANEXPRESS_TITLE="+payload.Title,
+		"SCANEXPRESS_TAGS="+strings.Join(payload.Tags, ","),
+		"SCANEXPRESS_PROFILE="+payload.Profile,
+	)
+
+	output, err := cmd.CombinedOutput()
+	result.Output = strings.TrimSpace(string(output))
+
+	var exitErr *exec.ExitError
+	switch {
+	case ctx.Err() == context.DeadlineExceeded:
+		result.ExitCode = -1
+		result.Error = fmt.Errorf("timed out after %s", timeout)
+	case errors.As(err, &exitErr):
+		result.ExitCode = exitErr.ExitCode()
+	case err != nil:
+		result.ExitCode = -1
+		result.Error = err
+	}
+	return result
+}
@@ -0,0 +1,99 @@
+package hooks
+
+import (
+	"encoding/json"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testPayload describes a page of a scan in a temporary directory
+func testPayload(t *testing.T) Payload {
+	return Payload{
+		Event:     EventPage,
+		Output:    filepath.Join(t.TempDir(), "page_2.png"),
+		Page:      2,
+		PageCount: 2,
+		Title:     "Tax return",
+		Tags:      []string{"tax", "2025"},
+		Profile:   "finance",
+	}
+}
+
+func TestEnvironment(t *testing.T) {
+	payload := testPayload(t)
+	results := Run(Config{AfterPage: []string{
+		`echo "$SCANEXPRESS_EVENT|$SCANEXPRESS_OUTPUT|$SCANEXPRESS_PAGE|$SCANEXPRESS_PAGE_COUNT|$SCANEXPRESS_TITLE|$SCANEXPRESS_TAGS|$SCANEXPRESS_PROFILE"`,
+		`pwd`,
+	}}, payload)
+	if len(results) != 2 {
+		t.Fatalf("%d results, want 2", len(results))
+	}
+
+	want := "page|" + payload.Output + "|2|2|Tax return|tax,2025|finance"
+	if r := results[0]; !r.Success() || r.Output != want {
+		t.Errorf("got %q (%v), want %q", r.Output, r.Error, want)
+	}
+	// Hooks run next to the file
+	if r := results[1]; r.Output != filepath.Dir(payload.Output) {
+		t.Errorf("ran in %q", r.Output)
+	}
+	if label := results[0].Label(); label != "after page 2" {
+		t.Errorf("label %q", label)
+	}
+}
+
+func TestPayloadOnStdin(t *testing.T) {
+	payload := testPayload(t)
+	payload.Event, payload.Page, payload.Tags = EventPDF, 0, nil
+	results := Run(Config{AfterPDF: []string{"cat"}, AfterPage: []string{"false"}}, payload)
+	if len(results) != 1 || !results[0].Success() {
+		t.Fatalf("results %+v", results)
+	}
+
+	var got map[string]any
+	if err := json.Unmarshal([]byte(results[0].Output), &got); err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]any{
+		"event":      "pdf",
+		"output":     payload.Output,
+		"page_count": 2.0,
+		"title":      "Tax return",
+		"tags":       []any{}, // An empty list rather than null
+		"profile":    "finance",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("payload %v, want %v", got, want)
+	}
+}
+
+func TestFailuresDontStopTheOtherHooks(t *testing.T) {
+	results := Run(Config{AfterPage: []string{"echo oops >&2; exit 3", "echo ok"}}, testPayload(t))
+	if len(results) != 2 {
+		t.Fatalf("%d results, want 2", len(results))
+	}
+	if r := results[0]; r.Success() || r.ExitCode != 3 || r.Output != "oops" || r.Error != nil {
+		t.Errorf("failing hook: %+v", r)
+	}
+	if r := results[1]; !r.Success() || r.Output != "ok" {
+		t.Errorf("next hook: %+v", r)
+	}
+}
+
+func TestTimeout(t *testing.T) {
+	start := time.Now()
+	results := Run(Config{AfterPage: []string{"echo started; sleep 30"}, Timeout: 200 * time.Millisecond}, testPayload(t))
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("hook stopped after %s", elapsed)
+	}
+	r := results[0]
+	if r.Success() || r.ExitCode != -1 || r.Error == nil || !strings.Contains(r.Error.Error(), "timed out") {
+		t.Errorf("result %+v, want a timeout", r)
+	}
+	if r.Output != "started" {
+		t.Errorf("output %q, want what the hook printed before it was stopped", r.Output)
+	}
+}
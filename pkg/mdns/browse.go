@@ -0,0 +1,193 @@
+// Package mdns browses the local network for services announced with
+// multicast DNS service discovery (DNS-SD)
+package mdns
+
+import (
+	"context"
+	"errors"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// group is the IPv4 multicast address of mDNS
+var group = &net.UDPAddr{IP: net.IPv4(224, 0, 0, 251), Port: 5353}
+
+// queryIntervals are the delays between repeated queries, growing to spare
+// the network once the responders had a chance to answer
+var queryIntervals = []time.Duration{time.Second, 2 * time.Second, 4 * time.Second}
+
+// Service is a service instance found on the network
+type Service struct {
+	Type     string // Service type, e.g. "_uscan._tcp"
+	Instance string // Instance name, e.g. "HP OfficeJet 8020"
+	Host     string // Host name, e.g. "printer.local."
+	Port     int
+	IPs      []net.IP
+	Text     map[string]string // TXT record, with lowercase keys
+}
+
+// Address returns the host:port to reach the service at, preferring IPv4
+func (s Service) Address() string {
+	ip := s.IPs[0]
+	for _, candidate := range s.IPs {
+		if candidate.To4() != nil {
+			ip = candidate
+			break
+		}
+	}
+	return net.JoinHostPort(ip.String(), strconv.Itoa(s.Port))
+}
+
+// instance is a service instance being resolved
+type instance struct {
+	Service
+	hasSRV   bool
+	hasTXT   bool
+	reported bool
+}
+
+// Browse looks for instances of the service types (e.g. "_uscan._tcp")
+// until the context is done, calling found once for each instance as soon
+// as its address is known. Queries are sent from an ephemeral port, so that
+// responders answer directly and no mDNS daemon is disturbed.
+func Browse(ctx context.Context, types []string, found func(Service)) error {
+	conn, err := net.ListenUDP("udp4", nil)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	browse := make([]string, len(types))
+	for i, t := range types {
+		browse[i] = t + ".local."
+	}
+
+	instances := make(map[string]*instance)
+	hosts := make(map[string][]net.IP)
+	nextQuery := time.Now()
+	queries := 0
+	buf := make([]byte, 9000)
+
+	for ctx.Err() == nil {
+		if !time.Now().Before(nextQuery) {
+			send(conn, typePTR, browse)
+			resolve(conn, instances, hosts)
+			nextQuery = time.Now().Add(queryIntervals[min(queries, len(queryIntervals)-1)])
+			queries++
+		}
+
+		// Wake up regularly to notice when the context is cancelled
+		deadline := time.Now().Add(250 * time.Millisecond)
+		if nextQuery.Before(deadline) {
+			deadline = nextQuery
+		}
+		conn.SetReadDeadline(deadline)
+		n, _, err := conn.ReadFromUDP(buf)
+		if err != nil {
+			if errors.Is(err, os.ErrDeadlineExceeded) {
+				continue
+			}
+			return err
+		}
+
+		records, err := parse(buf[:n])
+		if err != nil {
+			continue
+		}
+		collect(records, browse, instances, hosts)
+
+		for _, inst := range instances {
+			if inst.reported || !inst.hasSRV || !inst.hasTXT || len(hosts[inst.Host]) == 0 {
+				continue
+			}
+			inst.reported = true
+			inst.IPs = hosts[inst.Host]
+			found(inst.Service)
+		}
+	}
+	return nil
+}
+
+// collect adds the records of a response to the instances being resolved
+func collect(records []record, browse []string, instances map[string]*instance, hosts map[string][]net.IP) {
+	get := func(name string) *instance {
+		return instances[strings.ToLower(name)]
+	}
+
+	// Pointers are handled first, as the records describing the instances
+	// may come before them
+	for _, r := range records {
+		if r.Type != typePTR {
+			continue
+		}
+		for _, service := range browse {
+			suffix := "." + service
+			if !strings.EqualFold(r.Name, service) || !strings.HasSuffix(strings.ToLower(r.Target), strings.ToLower(suffix)) {
+				continue
+			}
+			if get(r.Target) == nil {
+				instances[strings.ToLower(r.Target)] = &instance{Service: Service{
+					Type:     strings.TrimSuffix(service, ".local."),
+					Instance: r.Target[:len(r.Target)-len(suffix)],
+				}}
+			}
+		}
+	}
+
+	for _, r := range records {
+		switch r.Type {
+		case typeSRV:
+			if inst := get(r.Name); inst != nil {
+				inst.Host = strings.ToLower(r.Target)
+				inst.Port = r.Port
+				inst.hasSRV = true
+			}
+		case typeTXT:
+			if inst := get(r.Name); inst != nil {
+				inst.Text = r.Text
+				inst.hasTXT = true
+			}
+		case typeA, typeAAAA:
+			name := strings.ToLower(r.Name)
+			known := false
+			for _, ip := range hosts[name] {
+				known = known || ip.Equal(r.IP)
+			}
+			if !known {
+				hosts[name] = append(hosts[name], r.IP)
+			}
+		}
+	}
+}
+
+// resolve asks for the records still missing to report the instances
+func resolve(conn *net.UDPConn, instances map[string]*instance, hosts map[string][]net.IP) {
+	var srv, txt, addr []string
+	for name, inst := range instances {
+		if inst.reported {
+			continue
+		}
+		if !inst.hasSRV {
+			srv = append(srv, name)
+		}
+		if !inst.hasTXT {
+			txt = append(txt, name)
+		}
+		if inst.hasSRV && len(hosts[inst.Host]) == 0 {
+			addr = append(addr, inst.Host)
+		}
+	}
+	send(conn, typeSRV, srv)
+	send(conn, typeTXT, txt)
+	send(conn, typeA, addr)
+}
+
+// send sends a one-shot query for the names, if any
+func send(conn *net.UDPConn, qtype uint16, names []string) {
+	if len(names) > 0 {
+		conn.WriteToUDP(query(qtype, names...), group)
+	}
+}
@@ -0,0 +1,195 @@
+package mdns
+
+import (
+	"encoding/binary"
+	"errors"
+	"net"
+	"strings"
+)
+
+// DNS record types used for service discovery
+const (
+	typeA    = 1
+	typePTR  = 12
+	typeTXT  = 16
+	typeAAAA = 28
+	typeSRV  = 33
+)
+
+// classIN is the Internet class; the top bit of the class of mDNS records is
+// the cache flush flag and is ignored
+const (
+	classIN   = 1
+	classMask = 0x7FFF
+)
+
+var errMalformed = errors.New("malformed DNS message")
+
+// record is a resource record of a response
+type record struct {
+	Name string
+	Type uint16
+
+	Target string            // PTR and SRV
+	Port   int               // SRV
+	IP     net.IP            // A and AAAA
+	Text   map[string]string // TXT
+}
+
+// query builds a DNS query message for the names
+func query(qtype uint16, names ...string) []byte {
+	msg := make([]byte, 12)
+	binary.BigEndian.PutUint16(msg[4:], uint16(len(names)))
+	for _, name := range names {
+		msg = appendName(msg, name)
+		msg = binary.BigEndian.AppendUint16(msg, qtype)
+		msg = binary.BigEndian.AppendUint16(msg, classIN)
+	}
+	return msg
+}
+
+// appendName appends a domain name as a sequence of labels
+func appendName(msg []byte, name string) []byte {
+	for _, label := range strings.Split(strings.TrimSuffix(name, "."), ".") {
+		if label == "" {
+			continue
+		}
+		msg = append(msg, byte(len(label)))
+		msg = append(msg, label...)
+	}
+	return append(msg, 0)
+}
+
+// parse returns the records of the answer, authority and additional
+// sections of a response
+func parse(msg []byte) ([]record, error) {
+	if len(msg) < 12 {
+		return nil, errMalformed
+	}
+	// Only responses are of interest
+	if msg[2]&0x80 == 0 {
+		return nil, nil
+	}
+
+	questions := int(binary.BigEndian.Uint16(msg[4:]))
+	count := int(binary.BigEndian.Uint16(msg[6:])) + int(binary.BigEndian.Uint16(msg[8:])) + int(binary.BigEndian.Uint16(msg[10:]))
+
+	offset := 12
+	for i := 0; i < questions; i++ {
+		_, next, err := readName(msg, offset)
+		if err != nil {
+			return nil, err
+		}
+		offset = next + 4
+	}
+
+	var records []record
+	for i := 0; i < count; i++ {
+		name, next, err := readName(msg, offset)
+		if err != nil {
+			return nil, err
+		}
+		if next+10 > len(msg) {
+			return nil, errMalformed
+		}
+		rtype := binary.BigEndian.Uint16(msg[next:])
+		class := binary.BigEndian.Uint16(msg[next+2:]) & classMask
+		length := int(binary.BigEndian.Uint16(msg[next+8:]))
+		start := next + 10
+		end := start + length
+		if end > len(msg) {
+			return nil, errMalformed
+		}
+		offset = end
+		if class != classIN {
+			continue
+		}
+
+		r := record{Name: name, Type: rtype}
+		data := msg[start:end]
+		switch rtype {
+		case typePTR:
+			if r.Target, _, err = readName(msg, start); err != nil {
+				return nil, err
+			}
+		case typeSRV:
+			if length < 7 {
+				return nil, errMalformed
+			}
+			r.Port = int(binary.BigEndian.Uint16(data[4:]))
+			if r.Target, _, err = readName(msg, start+6); err != nil {
+				return nil, err
+			}
+		case typeTXT:
+			r.Text = parseText(data)
+		case typeA:
+			if length != net.IPv4len {
+				return nil, errMalformed
+			}
+			r.IP = net.IP(append([]byte(nil), data...))
+		case typeAAAA:
+			if length != net.IPv6len {
+				return nil, errMalformed
+			}
+			r.IP = net.IP(append([]byte(nil), data...))
+		default:
+			continue
+		}
+		records = append(records, r)
+	}
+	return records, nil
+}
+
+// readName reads a possibly compressed domain name, returning it and the
+// offset following it
+func readName(msg []byte, offset int) (string, int, error) {
+	var labels []string
+	next := -1
+	for jumps := 0; ; {
+		if offset >= len(msg) {
+			return "", 0, errMalformed
+		}
+		length := int(msg[offset])
+		switch {
+		case length == 0:
+			if next < 0 {
+				next = offset + 1
+			}
+			return strings.Join(labels, ".") + ".", next, nil
+
+		case length&0xC0 == 0xC0:
+			if offset+1 >= len(msg) || jumps > 10 {
+				return "", 0, errMalformed
+			}
+			if next < 0 {
+				next = offset + 2
+			}
+			offset = int(binary.BigEndian.Uint16(msg[offset:]) & 0x3FFF)
+			jumps++
+
+		default:
+			if offset+1+length > len(msg) {
+				return "", 0, errMalformed
+			}
+			labels = append(labels, string(msg[offset+1:offset+1+length]))
+			offset += 1 + length
+		}
+	}
+}
+
+// parseText reads the key=value strings of a TXT record
+func parseText(data []byte) map[string]string {
+	text := make(map[string]string)
+	for len(data) > 0 {
+		length := int(data[0])
+		if 1+length > len(data) {
+			break
+		}
+		key, value, _ := strings.Cut(string(data[1:1+length]), "=")
+		if key != "" {
+			text[strings.ToLower(key)] = value
+		}
+		data = data[1+length:]
+	}
+	return text
+}
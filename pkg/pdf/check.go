@@ -0,0 +1,113 @@
+package pdf
+
+import (
+	"bytes"
+	"fmt"
+	"regexp"
+	"sort"
+)
+
+// Regular expressions for PDF/A requirements checked in object dictionaries
+var (
+	forbiddenFilterRegex = regexp.MustCompile(`/LZWDecode\b`)
+	javaScriptRegex      = regexp.MustCompile(`/(JavaScript|JS)\b`)
+	interpolateRegex     = regexp.MustCompile(`/Interpolate\s+true`)
+	pdfaPartRegex        = regexp.MustCompile(`<pdfaid:part>\s*2\s*</pdfaid:part>|pdfaid:part="2"`)
+	pdfaConformanceRegex = regexp.MustCompile(`<pdfaid:conformance>\s*B\s*</pdfaid:conformance>|pdfaid:conformance="B"`)
+)
+
+// CheckPDFA performs a structural self-check of a PDF/A-2b document. It
+// verifies the file structure rules and the presence of the PDF/A metadata,
+// not the full rendering semantics a dedicated validator would check.
+func CheckPDFA(data []byte) error {
+	// Header followed by a comment with at least four high-bit bytes
+	if !bytes.HasPrefix(data, []byte("%PDF-1.")) {
+		return fmt.Errorf("missing PDF header")
+	}
+	lines := bytes.SplitN(data, []byte("\n"), 3)
+	if len(lines) < 3 || len(lines[1]) < 5 || lines[1][0] != '%' {
+		return fmt.Errorf("missing binary comment after the header")
+	}
+	for _, b := range lines[1][1:5] {
+		if b < 128 {
+			return fmt.Errorf("binary comment after the header must use bytes above 127")
+		}
+	}
+
+	doc, err := Parse(data)
+	if err != nil {
+		return err
+	}
+
+	if !regexp.MustCompile(`/ID\s*\[\s*<[0-9A-Fa-f]+>\s*<[0-9A-Fa-f]+>\s*\]`).MatchString(doc.Trailer) {
+		return fmt.Errorf("trailer has no file identifier")
+	}
+	if bytes.Contains([]byte(doc.Trailer), []byte("/Encrypt")) {
+		return fmt.Errorf("encrypted documents are not allowed")
+	}
+
+	// Every object must be reachable through its cross-reference offset and
+	// free of features PDF/A forbids
+	objects := make([]int, 0, len(doc.Offsets))
+	for num := range doc.Offsets {
+		objects = append(objects, num)
+	}
+	sort.Ints(objects)
+	for _, num := range objects {
+		dict, _, err := doc.Object(num)
+		if err != nil {
+			return err
+		}
+		switch {
+		case forbiddenFilterRegex.MatchString(dict):
+			return fmt.Errorf("object %d uses the LZWDecode filter", num)
+		case javaScriptRegex.MatchString(dict):
+			return fmt.Errorf("object %d contains JavaScript", num)
+		case interpolateRegex.MatchString(dict):
+			return fmt.Errorf("object %d enables image interpolation", num)
+		}
+	}
+
+	root, ok := doc.TrailerRef("Root")
+	if !ok {
+		return fmt.Errorf("trailer has no /Root entry")
+	}
+	catalog, _, err := doc.Object(root)
+	if err != nil {
+		return err
+	}
+
+	// XMP metadata with the PDF/A identification
+	metadataRef, ok := dictRef(catalog, "Metadata")
+	if !ok {
+		return fmt.Errorf("catalog has no XMP metadata")
+	}
+	metadataDict, metadata, err := doc.Object(metadataRef)
+	if err != nil {
+		return err
+	}
+	if bytes.Contains([]byte(metadataDict), []byte("/Filter")) {
+		return fmt.Errorf("XMP metadata stream must not be compressed")
+	}
+	if !pdfaPartRegex.Match(metadata) || !pdfaConformanceRegex.Match(metadata) {
+		return fmt.Errorf("XMP metadata does not identify the document as PDF/A-2b")
+	}
+
+	// Output intent with an embedded ICC profile
+	if !bytes.Contains([]byte(catalog), []byte("/OutputIntents")) || !bytes.Contains([]byte(catalog), []byte("/GTS_PDFA1")) {
+		return fmt.Errorf("catalog has no PDF/A output intent")
+	}
+	profileRef, ok := dictRef(catalog, "DestOutputProfile")
+	if !ok {
+		return fmt.Errorf("output intent has no embedded ICC profile")
+	}
+	profileDict, _, err := doc.Object(profileRef)
+	if err != nil {
+		return err
+	}
+	if _, ok := dictInt(profileDict, "N"); !ok {
+		return fmt.Errorf("ICC profile stream has no component count")
+	}
+
+	return nil
+}
@@ -0,0 +1,71 @@
+package pdf
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+)
+
+// pdfaDocument renders a page in PDF/A mode
+func pdfaDocument(t *testing.T) []byte {
+	t.Helper()
+	data, err := RenderImages([]string{testImage(t)}, Options{Title: "scan", PDFA: true}, time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+func TestCheckPDFA(t *testing.T) {
+	if err := CheckPDFA(pdfaDocument(t)); err != nil {
+		t.Fatalf("PDF/A document refused: %v", err)
+	}
+
+	plain, err := RenderImages([]string{testImage(t)}, Options{}, time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	encrypted, err := RenderImages([]string{testImage(t)}, Options{Encryption: &Encryption{OwnerPassword: "owner"}}, time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Replacements keep the length, so that the cross-reference offsets
+	// stay valid
+	replace := func(old, new string) []byte {
+		if len(old) != len(new) {
+			t.Fatalf("%q and %q differ in length", old, new)
+		}
+		data := pdfaDocument(t)
+		if !bytes.Contains(data, []byte(old)) {
+			t.Fatalf("%q not found", old)
+		}
+		return bytes.Replace(data, []byte(old), []byte(new), 1)
+	}
+
+	for _, test := range []struct {
+		name string
+		data []byte
+		want string
+	}{
+		{"not a PDF", []byte("<html></html>\n"), "missing PDF header"},
+		{"text comment", replace("%\xE2\xE3\xCF\xD3", "%scan"), "binary comment"},
+		{"truncated", pdfaDocument(t)[:1000], "startxref"},
+		{"plain document", plain, "XMP metadata does not identify the document as PDF/A-2b"},
+		{"encrypted", encrypted, "encrypted documents are not allowed"},
+		{"LZW image", replace("/Filter /FlateDecode", "/Filter /LZWDecode  "), "LZWDecode"},
+		{"JavaScript", replace("/Type /Catalog", "/JS  /Catalog "), "JavaScript"},
+		{"interpolation", replace("/BitsPerComponent 8", "/Interpolate true  "), "interpolation"},
+		{"PDF/A-1", replace("<pdfaid:part>2<", "<pdfaid:part>1<"), "does not identify the document as PDF/A-2b"},
+		{"no output intent", replace("/GTS_PDFA1", "/GTS_PDFX1"), "no PDF/A output intent"},
+		{"no profile", replace("/DestOutputProfile", "/DestOutputProfil_"), "no embedded ICC profile"},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			err := CheckPDFA(test.data)
+			if err == nil || !strings.Contains(err.Error(), test.want) {
+				t.Errorf("got %v, want an error about %q", err, test.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,366 @@
+package pdf
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	_ "crypto/sha512" // Register SHA-384 and SHA-512 for verification
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"fmt"
+	"math/big"
+	"sort"
+	"time"
+)
+
+// Object identifiers used in CMS signatures
+var (
+	oidData                 = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 7, 1}
+	oidSignedData           = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 7, 2}
+	oidContentType          = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 3}
+	oidMessageDigest        = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 4}
+	oidSigningTime          = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 5}
+	oidSigningCertificateV2 = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 16, 2, 47}
+	oidTimeStampToken       = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 16, 2, 14}
+	oidTSTInfo              = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 16, 1, 4}
+	oidSHA256               = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 2, 1}
+	oidSHA384               = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 2, 2}
+	oidSHA512               = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 2, 3}
+	oidRSAEncryption        = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 1, 1}
+	oidSHA256WithRSA        = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 1, 11}
+	oidECDSAWithSHA256      = asn1.ObjectIdentifier{1, 2, 840, 10045, 4, 3, 2}
+	oidECDSAWithSHA384      = asn1.ObjectIdentifier{1, 2, 840, 10045, 4, 3, 3}
+	oidECDSAWithSHA512      = asn1.ObjectIdentifier{1, 2, 840, 10045, 4, 3, 4}
+)
+
+// contentInfo is the outer CMS structure
+type contentInfo struct {
+	ContentType asn1.ObjectIdentifier
+	Content     asn1.RawValue // [0] EXPLICIT content
+}
+
+// signedData is the CMS SignedData structure (RFC 5652)
+type signedData struct {
+	Version          int
+	DigestAlgorithms []pkix.AlgorithmIdentifier `asn1:"set"`
+	EncapContentInfo encapContentInfo
+	Certificates     asn1.RawValue `asn1:"optional,tag:0"`
+	SignerInfos      []signerInfo  `asn1:"set"`
+}
+
+// encapContentInfo holds the signed content, which is absent in detached signatures
+type encapContentInfo struct {
+	ContentType asn1.ObjectIdentifier
+	Content     asn1.RawValue `asn1:"optional,explicit,tag:0"`
+}
+
+// signerInfo describes a single signer
+type signerInfo struct {
+	Version            int
+	SID                issuerAndSerial
+	DigestAlgorithm    pkix.AlgorithmIdentifier
+	SignedAttrs        asn1.RawValue `asn1:"optional,tag:0"`
+	SignatureAlgorithm pkix.AlgorithmIdentifier
+	Signature          []byte
+	UnsignedAttrs      asn1.RawValue `asn1:"optional,tag:1"`
+}
+
+// issuerAndSerial identifies a certificate
+type issuerAndSerial struct {
+	Issuer asn1.RawValue
+	Serial *big.Int
+}
+
+// attribute is a CMS signed or unsigned attribute
+type attribute struct {
+	Type   asn1.ObjectIdentifier
+	Values []asn1.RawValue `asn1:"set"`
+}
+
+// essCertIDv2 identifies the signing certificate by its SHA-256 hash
+type essCertIDv2 struct {
+	CertHash []byte
+}
+
+// signingCertificateV2 protects the signer against certificate substitution,
+// as CAdES requires
+type signingCertificateV2 struct {
+	Certs []essCertIDv2
+}
+
+// createCMS builds a detached CAdES-BES signature of the content. When a
+// timestamp URL is given, an RFC 3161 timestamp of the signature is added.
+func createCMS(content []byte, signature Signature) ([]byte, error) {
+	if len(signature.Certificates) == 0 {
+		return nil, fmt.Errorf("no signing certificate")
+	}
+	cert := signature.Certificates[0]
+
+	digest := sha256.Sum256(content)
+	certHash := sha256.Sum256(cert.Raw)
+
+	signedAttrs, err := marshalAttributes([]attributeValue{
+		{oidContentType, oidData},
+		{oidMessageDigest, digest[:]},
+		{oidSigningCertificateV2, signingCertificateV2{Certs: []essCertIDv2{{CertHash: certHash[:]}}}},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// The signature covers the DER encoding of the attributes as a SET
+	attrsDigest := sha256.Sum256(append([]byte{0x31}, signedAttrs[1:]...))
+	signatureValue, err := signature.Signer.Sign(rand.Reader, attrsDigest[:], crypto.SHA256)
+	if err != nil {
+		return nil, fmt.Errorf("failed to sign: %v", err)
+	}
+
+	var signatureAlgorithm pkix.AlgorithmIdentifier
+	switch signature.Signer.Public().(type) {
+	case *rsa.PublicKey:
+		signatureAlgorithm = pkix.AlgorithmIdentifier{Algorithm: oidRSAEncryption, Parameters: asn1.NullRawValue}
+	case *ecdsa.PublicKey:
+		signatureAlgorithm = pkix.AlgorithmIdentifier{Algorithm: oidECDSAWithSHA256}
+	default:
+		return nil, fmt.Errorf("unsupported signing key type %T", signature.Signer.Public())
+	}
+
+	info := signerInfo{
+		Version:            1,
+		SID:                issuerAndSerial{Issuer: asn1.RawValue{FullBytes: cert.RawIssuer}, Serial: cert.SerialNumber},
+		DigestAlgorithm:    pkix.AlgorithmIdentifier{Algorithm: oidSHA256},
+		SignedAttrs:        asn1.RawValue{FullBytes: signedAttrs},
+		SignatureAlgorithm: signatureAlgorithm,
+		Signature:          signatureValue,
+	}
+
+	if signature.TimestampURL != "" {
+		token, err := requestTimestamp(signature.TimestampURL, signatureValue)
+		if err != nil {
+			return nil, err
+		}
+		unsignedAttrs, err := marshalAttributes([]attributeValue{{oidTimeStampToken, asn1.RawValue{FullBytes: token}}})
+		if err != nil {
+			return nil, err
+		}
+		unsignedAttrs[0] = 0xA1 // [1] IMPLICIT
+		info.UnsignedAttrs = asn1.RawValue{FullBytes: unsignedAttrs}
+	}
+
+	var certs []byte
+	for _, c := range signature.Certificates {
+		certs = append(certs, c.Raw...)
+	}
+
+	sd, err := asn1.Marshal(signedData{
+		Version:          1,
+		DigestAlgorithms: []pkix.AlgorithmIdentifier{{Algorithm: oidSHA256}},
+		EncapContentInfo: encapContentInfo{ContentType: oidData},
+		Certificates:     asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 0, IsCompound: true, Bytes: certs},
+		SignerInfos:      []signerInfo{info},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return asn1.Marshal(contentInfo{
+		ContentType: oidSignedData,
+		Content:     asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 0, IsCompound: true, Bytes: sd},
+	})
+}
+
+// attributeValue is an attribute type with its single value
+type attributeValue struct {
+	Type  asn1.ObjectIdentifier
+	Value any
+}
+
+// marshalAttributes encodes attributes as a [0] IMPLICIT SET OF Attribute,
+// sorted as DER requires
+func marshalAttributes(values []attributeValue) ([]byte, error) {
+	encoded := make([][]byte, 0, len(values))
+	for _, v := range values {
+		value, err := asn1.Marshal(v.Value)
+		if err != nil {
+			return nil, err
+		}
+		attr, err := asn1.Marshal(attribute{Type: v.Type, Values: []asn1.RawValue{{FullBytes: value}}})
+		if err != nil {
+			return nil, err
+		}
+		encoded = append(encoded, attr)
+	}
+	sort.Slice(encoded, func(i, j int) bool { return bytes.Compare(encoded[i], encoded[j]) < 0 })
+
+	set, err := asn1.Marshal(asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 0, IsCompound: true, Bytes: bytes.Join(encoded, nil)})
+	if err != nil {
+		return nil, err
+	}
+	return set, nil
+}
+
+// SignerReport describes a verified CMS signer
+type SignerReport struct {
+	Certificate   *x509.Certificate
+	Intermediates []*x509.Certificate
+	SigningTime   time.Time     // From the signed attributes, zero when absent
+	Timestamp     time.Time     // From the RFC 3161 timestamp token, zero when absent
+	TimestampedBy *SignerReport // Signer of the timestamp token, nil when absent
+}
+
+// verifyCMS checks a detached CMS signature of the content and returns the
+// signer. Certificate trust is not checked here.
+func verifyCMS(der, content []byte) (SignerReport, error) {
+	var ci contentInfo
+	if _, err := asn1.Unmarshal(der, &ci); err != nil {
+		return SignerReport{}, fmt.Errorf("malformed signature: %v", err)
+	}
+	if !ci.ContentType.Equal(oidSignedData) {
+		return SignerReport{}, fmt.Errorf("signature is not CMS signed data")
+	}
+
+	var sd signedData
+	if _, err := asn1.Unmarshal(ci.Content.Bytes, &sd); err != nil {
+		return SignerReport{}, fmt.Errorf("malformed signed data: %v", err)
+	}
+	if len(sd.SignerInfos) != 1 {
+		return SignerReport{}, fmt.Errorf("expected one signer, found %d", len(sd.SignerInfos))
+	}
+	if len(sd.EncapContentInfo.Content.Bytes) > 0 {
+		// Attached signature, as found in timestamp tokens
+		var inner []byte
+		if _, err := asn1.Unmarshal(sd.EncapContentInfo.Content.Bytes, &inner); err != nil {
+			return SignerReport{}, fmt.Errorf("malformed signed content: %v", err)
+		}
+		content = inner
+	}
+
+	certs, err := x509.ParseCertificates(sd.Certificates.Bytes)
+	if err != nil {
+		return SignerReport{}, fmt.Errorf("malformed certificates: %v", err)
+	}
+
+	info := sd.SignerInfos[0]
+	report := SignerReport{}
+	for _, c := range certs {
+		if bytes.Equal(c.RawIssuer, info.SID.Issuer.FullBytes) && c.SerialNumber.Cmp(info.SID.Serial) == 0 {
+			report.Certificate = c
+		} else {
+			report.Intermediates = append(report.Intermediates, c)
+		}
+	}
+	if report.Certificate == nil {
+		return SignerReport{}, fmt.Errorf("signing certificate not included in the signature")
+	}
+
+	hash, err := hashForOID(info.DigestAlgorithm.Algorithm)
+	if err != nil {
+		return SignerReport{}, err
+	}
+	h := hash.New()
+	h.Write(content)
+	digest := h.Sum(nil)
+
+	if len(info.SignedAttrs.FullBytes) == 0 {
+		return SignerReport{}, fmt.Errorf("signature has no signed attributes")
+	}
+
+	var attrs []attribute
+	if _, err := asn1.UnmarshalWithParams(info.SignedAttrs.FullBytes, &attrs, "set,tag:0"); err != nil {
+		return SignerReport{}, fmt.Errorf("malformed signed attributes: %v", err)
+	}
+	digestMatches := false
+	for _, attr := range attrs {
+		if len(attr.Values) == 0 {
+			continue
+		}
+		switch {
+		case attr.Type.Equal(oidMessageDigest):
+			var messageDigest []byte
+			if _, err := asn1.Unmarshal(attr.Values[0].FullBytes, &messageDigest); err == nil {
+				digestMatches = bytes.Equal(messageDigest, digest)
+			}
+		case attr.Type.Equal(oidSigningTime):
+			var t time.Time
+			if _, err := asn1.Unmarshal(attr.Values[0].FullBytes, &t); err == nil {
+				report.SigningTime = t
+			}
+		}
+	}
+	if !digestMatches {
+		return SignerReport{}, fmt.Errorf("document digest does not match, the document was modified after signing")
+	}
+
+	signed := append([]byte{0x31}, info.SignedAttrs.FullBytes[1:]...)
+	algorithm, err := signatureAlgorithmFor(info.SignatureAlgorithm.Algorithm, info.DigestAlgorithm.Algorithm)
+	if err != nil {
+		return SignerReport{}, err
+	}
+	if err := report.Certificate.CheckSignature(algorithm, signed, info.Signature); err != nil {
+		return SignerReport{}, fmt.Errorf("signature value is invalid: %v", err)
+	}
+
+	// Verify the timestamp token over the signature value when present
+	if len(info.UnsignedAttrs.FullBytes) > 0 {
+		var unsigned []attribute
+		if _, err := asn1.UnmarshalWithParams(info.UnsignedAttrs.FullBytes, &unsigned, "set,tag:1"); err == nil {
+			for _, attr := range unsigned {
+				if attr.Type.Equal(oidTimeStampToken) && len(attr.Values) > 0 {
+					t, authority, err := verifyTimestampToken(attr.Values[0].FullBytes, info.Signature)
+					if err != nil {
+						return SignerReport{}, fmt.Errorf("invalid timestamp: %v", err)
+					}
+					report.Timestamp = t
+					report.TimestampedBy = &authority
+				}
+			}
+		}
+	}
+
+	return report, nil
+}
+
+// hashForOID returns the hash function for a digest algorithm identifier
+func hashForOID(oid asn1.ObjectIdentifier) (crypto.Hash, error) {
+	switch {
+	case oid.Equal(oidSHA256):
+		return crypto.SHA256, nil
+	case oid.Equal(oidSHA384):
+		return crypto.SHA384, nil
+	case oid.Equal(oidSHA512):
+		return crypto.SHA512, nil
+	}
+	return 0, fmt.Errorf("unsupported digest algorithm %v", oid)
+}
+
+// signatureAlgorithmFor maps CMS signature and digest algorithms to an x509
+// signature algorithm
+func signatureAlgorithmFor(signature, digest asn1.ObjectIdentifier) (x509.SignatureAlgorithm, error) {
+	hash, err := hashForOID(digest)
+	if err != nil {
+		return 0, err
+	}
+
+	switch {
+	case signature.Equal(oidRSAEncryption), signature.Equal(oidSHA256WithRSA):
+		switch hash {
+		case crypto.SHA256:
+			return x509.SHA256WithRSA, nil
+		case crypto.SHA384:
+			return x509.SHA384WithRSA, nil
+		case crypto.SHA512:
+			return x509.SHA512WithRSA, nil
+		}
+	case signature.Equal(oidECDSAWithSHA256):
+		return x509.ECDSAWithSHA256, nil
+	case signature.Equal(oidECDSAWithSHA384):
+		return x509.ECDSAWithSHA384, nil
+	case signature.Equal(oidECDSAWithSHA512):
+		return x509.ECDSAWithSHA512, nil
+	}
+	return 0, fmt.Errorf("unsupported signature algorithm %v", signature)
+}
@@ -0,0 +1,233 @@
+package pdf
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"sort"
+	"strings"
+)
+
+// Permissions is the set of operations allowed to users opening an
+// encrypted document with the user password
+type Permissions uint32
+
+// Permission flags, as defined for the /P entry of the encryption dictionary
+const (
+	PermissionPrint         Permissions = 1 << 2
+	PermissionModify        Permissions = 1 << 3
+	PermissionCopy          Permissions = 1 << 4
+	PermissionAnnotate      Permissions = 1 << 5
+	PermissionFillForms     Permissions = 1 << 8
+	PermissionAccessibility Permissions = 1 << 9
+	PermissionAssemble      Permissions = 1 << 10
+	PermissionPrintHigh     Permissions = 1 << 11
+)
+
+// permissionNames maps configuration names to permission flags
+var permissionNames = map[string]Permissions{
+	"print":         PermissionPrint,
+	"modify":        PermissionModify,
+	"copy":          PermissionCopy,
+	"annotate":      PermissionAnnotate,
+	"fill-forms":    PermissionFillForms,
+	"accessibility": PermissionAccessibility,
+	"assemble":      PermissionAssemble,
+	"print-high":    PermissionPrintHigh,
+}
+
+// PermissionNames returns the permission names accepted by ParsePermissions
+func PermissionNames() []string {
+	names := make([]string, 0, len(permissionNames))
+	for name := range permissionNames {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// ParsePermissions converts permission names to permission flags
+func ParsePermissions(names []string) (Permissions, error) {
+	var p Permissions
+	for _, name := range names {
+		flag, ok := permissionNames[strings.ToLower(strings.TrimSpace(name))]
+		if !ok {
+			return 0, fmt.Errorf("unknown permission %q (valid: %s)", name, strings.Join(PermissionNames(), ", "))
+		}
+		p |= flag
+	}
+	return p, nil
+}
+
+// Encryption holds the passwords and permissions of an encrypted document
+type Encryption struct {
+	UserPassword  string // Password needed to open the document, may be empty
+	OwnerPassword string // Password granting full access
+	Permissions   Permissions
+}
+
+// securityHandler encrypts the strings and streams of a document with the
+// AES-256 standard security handler (revision 6)
+type securityHandler struct {
+	key        []byte // File encryption key
+	dictionary string // Encryption dictionary
+}
+
+// newSecurityHandler derives the encryption dictionary for the passwords
+func newSecurityHandler(e Encryption) (*securityHandler, error) {
+	if e.OwnerPassword == "" {
+		return nil, fmt.Errorf("an owner password is required")
+	}
+
+	key := make([]byte, 32)
+	salts := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		return nil, err
+	}
+	if _, err := rand.Read(salts); err != nil {
+		return nil, err
+	}
+
+	userPassword := truncatePassword(e.UserPassword)
+	ownerPassword := truncatePassword(e.OwnerPassword)
+
+	// User validation and key salts
+	u := append(hashR6(userPassword, salts[0:8], nil), salts[0:16]...)
+	ue, err := aesNoIV(hashR6(userPassword, salts[8:16], nil), key)
+	if err != nil {
+		return nil, err
+	}
+
+	// Owner entries also depend on the complete U entry
+	o := append(hashR6(ownerPassword, salts[16:24], u), salts[16:32]...)
+	oe, err := aesNoIV(hashR6(ownerPassword, salts[24:32], u), key)
+	if err != nil {
+		return nil, err
+	}
+
+	// Reserved bits are set as the specification requires
+	p := uint32(e.Permissions) | 0xFFFFF0C0
+
+	perms := make([]byte, 16)
+	binary.LittleEndian.PutUint32(perms[0:4], p)
+	binary.LittleEndian.PutUint32(perms[4:8], 0xFFFFFFFF)
+	copy(perms[8:12], "Tadb") // Metadata is encrypted too
+	if _, err := rand.Read(perms[12:16]); err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	block.Encrypt(perms, perms)
+
+	return &securityHandler{
+		key: key,
+		dictionary: fmt.Sprintf(
+			"<< /Filter /Standard /V 5 /R 6 /Length 256 /CF << /StdCF << /AuthEvent /DocOpen /CFM /AESV3 /Length 32 >> >> /StmF /StdCF /StrF /StdCF /O <%s> /U <%s> /OE <%s> /UE <%s> /P %d /Perms <%s> >>",
+			hex.EncodeToString(o), hex.EncodeToString(u), hex.EncodeToString(oe), hex.EncodeToString(ue),
+			int32(p), hex.EncodeToString(perms),
+		),
+	}, nil
+}
+
+// encrypt encrypts string or stream data with a random initialization
+// vector, as AESV3 crypt filters expect
+func (h *securityHandler) encrypt(data []byte) ([]byte, error) {
+	block, err := aes.NewCipher(h.key)
+	if err != nil {
+		return nil, err
+	}
+
+	// PKCS#7 padding
+	padding := aes.BlockSize - len(data)%aes.BlockSize
+	padded := append(append([]byte{}, data...), bytes.Repeat([]byte{byte(padding)}, padding)...)
+
+	out := make([]byte, aes.BlockSize+len(padded))
+	if _, err := rand.Read(out[:aes.BlockSize]); err != nil {
+		return nil, err
+	}
+	cipher.NewCBCEncrypter(block, out[:aes.BlockSize]).CryptBlocks(out[aes.BlockSize:], padded)
+	return out, nil
+}
+
+// encryptString encrypts a text string and returns it as a hex string
+func (h *securityHandler) encryptString(s string) (string, error) {
+	encrypted, err := h.encrypt(textBytes(s))
+	if err != nil {
+		return "", err
+	}
+	return "<" + hex.EncodeToString(encrypted) + ">", nil
+}
+
+// truncatePassword limits a UTF-8 password to the 127 bytes revision 6 uses
+func truncatePassword(password string) []byte {
+	b := []byte(password)
+	if len(b) > 127 {
+		b = b[:127]
+	}
+	return b
+}
+
+// hashR6 computes the revision 6 password hash (algorithm 2.B of ISO 32000-2)
+func hashR6(password, salt, userKey []byte) []byte {
+	h := sha256.New()
+	h.Write(password)
+	h.Write(salt)
+	h.Write(userKey)
+	k := h.Sum(nil)
+
+	for round := 0; ; round++ {
+		seq := make([]byte, 0, len(password)+len(k)+len(userKey))
+		seq = append(seq, password...)
+		seq = append(seq, k...)
+		seq = append(seq, userKey...)
+		k1 := bytes.Repeat(seq, 64)
+
+		block, _ := aes.NewCipher(k[:16])
+		e := make([]byte, len(k1))
+		cipher.NewCBCEncrypter(block, k[16:32]).CryptBlocks(e, k1)
+
+		// The sum of the first 16 bytes modulo 3 selects the next hash
+		sum := 0
+		for _, b := range e[:16] {
+			sum += int(b)
+		}
+		var next hash.Hash
+		switch sum % 3 {
+		case 0:
+			next = sha256.New()
+		case 1:
+			next = sha512.New384()
+		default:
+			next = sha512.New()
+		}
+		next.Write(e)
+		k = next.Sum(nil)
+
+		if round >= 63 && int(e[len(e)-1]) <= round-31 {
+			break
+		}
+	}
+
+	return k[:32]
+}
+
+// aesNoIV encrypts a 32 byte key with AES-256 in CBC mode with a zero
+// initialization vector and no padding
+func aesNoIV(key, data []byte) ([]byte, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	out := make([]byte, len(data))
+	cipher.NewCBCEncrypter(block, make([]byte, aes.BlockSize)).CryptBlocks(out, data)
+	return out, nil
+}
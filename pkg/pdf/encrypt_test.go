@@ -0,0 +1,127 @@
+package pdf
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"encoding/binary"
+	"encoding/hex"
+	"regexp"
+	"testing"
+	"time"
+)
+
+func TestHashR6(t *testing.T) {
+	// Expected hashes from an independent implementation of algorithm 2.B
+	seq := func(from, to byte) []byte {
+		var b []byte
+		for i := from; i < to; i++ {
+			b = append(b, i)
+		}
+		return b
+	}
+	for _, test := range []struct {
+		password, salt, userKey []byte
+		want                    string
+	}{
+		{[]byte("user"), seq(0, 8), nil, "731758c09c8b0160a34721d18bdd24220abada0070aa3f05b8103fd5b8d05f17"},
+		{[]byte("owner"), seq(8, 16), seq(0, 48), "400c13628b144fe2fbb850b65729e9ecb63c00fbb817c685725f25de85af0521"},
+	} {
+		if got := hex.EncodeToString(hashR6(test.password, test.salt, test.userKey)); got != test.want {
+			t.Errorf("hash of %q is %s, want %s", test.password, got, test.want)
+		}
+	}
+}
+
+// aesDecryptNoIV reverses aesNoIV
+func aesDecryptNoIV(t *testing.T, key, data []byte) []byte {
+	t.Helper()
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	out := make([]byte, len(data))
+	cipher.NewCBCDecrypter(block, make([]byte, aes.BlockSize)).CryptBlocks(out, data)
+	return out
+}
+
+func TestEncryptedDocument(t *testing.T) {
+	encryption := Encryption{UserPassword: "user", OwnerPassword: "owner", Permissions: PermissionPrint | PermissionCopy}
+	data, err := RenderImages([]string{testImage(t)}, Options{Title: "Payroll", Encryption: &encryption}, time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Contains(data, []byte("Payroll")) {
+		t.Error("title is stored in clear text")
+	}
+
+	doc, err := Parse(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	encryptRef, ok := doc.TrailerRef("Encrypt")
+	if !ok {
+		t.Fatal("trailer has no /Encrypt entry")
+	}
+	dict, _ := object(t, doc, encryptRef)
+	entry := func(key string) []byte {
+		m := regexp.MustCompile(`/` + key + ` <([0-9a-f]+)>`).FindStringSubmatch(dict)
+		if m == nil {
+			t.Fatalf("encryption dictionary has no /%s entry", key)
+		}
+		b, _ := hex.DecodeString(m[1])
+		return b
+	}
+	o, u, oe, ue, perms := entry("O"), entry("U"), entry("OE"), entry("UE"), entry("Perms")
+
+	// Both passwords validate and unlock the same file key, as a reader
+	// would check them (algorithm 2.A)
+	if !bytes.Equal(hashR6([]byte("user"), u[32:40], nil), u[:32]) {
+		t.Error("user password doesn't validate")
+	}
+	if bytes.Equal(hashR6([]byte("owner"), u[32:40], nil), u[:32]) {
+		t.Error("owner password validates as the user password")
+	}
+	if !bytes.Equal(hashR6([]byte("owner"), o[32:40], u), o[:32]) {
+		t.Error("owner password doesn't validate")
+	}
+	key := aesDecryptNoIV(t, hashR6([]byte("user"), u[40:48], nil), ue)
+	if ownerKey := aesDecryptNoIV(t, hashR6([]byte("owner"), o[40:48], u), oe); !bytes.Equal(key, ownerKey) {
+		t.Error("passwords unlock different keys")
+	}
+
+	// The permissions are sealed with the file key
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	block.Decrypt(perms, perms)
+	if p := Permissions(binary.LittleEndian.Uint32(perms[0:4])); p&0xFFF != PermissionPrint|PermissionCopy|0xC0 || string(perms[8:12]) != "Tadb" {
+		t.Errorf("unexpected permissions %x", perms)
+	}
+
+	// Strings decrypt with the file key
+	infoRef, _ := doc.TrailerRef("Info")
+	info, _ := object(t, doc, infoRef)
+	m := regexp.MustCompile(`/Title <([0-9a-f]+)>`).FindStringSubmatch(info)
+	if m == nil {
+		t.Fatalf("title is not encrypted: %s", info)
+	}
+	title, _ := hex.DecodeString(m[1])
+	cipher.NewCBCDecrypter(block, title[:aes.BlockSize]).CryptBlocks(title[aes.BlockSize:], title[aes.BlockSize:])
+	plain := title[aes.BlockSize:]
+	plain = plain[:len(plain)-int(plain[len(plain)-1])]
+	if string(plain) != "Payroll" {
+		t.Errorf("title decrypts to %q", plain)
+	}
+}
+
+func TestParsePermissions(t *testing.T) {
+	p, err := ParsePermissions([]string{"Print", " copy "})
+	if err != nil || p != PermissionPrint|PermissionCopy {
+		t.Errorf("got %b, %v", p, err)
+	}
+	if _, err := ParsePermissions([]string{"delete"}); err == nil {
+		t.Error("unknown permission accepted")
+	}
+}
@@ -0,0 +1,142 @@
+package pdf
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math"
+	"time"
+)
+
+// SRGBOutputCondition identifies the output condition of the built-in profile
+const SRGBOutputCondition = "sRGB IEC61966-2.1"
+
+// iccTag is a single tagged element of an ICC profile
+type iccTag struct {
+	signature string
+	data      []byte
+}
+
+// SRGBProfile builds an ICC version 2 display profile for the sRGB color
+// space, used as the output intent of PDF/A documents
+func SRGBProfile() []byte {
+	trc := iccCurve(1024, srgbToLinear)
+
+	tags := []iccTag{
+		{"desc", iccTextDescription(SRGBOutputCondition)},
+		{"cprt", iccText("No copyright, use freely")},
+		{"wtpt", iccXYZ(0.9505, 1.0, 1.0891)},
+		// Primaries adapted to the D50 profile connection space
+		{"rXYZ", iccXYZ(0.4361, 0.2225, 0.0139)},
+		{"gXYZ", iccXYZ(0.3851, 0.7169, 0.0971)},
+		{"bXYZ", iccXYZ(0.1431, 0.0606, 0.7141)},
+		{"rTRC", trc},
+		{"gTRC", trc},
+		{"bTRC", trc},
+	}
+
+	// Lay out the tag data after the header and tag table
+	offset := 128 + 4 + 12*len(tags)
+	var table, data bytes.Buffer
+	binary.Write(&table, binary.BigEndian, uint32(len(tags)))
+	for _, tag := range tags {
+		table.WriteString(tag.signature)
+		binary.Write(&table, binary.BigEndian, uint32(offset+data.Len()))
+		binary.Write(&table, binary.BigEndian, uint32(len(tag.data)))
+		data.Write(tag.data)
+		for data.Len()%4 != 0 {
+			data.WriteByte(0)
+		}
+	}
+
+	size := 128 + table.Len() + data.Len()
+	now := time.Now().UTC()
+
+	var header bytes.Buffer
+	binary.Write(&header, binary.BigEndian, uint32(size))
+	header.Write(make([]byte, 4))                               // Preferred CMM
+	binary.Write(&header, binary.BigEndian, uint32(0x02100000)) // Version 2.1
+	header.WriteString("mntr")                                  // Display device profile
+	header.WriteString("RGB ")                                  // Data color space
+	header.WriteString("XYZ ")                                  // Profile connection space
+	for _, v := range []int{now.Year(), int(now.Month()), now.Day(), now.Hour(), now.Minute(), now.Second()} {
+		binary.Write(&header, binary.BigEndian, uint16(v))
+	}
+	header.WriteString("acsp")
+	header.Write(make([]byte, 4+4+4+4+8))              // Platform, flags, manufacturer, model, attributes
+	binary.Write(&header, binary.BigEndian, uint32(0)) // Perceptual rendering intent
+	header.Write(s15Fixed16(0.9642))                   // D50 illuminant
+	header.Write(s15Fixed16(1.0))
+	header.Write(s15Fixed16(0.8249))
+	header.Write(make([]byte, 4))  // Creator
+	header.Write(make([]byte, 44)) // Profile ID and reserved bytes
+
+	profile := make([]byte, 0, size)
+	profile = append(profile, header.Bytes()...)
+	profile = append(profile, table.Bytes()...)
+	profile = append(profile, data.Bytes()...)
+	return profile
+}
+
+// srgbToLinear is the sRGB transfer function
+func srgbToLinear(v float64) float64 {
+	if v <= 0.04045 {
+		return v / 12.92
+	}
+	return math.Pow((v+0.055)/1.055, 2.4)
+}
+
+// s15Fixed16 encodes a number in the ICC signed 15.16 fixed point format
+func s15Fixed16(v float64) []byte {
+	b := make([]byte, 4)
+	binary.BigEndian.PutUint32(b, uint32(int32(math.Round(v*65536))))
+	return b
+}
+
+// iccXYZ encodes an XYZType tag
+func iccXYZ(x, y, z float64) []byte {
+	var b bytes.Buffer
+	b.WriteString("XYZ ")
+	b.Write(make([]byte, 4))
+	b.Write(s15Fixed16(x))
+	b.Write(s15Fixed16(y))
+	b.Write(s15Fixed16(z))
+	return b.Bytes()
+}
+
+// iccCurve encodes a curveType tag sampling the transfer function
+func iccCurve(entries int, transfer func(float64) float64) []byte {
+	var b bytes.Buffer
+	b.WriteString("curv")
+	b.Write(make([]byte, 4))
+	binary.Write(&b, binary.BigEndian, uint32(entries))
+	for i := 0; i < entries; i++ {
+		v := transfer(float64(i) / float64(entries-1))
+		binary.Write(&b, binary.BigEndian, uint16(math.Round(v*65535)))
+	}
+	return b.Bytes()
+}
+
+// iccText encodes a textType tag
+func iccText(text string) []byte {
+	var b bytes.Buffer
+	b.WriteString("text")
+	b.Write(make([]byte, 4))
+	b.WriteString(text)
+	b.WriteByte(0)
+	return b.Bytes()
+}
+
+// iccTextDescription encodes a textDescriptionType tag with an ASCII
+// description and empty Unicode and ScriptCode descriptions
+func iccTextDescription(text string) []byte {
+	var b bytes.Buffer
+	b.WriteString("desc")
+	b.Write(make([]byte, 4))
+	binary.Write(&b, binary.BigEndian, uint32(len(text)+1))
+	b.WriteString(text)
+	b.WriteByte(0)
+	b.Write(make([]byte, 4+4)) // Unicode language code and count
+	b.Write(make([]byte, 2+1)) // ScriptCode code and count
+	b.Write(make([]byte, 67))  // ScriptCode description
+	return b.Bytes()
+}
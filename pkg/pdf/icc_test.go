@@ -0,0 +1,85 @@
+package pdf
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestSRGBProfile(t *testing.T) {
+	profile := SRGBProfile()
+	if len(profile) < 132 {
+		t.Fatalf("profile is %d bytes", len(profile))
+	}
+	if size := binary.BigEndian.Uint32(profile[0:4]); int(size) != len(profile) {
+		t.Errorf("header records %d bytes, profile is %d", size, len(profile))
+	}
+	for _, field := range []struct {
+		offset int
+		want   string
+	}{
+		{12, "mntr"},
+		{16, "RGB "},
+		{20, "XYZ "},
+		{36, "acsp"},
+	} {
+		if got := string(profile[field.offset : field.offset+4]); got != field.want {
+			t.Errorf("header field at %d is %q, want %q", field.offset, got, field.want)
+		}
+	}
+
+	// Every tag lies within the profile, aligned, and starts with its type
+	tags := make(map[string][]byte)
+	count := int(binary.BigEndian.Uint32(profile[128:132]))
+	for i := 0; i < count; i++ {
+		entry := profile[132+12*i : 144+12*i]
+		offset := int(binary.BigEndian.Uint32(entry[4:8]))
+		size := int(binary.BigEndian.Uint32(entry[8:12]))
+		if offset%4 != 0 || offset+size > len(profile) {
+			t.Fatalf("tag %s at %d+%d is out of place", entry[0:4], offset, size)
+		}
+		tags[string(entry[0:4])] = profile[offset : offset+size]
+	}
+	for signature, typ := range map[string]string{
+		"desc": "desc", "cprt": "text", "wtpt": "XYZ ",
+		"rXYZ": "XYZ ", "gXYZ": "XYZ ", "bXYZ": "XYZ ",
+		"rTRC": "curv", "gTRC": "curv", "bTRC": "curv",
+	} {
+		if !bytes.HasPrefix(tags[signature], []byte(typ)) {
+			t.Errorf("tag %s is not a %q element", signature, typ)
+		}
+	}
+	if !bytes.Contains(tags["desc"], []byte(SRGBOutputCondition+"\x00")) {
+		t.Errorf("description doesn't name %s", SRGBOutputCondition)
+	}
+}
+
+func TestPDFAOutputIntent(t *testing.T) {
+	doc, err := Parse(pdfaDocument(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	intent := fmt.Sprintf("/S /GTS_PDFA1 /OutputConditionIdentifier (%s) /Info (%s)", SRGBOutputCondition, SRGBOutputCondition)
+	root := catalog(t, doc)
+	if !strings.Contains(root, intent) {
+		t.Errorf("catalog has no sRGB output intent: %s", root)
+	}
+
+	m := regexp.MustCompile(`/DestOutputProfile (\d+) 0 R`).FindStringSubmatch(root)
+	if m == nil {
+		t.Fatal("output intent has no profile")
+	}
+	num, _ := strconv.Atoi(m[1])
+	dict, data := object(t, doc, num)
+	if n, _ := dictInt(dict, "N"); n != 3 {
+		t.Errorf("profile has %d components, want 3", n)
+	}
+	profile := inflate(t, data)
+	if len(profile) < 40 || string(profile[36:40]) != "acsp" || string(profile[16:20]) != "RGB " {
+		t.Error("embedded profile is not an RGB ICC profile")
+	}
+}
@@ -0,0 +1,222 @@
+package pdf
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"fmt"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"os"
+
+	// Register the PNG decoder for page images
+	_ "image/png"
+)
+
+// DefaultDPI is assumed for page images that do not record their resolution,
+// unless Options.DPI gives another
+const DefaultDPI = 300
+
+// pageImage is a page image encoded as a PDF image XObject
+type pageImage struct {
+	Width            int
+	Height           int
+	DPIX             float64
+	DPIY             float64
+	ColorSpace       string // DeviceGray or DeviceRGB
+	BitsPerComponent int
+	Filter           string // DCTDecode or FlateDecode
+	Data             []byte
+}
+
+// loadPageImage reads a PNG or JPEG page image and prepares it for embedding.
+// Baseline grayscale and RGB JPEG files are embedded as they are, everything
+// else is decoded and stored losslessly with Flate compression. Images that
+// don't record their resolution are taken to be at dpi, or DefaultDPI.
+func loadPageImage(path string, dpi float64) (pageImage, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return pageImage{}, err
+	}
+
+	if dpi <= 0 {
+		dpi = DefaultDPI
+	}
+	img := pageImage{DPIX: dpi, DPIY: dpi}
+	if x, y, ok := ReadDPI(data); ok {
+		img.DPIX, img.DPIY = x, y
+	}
+
+	if isJPEG(data) {
+		config, err := jpeg.DecodeConfig(bytes.NewReader(data))
+		if err != nil {
+			return pageImage{}, fmt.Errorf("failed to read %s: %v", path, err)
+		}
+
+		colorSpace := ""
+		switch config.ColorModel {
+		case color.GrayModel:
+			colorSpace = "DeviceGray"
+		case color.YCbCrModel:
+			colorSpace = "DeviceRGB"
+		}
+
+		// CMYK JPEG files are re-encoded below
+		if colorSpace != "" {
+			img.Width, img.Height = config.Width, config.Height
+			img.ColorSpace = colorSpace
+			img.BitsPerComponent = 8
+			img.Filter = "DCTDecode"
+			img.Data = data
+			return img, nil
+		}
+	}
+
+	decoded, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return pageImage{}, fmt.Errorf("failed to decode %s: %v", path, err)
+	}
+
+	bounds := decoded.Bounds()
+	img.Width, img.Height = bounds.Dx(), bounds.Dy()
+	img.Filter = "FlateDecode"
+
+	var raw []byte
+	switch {
+	case isBilevel(decoded):
+		img.ColorSpace = "DeviceGray"
+		img.BitsPerComponent = 1
+		raw = packBilevel(decoded)
+	case isGray(decoded):
+		img.ColorSpace = "DeviceGray"
+		img.BitsPerComponent = 8
+		raw = make([]byte, 0, img.Width*img.Height)
+		for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+			for x := bounds.Min.X; x < bounds.Max.X; x++ {
+				raw = append(raw, color.GrayModel.Convert(decoded.At(x, y)).(color.Gray).Y)
+			}
+		}
+	default:
+		img.ColorSpace = "DeviceRGB"
+		img.BitsPerComponent = 8
+		raw = make([]byte, 0, img.Width*img.Height*3)
+		for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+			for x := bounds.Min.X; x < bounds.Max.X; x++ {
+				c := flattenAlpha(decoded.At(x, y))
+				raw = append(raw, c.R, c.G, c.B)
+			}
+		}
+	}
+
+	var compressed bytes.Buffer
+	zw := zlib.NewWriter(&compressed)
+	if _, err := zw.Write(raw); err != nil {
+		return pageImage{}, err
+	}
+	if err := zw.Close(); err != nil {
+		return pageImage{}, err
+	}
+	img.Data = compressed.Bytes()
+
+	return img, nil
+}
+
+// isJPEG reports whether the data starts with a JPEG SOI marker
+func isJPEG(data []byte) bool {
+	return len(data) > 2 && data[0] == 0xFF && data[1] == 0xD8
+}
+
+// isBilevel reports whether the image is a two-color black and white palette image
+func isBilevel(img image.Image) bool {
+	paletted, ok := img.(*image.Paletted)
+	return ok && len(paletted.Palette) <= 2
+}
+
+// isGray reports whether the image has a grayscale color model
+func isGray(img image.Image) bool {
+	switch img.ColorModel() {
+	case color.GrayModel, color.Gray16Model:
+		return true
+	}
+	return false
+}
+
+// packBilevel packs a two-color palette image into 1-bit rows, where a set
+// bit is white as in the DeviceGray color space
+func packBilevel(img image.Image) []byte {
+	paletted := img.(*image.Paletted)
+	white := make([]bool, len(paletted.Palette))
+	for i, c := range paletted.Palette {
+		white[i] = color.GrayModel.Convert(c).(color.Gray).Y >= 128
+	}
+
+	bounds := paletted.Bounds()
+	rowBytes := (bounds.Dx() + 7) / 8
+	raw := make([]byte, rowBytes*bounds.Dy())
+	for y := 0; y < bounds.Dy(); y++ {
+		for x := 0; x < bounds.Dx(); x++ {
+			index := paletted.ColorIndexAt(bounds.Min.X+x, bounds.Min.Y+y)
+			if int(index) < len(white) && white[index] {
+				raw[y*rowBytes+x/8] |= 0x80 >> (x % 8)
+			}
+		}
+	}
+	return raw
+}
+
+// flattenAlpha composites a possibly transparent color over white paper
+func flattenAlpha(c color.Color) color.RGBA {
+	r, g, b, a := c.RGBA()
+	if a == 0xFFFF {
+		return color.RGBA{uint8(r >> 8), uint8(g >> 8), uint8(b >> 8), 0xFF}
+	}
+	paper := 0xFFFF - a
+	return color.RGBA{uint8((r + paper) >> 8), uint8((g + paper) >> 8), uint8((b + paper) >> 8), 0xFF}
+}
+
+// ReadDPI extracts the resolution recorded in a PNG pHYs chunk or a JPEG
+// JFIF header. The data may be cut after the header.
+func ReadDPI(data []byte) (float64, float64, bool) {
+	if isJPEG(data) {
+		// SOI followed by an APP0 JFIF segment
+		if len(data) < 18 || data[2] != 0xFF || data[3] != 0xE0 || string(data[6:11]) != "JFIF\x00" {
+			return 0, 0, false
+		}
+		units := data[13]
+		x := float64(binary.BigEndian.Uint16(data[14:16]))
+		y := float64(binary.BigEndian.Uint16(data[16:18]))
+		switch {
+		case x == 0 || y == 0:
+			return 0, 0, false
+		case units == 1: // Dots per inch
+			return x, y, true
+		case units == 2: // Dots per centimeter
+			return x * 2.54, y * 2.54, true
+		}
+		return 0, 0, false
+	}
+
+	const pngSignature = "\x89PNG\r\n\x1a\n"
+	if len(data) < 8 || string(data[:8]) != pngSignature {
+		return 0, 0, false
+	}
+	for pos := 8; pos+8 <= len(data); {
+		length := int(binary.BigEndian.Uint32(data[pos : pos+4]))
+		chunkType := string(data[pos+4 : pos+8])
+		if chunkType == "IDAT" || pos+12+length > len(data) {
+			break
+		}
+		if chunkType == "pHYs" && length == 9 {
+			chunk := data[pos+8 : pos+8+length]
+			x := float64(binary.BigEndian.Uint32(chunk[0:4]))
+			y := float64(binary.BigEndian.Uint32(chunk[4:8]))
+			if chunk[8] == 1 && x > 0 && y > 0 { // Pixels per meter
+				return x * 0.0254, y * 0.0254, true
+			}
+			return 0, 0, false
+		}
+		pos += 12 + length
+	}
+	return 0, 0, false
+}
@@ -0,0 +1,252 @@
+package pdf
+
+import (
+	"bytes"
+	"fmt"
+	"regexp"
+	"strconv"
+)
+
+// Regular expressions for the parts of a PDF the reader understands
+var (
+	startXRefRegex = regexp.MustCompile(`startxref\s+(\d+)\s+%%EOF\s*$`)
+	xrefEntryRegex = regexp.MustCompile(`^(\d{10}) (\d{5}) ([nf])`)
+	subsectionRe   = regexp.MustCompile(`^(\d+) (\d+)\s*$`)
+	prevRegex      = regexp.MustCompile(`/Prev\s+(\d+)`)
+)
+
+// Document is a minimal read-only view of a PDF that uses classic
+// cross-reference tables, as written by this package and by img2pdf.
+// Object streams and cross-reference streams are not supported.
+type Document struct {
+	Data      []byte
+	Trailer   string      // Dictionary of the most recent trailer
+	StartXRef int         // Offset of the most recent cross-reference section
+	Offsets   map[int]int // Byte offset of each object in use
+	Size      int         // Number of entries in the cross-reference table
+}
+
+// Parse reads the cross-reference sections and trailer of a PDF
+func Parse(data []byte) (*Document, error) {
+	match := startXRefRegex.FindSubmatch(bytes.TrimRight(data, "\r\n\x00 "))
+	if match == nil {
+		return nil, fmt.Errorf("missing startxref or %%%%EOF marker")
+	}
+	startXRef, _ := strconv.Atoi(string(match[1]))
+
+	doc := &Document{
+		Data:      data,
+		StartXRef: startXRef,
+		Offsets:   make(map[int]int),
+	}
+
+	// Walk the chain of incremental updates, newest first
+	seen := make(map[int]bool)
+	for offset := startXRef; ; {
+		if seen[offset] {
+			return nil, fmt.Errorf("cross-reference sections form a loop")
+		}
+		seen[offset] = true
+
+		trailer, err := doc.readXRefSection(offset)
+		if err != nil {
+			return nil, err
+		}
+		if doc.Trailer == "" {
+			doc.Trailer = trailer
+		}
+
+		prev := prevRegex.FindStringSubmatch(trailer)
+		if prev == nil {
+			break
+		}
+		offset, _ = strconv.Atoi(prev[1])
+	}
+
+	size, ok := doc.TrailerInt("Size")
+	if !ok {
+		return nil, fmt.Errorf("trailer has no /Size entry")
+	}
+	doc.Size = size
+
+	return doc, nil
+}
+
+// readXRefSection reads one classic cross-reference section and returns the
+// trailer dictionary that follows it. Entries already known from a newer
+// section are kept.
+func (d *Document) readXRefSection(offset int) (string, error) {
+	if offset < 0 || offset >= len(d.Data) || !bytes.HasPrefix(d.Data[offset:], []byte("xref")) {
+		return "", fmt.Errorf("no cross-reference table at offset %d (cross-reference streams are not supported)", offset)
+	}
+
+	lines := bytes.Split(d.Data[offset+len("xref"):], []byte("\n"))
+	object := 0
+	for _, line := range lines {
+		line = bytes.TrimSpace(line)
+		if len(line) == 0 {
+			continue
+		}
+		if bytes.HasPrefix(line, []byte("trailer")) {
+			break
+		}
+		if m := subsectionRe.FindSubmatch(line); m != nil {
+			object, _ = strconv.Atoi(string(m[1]))
+			continue
+		}
+		m := xrefEntryRegex.FindSubmatch(line)
+		if m == nil {
+			return "", fmt.Errorf("malformed cross-reference entry %q", line)
+		}
+		if _, known := d.Offsets[object]; !known && string(m[3]) == "n" {
+			entryOffset, _ := strconv.Atoi(string(m[1]))
+			d.Offsets[object] = entryOffset
+		}
+		object++
+	}
+
+	trailerPos := bytes.Index(d.Data[offset:], []byte("trailer"))
+	if trailerPos < 0 {
+		return "", fmt.Errorf("missing trailer after cross-reference table")
+	}
+	return readDict(d.Data, offset+trailerPos+len("trailer"))
+}
+
+// TrailerRef returns the object number referenced by a trailer entry
+func (d *Document) TrailerRef(key string) (int, bool) {
+	return dictRef(d.Trailer, key)
+}
+
+// TrailerInt returns the integer value of a trailer entry
+func (d *Document) TrailerInt(key string) (int, bool) {
+	m := regexp.MustCompile(`/` + key + `\s+(\d+)`).FindStringSubmatch(d.Trailer)
+	if m == nil {
+		return 0, false
+	}
+	v, err := strconv.Atoi(m[1])
+	return v, err == nil
+}
+
+// Object returns the dictionary and, for streams, the raw stream data of an
+// object
+func (d *Document) Object(num int) (string, []byte, error) {
+	offset, ok := d.Offsets[num]
+	if !ok {
+		return "", nil, fmt.Errorf("object %d not found", num)
+	}
+
+	header := regexp.MustCompile(`^\s*` + strconv.Itoa(num) + `\s+\d+\s+obj\s*`)
+	if offset >= len(d.Data) {
+		return "", nil, fmt.Errorf("object %d offset is out of range", num)
+	}
+	loc := header.FindIndex(d.Data[offset:])
+	if loc == nil {
+		return "", nil, fmt.Errorf("cross-reference offset of object %d does not point to it", num)
+	}
+	start := offset + loc[1]
+
+	if !bytes.HasPrefix(d.Data[start:], []byte("<<")) {
+		// Not a dictionary, return the plain object body
+		end := bytes.Index(d.Data[start:], []byte("endobj"))
+		if end < 0 {
+			return "", nil, fmt.Errorf("object %d is not terminated", num)
+		}
+		return string(bytes.TrimSpace(d.Data[start : start+end])), nil, nil
+	}
+
+	dict, err := readDict(d.Data, start)
+	if err != nil {
+		return "", nil, fmt.Errorf("object %d: %v", num, err)
+	}
+
+	rest := d.Data[start+len(dict):]
+	trimmed := bytes.TrimLeft(rest, " \r\n")
+	if !bytes.HasPrefix(trimmed, []byte("stream")) {
+		return dict, nil, nil
+	}
+
+	// Stream data starts after the EOL following the stream keyword
+	dataStart := len(rest) - len(trimmed) + len("stream")
+	if bytes.HasPrefix(rest[dataStart:], []byte("\r\n")) {
+		dataStart += 2
+	} else if bytes.HasPrefix(rest[dataStart:], []byte("\n")) {
+		dataStart++
+	}
+	length, ok := dictInt(dict, "Length")
+	if !ok || dataStart+length > len(rest) {
+		return "", nil, fmt.Errorf("object %d has an invalid stream length", num)
+	}
+	return dict, rest[dataStart : dataStart+length], nil
+}
+
+// readDict returns the dictionary starting at the first "<<" at or after pos
+func readDict(data []byte, pos int) (string, error) {
+	start := bytes.Index(data[pos:], []byte("<<"))
+	if start < 0 {
+		return "", fmt.Errorf("dictionary expected at offset %d", pos)
+	}
+	start += pos
+
+	depth := 0
+	for i := start; i < len(data)-1; i++ {
+		switch data[i] {
+		case '(':
+			// Skip literal strings, which may contain unbalanced brackets
+			i = skipLiteralString(data, i)
+		case '<':
+			if data[i+1] == '<' {
+				depth++
+				i++
+			}
+		case '>':
+			if data[i+1] == '>' {
+				depth--
+				i++
+				if depth == 0 {
+					return string(data[start : i+1]), nil
+				}
+			}
+		}
+	}
+	return "", fmt.Errorf("unterminated dictionary at offset %d", start)
+}
+
+// skipLiteralString returns the index of the closing parenthesis of the
+// literal string opened at pos
+func skipLiteralString(data []byte, pos int) int {
+	depth := 0
+	for i := pos; i < len(data); i++ {
+		switch data[i] {
+		case '\\':
+			i++
+		case '(':
+			depth++
+		case ')':
+			depth--
+			if depth == 0 {
+				return i
+			}
+		}
+	}
+	return len(data) - 1
+}
+
+// dictRef returns the object number of an indirect reference entry
+func dictRef(dict, key string) (int, bool) {
+	m := regexp.MustCompile(`/` + key + `\s+(\d+)\s+\d+\s+R`).FindStringSubmatch(dict)
+	if m == nil {
+		return 0, false
+	}
+	v, err := strconv.Atoi(m[1])
+	return v, err == nil
+}
+
+// dictInt returns the value of a direct integer entry
+func dictInt(dict, key string) (int, bool) {
+	m := regexp.MustCompile(`/` + key + `\s+(\d+)(\s+\d+\s+R)?`).FindStringSubmatch(dict)
+	if m == nil || m[2] != "" {
+		return 0, false
+	}
+	v, err := strconv.Atoi(m[1])
+	return v, err == nil
+}
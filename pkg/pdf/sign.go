@@ -0,0 +1,120 @@
+package pdf
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/x509"
+	"encoding/hex"
+	"fmt"
+	"os"
+
+	"software.sslmate.com/src/go-pkcs12"
+)
+
+// signatureSize is the space reserved for the CMS signature, enough for a
+// certificate chain and a timestamp token
+const signatureSize = 16384
+
+// byteRangePlaceholder reserves room for the byte range, patched in place
+// once the file layout is known
+var byteRangePlaceholder = []byte("/ByteRange [0 0000000000 0000000000 0000000000]")
+
+// Signature holds the signing key and the details recorded in the signature
+// dictionary of a signed document
+type Signature struct {
+	Signer       crypto.Signer
+	Certificates []*x509.Certificate // Signing certificate first, then its chain
+	Name         string              // Signer name, the certificate's common name when empty
+	Reason       string              // Reason for signing
+	Location     string              // Where the document was signed
+	TimestampURL string              // RFC 3161 timestamp authority, optional
+}
+
+// LoadPKCS12 reads a signing key and its certificate chain from a PKCS#12
+// (.p12/.pfx) file
+func LoadPKCS12(path, password string) (*Signature, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read certificate: %v", err)
+	}
+
+	key, cert, chain, err := pkcs12.DecodeChain(data, password)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open certificate %s: %v", path, err)
+	}
+	signer, ok := key.(crypto.Signer)
+	if !ok {
+		return nil, fmt.Errorf("unsupported private key type %T", key)
+	}
+
+	return &Signature{
+		Signer:       signer,
+		Certificates: append([]*x509.Certificate{cert}, chain...),
+	}, nil
+}
+
+// signerName returns the name recorded in the signature dictionary
+func (s *Signature) signerName() string {
+	if s.Name != "" {
+		return s.Name
+	}
+	if len(s.Certificates) > 0 {
+		return s.Certificates[0].Subject.CommonName
+	}
+	return ""
+}
+
+// signatureDictionary returns the signature dictionary with placeholders for
+// the byte range and the signature contents
+func (w *writer) signatureDictionary(signature *Signature, signedAt string) string {
+	var b bytes.Buffer
+	b.WriteString("<< /Type /Sig /Filter /Adobe.PPKLite /SubFilter /ETSI.CAdES.detached ")
+	b.Write(byteRangePlaceholder)
+	// The signature contents are never encrypted
+	b.WriteString(" /Contents <")
+	b.Write(bytes.Repeat([]byte("0"), 2*signatureSize))
+	b.WriteString(">")
+	fmt.Fprintf(&b, " /M %s", w.text(signedAt))
+	if name := signature.signerName(); name != "" {
+		fmt.Fprintf(&b, " /Name %s", w.text(name))
+	}
+	if signature.Reason != "" {
+		fmt.Fprintf(&b, " /Reason %s", w.text(signature.Reason))
+	}
+	if signature.Location != "" {
+		fmt.Fprintf(&b, " /Location %s", w.text(signature.Location))
+	}
+	b.WriteString(" >>")
+	return b.String()
+}
+
+// signDocument fills in the byte range and signature contents of a
+// rendered document. offset is where the signature dictionary object starts.
+func signDocument(data []byte, offset int, signature *Signature) error {
+	rangeStart := bytes.Index(data[offset:], byteRangePlaceholder)
+	contentsStart := bytes.Index(data[offset:], []byte("/Contents <"))
+	if rangeStart < 0 || contentsStart < 0 {
+		return fmt.Errorf("signature placeholder not found")
+	}
+	rangeStart += offset
+	contentsStart += offset + len("/Contents ")
+	contentsEnd := contentsStart + 2*signatureSize + 2 // Including the angle brackets
+
+	byteRange := fmt.Sprintf("/ByteRange [0 %010d %010d %010d]", contentsStart, contentsEnd, len(data)-contentsEnd)
+	copy(data[rangeStart:], byteRange)
+
+	// Everything but the contents string is signed
+	signed := make([]byte, 0, len(data)-(contentsEnd-contentsStart))
+	signed = append(signed, data[:contentsStart]...)
+	signed = append(signed, data[contentsEnd:]...)
+
+	cms, err := createCMS(signed, *signature)
+	if err != nil {
+		return err
+	}
+	if len(cms) > signatureSize {
+		return fmt.Errorf("signature is too large (%d bytes, %d reserved)", len(cms), signatureSize)
+	}
+	hex.Encode(data[contentsStart+1:], cms)
+	return nil
+}
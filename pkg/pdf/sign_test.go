@@ -0,0 +1,246 @@
+package pdf
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"image"
+	"image/color"
+	"image/png"
+	"io"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// testImage writes a small gray page and returns its path
+func testImage(t *testing.T) string {
+	t.Helper()
+	img := image.NewGray(image.Rect(0, 0, 32, 24))
+	for y := 0; y < 24; y++ {
+		for x := 0; x < 32; x++ {
+			img.SetGray(x, y, color.Gray{Y: uint8(x * 8)})
+		}
+	}
+	path := filepath.Join(t.TempDir(), "page.png")
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if err := png.Encode(f, img); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// testCertificate issues a certificate valid during [notBefore, notAfter],
+// self-signed when parent is nil
+func testCertificate(t *testing.T, name string, parent *x509.Certificate, parentKey crypto.Signer, notBefore, notAfter time.Time, usage ...x509.ExtKeyUsage) (*x509.Certificate, crypto.Signer) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(time.Now().UnixNano()),
+		Subject:               pkix.Name{CommonName: name},
+		NotBefore:             notBefore,
+		NotAfter:              notAfter,
+		KeyUsage:              x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:           usage,
+		BasicConstraintsValid: true,
+	}
+	if parent == nil {
+		template.IsCA = true
+		template.KeyUsage |= x509.KeyUsageCertSign
+		parent, parentKey = template, key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, parent, key.Public(), parentKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cert, key
+}
+
+// timestampAuthority runs an RFC 3161 service that certifies the given time
+func timestampAuthority(t *testing.T, cert *x509.Certificate, key crypto.Signer, at time.Time) string {
+	t.Helper()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		var request timeStampReq
+		if _, err := asn1.Unmarshal(body, &request); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		token, err := timestampToken(cert, key, tstInfo{
+			Version:        1,
+			Policy:         asn1.ObjectIdentifier{1, 2, 3, 4},
+			MessageImprint: request.MessageImprint,
+			SerialNumber:   big.NewInt(1),
+			GenTime:        at.UTC().Truncate(time.Second),
+		})
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		response, _ := asn1.Marshal(timeStampResp{TimeStampToken: asn1.RawValue{FullBytes: token}})
+		w.Write(response)
+	}))
+	t.Cleanup(server.Close)
+	return server.URL
+}
+
+// timestampToken signs the timestamp information as a CMS token
+func timestampToken(cert *x509.Certificate, key crypto.Signer, info tstInfo) ([]byte, error) {
+	content, err := asn1.Marshal(info)
+	if err != nil {
+		return nil, err
+	}
+	digest := sha256.Sum256(content)
+	signedAttrs, err := marshalAttributes([]attributeValue{
+		{oidContentType, oidTSTInfo},
+		{oidMessageDigest, digest[:]},
+	})
+	if err != nil {
+		return nil, err
+	}
+	attrsDigest := sha256.Sum256(append([]byte{0x31}, signedAttrs[1:]...))
+	signature, err := key.Sign(rand.Reader, attrsDigest[:], crypto.SHA256)
+	if err != nil {
+		return nil, err
+	}
+
+	encapsulated, err := asn1.Marshal(content)
+	if err != nil {
+		return nil, err
+	}
+	sd, err := asn1.Marshal(signedData{
+		Version:          3,
+		DigestAlgorithms: []pkix.AlgorithmIdentifier{{Algorithm: oidSHA256}},
+		EncapContentInfo: encapContentInfo{
+			ContentType: oidTSTInfo,
+			Content:     asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 0, IsCompound: true, Bytes: encapsulated},
+		},
+		Certificates: asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 0, IsCompound: true, Bytes: cert.Raw},
+		SignerInfos: []signerInfo{{
+			Version:            1,
+			SID:                issuerAndSerial{Issuer: asn1.RawValue{FullBytes: cert.RawIssuer}, Serial: cert.SerialNumber},
+			DigestAlgorithm:    pkix.AlgorithmIdentifier{Algorithm: oidSHA256},
+			SignedAttrs:        asn1.RawValue{FullBytes: signedAttrs},
+			SignatureAlgorithm: pkix.AlgorithmIdentifier{Algorithm: oidECDSAWithSHA256},
+			Signature:          signature,
+		}},
+	})
+	if err != nil {
+		return nil, err
+	}
+	return asn1.Marshal(contentInfo{
+		ContentType: oidSignedData,
+		Content:     asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 0, IsCompound: true, Bytes: sd},
+	})
+}
+
+// verifyOne verifies a document that has a single signature
+func verifyOne(t *testing.T, data []byte, roots *x509.CertPool) SignatureReport {
+	t.Helper()
+	reports, err := VerifySignatures(data, roots)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(reports) != 1 {
+		t.Fatalf("found %d signatures, want 1", len(reports))
+	}
+	return reports[0]
+}
+
+func TestSignAndVerify(t *testing.T) {
+	now := time.Now()
+	root, rootKey := testCertificate(t, "Test Root", nil, nil, now.Add(-time.Hour), now.Add(time.Hour))
+	cert, key := testCertificate(t, "Jane Doe", root, rootKey, now.Add(-time.Hour), now.Add(time.Hour))
+	roots := x509.NewCertPool()
+	roots.AddCert(root)
+
+	signature := &Signature{Signer: key, Certificates: []*x509.Certificate{cert, root}, Reason: "Approved"}
+	data, err := RenderImages([]string{testImage(t)}, Options{Signature: signature}, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	report := verifyOne(t, data, roots)
+	if !report.Valid() || report.TrustError != nil {
+		t.Fatalf("valid %v, error %v, trust error %v", report.Valid(), report.Error, report.TrustError)
+	}
+	if report.Signer() != "Jane Doe" || report.Reason != "Approved" {
+		t.Errorf("signer %q, reason %q", report.Signer(), report.Reason)
+	}
+
+	// A signer the roots don't know isn't trusted
+	if report := verifyOne(t, data, x509.NewCertPool()); report.TrustError == nil {
+		t.Error("signer trusted without its root")
+	}
+
+	// Any change to the signed bytes invalidates the signature. The binary
+	// comment of the header is inside the first range.
+	tampered := append([]byte(nil), data...)
+	tampered[10] ^= 0x01
+	if report := verifyOne(t, tampered, roots); report.Error == nil {
+		t.Error("tampered document verified")
+	}
+}
+
+func TestExpiredSigner(t *testing.T) {
+	now := time.Now()
+	root, rootKey := testCertificate(t, "Test Root", nil, nil, now.Add(-48*time.Hour), now.Add(time.Hour))
+	cert, key := testCertificate(t, "Jane Doe", root, rootKey, now.Add(-48*time.Hour), now.Add(-24*time.Hour))
+	roots := x509.NewCertPool()
+	roots.AddCert(root)
+	tsa, tsaKey := testCertificate(t, "Test TSA", root, rootKey, now.Add(-48*time.Hour), now.Add(time.Hour), x509.ExtKeyUsageTimeStamping)
+	otherRoot, otherKey := testCertificate(t, "Other Root", nil, nil, now.Add(-48*time.Hour), now.Add(time.Hour))
+	untrustedTSA, untrustedKey := testCertificate(t, "Untrusted TSA", otherRoot, otherKey, now.Add(-48*time.Hour), now.Add(time.Hour), x509.ExtKeyUsageTimeStamping)
+	whileValid := now.Add(-36 * time.Hour)
+
+	for _, test := range []struct {
+		name      string
+		tsa       string
+		trusted   bool
+		timestamp bool
+	}{
+		// The signing time in the signature dictionary is the signer's claim
+		{name: "no timestamp"},
+		{name: "trusted timestamp", tsa: timestampAuthority(t, tsa, tsaKey, whileValid), trusted: true, timestamp: true},
+		{name: "untrusted timestamp", tsa: timestampAuthority(t, untrustedTSA, untrustedKey, whileValid), timestamp: true},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			signature := &Signature{Signer: key, Certificates: []*x509.Certificate{cert}, TimestampURL: test.tsa}
+			data, err := RenderImages([]string{testImage(t)}, Options{Signature: signature}, whileValid)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			report := verifyOne(t, data, roots)
+			if !report.Valid() {
+				t.Fatalf("invalid signature: %v", report.Error)
+			}
+			if got := !report.Timestamp.IsZero(); got != test.timestamp {
+				t.Errorf("timestamp %v, want %v", report.Timestamp, test.timestamp)
+			}
+			if trusted := report.TrustError == nil; trusted != test.trusted {
+				t.Errorf("trusted %v, want %v (%v)", trusted, test.trusted, report.TrustError)
+			}
+		})
+	}
+}
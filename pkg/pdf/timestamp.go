@@ -0,0 +1,147 @@
+package pdf
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"time"
+)
+
+// timestampTimeout limits how long we wait for a timestamp authority
+const timestampTimeout = 30 * time.Second
+
+// messageImprint is the hash of the timestamped data
+type messageImprint struct {
+	HashAlgorithm pkix.AlgorithmIdentifier
+	HashedMessage []byte
+}
+
+// timeStampReq is an RFC 3161 timestamp request
+type timeStampReq struct {
+	Version        int
+	MessageImprint messageImprint
+	Nonce          *big.Int
+	CertReq        bool
+}
+
+// timeStampResp is an RFC 3161 timestamp response
+type timeStampResp struct {
+	Status         pkiStatusInfo
+	TimeStampToken asn1.RawValue `asn1:"optional"`
+}
+
+// pkiStatusInfo holds the status of a timestamp response
+type pkiStatusInfo struct {
+	Status int
+}
+
+// tstInfo is the content signed by a timestamp authority
+type tstInfo struct {
+	Version        int
+	Policy         asn1.ObjectIdentifier
+	MessageImprint messageImprint
+	SerialNumber   *big.Int
+	GenTime        time.Time `asn1:"generalized"`
+}
+
+// requestTimestamp asks a timestamp authority to timestamp the signature
+// value and returns the DER encoded timestamp token
+func requestTimestamp(url string, signature []byte) ([]byte, error) {
+	digest := sha256.Sum256(signature)
+	nonce, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 64))
+	if err != nil {
+		return nil, err
+	}
+
+	request, err := asn1.Marshal(timeStampReq{
+		Version: 1,
+		MessageImprint: messageImprint{
+			HashAlgorithm: pkix.AlgorithmIdentifier{Algorithm: oidSHA256},
+			HashedMessage: digest[:],
+		},
+		Nonce:   nonce,
+		CertReq: true,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	client := &http.Client{Timeout: timestampTimeout}
+	resp, err := client.Post(url, "application/timestamp-query", bytes.NewReader(request))
+	if err != nil {
+		return nil, fmt.Errorf("timestamp request failed: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("timestamp authority returned %s", resp.Status)
+	}
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read timestamp response: %v", err)
+	}
+
+	var response timeStampResp
+	if _, err := asn1.Unmarshal(body, &response); err != nil {
+		return nil, fmt.Errorf("malformed timestamp response: %v", err)
+	}
+	// 0 is granted, 1 granted with modifications
+	if response.Status.Status > 1 || len(response.TimeStampToken.FullBytes) == 0 {
+		return nil, fmt.Errorf("timestamp authority rejected the request (status %d)", response.Status.Status)
+	}
+
+	if _, _, err := verifyTimestampToken(response.TimeStampToken.FullBytes, signature); err != nil {
+		return nil, fmt.Errorf("invalid timestamp token: %v", err)
+	}
+	return response.TimeStampToken.FullBytes, nil
+}
+
+// verifyTimestampToken checks that the token is a valid timestamp of the
+// signature value and returns the time it certifies with the timestamp
+// authority. Whether the authority is trusted is not checked here.
+func verifyTimestampToken(token, signature []byte) (time.Time, SignerReport, error) {
+	authority, err := verifyCMS(token, nil)
+	if err != nil {
+		return time.Time{}, SignerReport{}, err
+	}
+
+	// verifyCMS checked the token's own signature, now read what it certifies
+	var ci contentInfo
+	if _, err := asn1.Unmarshal(token, &ci); err != nil {
+		return time.Time{}, SignerReport{}, err
+	}
+	var sd signedData
+	if _, err := asn1.Unmarshal(ci.Content.Bytes, &sd); err != nil {
+		return time.Time{}, SignerReport{}, err
+	}
+	if !sd.EncapContentInfo.ContentType.Equal(oidTSTInfo) {
+		return time.Time{}, SignerReport{}, fmt.Errorf("token does not contain timestamp information")
+	}
+
+	var content []byte
+	if _, err := asn1.Unmarshal(sd.EncapContentInfo.Content.Bytes, &content); err != nil {
+		return time.Time{}, SignerReport{}, err
+	}
+	var info tstInfo
+	if _, err := asn1.Unmarshal(content, &info); err != nil {
+		return time.Time{}, SignerReport{}, fmt.Errorf("malformed timestamp information: %v", err)
+	}
+
+	hash, err := hashForOID(info.MessageImprint.HashAlgorithm.Algorithm)
+	if err != nil {
+		return time.Time{}, SignerReport{}, err
+	}
+	h := hash.New()
+	h.Write(signature)
+	if !bytes.Equal(h.Sum(nil), info.MessageImprint.HashedMessage) {
+		return time.Time{}, SignerReport{}, fmt.Errorf("timestamp does not cover this signature")
+	}
+
+	return info.GenTime, authority, nil
+}
@@ -0,0 +1,261 @@
+package pdf
+
+import (
+	"bytes"
+	"crypto/x509"
+	"encoding/hex"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf16"
+)
+
+// Regular expressions for the entries of signature dictionaries
+var (
+	byteRangeRegex = regexp.MustCompile(`/ByteRange\s*\[\s*(\d+)\s+(\d+)\s+(\d+)\s+(\d+)\s*\]`)
+	contentsRegex  = regexp.MustCompile(`/Contents\s*<([0-9A-Fa-f\s]*)>`)
+	pdfDateRegex   = regexp.MustCompile(`^D:(\d{4})(\d{2})?(\d{2})?(\d{2})?(\d{2})?(\d{2})?([Zz+-])?(\d{2})?'?(\d{2})?`)
+)
+
+// SignatureReport is the result of verifying one signature of a document
+type SignatureReport struct {
+	SignerReport
+	Name            string    // Signer name from the signature dictionary
+	Reason          string    // Reason for signing
+	Location        string    // Where the document was signed
+	SignedAt        time.Time // Signing time from the signature dictionary
+	CoversDocument  bool      // Whether the signature covers the whole file
+	Error           error     // Why the signature is invalid, nil when valid
+	TrustError      error     // Why the signer is not trusted, nil when trusted
+	EncryptedFields bool      // Name, reason and location could not be read
+}
+
+// Valid reports whether the signature is intact and covers the document
+func (r SignatureReport) Valid() bool {
+	return r.Error == nil && r.CoversDocument
+}
+
+// Signer returns the best available name for the signer
+func (r SignatureReport) Signer() string {
+	if r.Certificate != nil && r.Certificate.Subject.CommonName != "" {
+		return r.Certificate.Subject.CommonName
+	}
+	return r.Name
+}
+
+// Time returns the most reliable signing time: the timestamp when present,
+// then the signing time recorded in the signature
+func (r SignatureReport) Time() time.Time {
+	switch {
+	case !r.Timestamp.IsZero():
+		return r.Timestamp
+	case !r.SigningTime.IsZero():
+		return r.SigningTime
+	}
+	return r.SignedAt
+}
+
+// VerifySignatures checks every signature of a document. Signer
+// certificates are checked against the roots, or the system roots when
+// roots is nil.
+func VerifySignatures(data []byte, roots *x509.CertPool) ([]SignatureReport, error) {
+	matches := byteRangeRegex.FindAllSubmatchIndex(data, -1)
+	if len(matches) == 0 {
+		return nil, fmt.Errorf("document is not signed")
+	}
+	encrypted := bytes.Contains(data, []byte("/Encrypt"))
+
+	reports := make([]SignatureReport, 0, len(matches))
+	for _, m := range matches {
+		// Read the signature dictionary around the byte range
+		objStart := bytes.LastIndex(data[:m[0]], []byte(" obj"))
+		if objStart < 0 {
+			objStart = 0
+		}
+		dict, err := readDict(data, objStart)
+		if err != nil {
+			reports = append(reports, SignatureReport{Error: err})
+			continue
+		}
+
+		report := SignatureReport{EncryptedFields: encrypted}
+		if !encrypted {
+			report.Name, _ = dictString(dict, "Name")
+			report.Reason, _ = dictString(dict, "Reason")
+			report.Location, _ = dictString(dict, "Location")
+			if m, ok := dictString(dict, "M"); ok {
+				report.SignedAt = parsePDFDate(m)
+			}
+		}
+
+		var byteRange [4]int
+		for i := range byteRange {
+			byteRange[i], _ = strconv.Atoi(string(data[m[2+2*i]:m[3+2*i]]))
+		}
+		report.SignerReport, report.Error = verifyByteRange(data, dict, byteRange)
+		if report.Error == nil {
+			report.CoversDocument = byteRange[0] == 0 && byteRange[2]+byteRange[3] == len(data)
+			report.TrustError = verifyChain(report, roots)
+		}
+		reports = append(reports, report)
+	}
+
+	return reports, nil
+}
+
+// verifyByteRange checks the signature contents against the signed bytes
+func verifyByteRange(data []byte, dict string, byteRange [4]int) (SignerReport, error) {
+	a, b, c, d := byteRange[0], byteRange[1], byteRange[2], byteRange[3]
+	if a < 0 || b < a || c < a+b || c+d > len(data) {
+		return SignerReport{}, fmt.Errorf("byte range is out of bounds")
+	}
+
+	contents := contentsRegex.FindStringSubmatch(dict)
+	if contents == nil {
+		return SignerReport{}, fmt.Errorf("signature has no contents")
+	}
+	der, err := hex.DecodeString(strings.Join(strings.Fields(contents[1]), ""))
+	if err != nil {
+		return SignerReport{}, fmt.Errorf("malformed signature contents: %v", err)
+	}
+
+	signed := make([]byte, 0, b+d)
+	signed = append(signed, data[a:a+b]...)
+	signed = append(signed, data[c:c+d]...)
+	return verifyCMS(der, signed)
+}
+
+// verifyChain checks that the signing certificate chains to a trusted root.
+// The other signing times are chosen by the signer, so the chain is
+// validated now, unless a trusted timestamp authority certified when the
+// signature was made. Timestamped signatures stay valid after the
+// certificate expires.
+func verifyChain(report SignatureReport, roots *x509.CertPool) error {
+	at := time.Now()
+	if authority := report.TimestampedBy; authority != nil {
+		if err := verifySigner(*authority, roots, at, x509.ExtKeyUsageTimeStamping); err == nil {
+			at = report.Timestamp
+		}
+	}
+	return verifySigner(report.SignerReport, roots, at, x509.ExtKeyUsageAny)
+}
+
+// verifySigner checks that a signer's certificate chains to a trusted root
+// at the given time
+func verifySigner(signer SignerReport, roots *x509.CertPool, at time.Time, usage x509.ExtKeyUsage) error {
+	intermediates := x509.NewCertPool()
+	for _, c := range signer.Intermediates {
+		intermediates.AddCert(c)
+	}
+
+	options := x509.VerifyOptions{
+		Roots:         roots,
+		Intermediates: intermediates,
+		CurrentTime:   at,
+		KeyUsages:     []x509.ExtKeyUsage{usage},
+	}
+	_, err := signer.Certificate.Verify(options)
+	return err
+}
+
+// dictString returns the decoded value of a text string entry
+func dictString(dict, key string) (string, bool) {
+	loc := regexp.MustCompile(`/` + key + `\s*([(<])`).FindStringSubmatchIndex(dict)
+	if loc == nil {
+		return "", false
+	}
+	start := loc[2]
+
+	var raw []byte
+	if dict[start] == '<' {
+		end := strings.IndexByte(dict[start:], '>')
+		if end < 0 {
+			return "", false
+		}
+		b, err := hex.DecodeString(strings.Join(strings.Fields(dict[start+1:start+end]), ""))
+		if err != nil {
+			return "", false
+		}
+		raw = b
+	} else {
+		end := skipLiteralString([]byte(dict), start)
+		raw = unescapeLiteral(dict[start+1 : end])
+	}
+
+	// UTF-16BE text strings start with a byte order mark
+	if len(raw) >= 2 && raw[0] == 0xFE && raw[1] == 0xFF {
+		units := make([]uint16, 0, len(raw)/2)
+		for i := 2; i+1 < len(raw); i += 2 {
+			units = append(units, uint16(raw[i])<<8|uint16(raw[i+1]))
+		}
+		return string(utf16.Decode(units)), true
+	}
+	return string(raw), true
+}
+
+// unescapeLiteral resolves the escape sequences of a literal string
+func unescapeLiteral(s string) []byte {
+	out := make([]byte, 0, len(s))
+	for i := 0; i < len(s); i++ {
+		if s[i] != '\\' || i+1 == len(s) {
+			out = append(out, s[i])
+			continue
+		}
+		i++
+		switch c := s[i]; c {
+		case 'n':
+			out = append(out, '\n')
+		case 'r':
+			out = append(out, '\r')
+		case 't':
+			out = append(out, '\t')
+		case 'b':
+			out = append(out, '\b')
+		case 'f':
+			out = append(out, '\f')
+		case '\r', '\n':
+			// Line continuation
+		default:
+			if c >= '0' && c <= '7' {
+				// Octal escape of up to three digits
+				v, j := 0, i
+				for ; j < len(s) && j < i+3 && s[j] >= '0' && s[j] <= '7'; j++ {
+					v = v*8 + int(s[j]-'0')
+				}
+				out = append(out, byte(v))
+				i = j - 1
+			} else {
+				out = append(out, c)
+			}
+		}
+	}
+	return out
+}
+
+// parsePDFDate parses a PDF date string, returning the zero time when it is
+// malformed
+func parsePDFDate(s string) time.Time {
+	m := pdfDateRegex.FindStringSubmatch(s)
+	if m == nil {
+		return time.Time{}
+	}
+
+	field := func(i, def int) int {
+		if m[i] == "" {
+			return def
+		}
+		v, _ := strconv.Atoi(m[i])
+		return v
+	}
+	location := time.UTC
+	if m[7] == "+" || m[7] == "-" {
+		offset := field(8, 0)*3600 + field(9, 0)*60
+		if m[7] == "-" {
+			offset = -offset
+		}
+		location = time.FixedZone("", offset)
+	}
+	return time.Date(field(1, 0), time.Month(field(2, 1)), field(3, 1), field(4, 0), field(5, 0), field(6, 0), 0, location)
+}
@@ -0,0 +1,322 @@
+package pdf
+
+import (
+	"bytes"
+	"compress/zlib"
+	"crypto/md5"
+	"encoding/hex"
+	"fmt"
+	"html"
+	"os"
+	"strings"
+	"time"
+	"unicode/utf16"
+)
+
+// Producer is recorded as the producing application of generated documents
+const Producer = "scanexpress"
+
+// Options controls how a document is written
+type Options struct {
+	Title      string      // Document title
+	PDFA       bool        // Write a PDF/A-2b conforming document
+	Encryption *Encryption // Encrypt the document with AES-256 when set
+	Signature  *Signature  // Sign the document when set
+	DPI        float64     // Resolution of page images that don't record theirs, DefaultDPI when 0
+}
+
+// writer assembles the objects of a PDF and tracks their offsets
+type writer struct {
+	buf      bytes.Buffer
+	offsets  []int
+	security *securityHandler // Encrypts strings and streams when set
+	err      error            // First encryption error
+}
+
+// reserve allocates an object number
+func (w *writer) reserve() int {
+	w.offsets = append(w.offsets, 0)
+	return len(w.offsets)
+}
+
+// object writes a non-stream object
+func (w *writer) object(num int, body string) {
+	w.offsets[num-1] = w.buf.Len()
+	fmt.Fprintf(&w.buf, "%d 0 obj\n%s\nendobj\n", num, body)
+}
+
+// stream writes a stream object, adding its length to the dictionary entries
+func (w *writer) stream(num int, entries string, data []byte) {
+	if w.security != nil {
+		encrypted, err := w.security.encrypt(data)
+		if err != nil && w.err == nil {
+			w.err = err
+		}
+		data = encrypted
+	}
+
+	w.offsets[num-1] = w.buf.Len()
+	fmt.Fprintf(&w.buf, "%d 0 obj\n<< %s /Length %d >>\nstream\n", num, entries, len(data))
+	w.buf.Write(data)
+	w.buf.WriteString("\nendstream\nendobj\n")
+}
+
+// text encodes a text string, encrypting it when the document is encrypted
+func (w *writer) text(s string) string {
+	if w.security == nil {
+		return textString(s)
+	}
+
+	encrypted, err := w.security.encryptString(s)
+	if err != nil && w.err == nil {
+		w.err = err
+	}
+	return encrypted
+}
+
+// WriteImages writes a PDF with one page per image to the output path. In
+// PDF/A mode the document passes the structural self-check before it is
+// written.
+func WriteImages(images []string, output string, options Options) error {
+	data, err := RenderImages(images, options, time.Now())
+	if err != nil {
+		return err
+	}
+
+	if options.PDFA {
+		if err := CheckPDFA(data); err != nil {
+			return fmt.Errorf("PDF/A self-check failed: %v", err)
+		}
+	}
+
+	return os.WriteFile(output, data, 0644)
+}
+
+// RenderImages builds a PDF with one page per image, each page sized to its
+// image at the image's resolution. Signed documents are signed last, over
+// the complete file.
+func RenderImages(images []string, options Options, created time.Time) ([]byte, error) {
+	if len(images) == 0 {
+		return nil, fmt.Errorf("no images to write")
+	}
+	if options.PDFA && options.Encryption != nil {
+		return nil, fmt.Errorf("PDF/A documents cannot be encrypted")
+	}
+
+	w := &writer{}
+	if options.Encryption != nil {
+		security, err := newSecurityHandler(*options.Encryption)
+		if err != nil {
+			return nil, err
+		}
+		w.security = security
+	}
+	// PDF 1.7 header followed by a comment with high-bit bytes, so that
+	// transfer programs treat the file as binary
+	w.buf.WriteString("%PDF-1.7\n%\xE2\xE3\xCF\xD3\n")
+
+	catalog := w.reserve()
+	pages := w.reserve()
+	info := w.reserve()
+	metadata := w.reserve()
+
+	// The signature field is an invisible widget on the first page
+	var field, annots string
+	var signatureField int
+	if options.Signature != nil {
+		signatureField = w.reserve()
+		field = fmt.Sprintf("%d 0 R", signatureField)
+		annots = fmt.Sprintf(" /Annots [%s]", field)
+	}
+
+	var kids []string
+	for i, path := range images {
+		img, err := loadPageImage(path, options.DPI)
+		if err != nil {
+			return nil, err
+		}
+
+		page := w.reserve()
+		content := w.reserve()
+		xobject := w.reserve()
+		kids = append(kids, fmt.Sprintf("%d 0 R", page))
+
+		// Page size in points from the image resolution
+		width := float64(img.Width) * 72 / img.DPIX
+		height := float64(img.Height) * 72 / img.DPIY
+
+		w.stream(xobject, fmt.Sprintf(
+			"/Type /XObject /Subtype /Image /Width %d /Height %d /ColorSpace /%s /BitsPerComponent %d /Filter /%s",
+			img.Width, img.Height, img.ColorSpace, img.BitsPerComponent, img.Filter,
+		), img.Data)
+
+		w.stream(content, "", []byte(fmt.Sprintf("q %s 0 0 %s 0 0 cm /Im%d Do Q", number(width), number(height), i)))
+
+		w.object(page, fmt.Sprintf(
+			"<< /Type /Page /Parent %d 0 R /MediaBox [0 0 %s %s] /Resources << /XObject << /Im%d %d 0 R >> >> /Contents %d 0 R%s >>",
+			pages, number(width), number(height), i, xobject, content, annots,
+		))
+		annots = ""
+	}
+
+	w.object(pages, fmt.Sprintf("<< /Type /Pages /Kids [%s] /Count %d >>", strings.Join(kids, " "), len(kids)))
+
+	w.object(info, fmt.Sprintf(
+		"<< /Title %s /Producer %s /Creator %s /CreationDate %s /ModDate %s >>",
+		w.text(options.Title), w.text(Producer), w.text(Producer),
+		w.text(pdfDate(created)), w.text(pdfDate(created)),
+	))
+
+	// XMP metadata must stay uncompressed in PDF/A documents
+	w.stream(metadata, "/Type /Metadata /Subtype /XML", []byte(xmpMetadata(options, created)))
+
+	catalogEntries := fmt.Sprintf("/Type /Catalog /Pages %d 0 R /Metadata %d 0 R", pages, metadata)
+	if options.PDFA {
+		profile := w.reserve()
+		var compressed bytes.Buffer
+		zw := zlib.NewWriter(&compressed)
+		zw.Write(SRGBProfile())
+		zw.Close()
+		w.stream(profile, "/N 3 /Filter /FlateDecode", compressed.Bytes())
+
+		catalogEntries += fmt.Sprintf(
+			" /OutputIntents [<< /Type /OutputIntent /S /GTS_PDFA1 /OutputConditionIdentifier %s /Info %s /DestOutputProfile %d 0 R >>]",
+			textString(SRGBOutputCondition), textString(SRGBOutputCondition), profile,
+		)
+	}
+	signatureOffset := -1
+	if options.Signature != nil {
+		signature := w.reserve()
+		w.object(signatureField, fmt.Sprintf(
+			"<< /Type /Annot /Subtype /Widget /FT /Sig /T %s /V %d 0 R /Rect [0 0 0 0] /F 132 /P %s >>",
+			w.text("Signature1"), signature, kids[0],
+		))
+		signatureOffset = w.buf.Len()
+		w.object(signature, w.signatureDictionary(options.Signature, pdfDate(created)))
+
+		catalogEntries += fmt.Sprintf(" /AcroForm << /Fields [%s] /SigFlags 3 >>", field)
+	}
+	var trailerEntries string
+	if w.security != nil {
+		// AES-256 encryption is an extension of PDF 1.7
+		catalogEntries += " /Extensions << /ADBE << /BaseVersion /1.7 /ExtensionLevel 8 >> >>"
+
+		encrypt := w.reserve()
+		w.object(encrypt, w.security.dictionary)
+		trailerEntries = fmt.Sprintf(" /Encrypt %d 0 R", encrypt)
+	}
+	w.object(catalog, "<< "+catalogEntries+" >>")
+
+	if w.err != nil {
+		return nil, fmt.Errorf("failed to encrypt document: %v", w.err)
+	}
+
+	// Cross-reference table with fixed 20 byte entries
+	xref := w.buf.Len()
+	fmt.Fprintf(&w.buf, "xref\n0 %d\n0000000000 65535 f\r\n", len(w.offsets)+1)
+	for _, offset := range w.offsets {
+		fmt.Fprintf(&w.buf, "%010d 00000 n\r\n", offset)
+	}
+
+	// The file identifier is derived from the content and creation time
+	hash := md5.New()
+	hash.Write(w.buf.Bytes())
+	hash.Write([]byte(created.String()))
+	fileID := hex.EncodeToString(hash.Sum(nil))
+	fmt.Fprintf(&w.buf, "trailer\n<< /Size %d /Root %d 0 R /Info %d 0 R%s /ID [<%s> <%s>] >>\nstartxref\n%d\n%%%%EOF\n",
+		len(w.offsets)+1, catalog, info, trailerEntries, fileID, fileID, xref)
+
+	data := w.buf.Bytes()
+	if signatureOffset >= 0 {
+		if err := signDocument(data, signatureOffset, options.Signature); err != nil {
+			return nil, fmt.Errorf("failed to sign document: %v", err)
+		}
+	}
+	return data, nil
+}
+
+// xmpMetadata builds the XMP packet describing the document, including the
+// PDF/A identification schema in PDF/A mode
+func xmpMetadata(options Options, created time.Time) string {
+	date := created.Format(time.RFC3339)
+
+	var pdfaID string
+	if options.PDFA {
+		pdfaID = `
+   <rdf:Description rdf:about="" xmlns:pdfaid="http://www.aiim.org/pdfa/ns/id/">
+    <pdfaid:part>2</pdfaid:part>
+    <pdfaid:conformance>B</pdfaid:conformance>
+   </rdf:Description>`
+	}
+
+	return `<?xpacket begin="` + "\ufeff" + `" id="W5M0MpCehiHzreSzNTczkc9d"?>
+<x:xmpmeta xmlns:x="adobe:ns:meta/">
+ <rdf:RDF xmlns:rdf="http://www.w3.org/1999/02/22-rdf-syntax-ns#">
+   <rdf:Description rdf:about="" xmlns:dc="http://purl.org/dc/elements/1.1/">
+    <dc:format>application/pdf</dc:format>
+    <dc:title><rdf:Alt><rdf:li xml:lang="x-default">` + html.EscapeString(options.Title) + `</rdf:li></rdf:Alt></dc:title>
+   </rdf:Description>
+   <rdf:Description rdf:about="" xmlns:xmp="http://ns.adobe.com/xap/1.0/">
+    <xmp:CreatorTool>` + Producer + `</xmp:CreatorTool>
+    <xmp:CreateDate>` + date + `</xmp:CreateDate>
+    <xmp:ModifyDate>` + date + `</xmp:ModifyDate>
+   </rdf:Description>
+   <rdf:Description rdf:about="" xmlns:pdf="http://ns.adobe.com/pdf/1.3/">
+    <pdf:Producer>` + Producer + `</pdf:Producer>
+   </rdf:Description>` + pdfaID + `
+ </rdf:RDF>
+</x:xmpmeta>
+<?xpacket end="w"?>`
+}
+
+// pdfDate formats a time as a PDF date string
+func pdfDate(t time.Time) string {
+	_, offset := t.Zone()
+	sign := '+'
+	if offset < 0 {
+		sign = '-'
+		offset = -offset
+	}
+	return fmt.Sprintf("D:%s%c%02d'%02d'", t.Format("20060102150405"), sign, offset/3600, offset%3600/60)
+}
+
+// isASCII reports whether the string only holds printable ASCII characters
+func isASCII(s string) bool {
+	for _, r := range s {
+		if r < 0x20 || r > 0x7E {
+			return false
+		}
+	}
+	return true
+}
+
+// textString encodes a PDF text string, as a literal string when it is
+// plain ASCII and as UTF-16BE otherwise
+func textString(s string) string {
+	if isASCII(s) {
+		r := strings.NewReplacer(`\`, `\\`, `(`, `\(`, `)`, `\)`)
+		return "(" + r.Replace(s) + ")"
+	}
+	return "<" + strings.ToUpper(hex.EncodeToString(textBytes(s))) + ">"
+}
+
+// textBytes returns the bytes of a PDF text string, plain ASCII or UTF-16BE
+// with a byte order mark
+func textBytes(s string) []byte {
+	if isASCII(s) {
+		return []byte(s)
+	}
+
+	b := []byte{0xFE, 0xFF}
+	for _, u := range utf16.Encode([]rune(s)) {
+		b = append(b, byte(u>>8), byte(u))
+	}
+	return b
+}
+
+// number formats a real number for use in a PDF
+func number(v float64) string {
+	s := fmt.Sprintf("%.4f", v)
+	s = strings.TrimRight(s, "0")
+	return strings.TrimSuffix(s, ".")
+}
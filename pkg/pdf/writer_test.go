@@ -0,0 +1,116 @@
+package pdf
+
+import (
+	"bytes"
+	"compress/zlib"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// refsRegex matches the indirect references of an array
+var refsRegex = regexp.MustCompile(`(\d+) 0 R`)
+
+// object reads an object of a parsed document
+func object(t *testing.T, doc *Document, num int) (string, []byte) {
+	t.Helper()
+	dict, data, err := doc.Object(num)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return dict, data
+}
+
+// catalog returns the catalog of a parsed document
+func catalog(t *testing.T, doc *Document) string {
+	t.Helper()
+	root, ok := doc.TrailerRef("Root")
+	if !ok {
+		t.Fatal("trailer has no /Root entry")
+	}
+	dict, _ := object(t, doc, root)
+	return dict
+}
+
+// inflate decompresses FlateDecode stream data
+func inflate(t *testing.T, data []byte) []byte {
+	t.Helper()
+	zr, err := zlib.NewReader(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	inflated, err := io.ReadAll(zr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return inflated
+}
+
+func TestWriteImagesRoundTrip(t *testing.T) {
+	output := filepath.Join(t.TempDir(), "scan.pdf")
+	title := "Zoë's invoice (March)"
+	if err := WriteImages([]string{testImage(t), testImage(t)}, output, Options{Title: title, DPI: 144}); err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(output)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	doc, err := Parse(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if doc.Size != len(doc.Offsets)+1 {
+		t.Errorf("cross-reference table has %d entries for %d objects", doc.Size, len(doc.Offsets))
+	}
+
+	infoRef, ok := doc.TrailerRef("Info")
+	if !ok {
+		t.Fatal("trailer has no /Info entry")
+	}
+	info, _ := object(t, doc, infoRef)
+	if got, _ := dictString(info, "Title"); got != title {
+		t.Errorf("title %q, want %q", got, title)
+	}
+
+	pagesRef, ok := dictRef(catalog(t, doc), "Pages")
+	if !ok {
+		t.Fatal("catalog has no /Pages entry")
+	}
+	pages, _ := object(t, doc, pagesRef)
+	kids := refsRegex.FindAllStringSubmatch(pages, -1)
+	if count, _ := dictInt(pages, "Count"); count != 2 || len(kids) != 2 {
+		t.Fatalf("%d pages with %d kids, want 2", count, len(kids))
+	}
+
+	for _, kid := range kids {
+		num, _ := strconv.Atoi(kid[1])
+		page, _ := object(t, doc, num)
+
+		// 32x24 pixels at 144 DPI
+		if !strings.Contains(page, "/MediaBox [0 0 16 12]") {
+			t.Errorf("page %d: unexpected size in %s", num, page)
+		}
+
+		m := regexp.MustCompile(`/Im\d+ (\d+) 0 R`).FindStringSubmatch(page)
+		if m == nil {
+			t.Fatalf("page %d has no image", num)
+		}
+		imageRef, _ := strconv.Atoi(m[1])
+		image, data := object(t, doc, imageRef)
+		for _, entry := range []string{"/Width 32", "/Height 24", "/ColorSpace /DeviceGray", "/BitsPerComponent 8", "/Filter /FlateDecode"} {
+			if !strings.Contains(image, entry) {
+				t.Errorf("image %d: %s missing from %s", imageRef, entry, image)
+			}
+		}
+		pixels := inflate(t, data)
+		if len(pixels) != 32*24 || pixels[1] != 8 || pixels[32+31] != 248 {
+			t.Errorf("image %d: pixels don't match the page", imageRef)
+		}
+	}
+}
@@ -0,0 +1,214 @@
+// Package sane implements a client of the SANE network protocol spoken by
+// saned, so that scanners shared by another machine can be used without
+// local SANE libraries.
+package sane
+
+import (
+	"bufio"
+	"crypto/md5"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DefaultPort is the port saned listens on
+const DefaultPort = 6566
+
+// dialTimeout limits how long connecting to a server may take
+const dialTimeout = 10 * time.Second
+
+// DeviceInfo describes a scanner offered by a server
+type DeviceInfo struct {
+	Name   string // Device name to open, e.g. "brother5:bus1;dev4"
+	Vendor string
+	Model  string
+	Type   string // e.g. "flatbed scanner"
+}
+
+// Client is a connection to a saned server. A client is not safe for
+// concurrent use.
+type Client struct {
+	Username string // Name sent to the server, and used for authorization
+	Password string // Password of resources the server protects
+
+	conn net.Conn
+	host string
+	enc  encoder
+	dec  decoder
+}
+
+// Address adds the default port to a host without one
+func Address(host string) string {
+	if _, _, err := net.SplitHostPort(host); err == nil {
+		return host
+	}
+	return net.JoinHostPort(strings.Trim(host, "[]"), strconv.Itoa(DefaultPort))
+}
+
+// Dial connects to the saned server at the address (host or host:port) and
+// performs the protocol handshake. When username is empty the name of the
+// current user is sent.
+func Dial(address, username, password string) (*Client, error) {
+	address = Address(address)
+	conn, err := net.DialTimeout("tcp", address, dialTimeout)
+	if err != nil {
+		return nil, err
+	}
+	host, _, _ := net.SplitHostPort(address)
+
+	c := &Client{
+		Username: username,
+		Password: password,
+		conn:     conn,
+		host:     host,
+		enc:      encoder{w: bufio.NewWriter(conn)},
+		dec:      decoder{r: bufio.NewReader(conn)},
+	}
+	if c.Username == "" {
+		c.Username = os.Getenv("USER")
+	}
+
+	if err := c.init(); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("%s: %v", address, err)
+	}
+	return c, nil
+}
+
+// init announces the protocol version and checks the server's
+func (c *Client) init() error {
+	c.enc.word(rpcInit)
+	c.enc.word(versionCode)
+	c.enc.string(c.Username)
+	if err := c.enc.flush(); err != nil {
+		return err
+	}
+
+	status := c.dec.status()
+	version := c.dec.word()
+	if c.dec.err != nil {
+		return c.dec.err
+	}
+	if err := status.err(); err != nil {
+		return err
+	}
+	if major := version >> 24; major != 1 {
+		return fmt.Errorf("unsupported SANE version %d", major)
+	}
+	return nil
+}
+
+// Close ends the session and closes the connection
+func (c *Client) Close() error {
+	c.enc.word(rpcExit)
+	c.enc.flush()
+	return c.conn.Close()
+}
+
+// Devices lists the scanners offered by the server
+func (c *Client) Devices() ([]DeviceInfo, error) {
+	c.enc.word(rpcGetDevices)
+	if err := c.enc.flush(); err != nil {
+		return nil, err
+	}
+
+	status := c.dec.status()
+	n := c.dec.length()
+	var devices []DeviceInfo
+	for i := 0; i < n && c.dec.err == nil; i++ {
+		// The list ends with a null pointer
+		if !c.dec.pointer() {
+			continue
+		}
+		devices = append(devices, DeviceInfo{
+			Name:   c.dec.string(),
+			Vendor: c.dec.string(),
+			Model:  c.dec.string(),
+			Type:   c.dec.string(),
+		})
+	}
+	if c.dec.err != nil {
+		return nil, c.dec.err
+	}
+	return devices, status.err()
+}
+
+// Open opens the named device
+func (c *Client) Open(name string) (*Handle, error) {
+	c.enc.word(rpcOpen)
+	c.enc.string(name)
+	if err := c.enc.flush(); err != nil {
+		return nil, err
+	}
+
+	var status Status
+	var handle int32
+	err := c.reply(func() string {
+		status = c.dec.status()
+		handle = c.dec.word()
+		return c.dec.string()
+	})
+	if err != nil {
+		return nil, err
+	}
+	if err := status.err(); err != nil {
+		return nil, fmt.Errorf("failed to open %s: %v", name, err)
+	}
+
+	h := &Handle{client: c, handle: handle, name: name}
+	if err := h.loadOptions(); err != nil {
+		h.Close()
+		return nil, err
+	}
+	return h, nil
+}
+
+// reply reads the reply of a call that may ask for authorization. read
+// decodes the reply and returns the resource to authorize, which is empty
+// when the call went through; the reply is read again once authorized.
+func (c *Client) reply(read func() string) error {
+	for attempt := 0; ; attempt++ {
+		resource := read()
+		if c.dec.err != nil {
+			return c.dec.err
+		}
+		if resource == "" {
+			return nil
+		}
+		if attempt > 0 {
+			return fmt.Errorf("%s: %v", resource, StatusAccessDenied)
+		}
+		if err := c.authorize(resource); err != nil {
+			return err
+		}
+	}
+}
+
+// authorize sends the credentials for a protected resource. Passwords are
+// sent as salted MD5 digests when the server offers a salt.
+func (c *Client) authorize(resource string) error {
+	if c.Password == "" {
+		return fmt.Errorf("%s requires a username and password", resource)
+	}
+
+	password := c.Password
+	if i := strings.Index(resource, "$MD5$"); i >= 0 {
+		sum := md5.Sum([]byte(resource[i+len("$MD5$"):] + c.Password))
+		password = "$MD5$" + hex.EncodeToString(sum[:])
+		resource = resource[:i]
+	}
+
+	c.enc.word(rpcAuthorize)
+	c.enc.string(resource)
+	c.enc.string(c.Username)
+	c.enc.string(password)
+	if err := c.enc.flush(); err != nil {
+		return err
+	}
+	c.dec.word() // Dummy reply
+	return c.dec.err
+}
@@ -0,0 +1,368 @@
+package sane
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"strings"
+)
+
+// ValueType is the type of an option's value
+type ValueType int32
+
+// Option value types
+const (
+	TypeBool   ValueType = 0
+	TypeInt    ValueType = 1
+	TypeFixed  ValueType = 2 // Fixed point number with 16 fractional bits
+	TypeString ValueType = 3
+	TypeButton ValueType = 4
+	TypeGroup  ValueType = 5 // Starts a group of options, holds no value
+)
+
+// Option capabilities
+const (
+	capSoftSelect = 1 << 0
+	capHardSelect = 1 << 1
+	capSoftDetect = 1 << 2
+	capInactive   = 1 << 5
+)
+
+// Constraint types of option values
+const (
+	constraintNone       = 0
+	constraintRange      = 1
+	constraintWordList   = 2
+	constraintStringList = 3
+)
+
+// Actions of the control option call
+const (
+	actionGet = 0
+	actionSet = 1
+)
+
+// Flags returned when setting an option
+const (
+	infoReloadOptions = 1 << 1
+)
+
+// Range constrains a numeric option
+type Range struct {
+	Min, Max, Quant float64
+}
+
+// Option describes a device option, such as the scan resolution
+type Option struct {
+	Index       int
+	Name        string // e.g. "resolution", empty for groups
+	Title       string
+	Description string
+	Type        ValueType
+	Unit        int32
+	Size        int // Size of the value in bytes
+	Cap         int32
+	Range       *Range    // Allowed range of numeric values, when constrained to one
+	Numbers     []float64 // Allowed numeric values, when constrained to a list
+	Strings     []string  // Allowed string values, when constrained to a list
+}
+
+// Active reports whether the option applies in the current configuration
+func (o Option) Active() bool {
+	return o.Cap&capInactive == 0
+}
+
+// Settable reports whether the option can be changed by software
+func (o Option) Settable() bool {
+	return o.Cap&capSoftSelect != 0 && o.Active()
+}
+
+// Sensor reports whether the option reflects the state of the hardware,
+// such as a button, and can only be read
+func (o Option) Sensor() bool {
+	return o.Active() && o.Cap&capSoftDetect != 0 && (o.Cap&capHardSelect != 0 || o.Cap&capSoftSelect == 0)
+}
+
+// Nearest returns the allowed value closest to v
+func (o Option) Nearest(v float64) float64 {
+	switch {
+	case o.Range != nil:
+		v = math.Max(o.Range.Min, math.Min(o.Range.Max, v))
+		if o.Range.Quant > 0 {
+			v = o.Range.Min + math.Round((v-o.Range.Min)/o.Range.Quant)*o.Range.Quant
+		}
+	case len(o.Numbers) > 0:
+		nearest := o.Numbers[0]
+		for _, n := range o.Numbers[1:] {
+			if math.Abs(n-v) < math.Abs(nearest-v) {
+				nearest = n
+			}
+		}
+		v = nearest
+	}
+	return v
+}
+
+// Handle is an open device
+type Handle struct {
+	Options []Option
+
+	client *Client
+	handle int32
+	name   string
+}
+
+// Option returns the named option
+func (h *Handle) Option(name string) (Option, bool) {
+	for _, o := range h.Options {
+		if o.Name == name {
+			return o, true
+		}
+	}
+	return Option{}, false
+}
+
+// Close closes the device
+func (h *Handle) Close() error {
+	h.client.enc.word(rpcClose)
+	h.client.enc.word(h.handle)
+	if err := h.client.enc.flush(); err != nil {
+		return err
+	}
+	h.client.dec.word() // Dummy reply
+	return h.client.dec.err
+}
+
+// Cancel stops the current scan, or ends a batch of scans
+func (h *Handle) Cancel() error {
+	h.client.enc.word(rpcCancel)
+	h.client.enc.word(h.handle)
+	if err := h.client.enc.flush(); err != nil {
+		return err
+	}
+	h.client.dec.word() // Dummy reply
+	return h.client.dec.err
+}
+
+// loadOptions reads the option descriptors of the device
+func (h *Handle) loadOptions() error {
+	c := h.client
+	c.enc.word(rpcGetOptionDescriptors)
+	c.enc.word(h.handle)
+	if err := c.enc.flush(); err != nil {
+		return err
+	}
+
+	n := c.dec.length()
+	options := make([]Option, 0, n)
+	for i := 0; i < n && c.dec.err == nil; i++ {
+		if !c.dec.pointer() {
+			continue
+		}
+		o := Option{
+			Index:       i,
+			Name:        c.dec.string(),
+			Title:       c.dec.string(),
+			Description: c.dec.string(),
+			Type:        ValueType(c.dec.word()),
+			Unit:        c.dec.word(),
+			Size:        int(c.dec.word()),
+			Cap:         c.dec.word(),
+		}
+
+		switch c.dec.word() {
+		case constraintRange:
+			if c.dec.pointer() {
+				o.Range = &Range{
+					Min:   o.number(c.dec.word()),
+					Max:   o.number(c.dec.word()),
+					Quant: o.number(c.dec.word()),
+				}
+			}
+		case constraintWordList:
+			// The first word is the number of values that follow
+			words := c.dec.length()
+			for j := 0; j < words; j++ {
+				w := c.dec.word()
+				if j > 0 {
+					o.Numbers = append(o.Numbers, o.number(w))
+				}
+			}
+		case constraintStringList:
+			// The list ends with a null string
+			strs := c.dec.length()
+			for j := 0; j < strs; j++ {
+				if s := c.dec.string(); s != "" {
+					o.Strings = append(o.Strings, s)
+				}
+			}
+		}
+		options = append(options, o)
+	}
+	if c.dec.err != nil {
+		return fmt.Errorf("failed to read the options of %s: %v", h.name, c.dec.err)
+	}
+
+	h.Options = options
+	return nil
+}
+
+// number converts a word holding a value of the option to a number
+func (o Option) number(w int32) float64 {
+	if o.Type == TypeFixed {
+		return float64(w) / (1 << 16)
+	}
+	return float64(w)
+}
+
+// word converts a number to a word holding a value of the option
+func (o Option) word(v float64) int32 {
+	if o.Type == TypeFixed {
+		return int32(math.Round(v * (1 << 16)))
+	}
+	return int32(math.Round(v))
+}
+
+// Get returns the value of an option: a bool, a float64 for numbers or a
+// string. Options holding several values return the first.
+func (h *Handle) Get(name string) (any, error) {
+	return h.control(name, actionGet, nil)
+}
+
+// Set changes the value of an option to a bool, a number (int or float64)
+// or a string. Options holding several values are set to v throughout.
+func (h *Handle) Set(name string, v any) error {
+	_, err := h.control(name, actionSet, v)
+	return err
+}
+
+// control gets or sets the value of an option
+func (h *Handle) control(name string, action int32, v any) (any, error) {
+	o, ok := h.Option(name)
+	if !ok {
+		return nil, fmt.Errorf("%s has no option %q", h.name, name)
+	}
+	if o.Type == TypeGroup {
+		return nil, fmt.Errorf("option %q holds no value", name)
+	}
+
+	encode, err := encodeValue(o, v)
+	if err != nil {
+		return nil, err
+	}
+
+	c := h.client
+	c.enc.word(rpcControlOption)
+	c.enc.word(h.handle)
+	c.enc.word(int32(o.Index))
+	c.enc.word(action)
+	c.enc.word(int32(o.Type))
+	c.enc.word(int32(o.Size))
+	encode(&c.enc)
+	if err := c.enc.flush(); err != nil {
+		return nil, err
+	}
+
+	var status Status
+	var info int32
+	var value any
+	err = c.reply(func() string {
+		status = c.dec.status()
+		info = c.dec.word()
+		valueType := ValueType(c.dec.word())
+		c.dec.word() // Value size
+		value = h.decodeValue(o, valueType)
+		return c.dec.string()
+	})
+	if err != nil {
+		return nil, err
+	}
+	if err := status.err(); err != nil {
+		return nil, fmt.Errorf("option %q: %v", name, err)
+	}
+
+	if info&infoReloadOptions != 0 {
+		if err := h.loadOptions(); err != nil {
+			return nil, err
+		}
+	}
+	return value, nil
+}
+
+// encodeValue returns a function writing the value array of a control
+// option request. The value is checked first so that nothing is sent when it
+// is invalid. Get requests send an empty buffer of the option's size.
+func encodeValue(o Option, v any) (func(*encoder), error) {
+	switch o.Type {
+	case TypeButton:
+		return func(enc *encoder) { enc.word(0) }, nil
+
+	case TypeString:
+		s, ok := v.(string)
+		if v != nil && !ok {
+			return nil, fmt.Errorf("option %q needs a string, not %T", o.Name, v)
+		}
+		if len(s)+1 > o.Size {
+			return nil, fmt.Errorf("value %q is too long for option %q", s, o.Name)
+		}
+		return func(enc *encoder) {
+			enc.word(int32(o.Size))
+			if enc.err == nil {
+				enc.w.WriteString(s)
+				_, enc.err = enc.w.Write(make([]byte, o.Size-len(s)))
+			}
+		}, nil
+	}
+
+	var w int32
+	switch v := v.(type) {
+	case nil:
+	case bool:
+		if v {
+			w = 1
+		}
+	case int:
+		w = o.word(float64(v))
+	case float64:
+		w = o.word(v)
+	default:
+		return nil, fmt.Errorf("option %q needs a number, not %T", o.Name, v)
+	}
+	return func(enc *encoder) {
+		count := max(o.Size/4, 1)
+		enc.word(int32(count))
+		for i := 0; i < count; i++ {
+			enc.word(w)
+		}
+	}, nil
+}
+
+// decodeValue reads the value array of a control option reply
+func (h *Handle) decodeValue(o Option, valueType ValueType) any {
+	dec := &h.client.dec
+	n := dec.length()
+
+	if valueType == TypeString {
+		b := make([]byte, n)
+		if _, err := io.ReadFull(dec.r, b); err != nil && dec.err == nil {
+			dec.err = err
+		}
+		s, _, _ := strings.Cut(string(b), "\x00")
+		return s
+	}
+
+	var value any
+	for i := 0; i < n; i++ {
+		w := dec.word()
+		if i > 0 {
+			continue
+		}
+		switch valueType {
+		case TypeBool:
+			value = w != 0
+		case TypeInt, TypeFixed:
+			value = o.number(w)
+		}
+	}
+	return value
+}
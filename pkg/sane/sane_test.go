@@ -0,0 +1,452 @@
+package sane
+
+import (
+	"bufio"
+	"crypto/md5"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"image"
+	"io"
+	"net"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// Options of the stand-in device, in descriptor order
+const (
+	optCount = iota
+	optResolution
+	optMode
+	optTLX
+)
+
+// saned is a stand-in server offering one gray 8-bit device, 4 pixels wide.
+// Sessions hold mu while they run.
+type saned struct {
+	mu       sync.Mutex
+	salt     string   // Salt offered when opening the device, open to anyone when empty
+	password string   // Password of the device
+	pages    [][]byte // Pages left in the feeder
+	jam      bool     // Whether the page data is followed by StatusJammed
+	lines    int      // Lines of the page being scanned
+
+	calls      []int32 // Procedures called
+	username   string  // Name sent by init
+	authorized string  // Credentials of the authorization, as "user:password"
+	resolution int32
+	mode       string
+	tlx        float64
+}
+
+const testHandle = 7
+
+// listen serves sessions until the test ends and returns the address
+func (s *saned) listen(t *testing.T) string {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { listener.Close() })
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			s.mu.Lock()
+			s.session(conn)
+			s.mu.Unlock()
+		}
+	}()
+	return listener.Addr().String()
+}
+
+func (s *saned) session(conn net.Conn) {
+	defer conn.Close()
+	enc := &encoder{w: bufio.NewWriter(conn)}
+	dec := &decoder{r: bufio.NewReader(conn)}
+	authorized := s.salt == ""
+
+	for {
+		call := dec.word()
+		if dec.err != nil {
+			return
+		}
+		s.calls = append(s.calls, call)
+
+		switch call {
+		case rpcInit:
+			dec.word() // Version
+			s.username = dec.string()
+			enc.word(int32(StatusGood))
+			enc.word(versionCode)
+
+		case rpcGetDevices:
+			enc.word(int32(StatusGood))
+			enc.word(2)
+			enc.word(0)
+			enc.string("test:0")
+			enc.string("Acme")
+			enc.string("Frobnicator")
+			enc.string("flatbed scanner")
+			enc.word(1) // Null pointer ending the list
+
+		case rpcOpen:
+			dec.string() // Device name
+			if !authorized {
+				enc.word(int32(StatusGood))
+				enc.word(0)
+				enc.string("test:0$MD5$" + s.salt)
+				enc.flush()
+
+				if dec.word() != rpcAuthorize {
+					return
+				}
+				s.calls = append(s.calls, rpcAuthorize)
+				dec.string() // Resource
+				username, password := dec.string(), dec.string()
+				s.authorized = username + ":" + password
+				sum := md5.Sum([]byte(s.salt + s.password))
+				authorized = password == "$MD5$"+hex.EncodeToString(sum[:])
+				enc.word(0)
+			}
+			if !authorized {
+				// saned asks again, the client gives up
+				enc.word(int32(StatusGood))
+				enc.word(0)
+				enc.string("test:0$MD5$" + s.salt)
+				break
+			}
+			enc.word(int32(StatusGood))
+			enc.word(testHandle)
+			enc.word(0) // No resource to authorize
+
+		case rpcGetOptionDescriptors:
+			dec.word()
+			s.options(enc)
+
+		case rpcControlOption:
+			s.controlOption(enc, dec)
+
+		case rpcGetParameters:
+			dec.word()
+			enc.word(int32(StatusGood))
+			enc.word(FrameGray)
+			enc.word(1) // Last frame
+			enc.word(4) // Bytes per line
+			enc.word(4) // Pixels per line
+			enc.word(int32(s.lines))
+			enc.word(8)
+
+		case rpcStart:
+			dec.word()
+			if len(s.pages) == 0 {
+				enc.word(int32(StatusNoDocs))
+				enc.word(0)
+				enc.word(0)
+				enc.word(0)
+				break
+			}
+			port, err := s.sendPage(s.pages[0])
+			if err != nil {
+				return
+			}
+			enc.word(int32(StatusGood))
+			enc.word(int32(port))
+			enc.word(bigEndian)
+			enc.word(0)
+
+		case rpcCancel:
+			dec.word()
+			s.pages = nil
+			enc.word(0)
+
+		case rpcClose:
+			dec.word()
+			enc.word(0)
+
+		case rpcExit:
+			return
+		}
+		if enc.flush() != nil {
+			return
+		}
+	}
+}
+
+// options writes the option descriptors: the option count, a resolution
+// word list, a mode string list and a fixed point range
+func (s *saned) options(enc *encoder) {
+	enc.word(4)
+	descriptor := func(name string, valueType ValueType, size, cap, constraint int32) {
+		enc.word(0)
+		enc.string(name)
+		enc.string(strings.ToUpper(name[:1]) + name[1:])
+		enc.string("")
+		enc.word(int32(valueType))
+		enc.word(0) // Unit
+		enc.word(size)
+		enc.word(cap)
+		enc.word(constraint)
+	}
+	descriptor("count", TypeInt, 4, 0, constraintNone)
+	descriptor("resolution", TypeInt, 4, capSoftSelect, constraintWordList)
+	enc.word(4) // The array holds the number of values, then the values
+	for _, w := range []int32{3, 75, 150, 300} {
+		enc.word(w)
+	}
+	descriptor("mode", TypeString, 16, capSoftSelect, constraintStringList)
+	enc.word(3)
+	enc.string("Color")
+	enc.string("Gray")
+	enc.word(0) // Null string ending the list
+	descriptor("tl-x", TypeFixed, 4, capSoftSelect, constraintRange)
+	enc.word(0)
+	enc.word(0)
+	enc.word(215 << 16)
+	enc.word(0)
+}
+
+// controlOption gets or sets an option
+func (s *saned) controlOption(enc *encoder, dec *decoder) {
+	dec.word() // Handle
+	index, action, valueType, size := dec.word(), dec.word(), dec.word(), dec.word()
+
+	var str string
+	var words []int32
+	if ValueType(valueType) == TypeString {
+		b := make([]byte, dec.length())
+		io.ReadFull(dec.r, b)
+		str, _, _ = strings.Cut(string(b), "\x00")
+	} else {
+		words = make([]int32, dec.length())
+		for i := range words {
+			words[i] = dec.word()
+		}
+	}
+
+	info := int32(0)
+	if action == actionSet {
+		switch index {
+		case optResolution:
+			s.resolution = words[0]
+			info = infoReloadOptions
+		case optMode:
+			s.mode = str
+		case optTLX:
+			s.tlx = float64(words[0]) / (1 << 16)
+		}
+	}
+
+	enc.word(int32(StatusGood))
+	enc.word(info)
+	enc.word(valueType)
+	enc.word(size)
+	switch index {
+	case optMode:
+		enc.word(size)
+		enc.w.WriteString(s.mode)
+		enc.w.Write(make([]byte, int(size)-len(s.mode)))
+	case optResolution:
+		enc.word(1)
+		enc.word(s.resolution)
+	case optTLX:
+		enc.word(1)
+		enc.word(int32(s.tlx * (1 << 16)))
+	default:
+		enc.word(1)
+		enc.word(4)
+	}
+	enc.word(0) // No resource to authorize
+}
+
+// sendPage listens on a data port and sends the page on the first
+// connection to it, in records of at most 5 bytes, followed by the end
+// record and the status
+func (s *saned) sendPage(page []byte) (int, error) {
+	data, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return 0, err
+	}
+	s.pages = s.pages[1:]
+	s.lines = len(page) / 4
+	status := StatusEOF
+	if s.jam {
+		status = StatusJammed
+	}
+
+	go func() {
+		defer data.Close()
+		conn, err := data.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		w := bufio.NewWriter(conn)
+		for len(page) > 0 {
+			n := min(len(page), 5)
+			binary.Write(w, binary.BigEndian, uint32(n))
+			w.Write(page[:n])
+			page = page[n:]
+		}
+		binary.Write(w, binary.BigEndian, uint32(endOfData))
+		w.WriteByte(byte(status))
+		w.Flush()
+	}()
+	return data.Addr().(*net.TCPAddr).Port, nil
+}
+
+func TestScan(t *testing.T) {
+	page := []byte{0, 50, 100, 150, 200, 250, 255, 128, 1, 2, 3, 4}
+	server := &saned{pages: [][]byte{page, page}}
+	address := server.listen(t)
+
+	client, err := Dial(address, "alice", "")
+	if err != nil {
+		t.Fatalf("Dial: %v", err)
+	}
+	devices, err := client.Devices()
+	if err != nil {
+		t.Fatalf("Devices: %v", err)
+	}
+	want := []DeviceInfo{{Name: "test:0", Vendor: "Acme", Model: "Frobnicator", Type: "flatbed scanner"}}
+	if !reflect.DeepEqual(devices, want) {
+		t.Errorf("devices %+v, want %+v", devices, want)
+	}
+
+	h, err := client.Open("test:0")
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	resolution, ok := h.Option("resolution")
+	if !ok || !reflect.DeepEqual(resolution.Numbers, []float64{75, 150, 300}) {
+		t.Errorf("resolution option %+v", resolution)
+	}
+	if got := resolution.Nearest(200); got != 150 {
+		t.Errorf("nearest resolution to 200 is %v, want 150", got)
+	}
+	if mode, _ := h.Option("mode"); !reflect.DeepEqual(mode.Strings, []string{"Color", "Gray"}) {
+		t.Errorf("mode option %+v", mode)
+	}
+	if tlx, _ := h.Option("tl-x"); tlx.Range == nil || tlx.Range.Max != 215 {
+		t.Errorf("tl-x option %+v", tlx)
+	}
+
+	if err := h.Set("resolution", 300); err != nil {
+		t.Fatalf("Set resolution: %v", err)
+	}
+	if err := h.Set("mode", "Color"); err != nil {
+		t.Fatalf("Set mode: %v", err)
+	}
+	if err := h.Set("tl-x", 12.5); err != nil {
+		t.Fatalf("Set tl-x: %v", err)
+	}
+	if v, err := h.Get("mode"); err != nil || v != "Color" {
+		t.Errorf("mode is %v (%v), want Color", v, err)
+	}
+
+	// Both pages of the feeder, then the end of the batch
+	for i := 0; i < 2; i++ {
+		img, err := h.Scan()
+		if err != nil {
+			t.Fatalf("Scan page %d: %v", i+1, err)
+		}
+		gray, ok := img.(*image.Gray)
+		if !ok || gray.Bounds() != image.Rect(0, 0, 4, 3) || !reflect.DeepEqual(gray.Pix, page) {
+			t.Fatalf("page %d is %T %v", i+1, img, img.Bounds())
+		}
+	}
+	if _, err := h.Scan(); !IsNoDocs(err) {
+		t.Errorf("error %v with an empty feeder, want StatusNoDocs", err)
+	}
+	if err := h.Cancel(); err != nil {
+		t.Errorf("Cancel: %v", err)
+	}
+	if err := h.Close(); err != nil {
+		t.Errorf("Close: %v", err)
+	}
+	client.Close()
+
+	server.mu.Lock()
+	defer server.mu.Unlock()
+	if server.username != "alice" {
+		t.Errorf("username %q", server.username)
+	}
+	if server.resolution != 300 || server.mode != "Color" || server.tlx != 12.5 {
+		t.Errorf("options set to %d dpi, %s, tl-x %v", server.resolution, server.mode, server.tlx)
+	}
+	wantCalls := []int32{rpcInit, rpcGetDevices, rpcOpen, rpcGetOptionDescriptors,
+		// Setting the resolution reloads the options
+		rpcControlOption, rpcGetOptionDescriptors, rpcControlOption, rpcControlOption, rpcControlOption,
+		rpcStart, rpcGetParameters, rpcStart, rpcGetParameters, rpcStart, rpcCancel, rpcClose, rpcExit}
+	if !reflect.DeepEqual(server.calls, wantCalls) {
+		t.Errorf("calls %v, want %v", server.calls, wantCalls)
+	}
+}
+
+func TestScanStatusAfterData(t *testing.T) {
+	server := &saned{pages: [][]byte{make([]byte, 8)}, jam: true}
+	address := server.listen(t)
+
+	client, err := Dial(address, "alice", "")
+	if err != nil {
+		t.Fatalf("Dial: %v", err)
+	}
+	defer client.Close()
+	h, err := client.Open("test:0")
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	if _, err := h.Scan(); !errors.Is(err, StatusJammed) {
+		t.Errorf("error %v, want the status sent after the data", err)
+	}
+}
+
+func TestAuthorizeMD5(t *testing.T) {
+	server := &saned{salt: "1a2b3c", password: "hunter2"}
+	address := server.listen(t)
+
+	client, err := Dial(address, "alice", "hunter2")
+	if err != nil {
+		t.Fatalf("Dial: %v", err)
+	}
+	if _, err := client.Open("test:0"); err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	client.Close()
+
+	server.mu.Lock()
+	sum := md5.Sum([]byte("1a2b3chunter2"))
+	if want := "alice:$MD5$" + hex.EncodeToString(sum[:]); server.authorized != want {
+		t.Errorf("authorized as %q, want %q", server.authorized, want)
+	}
+	server.mu.Unlock()
+
+	// A wrong password is refused rather than retried
+	client, err = Dial(address, "alice", "wrong")
+	if err != nil {
+		t.Fatalf("Dial: %v", err)
+	}
+	defer client.Close()
+	if _, err := client.Open("test:0"); err == nil || !strings.Contains(err.Error(), StatusAccessDenied.Error()) {
+		t.Errorf("error %v with a wrong password, want access denied", err)
+	}
+}
+
+func TestAuthorizeWithoutPassword(t *testing.T) {
+	server := &saned{salt: "1a2b3c", password: "hunter2"}
+	address := server.listen(t)
+
+	client, err := Dial(address, "alice", "")
+	if err != nil {
+		t.Fatalf("Dial: %v", err)
+	}
+	defer client.Close()
+	if _, err := client.Open("test:0"); err == nil || !strings.Contains(err.Error(), "requires a username and password") {
+		t.Errorf("error %v, want the password asked for", err)
+	}
+}
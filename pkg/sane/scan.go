@@ -0,0 +1,279 @@
+package sane
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"io"
+	"net"
+	"strconv"
+)
+
+// Frame formats
+const (
+	FrameGray  = 0
+	FrameRGB   = 1
+	FrameRed   = 2
+	FrameGreen = 3
+	FrameBlue  = 4
+)
+
+// endOfData is the record length marking the end of the image data
+const endOfData = 0xFFFFFFFF
+
+// Parameters describe the frame about to be scanned
+type Parameters struct {
+	Format        int32
+	LastFrame     bool
+	BytesPerLine  int
+	PixelsPerLine int
+	Lines         int // -1 when unknown until the frame is read
+	Depth         int // Bits per sample: 1, 8 or 16
+}
+
+// Parameters returns the parameters of the current or next frame
+func (h *Handle) Parameters() (Parameters, error) {
+	c := h.client
+	c.enc.word(rpcGetParameters)
+	c.enc.word(h.handle)
+	if err := c.enc.flush(); err != nil {
+		return Parameters{}, err
+	}
+
+	status := c.dec.status()
+	p := Parameters{
+		Format:        c.dec.word(),
+		LastFrame:     c.dec.word() != 0,
+		BytesPerLine:  int(c.dec.word()),
+		PixelsPerLine: int(c.dec.word()),
+		Lines:         int(c.dec.word()),
+		Depth:         int(c.dec.word()),
+	}
+	if c.dec.err != nil {
+		return Parameters{}, c.dec.err
+	}
+	return p, status.err()
+}
+
+// frame is the data of one scanned frame
+type frame struct {
+	Parameters
+	data  []byte
+	order binary.ByteOrder // Byte order of 16-bit samples
+}
+
+// readFrame starts the next frame and reads its data from the data
+// connection the server opens for it
+func (h *Handle) readFrame() (frame, error) {
+	c := h.client
+	c.enc.word(rpcStart)
+	c.enc.word(h.handle)
+	if err := c.enc.flush(); err != nil {
+		return frame{}, err
+	}
+
+	var status Status
+	var port, byteOrder int32
+	err := c.reply(func() string {
+		status = c.dec.status()
+		port = c.dec.word()
+		byteOrder = c.dec.word()
+		return c.dec.string()
+	})
+	if err != nil {
+		return frame{}, err
+	}
+	if err := status.err(); err != nil {
+		return frame{}, err
+	}
+
+	data, err := net.DialTimeout("tcp", net.JoinHostPort(c.host, strconv.Itoa(int(port))), dialTimeout)
+	if err != nil {
+		return frame{}, fmt.Errorf("failed to connect to the data port: %v", err)
+	}
+	defer data.Close()
+
+	// Parameters are final once the frame has started
+	p, err := h.Parameters()
+	if err != nil {
+		return frame{}, err
+	}
+
+	f := frame{Parameters: p, order: binary.BigEndian}
+	if byteOrder == littleEndian {
+		f.order = binary.LittleEndian
+	}
+	if f.data, err = readRecords(bufio.NewReader(data)); err != nil {
+		return frame{}, err
+	}
+	return f, nil
+}
+
+// readRecords reads the length-prefixed records of image data up to the end
+// marker and the final status
+func readRecords(r *bufio.Reader) ([]byte, error) {
+	var data []byte
+	for {
+		var length uint32
+		if err := binary.Read(r, binary.BigEndian, &length); err != nil {
+			return nil, fmt.Errorf("image data ended early: %v", err)
+		}
+
+		if length == endOfData {
+			b, err := r.ReadByte()
+			if err != nil {
+				return nil, fmt.Errorf("image data ended early: %v", err)
+			}
+			if status := Status(b); status != StatusEOF {
+				return nil, status.err()
+			}
+			return data, nil
+		}
+
+		start := len(data)
+		data = append(data, make([]byte, length)...)
+		if _, err := io.ReadFull(r, data[start:]); err != nil {
+			return nil, fmt.Errorf("image data ended early: %v", err)
+		}
+	}
+}
+
+// Scan scans an image, reading all of its frames. In a batch, such as a
+// document feeder holding several pages, each call scans the next page and
+// fails with StatusNoDocs once the feeder is empty; Cancel ends the batch.
+func (h *Handle) Scan() (image.Image, error) {
+	var frames []frame
+	for {
+		f, err := h.readFrame()
+		if err != nil {
+			return nil, err
+		}
+		frames = append(frames, f)
+		if f.LastFrame || f.Format == FrameGray || f.Format == FrameRGB {
+			break
+		}
+	}
+	return assemble(frames)
+}
+
+// IsNoDocs reports whether the error means the document feeder is empty
+func IsNoDocs(err error) bool {
+	return errors.Is(err, StatusNoDocs)
+}
+
+// assemble converts the frames of a scan to an image: a single gray or RGB
+// frame, or separate red, green and blue frames
+func assemble(frames []frame) (image.Image, error) {
+	first := frames[0]
+	width := first.PixelsPerLine
+	height := first.Lines
+	for _, f := range frames {
+		if f.Depth != 1 && f.Depth != 8 && f.Depth != 16 {
+			return nil, fmt.Errorf("unsupported bit depth %d", f.Depth)
+		}
+		if f.PixelsPerLine != width || f.BytesPerLine <= 0 {
+			return nil, fmt.Errorf("frames of the image do not match")
+		}
+		// The line count may be unknown until all data was read, and feeders
+		// detecting the page length may send fewer lines than announced
+		if lines := len(f.data) / f.BytesPerLine; height < 0 || lines < height {
+			height = lines
+		}
+	}
+	if width <= 0 || height <= 0 {
+		return nil, fmt.Errorf("the scanner sent an empty image")
+	}
+	bounds := image.Rect(0, 0, width, height)
+
+	switch {
+	case len(frames) == 1 && first.Format == FrameGray:
+		if first.Depth == 16 {
+			img := image.NewGray16(bounds)
+			for y := 0; y < height; y++ {
+				for x := 0; x < width; x++ {
+					img.SetGray16(x, y, color.Gray16{Y: first.sample(x, y, 0, 1)})
+				}
+			}
+			return img, nil
+		}
+		img := image.NewGray(bounds)
+		for y := 0; y < height; y++ {
+			for x := 0; x < width; x++ {
+				img.SetGray(x, y, color.Gray{Y: uint8(first.sample(x, y, 0, 1) >> 8)})
+			}
+		}
+		return img, nil
+
+	case len(frames) == 1 && first.Format == FrameRGB:
+		return rgbImage(bounds, first.Depth, func(x, y, c int) uint16 {
+			return first.sample(x, y, c, 3)
+		}), nil
+
+	case len(frames) == 3:
+		channels := map[int32]frame{}
+		for _, f := range frames {
+			channels[f.Format] = f
+		}
+		red, okRed := channels[FrameRed]
+		green, okGreen := channels[FrameGreen]
+		blue, okBlue := channels[FrameBlue]
+		if !okRed || !okGreen || !okBlue {
+			return nil, fmt.Errorf("expected red, green and blue frames")
+		}
+		planes := []frame{red, green, blue}
+		return rgbImage(bounds, first.Depth, func(x, y, c int) uint16 {
+			return planes[c].sample(x, y, 0, 1)
+		}), nil
+	}
+
+	return nil, fmt.Errorf("unsupported frame format %d", first.Format)
+}
+
+// rgbImage builds an RGB image from 16-bit samples, keeping 16 bits per
+// channel only when the scan had them
+func rgbImage(bounds image.Rectangle, depth int, sample func(x, y, c int) uint16) image.Image {
+	if depth == 16 {
+		img := image.NewRGBA64(bounds)
+		for y := 0; y < bounds.Dy(); y++ {
+			for x := 0; x < bounds.Dx(); x++ {
+				img.SetRGBA64(x, y, color.RGBA64{R: sample(x, y, 0), G: sample(x, y, 1), B: sample(x, y, 2), A: 0xFFFF})
+			}
+		}
+		return img
+	}
+
+	img := image.NewRGBA(bounds)
+	for y := 0; y < bounds.Dy(); y++ {
+		for x := 0; x < bounds.Dx(); x++ {
+			img.SetRGBA(x, y, color.RGBA{
+				R: uint8(sample(x, y, 0) >> 8),
+				G: uint8(sample(x, y, 1) >> 8),
+				B: uint8(sample(x, y, 2) >> 8),
+				A: 0xFF,
+			})
+		}
+	}
+	return img
+}
+
+// sample returns a sample of the frame scaled to 16 bits. In 1-bit gray
+// frames a set bit is black.
+func (f frame) sample(x, y, channel, channels int) uint16 {
+	line := f.data[y*f.BytesPerLine:]
+	i := x*channels + channel
+
+	switch f.Depth {
+	case 1:
+		set := line[i/8]>>(7-i%8)&1 == 1
+		if set == (f.Format == FrameGray) {
+			return 0
+		}
+		return 0xFFFF
+	case 16:
+		return f.order.Uint16(line[2*i:])
+	}
+	return uint16(line[i]) * 0x101
+}
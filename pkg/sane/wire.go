@@ -0,0 +1,170 @@
+package sane
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+)
+
+// Remote procedure calls of the SANE network protocol
+const (
+	rpcInit                 = 0
+	rpcGetDevices           = 1
+	rpcOpen                 = 2
+	rpcClose                = 3
+	rpcGetOptionDescriptors = 4
+	rpcControlOption        = 5
+	rpcGetParameters        = 6
+	rpcStart                = 7
+	rpcCancel               = 8
+	rpcAuthorize            = 9
+	rpcExit                 = 10
+)
+
+// versionCode is SANE 1.0, network protocol version 3
+const versionCode = 1<<24 | 0<<16 | 3
+
+// Byte orders of 16-bit image data announced by the server
+const (
+	littleEndian = 0x1234
+	bigEndian    = 0x4321
+)
+
+// Status is a SANE status code. Every status other than StatusGood is used as
+// an error.
+type Status int32
+
+// Status codes defined by the SANE standard
+const (
+	StatusGood         Status = 0
+	StatusUnsupported  Status = 1
+	StatusCancelled    Status = 2
+	StatusDeviceBusy   Status = 3
+	StatusInvalid      Status = 4
+	StatusEOF          Status = 5
+	StatusJammed       Status = 6
+	StatusNoDocs       Status = 7
+	StatusCoverOpen    Status = 8
+	StatusIOError      Status = 9
+	StatusNoMem        Status = 10
+	StatusAccessDenied Status = 11
+)
+
+var statusMessages = map[Status]string{
+	StatusGood:         "success",
+	StatusUnsupported:  "operation not supported",
+	StatusCancelled:    "operation was cancelled",
+	StatusDeviceBusy:   "device busy",
+	StatusInvalid:      "invalid argument",
+	StatusEOF:          "end of file reached",
+	StatusJammed:       "document feeder jammed",
+	StatusNoDocs:       "document feeder out of documents",
+	StatusCoverOpen:    "scanner cover is open",
+	StatusIOError:      "error during device I/O",
+	StatusNoMem:        "out of memory",
+	StatusAccessDenied: "access to resource has been denied",
+}
+
+func (s Status) Error() string {
+	if message, ok := statusMessages[s]; ok {
+		return message
+	}
+	return fmt.Sprintf("unknown SANE status %d", int32(s))
+}
+
+// err returns the status as an error, nil for StatusGood
+func (s Status) err() error {
+	if s == StatusGood {
+		return nil
+	}
+	return s
+}
+
+// encoder writes values in the wire format of the protocol: big-endian
+// 32-bit words, and strings and arrays prefixed with their length
+type encoder struct {
+	w   *bufio.Writer
+	err error
+}
+
+func (e *encoder) word(v int32) {
+	if e.err != nil {
+		return
+	}
+	e.err = binary.Write(e.w, binary.BigEndian, v)
+}
+
+// string writes a NUL-terminated string, its length including the NUL
+func (e *encoder) string(s string) {
+	e.word(int32(len(s) + 1))
+	if e.err != nil {
+		return
+	}
+	e.w.WriteString(s)
+	e.err = e.w.WriteByte(0)
+}
+
+func (e *encoder) flush() error {
+	if e.err != nil {
+		return e.err
+	}
+	return e.w.Flush()
+}
+
+// decoder reads values in the wire format of the protocol. The first error
+// is kept and later reads return zero values.
+type decoder struct {
+	r   *bufio.Reader
+	err error
+}
+
+// maxArrayLength guards against allocating huge arrays for corrupt replies
+const maxArrayLength = 1 << 20
+
+func (d *decoder) word() int32 {
+	if d.err != nil {
+		return 0
+	}
+	var v int32
+	d.err = binary.Read(d.r, binary.BigEndian, &v)
+	return v
+}
+
+// length reads the length of an array or string
+func (d *decoder) length() int {
+	n := d.word()
+	if n < 0 || n > maxArrayLength {
+		if d.err == nil {
+			d.err = fmt.Errorf("invalid array length %d", n)
+		}
+		return 0
+	}
+	return int(n)
+}
+
+// string reads a NUL-terminated string, empty for a null string
+func (d *decoder) string() string {
+	n := d.length()
+	if n == 0 || d.err != nil {
+		return ""
+	}
+	b := make([]byte, n)
+	if _, err := io.ReadFull(d.r, b); err != nil {
+		d.err = err
+		return ""
+	}
+	if b[n-1] == 0 {
+		b = b[:n-1]
+	}
+	return string(b)
+}
+
+// pointer reads the null flag of a pointer, reporting whether a value follows
+func (d *decoder) pointer() bool {
+	return d.word() == 0
+}
+
+func (d *decoder) status() Status {
+	return Status(d.word())
+}
@@ -0,0 +1,115 @@
+package scanner
+
+import (
+	"image"
+	"image/color"
+)
+
+// ColorMode describes how much color information a scanned page carries
+type ColorMode string
+
+// Page color modes
+const (
+	ColorModeColor      ColorMode = "color"
+	ColorModeGrayscale  ColorMode = "grayscale"
+	ColorModeBlackWhite ColorMode = "black & white"
+)
+
+// ColorModes lists the color modes from richest to poorest
+var ColorModes = []ColorMode{ColorModeColor, ColorModeGrayscale, ColorModeBlackWhite}
+
+// Next returns the following color mode, wrapping around after the last one
+func (c ColorMode) Next() ColorMode {
+	for i, mode := range ColorModes {
+		if mode == c {
+			return ColorModes[(i+1)%len(ColorModes)]
+		}
+	}
+	return ColorModes[0]
+}
+
+// ClassifyPage decides from the scanned pixels whether the page is color,
+// grayscale or black and white
+func ClassifyPage(path string) (ColorMode, error) {
+	img, err := loadImage(path)
+	if err != nil {
+		return "", err
+	}
+	return classifyImage(img), nil
+}
+
+// classifyImage classifies a decoded page image
+func classifyImage(img image.Image) ColorMode {
+	sample := newColorSample(img, 800)
+	switch {
+	case sample.hasColor():
+		return ColorModeColor
+	case sample.isText():
+		return ColorModeBlackWhite
+	default:
+		return ColorModeGrayscale
+	}
+}
+
+// colorSample is a downscaled copy of a page used to decide how it can be
+// encoded
+type colorSample struct {
+	pixels []color.NRGBA
+}
+
+// newColorSample samples the image so that its largest side is at most
+// maxDim pixels
+func newColorSample(img image.Image, maxDim int) colorSample {
+	bounds := img.Bounds()
+	step := 1
+	if largest := max(bounds.Dx(), bounds.Dy()); largest > maxDim {
+		step = (largest + maxDim - 1) / maxDim
+	}
+
+	var sample colorSample
+	for y := bounds.Min.Y; y < bounds.Max.Y; y += step {
+		for x := bounds.Min.X; x < bounds.Max.X; x += step {
+			sample.pixels = append(sample.pixels, color.NRGBAModel.Convert(img.At(x, y)).(color.NRGBA))
+		}
+	}
+	return sample
+}
+
+// hasColor reports whether a meaningful share of the page is saturated
+// rather than a tint of paper or scanner noise
+func (s colorSample) hasColor() bool {
+	if len(s.pixels) == 0 {
+		return false
+	}
+
+	colored := 0
+	for _, p := range s.pixels {
+		hi := max(p.R, p.G, p.B)
+		lo := min(p.R, p.G, p.B)
+		if hi-lo > 40 {
+			colored++
+		}
+	}
+
+	// More than 0.5% of the page
+	return colored*200 > len(s.pixels)
+}
+
+// isText reports whether the page is made of ink on paper with hardly any
+// mid-tones, so that it survives conversion to 1-bit
+func (s colorSample) isText() bool {
+	if len(s.pixels) == 0 {
+		return false
+	}
+
+	midTones := 0
+	for _, p := range s.pixels {
+		y := color.GrayModel.Convert(p).(color.Gray).Y
+		if y > 64 && y < 192 {
+			midTones++
+		}
+	}
+
+	// Less than 5% of the page
+	return midTones*20 < len(s.pixels)
+}
@@ -0,0 +1,235 @@
+package scanner
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/jpeg"
+	"math"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// Page encodings chosen by the compression step
+const (
+	EncodingOriginal  = "original"
+	EncodingColorJPEG = "color JPEG"
+	EncodingGrayJPEG  = "grayscale JPEG"
+	EncodingGrayPNG   = "grayscale PNG"
+	EncodingBilevel   = "1-bit"
+)
+
+// DefaultJPEGQuality is used when no valid JPEG quality is configured
+const DefaultJPEGQuality = 85
+
+// CompressionOptions controls how scanned pages are re-encoded before they
+// are put into the PDF
+type CompressionOptions struct {
+	JPEG        bool // Encode color and grayscale pages as JPEG
+	JPEGQuality int  // JPEG quality (1-100)
+	Grayscale   bool // Drop the color channels of pages without color
+	Bilevel     bool // Convert text pages to 1-bit with adaptive thresholding
+}
+
+// Enabled reports whether any compression policy is active
+func (o CompressionOptions) Enabled() bool {
+	return o.JPEG || o.Grayscale || o.Bilevel
+}
+
+// PageCompression records the encoding chosen for a single page
+type PageCompression struct {
+	File         string    // Path to the encoded page image
+	ColorMode    ColorMode // Color mode the encoding was chosen for
+	Encoding     string    // One of the Encoding* constants
+	OriginalSize int64     // Size of the scanned image in bytes
+	Size         int64     // Size of the encoded image in bytes
+}
+
+// CompressPage re-encodes the scanned page according to the options and its
+// color mode, and returns the encoding that was chosen. The page is
+// classified first when no color mode is given. A mode chosen by the user
+// rather than detected is applied whatever the options: grayscale pages lose
+// their color and black and white pages are made 1-bit. The original PNG is
+// replaced by the new encoding only when that is smaller, unless the mode was
+// chosen.
+func CompressPage(path string, mode ColorMode, chosen bool, options CompressionOptions) (PageCompression, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return PageCompression{}, err
+	}
+	result := PageCompression{
+		File:         path,
+		ColorMode:    mode,
+		Encoding:     EncodingOriginal,
+		OriginalSize: info.Size(),
+		Size:         info.Size(),
+	}
+
+	if !options.Enabled() && (!chosen || mode == ColorModeColor) {
+		return result, nil
+	}
+
+	img, err := loadImage(path)
+	if err != nil {
+		return result, err
+	}
+
+	if mode == "" {
+		mode = classifyImage(img)
+		result.ColorMode = mode
+	}
+	base := strings.TrimSuffix(path, filepath.Ext(path))
+
+	var (
+		encoded  image.Image
+		encoding string
+		outPath  string
+	)
+
+	switch {
+	case (options.Bilevel || chosen) && mode == ColorModeBlackWhite:
+		encoded = adaptiveThreshold(toGray(img))
+		encoding = EncodingBilevel
+		outPath = base + ".png"
+
+	case (options.Grayscale || chosen) && mode != ColorModeColor:
+		encoded = toGray(img)
+		encoding = EncodingGrayPNG
+		outPath = base + ".png"
+		if options.JPEG {
+			encoding = EncodingGrayJPEG
+			outPath = base + ".jpg"
+		}
+
+	case options.JPEG:
+		encoded = img
+		encoding = EncodingColorJPEG
+		outPath = base + ".jpg"
+
+	default:
+		return result, nil
+	}
+
+	// The new encoding keeps the resolution of the scan. It is written next
+	// to the scan first, as it may turn out larger.
+	dpi := fileDPI(path)
+	tmpPath := outPath + ".tmp"
+	if strings.HasSuffix(outPath, ".jpg") {
+		err = saveJPEG(tmpPath, encoded, options.JPEGQuality, dpi)
+	} else {
+		err = savePNG(tmpPath, encoded, dpi)
+	}
+	if err != nil {
+		os.Remove(tmpPath)
+		return result, err
+	}
+	info, err = os.Stat(tmpPath)
+	if err != nil {
+		os.Remove(tmpPath)
+		return result, err
+	}
+
+	// Keep the scan unless the new encoding is smaller, or the color mode
+	// was chosen and has to be applied
+	if info.Size() >= result.OriginalSize && !chosen {
+		os.Remove(tmpPath)
+		return result, nil
+	}
+	if err := os.Rename(tmpPath, outPath); err != nil {
+		os.Remove(tmpPath)
+		return result, err
+	}
+
+	// Remove the original scan once it has been replaced by a new file
+	if outPath != path {
+		if err := os.Remove(path); err != nil {
+			return result, fmt.Errorf("failed to remove %s: %v", filepath.Base(path), err)
+		}
+	}
+
+	result.Size = info.Size()
+	result.File = outPath
+	result.Encoding = encoding
+	return result, nil
+}
+
+// saveJPEG encodes the image as JPEG at the given quality. The resolution is
+// recorded in a JFIF header when dpi is not 0.
+func saveJPEG(path string, img image.Image, quality int, dpi int) error {
+	if quality < 1 || quality > 100 {
+		quality = DefaultJPEGQuality
+	}
+
+	var b bytes.Buffer
+	if err := jpeg.Encode(&b, img, &jpeg.Options{Quality: quality}); err != nil {
+		return fmt.Errorf("failed to encode %s: %v", path, err)
+	}
+	data := b.Bytes()
+
+	if dpi > 0 && dpi <= math.MaxUint16 {
+		// An APP0 segment right after the start of image
+		app0 := []byte{0xFF, 0xE0, 0, 16, 'J', 'F', 'I', 'F', 0, 1, 1, 1, 0, 0, 0, 0, 0, 0}
+		binary.BigEndian.PutUint16(app0[12:14], uint16(dpi))
+		binary.BigEndian.PutUint16(app0[14:16], uint16(dpi))
+		data = slices.Concat(data[:2], app0, data[2:])
+	}
+	return os.WriteFile(path, data, 0644)
+}
+
+// toGray converts the image to 8-bit grayscale
+func toGray(img image.Image) *image.Gray {
+	if gray, ok := img.(*image.Gray); ok {
+		return gray
+	}
+
+	bounds := img.Bounds()
+	gray := image.NewGray(image.Rect(0, 0, bounds.Dx(), bounds.Dy()))
+	draw.Draw(gray, gray.Bounds(), img, bounds.Min, draw.Src)
+	return gray
+}
+
+// adaptiveThreshold converts a grayscale page to black and white using
+// Bradley's local mean thresholding, which copes with uneven lighting and
+// shadows near the page edges far better than a global threshold
+func adaptiveThreshold(gray *image.Gray) *image.Paletted {
+	bounds := gray.Bounds()
+	w, h := bounds.Dx(), bounds.Dy()
+
+	// Integral image of the luminance
+	integral := make([]int64, (w+1)*(h+1))
+	for y := 0; y < h; y++ {
+		var rowSum int64
+		for x := 0; x < w; x++ {
+			rowSum += int64(gray.Pix[y*gray.Stride+x])
+			integral[(y+1)*(w+1)+x+1] = integral[y*(w+1)+x+1] + rowSum
+		}
+	}
+
+	// A window of about 1/16 of the page width, darker than 85% of its mean
+	window := max(w/16, 8)
+	const percent = 15
+
+	// A two-color palette makes the PNG encoder write a 1-bit image
+	out := image.NewPaletted(image.Rect(0, 0, w, h), color.Palette{color.Black, color.White})
+	for y := 0; y < h; y++ {
+		y0, y1 := max(y-window/2, 0), min(y+window/2, h-1)
+		for x := 0; x < w; x++ {
+			x0, x1 := max(x-window/2, 0), min(x+window/2, w-1)
+
+			count := int64((x1 - x0 + 1) * (y1 - y0 + 1))
+			sum := integral[(y1+1)*(w+1)+x1+1] - integral[y0*(w+1)+x1+1] -
+				integral[(y1+1)*(w+1)+x0] + integral[y0*(w+1)+x0]
+
+			if int64(gray.Pix[y*gray.Stride+x])*count*100 > sum*(100-percent) {
+				out.Pix[y*out.Stride+x] = 1
+			}
+		}
+	}
+
+	return out
+}
@@ -0,0 +1,98 @@
+package scanner
+
+import (
+	"image"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// noisyPage returns a page of random pixels, which every re-encoding makes
+// smaller
+func noisyPage(width, height int) image.Image {
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	rand.New(rand.NewSource(1)).Read(img.Pix)
+	for i := 3; i < len(img.Pix); i += 4 {
+		img.Pix[i] = 0xFF
+	}
+	return img
+}
+
+func TestCompressPageChosenMode(t *testing.T) {
+	for _, test := range []struct {
+		mode     ColorMode
+		chosen   bool
+		options  CompressionOptions
+		encoding string
+	}{
+		// Detected modes only apply with a compression policy
+		{ColorModeGrayscale, false, CompressionOptions{}, EncodingOriginal},
+		{ColorModeGrayscale, false, CompressionOptions{Grayscale: true}, EncodingGrayPNG},
+		{ColorModeBlackWhite, false, CompressionOptions{JPEG: true}, EncodingColorJPEG},
+		// Chosen modes apply whatever the policy
+		{ColorModeGrayscale, true, CompressionOptions{}, EncodingGrayPNG},
+		{ColorModeGrayscale, true, CompressionOptions{JPEG: true}, EncodingGrayJPEG},
+		{ColorModeBlackWhite, true, CompressionOptions{}, EncodingBilevel},
+		{ColorModeBlackWhite, true, CompressionOptions{Grayscale: true}, EncodingBilevel},
+		{ColorModeColor, true, CompressionOptions{}, EncodingOriginal},
+	} {
+		file := filepath.Join(t.TempDir(), "page_001.png")
+		if err := savePNG(file, noisyPage(200, 200), 300); err != nil {
+			t.Fatal(err)
+		}
+		page, err := CompressPage(file, test.mode, test.chosen, test.options)
+		if err != nil {
+			t.Fatalf("CompressPage: %v", err)
+		}
+		if page.Encoding != test.encoding {
+			t.Errorf("%s page (chosen %v) with %+v encoded as %s, want %s", test.mode, test.chosen, test.options, page.Encoding, test.encoding)
+		}
+		if dpi := fileDPI(page.File); dpi != 300 {
+			t.Errorf("%s page encoded as %s records %d DPI, want 300", test.mode, page.Encoding, dpi)
+		}
+	}
+}
+
+func TestCompressPageKeepsSmallerOriginal(t *testing.T) {
+	dir := t.TempDir()
+
+	// Thin stripes are smaller as PNG than as JPEG
+	striped := filepath.Join(dir, "page_001.png")
+	img := image.NewGray(image.Rect(0, 0, 600, 800))
+	for i := range img.Pix {
+		img.Pix[i] = uint8(i%2) * 0xFF
+	}
+	if err := savePNG(striped, img, 300); err != nil {
+		t.Fatal(err)
+	}
+	page, err := CompressPage(striped, ColorModeColor, false, CompressionOptions{JPEG: true})
+	if err != nil {
+		t.Fatalf("CompressPage: %v", err)
+	}
+	if page.Encoding != EncodingOriginal || page.File != striped || page.Size != page.OriginalSize {
+		t.Errorf("striped page encoded as %s in %s, want the original kept", page.Encoding, page.File)
+	}
+	if _, err := os.Stat(striped); err != nil {
+		t.Errorf("original removed: %v", err)
+	}
+	if matches, _ := filepath.Glob(filepath.Join(dir, "*.jpg*")); len(matches) > 0 {
+		t.Errorf("JPEG files left: %v", matches)
+	}
+
+	// Noise is smaller as JPEG
+	noisy := filepath.Join(dir, "page_002.png")
+	if err := savePNG(noisy, noisyPage(600, 800), 300); err != nil {
+		t.Fatal(err)
+	}
+	page, err = CompressPage(noisy, ColorModeColor, false, CompressionOptions{JPEG: true})
+	if err != nil {
+		t.Fatalf("CompressPage: %v", err)
+	}
+	if page.Encoding != EncodingColorJPEG || page.Size >= page.OriginalSize {
+		t.Errorf("noisy page encoded as %s, %d bytes from %d", page.Encoding, page.Size, page.OriginalSize)
+	}
+	if _, err := os.Stat(noisy); !os.IsNotExist(err) {
+		t.Error("original kept next to the JPEG")
+	}
+}
@@ -0,0 +1,88 @@
+package scanner
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+
+	"scanexpress/pkg/mdns"
+)
+
+// Transports scanners are reached through
+const (
+	TransportLocal = "local" // scanimage and the local SANE backends
+	TransportSANE  = "saned" // SANE network protocol
+	TransportESCL  = "eSCL"  // Driverless scanning over HTTP
+)
+
+// DNS-SD service types announcing network scanners
+const (
+	serviceESCL       = "_uscan._tcp"
+	serviceESCLSecure = "_uscans._tcp"
+	serviceSANE       = "_sane-port._tcp"
+)
+
+// Transport returns how the device is reached
+func Transport(device string) string {
+	switch {
+	case strings.HasPrefix(device, NetworkDevicePrefix):
+		return TransportSANE
+	case strings.HasPrefix(device, ESCLDevicePrefix):
+		return TransportESCL
+	}
+	return TransportLocal
+}
+
+// Discover browses the local network for eSCL scanners and saned servers
+// until the context is done, sending every scanner found on the channel.
+// The channel is closed once discovery ends.
+func (n Network) Discover(ctx context.Context, found chan<- Scanner) error {
+	var wg sync.WaitGroup
+	defer func() {
+		wg.Wait()
+		close(found)
+	}()
+
+	types := []string{serviceESCL, serviceESCLSecure, serviceSANE}
+	return mdns.Browse(ctx, types, func(service mdns.Service) {
+		switch service.Type {
+		case serviceESCL, serviceESCLSecure:
+			found <- discoveredESCL(service)
+
+		case serviceSANE:
+			// Listing the devices of a server takes a connection, don't hold
+			// up the discovery of others meanwhile
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				network := Network{Hosts: []string{service.Address()}, Username: n.Username, Password: n.Password}
+				for _, scanner := range network.ListScanners().Scanners {
+					found <- scanner
+				}
+			}()
+		}
+	})
+}
+
+// discoveredESCL describes an eSCL scanner from its announcement. The TXT
+// record holds the root of the service and the model name.
+func discoveredESCL(service mdns.Service) Scanner {
+	scheme := "http"
+	if service.Type == serviceESCLSecure {
+		scheme = "https"
+	}
+	root := strings.Trim(service.Text["rs"], "/")
+	if root == "" {
+		root = "eSCL"
+	}
+
+	title := service.Text["ty"]
+	if title == "" {
+		title = service.Instance
+	}
+	return Scanner{
+		Device: fmt.Sprintf("%s%s://%s/%s", ESCLDevicePrefix, scheme, service.Address(), root),
+		Title:  fmt.Sprintf("%s on %s", title, strings.TrimSuffix(service.Host, ".")),
+	}
+}
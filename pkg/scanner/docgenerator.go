@@ -1,18 +1,36 @@
 package scanner
 
 import (
+	"crypto/rand"
+	"encoding/hex"
 	"fmt"
 	"os"
 	"os/exec"
 	"path/filepath"
 	"sort"
+
+	"scanexpress/pkg/pdf"
 )
 
 // PDFGenerationResult holds the result of PDF generation
 type PDFGenerationResult struct {
 	Success   bool
 	Error     error
-	OutputPDF string // Path to the generated PDF
+	OutputPDF string            // Path to the generated PDF
+	FileSize  int64             // Size of the generated PDF in bytes
+	Pages     []PageCompression // Encoding chosen for each page
+	Photos    []string          // Photos split from the pages, when SplitPhotos is set
+}
+
+// PDFOptions holds the options used when generating a PDF
+type PDFOptions struct {
+	PDFA        bool // Write a PDF/A-2b archival document
+	Compression CompressionOptions
+	Encryption  EncryptionOptions
+	Signing     SigningOptions
+	ColorModes  map[string]ColorMode // Color mode of each page image, pages missing here are classified on the fly
+	ChosenModes map[string]bool      // Pages whose color mode was chosen by the user, applied whatever the compression
+	SplitPhotos bool                 // Also save each photo found on the pages as a JPEG file next to the PDF
 }
 
 // GeneratePDFMsg is sent when a PDF has been generated
@@ -20,50 +38,143 @@ type GeneratePDFMsg struct {
 	Result PDFGenerationResult
 }
 
+// EncryptionOptions holds the passwords and permissions of encrypted PDFs
+type EncryptionOptions struct {
+	Enabled       bool
+	UserPassword  string   // Password needed to open the document
+	OwnerPassword string   // Password granting full access, a random one is used when empty
+	Permissions   []string // Operations allowed with the user password (e.g. "print", "copy")
+}
+
+// SigningOptions holds the certificate and details of signed PDFs
+type SigningOptions struct {
+	Enabled      bool
+	Certificate  string // Path to the PKCS#12 file holding the key and certificate
+	Password     string // Password of the PKCS#12 file
+	Name         string // Signer name, the certificate's common name when empty
+	Reason       string // Reason for signing
+	Location     string // Where the document was signed
+	TimestampURL string // RFC 3161 timestamp authority, optional
+}
+
 // GeneratePDF converts scanned images to a PDF document
-// It uses img2pdf to create the PDF from the images in the given directory
+// Pages are first re-encoded according to the compression options, then img2pdf
+// creates the PDF from the images in the given directory. PDF/A, encrypted and
+// signed documents are written by the built-in PDF writer instead, as img2pdf
+// only supports PDF/A-1b and neither encryption nor signatures.
+// With SplitPhotos, the photos on the pages are also saved next to the PDF.
 // After successful generation, it moves the PDF up one directory and removes the image directory
-func GeneratePDF(imageDir string) PDFGenerationResult {
+func GeneratePDF(imageDir string, options PDFOptions) PDFGenerationResult {
 	// Get the parent directory name for the PDF filename
 	parentDir := filepath.Dir(imageDir)
 	dirName := filepath.Base(imageDir)
 	pdfFile := dirName + ".pdf"
 	pdfPath := filepath.Join(imageDir, pdfFile)
 
-	// Get list of all PNG files in the directory
-	pngFiles, err := filepath.Glob(filepath.Join(imageDir, "page_*.png"))
+	// Get list of all scanned pages in the directory
+	pageFiles, err := listPageImages(imageDir)
 	if err != nil {
 		return PDFGenerationResult{
 			Success:   false,
-			Error:     fmt.Errorf("failed to list PNG files: %v", err),
+			Error:     fmt.Errorf("failed to list page images: %v", err),
 			OutputPDF: "",
 		}
 	}
 
-	// Sort files in proper order to maintain page sequence
-	sort.Strings(pngFiles)
-
 	// Check if we have any files
-	if len(pngFiles) == 0 {
+	if len(pageFiles) == 0 {
 		return PDFGenerationResult{
 			Success:   false,
-			Error:     fmt.Errorf("no PNG files found in directory"),
+			Error:     fmt.Errorf("no page images found in directory"),
 			OutputPDF: "",
 		}
 	}
 
-	// Prepare command with img2pdf and all files as individual arguments
-	args := append(pngFiles, "-o", pdfFile)
-	cmd := exec.Command("img2pdf", args...)
-	cmd.Dir = imageDir // Set working directory to the image directory
-	output, err := cmd.CombinedOutput()
+	// Photos are cut from the pages as scanned, before they are re-encoded
+	var photos []string
+	if options.SplitPhotos {
+		photos, err = savePhotos(pageFiles, parentDir, dirName)
+		if err != nil {
+			return PDFGenerationResult{
+				Success:   false,
+				Error:     fmt.Errorf("failed to split the photos: %v", err),
+				OutputPDF: "",
+			}
+		}
+	}
 
-	// Check if command succeeded
-	if err != nil {
-		return PDFGenerationResult{
-			Success:   false,
-			Error:     fmt.Errorf("PDF generation failed: %v - %s", err, string(output)),
-			OutputPDF: "",
+	// Re-encode the pages to shrink the PDF
+	pages := make([]PageCompression, 0, len(pageFiles))
+	for i, file := range pageFiles {
+		page, err := CompressPage(file, options.ColorModes[file], options.ChosenModes[file], options.Compression)
+		if err != nil {
+			return PDFGenerationResult{
+				Success:   false,
+				Error:     fmt.Errorf("failed to compress %s: %v", filepath.Base(file), err),
+				OutputPDF: "",
+			}
+		}
+		pages = append(pages, page)
+		pageFiles[i] = page.File
+	}
+
+	// The pages record the resolution they were scanned at, which sets the
+	// page size. Pages that don't are taken to be at the resolution of the
+	// others.
+	dpi, recorded := pagesDPI(pageFiles)
+
+	if options.PDFA || options.Encryption.Enabled || options.Signing.Enabled {
+		writerOptions := pdf.Options{Title: dirName, PDFA: options.PDFA, DPI: float64(dpi)}
+		if options.Encryption.Enabled {
+			encryption, err := pdfEncryption(options.Encryption)
+			if err != nil {
+				return PDFGenerationResult{
+					Success:   false,
+					Error:     err,
+					OutputPDF: "",
+				}
+			}
+			writerOptions.Encryption = encryption
+		}
+		if options.Signing.Enabled {
+			signature, err := pdfSignature(options.Signing)
+			if err != nil {
+				return PDFGenerationResult{
+					Success:   false,
+					Error:     err,
+					OutputPDF: "",
+				}
+			}
+			writerOptions.Signature = signature
+		}
+
+		err := pdf.WriteImages(pageFiles, pdfPath, writerOptions)
+		if err != nil {
+			return PDFGenerationResult{
+				Success:   false,
+				Error:     fmt.Errorf("PDF generation failed: %v", err),
+				OutputPDF: "",
+			}
+		}
+	} else {
+		// Prepare command with img2pdf and all files as individual arguments
+		// img2pdf reads the resolution of each page, and assumes 96 DPI for
+		// pages recording none, so the resolution is given for those
+		args := append(pageFiles, "-o", pdfFile)
+		if !recorded {
+			args = append(args, "--imgsize", fmt.Sprintf("%ddpix%ddpi", dpi, dpi))
+		}
+		cmd := exec.Command("img2pdf", args...)
+		cmd.Dir = imageDir // Set working directory to the image directory
+		output, err := cmd.CombinedOutput()
+
+		// Check if command succeeded
+		if err != nil {
+			return PDFGenerationResult{
+				Success:   false,
+				Error:     fmt.Errorf("PDF generation failed: %v - %s", err, string(output)),
+				OutputPDF: "",
+			}
 		}
 	}
 
@@ -81,8 +192,93 @@ func GeneratePDF(imageDir string) PDFGenerationResult {
 	// Clean up the image directory (best effort, don't fail if this doesn't work)
 	os.RemoveAll(imageDir)
 
+	var fileSize int64
+	if info, err := os.Stat(destPDFPath); err == nil {
+		fileSize = info.Size()
+	}
+
 	return PDFGenerationResult{
 		Success:   true,
 		OutputPDF: destPDFPath,
+		FileSize:  fileSize,
+		Pages:     pages,
+		Photos:    photos,
 	}
 }
+
+// pagesDPI returns the resolution recorded in the page images, ScanResolution
+// when none records one, and whether every page records its own
+func pagesDPI(pageFiles []string) (int, bool) {
+	dpi, recorded := 0, true
+	for _, file := range pageFiles {
+		d := fileDPI(file)
+		if d == 0 {
+			recorded = false
+			continue
+		}
+		if dpi == 0 {
+			dpi = d
+		}
+	}
+	if dpi == 0 {
+		dpi = ScanResolution
+	}
+	return dpi, recorded
+}
+
+// pdfEncryption converts the encryption options for the PDF writer
+func pdfEncryption(options EncryptionOptions) (*pdf.Encryption, error) {
+	permissions, err := pdf.ParsePermissions(options.Permissions)
+	if err != nil {
+		return nil, fmt.Errorf("invalid encryption permissions: %v", err)
+	}
+
+	ownerPassword := options.OwnerPassword
+	if ownerPassword == "" {
+		// Nobody gets full access without an owner password of their own
+		random := make([]byte, 24)
+		if _, err := rand.Read(random); err != nil {
+			return nil, fmt.Errorf("failed to generate owner password: %v", err)
+		}
+		ownerPassword = hex.EncodeToString(random)
+	}
+
+	return &pdf.Encryption{
+		UserPassword:  options.UserPassword,
+		OwnerPassword: ownerPassword,
+		Permissions:   permissions,
+	}, nil
+}
+
+// pdfSignature loads the signing certificate for the PDF writer
+func pdfSignature(options SigningOptions) (*pdf.Signature, error) {
+	if options.Certificate == "" {
+		return nil, fmt.Errorf("no signing certificate configured (signing.certificate)")
+	}
+
+	signature, err := pdf.LoadPKCS12(options.Certificate, options.Password)
+	if err != nil {
+		return nil, err
+	}
+	signature.Name = options.Name
+	signature.Reason = options.Reason
+	signature.Location = options.Location
+	signature.TimestampURL = options.TimestampURL
+	return signature, nil
+}
+
+// listPageImages returns the scanned page images in the directory in page order
+func listPageImages(imageDir string) ([]string, error) {
+	var files []string
+	for _, pattern := range []string{"page_*.png", "page_*.jpg"} {
+		matches, err := filepath.Glob(filepath.Join(imageDir, pattern))
+		if err != nil {
+			return nil, err
+		}
+		files = append(files, matches...)
+	}
+
+	// Sort files in proper order to maintain page sequence
+	sort.Strings(files)
+	return files, nil
+}
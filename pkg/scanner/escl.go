@@ -0,0 +1,235 @@
+package scanner
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	"slices"
+	"strings"
+	"sync"
+
+	"scanexpress/pkg/escl"
+)
+
+// ESCLDevicePrefix starts the identifiers of eSCL (AirScan) scanners, which
+// are followed by the base URL, e.g. "escl:http://printer.local/eSCL"
+const ESCLDevicePrefix = "escl:"
+
+// esclColorModes maps eSCL color modes to the page color modes
+var esclColorModes = map[string]ColorMode{
+	escl.ColorModeRGB:       ColorModeColor,
+	escl.ColorModeGray:      ColorModeGrayscale,
+	escl.ColorModeBlackDots: ColorModeBlackWhite,
+}
+
+// esclScanner describes the eSCL scanner at the base URL
+func esclScanner(baseURL string) (Scanner, error) {
+	baseURL = strings.TrimSuffix(baseURL, "/")
+	caps, err := escl.NewClient(baseURL).Capabilities()
+	if err != nil {
+		return Scanner{}, fmt.Errorf("%s: %v", baseURL, err)
+	}
+
+	title := caps.MakeAndModel
+	if title == "" {
+		title = "eSCL scanner"
+	}
+	return Scanner{
+		Device: ESCLDevicePrefix + baseURL,
+		Title:  fmt.Sprintf("%s on %s", title, escl.Host(baseURL)),
+	}, nil
+}
+
+// esclCapabilities maps the capabilities of an eSCL scanner to the scan
+// options
+func esclCapabilities(device string) (Capabilities, error) {
+	caps, err := escl.NewClient(strings.TrimPrefix(device, ESCLDevicePrefix)).Capabilities()
+	if err != nil {
+		return Capabilities{}, err
+	}
+
+	result := Capabilities{
+		Feeder:  caps.Feeder != nil || caps.Duplex != nil,
+		Duplex:  caps.Duplex != nil,
+		Flatbed: caps.Platen != nil,
+	}
+	for _, input := range []*escl.InputCaps{caps.Platen, caps.Feeder, caps.Duplex} {
+		if input == nil {
+			continue
+		}
+		for _, r := range input.Resolutions {
+			if !slices.Contains(result.Resolutions, r) {
+				result.Resolutions = append(result.Resolutions, r)
+			}
+		}
+		for _, mode := range ColorModes {
+			for _, m := range input.ColorModes {
+				if esclColorModes[m] == mode && !slices.Contains(result.ColorModes, mode) {
+					result.ColorModes = append(result.ColorModes, mode)
+				}
+			}
+		}
+	}
+	slices.Sort(result.Resolutions)
+	return result, nil
+}
+
+// esclBatch is the feeder job of an eSCL scanner, read page by page
+type esclBatch struct {
+	job        *escl.Job
+	duplex     bool
+	resolution int // Resolution the job scans at
+	pages      int // Pages read from the job
+}
+
+// esclBatches holds the open feeder job of each eSCL scanner. A feeder job
+// scans every sheet the feeder holds, and deleting it while the scanner is
+// still pulling sheets can leave one stuck in the feeder, so the job is kept
+// across calls of esclScanPage until the scanner has no more documents or
+// EndBatch is called.
+var esclBatches = struct {
+	sync.Mutex
+	jobs map[string]*esclBatch
+}{jobs: make(map[string]*esclBatch)}
+
+// esclScanPage scans a single page, or both sides of it in duplex mode, from
+// an eSCL scanner, with the settings ScanPage passes to scanimage. The page
+// is the next document of the feeder job, which is started when none is
+// open. When the job has no document left, a new one is started once for
+// sheets loaded since.
+func esclScanPage(device string, outputFile string, isDuplex bool, pageNum int) PageScanResult {
+	esclBatches.Lock()
+	defer esclBatches.Unlock()
+
+	batch := esclBatches.jobs[device]
+	if batch != nil && batch.duplex != isDuplex {
+		batch.job.Cancel()
+		batch = nil
+	}
+	for {
+		if batch == nil {
+			var err error
+			if batch, err = startESCLBatch(device, isDuplex); err != nil {
+				delete(esclBatches.jobs, device)
+				return failedPage(pageNum, err)
+			}
+		}
+		esclBatches.jobs[device] = batch
+
+		result := saveSides(outputFile, isDuplex, pageNum, batch.resolution, func() (image.Image, error) {
+			data, err := batch.job.NextDocument()
+			if errors.Is(err, escl.ErrNoMoreDocuments) {
+				return nil, nil
+			}
+			if err != nil {
+				return nil, err
+			}
+			batch.pages++
+			img, _, err := image.Decode(bytes.NewReader(data))
+			if err != nil {
+				return nil, fmt.Errorf("failed to decode the scanned page: %v", err)
+			}
+			return img, nil
+		})
+
+		if errors.Is(result.Error, ErrFeederEmpty) && batch.pages > 0 {
+			// The job ended with the sheets it was started with
+			delete(esclBatches.jobs, device)
+			batch = nil
+			continue
+		}
+		if !result.Success {
+			batch.job.Cancel()
+			delete(esclBatches.jobs, device)
+		}
+		return result
+	}
+}
+
+// startESCLBatch starts a feeder job on an eSCL scanner
+func startESCLBatch(device string, isDuplex bool) (*esclBatch, error) {
+	client := escl.NewClient(strings.TrimPrefix(device, ESCLDevicePrefix))
+	caps, err := client.Capabilities()
+	if err != nil {
+		return nil, err
+	}
+	settings, err := esclSettings(caps, isDuplex)
+	if err != nil {
+		return nil, err
+	}
+	job, err := client.Scan(settings)
+	if err != nil {
+		return nil, err
+	}
+	return &esclBatch{job: job, duplex: isDuplex, resolution: settings.Resolution}, nil
+}
+
+// endESCLBatch deletes the open feeder job of an eSCL scanner, if any,
+// which stops the scanner when sheets are left
+func endESCLBatch(device string) {
+	esclBatches.Lock()
+	defer esclBatches.Unlock()
+	if batch := esclBatches.jobs[device]; batch != nil {
+		batch.job.Cancel()
+		delete(esclBatches.jobs, device)
+	}
+}
+
+// esclSettings chooses the job settings closest to a scanimage scan: color
+// at ScanResolution from the document feeder, over the largest area
+func esclSettings(caps escl.Capabilities, isDuplex bool) (escl.Settings, error) {
+	settings := escl.Settings{Version: caps.Version}
+
+	var input *escl.InputCaps
+	switch {
+	case isDuplex && caps.Duplex != nil:
+		input, settings.Source, settings.Duplex = caps.Duplex, escl.SourceFeeder, true
+	case isDuplex:
+		return settings, fmt.Errorf("the scanner can't scan both sides")
+	case caps.Feeder != nil:
+		input, settings.Source = caps.Feeder, escl.SourceFeeder
+	case caps.Platen != nil:
+		input, settings.Source = caps.Platen, escl.SourcePlaten
+	default:
+		return settings, fmt.Errorf("the scanner reports no input source")
+	}
+
+	settings.ColorMode = escl.ColorModeRGB
+	for _, mode := range []string{escl.ColorModeRGB, escl.ColorModeGray} {
+		if slices.Contains(input.ColorModes, mode) {
+			settings.ColorMode = mode
+			break
+		}
+	}
+
+	// PNG keeps the scan lossless, JPEG is supported by every scanner
+	settings.Format = "image/jpeg"
+	if slices.Contains(input.Formats, "image/png") {
+		settings.Format = "image/png"
+	}
+
+	settings.Resolution = ScanResolution
+	switch {
+	case len(input.Resolutions) > 0:
+		settings.Resolution = input.Resolutions[0]
+		for _, r := range input.Resolutions {
+			if abs(r-ScanResolution) < abs(settings.Resolution-ScanResolution) {
+				settings.Resolution = r
+			}
+		}
+	case input.MaxRes > 0:
+		settings.Resolution = min(max(ScanResolution, input.MinRes), input.MaxRes)
+	}
+
+	settings.Width, settings.Height = input.MaxWidth, input.MaxHeight
+	return settings, nil
+}
+
+// abs returns the absolute value of an integer
+func abs(v int) int {
+	if v < 0 {
+		return -v
+	}
+	return v
+}
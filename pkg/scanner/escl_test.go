@@ -0,0 +1,139 @@
+package scanner
+
+import (
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// esclFeeder is an eSCL scanner with a document feeder holding sheets of
+// different shades. Each job scans the sheets the feeder holds when it is
+// created.
+type esclFeeder struct {
+	sheets  []uint8   // Shade of the sheets in the feeder
+	jobs    [][]uint8 // Sheets left to read of each job, from job 1
+	deleted []string  // Paths of the deleted jobs
+}
+
+// device serves the scanner until the test ends and returns its device name
+func (f *esclFeeder) device(t *testing.T) string {
+	server := httptest.NewServer(f)
+	t.Cleanup(server.Close)
+	return ESCLDevicePrefix + server.URL + "/eSCL"
+}
+
+func (f *esclFeeder) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch {
+	case r.URL.Path == "/eSCL/ScannerCapabilities":
+		fmt.Fprint(w, `<?xml version="1.0"?>
+<scan:ScannerCapabilities xmlns:scan="http://schemas.hp.com/imaging/escl/2011/05/03" xmlns:pwg="http://www.pwg.org/schemas/2010/12/sm">
+<pwg:Version>2.63</pwg:Version>
+<scan:Adf><scan:AdfSimplexInputCaps>
+<scan:MaxWidth>2550</scan:MaxWidth><scan:MaxHeight>4200</scan:MaxHeight>
+<scan:SettingProfiles><scan:SettingProfile>
+<scan:ColorModes><scan:ColorMode>RGB24</scan:ColorMode></scan:ColorModes>
+<scan:DocumentFormats><pwg:DocumentFormat>image/png</pwg:DocumentFormat></scan:DocumentFormats>
+<scan:SupportedResolutions><scan:DiscreteResolutions><scan:DiscreteResolution><scan:XResolution>300</scan:XResolution><scan:YResolution>300</scan:YResolution></scan:DiscreteResolution></scan:DiscreteResolutions></scan:SupportedResolutions>
+</scan:SettingProfile></scan:SettingProfiles>
+</scan:AdfSimplexInputCaps></scan:Adf>
+</scan:ScannerCapabilities>`)
+
+	case r.Method == http.MethodPost && r.URL.Path == "/eSCL/ScanJobs":
+		f.jobs, f.sheets = append(f.jobs, f.sheets), nil
+		w.Header().Set("Location", fmt.Sprintf("/eSCL/ScanJobs/%d", len(f.jobs)))
+		w.WriteHeader(http.StatusCreated)
+
+	case r.Method == http.MethodDelete:
+		f.deleted = append(f.deleted, r.URL.Path)
+
+	case strings.HasSuffix(r.URL.Path, "/NextDocument"):
+		var id int
+		fmt.Sscanf(r.URL.Path, "/eSCL/ScanJobs/%d/NextDocument", &id)
+		if id < 1 || id > len(f.jobs) || len(f.jobs[id-1]) == 0 {
+			http.NotFound(w, r)
+			return
+		}
+		img := image.NewGray(image.Rect(0, 0, 4, 4))
+		for i := range img.Pix {
+			img.Pix[i] = f.jobs[id-1][0]
+		}
+		f.jobs[id-1] = f.jobs[id-1][1:]
+		w.Header().Set("Content-Type", "image/png")
+		png.Encode(w, img)
+
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// shade returns the shade of the scanned page
+func shade(t *testing.T, file string) uint8 {
+	img, err := loadImage(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return color.GrayModel.Convert(img.At(0, 0)).(color.Gray).Y
+}
+
+func TestESCLFeederBatch(t *testing.T) {
+	feeder := &esclFeeder{sheets: []uint8{10, 20}}
+	device := feeder.device(t)
+	dir := t.TempDir()
+	scan := func(page int) PageScanResult {
+		return esclScanPage(device, filepath.Join(dir, fmt.Sprintf("page_%03d.png", page)), false, page)
+	}
+
+	// Both sheets come from one job
+	for page, want := range []uint8{10, 20} {
+		result := scan(page + 1)
+		if !result.Success {
+			t.Fatalf("page %d: %v", page+1, result.Error)
+		}
+		if got := shade(t, result.FilePaths[0]); got != want {
+			t.Errorf("page %d has shade %d, want %d", page+1, got, want)
+		}
+		if result.Resolution != 300 || fileDPI(result.FilePaths[0]) != 300 {
+			t.Errorf("page %d scanned at %d DPI, recording %d", page+1, result.Resolution, fileDPI(result.FilePaths[0]))
+		}
+	}
+	if len(feeder.jobs) != 1 || len(feeder.deleted) != 0 {
+		t.Errorf("%d jobs, %v deleted, want one job kept open", len(feeder.jobs), feeder.deleted)
+	}
+	// A sheet loaded after the job ran empty is scanned by a new job
+	feeder.sheets = []uint8{30}
+
+	result := scan(3)
+	if !result.Success {
+		t.Fatalf("page 3: %v", result.Error)
+	}
+	if got := shade(t, result.FilePaths[0]); got != 30 {
+		t.Errorf("page 3 has shade %d, want 30", got)
+	}
+	if result := scan(4); !errors.Is(result.Error, ErrFeederEmpty) {
+		t.Errorf("error %v, want the feeder empty", result.Error)
+	}
+	if len(feeder.jobs) != 3 {
+		t.Errorf("%d jobs, want 3", len(feeder.jobs))
+	}
+}
+
+func TestESCLEndBatch(t *testing.T) {
+	feeder := &esclFeeder{sheets: []uint8{10, 20, 30}}
+	device := feeder.device(t)
+
+	result := esclScanPage(device, filepath.Join(t.TempDir(), "page_001.png"), false, 1)
+	if !result.Success {
+		t.Fatalf("page 1: %v", result.Error)
+	}
+	Network{}.EndBatch(device)
+	if len(feeder.deleted) != 1 || feeder.deleted[0] != "/eSCL/ScanJobs/1" {
+		t.Errorf("deleted %v, want the open job", feeder.deleted)
+	}
+}
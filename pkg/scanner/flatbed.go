@@ -0,0 +1,324 @@
+package scanner
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	"math"
+	"os/exec"
+	"slices"
+	"strconv"
+	"strings"
+
+	"scanexpress/pkg/escl"
+	"scanexpress/pkg/sane"
+)
+
+// PreviewResolution is the resolution in dots per inch the flatbed is
+// previewed at
+const PreviewResolution = 75
+
+// mmPerInch converts between the millimetres of scan areas and inches
+const mmPerInch = 25.4
+
+// Area is a rectangle of the flatbed glass in millimetres from its top-left
+// corner, as scanimage takes it with the -l, -t, -x and -y options
+type Area struct {
+	Left, Top     float64
+	Width, Height float64
+}
+
+// String describes the area, e.g. "85.0 × 120.5 mm at 10.0, 12.5 mm"
+func (a Area) String() string {
+	return fmt.Sprintf("%.1f × %.1f mm at %.1f, %.1f mm", a.Width, a.Height, a.Left, a.Top)
+}
+
+// PreviewFlatbed scans the whole flatbed glass at a low resolution, to
+// choose the area of the scans that follow. It returns the size of the glass
+// and the area of what lies on it.
+func (n Network) PreviewFlatbed(device, outputFile string) (glass, content Area, err error) {
+	resolution, err := n.scanFlatbed(device, outputFile, PreviewResolution, nil)
+	if err != nil {
+		return Area{}, Area{}, fmt.Errorf("previewing the flatbed failed: %w", err)
+	}
+	img, err := loadImage(outputFile)
+	if err != nil {
+		return Area{}, Area{}, err
+	}
+	bounds := img.Bounds()
+	glass = Area{
+		Width:  float64(bounds.Dx()) * mmPerInch / float64(resolution),
+		Height: float64(bounds.Dy()) * mmPerInch / float64(resolution),
+	}
+	return glass, contentArea(img, glass), nil
+}
+
+// ScanFlatbed scans an area of the flatbed glass at ScanResolution and saves
+// it as PNG like ScanPage does
+func (n Network) ScanFlatbed(device, outputFile string, pageNum int, area Area) PageScanResult {
+	resolution, err := n.scanFlatbed(device, outputFile, ScanResolution, &area)
+	if err != nil {
+		return failedPage(pageNum, err)
+	}
+	return PageScanResult{
+		Success:    true,
+		FilePaths:  []string{outputFile},
+		PageNums:   []int{pageNum},
+		Resolution: resolution,
+	}
+}
+
+// scanFlatbed scans the area of the flatbed, the whole glass when area is
+// nil, at the resolution closest to the one asked for, which it returns
+func (n Network) scanFlatbed(device, outputFile string, resolution int, area *Area) (int, error) {
+	if !IsNetworkDevice(device) {
+		return scanFlatbedLocal(device, outputFile, resolution, area)
+	}
+	if strings.HasPrefix(device, ESCLDevicePrefix) {
+		return scanFlatbedESCL(device, outputFile, resolution, area)
+	}
+
+	host, name, err := parseNetworkDevice(device)
+	if err != nil {
+		return 0, err
+	}
+	client, err := sane.Dial(host, n.Username, n.Password)
+	if err != nil {
+		return 0, err
+	}
+	defer client.Close()
+	handle, err := client.Open(name)
+	if err != nil {
+		return 0, err
+	}
+	defer handle.Close()
+
+	used, err := configureFlatbedScan(handle, resolution, area)
+	if err != nil {
+		return 0, err
+	}
+	img, err := handle.Scan()
+	handle.Cancel()
+	if err != nil {
+		return 0, err
+	}
+	return used, savePNG(outputFile, img, used)
+}
+
+// scanFlatbedLocal scans from the flatbed of a local scanner with scanimage
+func scanFlatbedLocal(device, outputFile string, resolution int, area *Area) (int, error) {
+	output, err := allOptions(device)
+	if err != nil {
+		return 0, err
+	}
+	options := localOptions(output)
+
+	args := []string{"--device-name=" + device, "--format=png", "--output-file=" + outputFile}
+	if sources, ok := options["source"]; ok {
+		source := flatbedSource(sources)
+		if source == "" {
+			return 0, errors.New("the scanner has no flatbed")
+		}
+		args = append(args, "--source="+source)
+	}
+
+	resolution = nearestResolution(localResolutions(options), resolution)
+	args = append(args, "--resolution="+strconv.Itoa(resolution))
+
+	if area != nil {
+		if _, ok := options["x"]; !ok {
+			return 0, errors.New("the scanner doesn't support scan areas")
+		}
+		mm := func(v float64) string { return strconv.FormatFloat(v, 'f', 1, 64) }
+		args = append(args, "-l", mm(area.Left), "-t", mm(area.Top), "-x", mm(area.Width), "-y", mm(area.Height))
+	}
+
+	if output, err := exec.Command("scanimage", args...).CombinedOutput(); err != nil {
+		return 0, fmt.Errorf("%v - %s", err, strings.TrimSpace(string(output)))
+	}
+	return resolution, nil
+}
+
+// configureFlatbedScan sets a saned scanner up to scan the area of the
+// flatbed, the whole glass when area is nil, in color. It returns the
+// resolution set.
+func configureFlatbedScan(h *sane.Handle, resolution int, area *Area) (int, error) {
+	if o, ok := h.Option("mode"); ok && o.Settable() {
+		if mode := findValue(o.Strings, "Color", "color", "24bit Color"); mode != "" {
+			if err := h.Set("mode", mode); err != nil {
+				return 0, err
+			}
+		}
+	}
+
+	if o, ok := h.Option("source"); ok && o.Settable() {
+		source := flatbedSource(o.Strings)
+		if source == "" {
+			return 0, errors.New("the scanner has no flatbed")
+		}
+		if err := h.Set("source", source); err != nil {
+			return 0, err
+		}
+	}
+
+	// The resolution is set after the mode and source, which may change
+	// the resolutions available
+	if o, ok := h.Option("resolution"); ok && o.Settable() {
+		nearest := o.Nearest(float64(resolution))
+		if err := h.Set("resolution", nearest); err != nil {
+			return 0, err
+		}
+		resolution = int(nearest)
+	}
+
+	// The area goes from the top-left corner to the bottom-right one, the
+	// whole glass by default
+	corners := []struct {
+		name  string
+		value func(o sane.Option) float64
+	}{
+		{"tl-x", func(o sane.Option) float64 { return o.Range.Min }},
+		{"tl-y", func(o sane.Option) float64 { return o.Range.Min }},
+		{"br-x", func(o sane.Option) float64 { return o.Range.Max }},
+		{"br-y", func(o sane.Option) float64 { return o.Range.Max }},
+	}
+	if area != nil {
+		corners[0].value = func(sane.Option) float64 { return area.Left }
+		corners[1].value = func(sane.Option) float64 { return area.Top }
+		corners[2].value = func(sane.Option) float64 { return area.Left + area.Width }
+		corners[3].value = func(sane.Option) float64 { return area.Top + area.Height }
+	}
+	for _, corner := range corners {
+		o, ok := h.Option(corner.name)
+		if !ok || !o.Settable() || o.Range == nil {
+			if area != nil {
+				return 0, errors.New("the scanner doesn't support scan areas")
+			}
+			continue
+		}
+		if err := h.Set(corner.name, o.Nearest(corner.value(o))); err != nil {
+			return 0, err
+		}
+	}
+	return resolution, nil
+}
+
+// scanFlatbedESCL scans from the platen of an eSCL scanner
+func scanFlatbedESCL(device, outputFile string, resolution int, area *Area) (int, error) {
+	// The platen can't be used while a feeder job is open
+	endESCLBatch(device)
+	client := escl.NewClient(strings.TrimPrefix(device, ESCLDevicePrefix))
+	caps, err := client.Capabilities()
+	if err != nil {
+		return 0, err
+	}
+	input := caps.Platen
+	if input == nil {
+		return 0, errors.New("the scanner has no flatbed")
+	}
+
+	settings := escl.Settings{
+		Version:    caps.Version,
+		Source:     escl.SourcePlaten,
+		ColorMode:  escl.ColorModeRGB,
+		Format:     "image/jpeg",
+		Resolution: nearestResolution(input.Resolutions, resolution),
+		Width:      input.MaxWidth,
+		Height:     input.MaxHeight,
+	}
+	if len(input.Resolutions) == 0 && input.MaxRes > 0 {
+		settings.Resolution = min(max(resolution, input.MinRes), input.MaxRes)
+	}
+	if slices.Contains(input.Formats, "image/png") {
+		settings.Format = "image/png"
+	}
+	if area != nil {
+		// Regions are given in 1/300 inch
+		units := func(mm float64) int { return int(math.Round(mm / mmPerInch * 300)) }
+		settings.XOffset, settings.YOffset = units(area.Left), units(area.Top)
+		settings.Width, settings.Height = units(area.Width), units(area.Height)
+	}
+
+	job, err := client.Scan(settings)
+	if err != nil {
+		return 0, err
+	}
+	defer job.Cancel()
+	data, err := job.NextDocument()
+	if err != nil {
+		return 0, err
+	}
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return 0, fmt.Errorf("failed to decode the scanned page: %v", err)
+	}
+	return settings.Resolution, savePNG(outputFile, img, settings.Resolution)
+}
+
+// flatbedSource picks the flatbed glass among the sources of a scanner
+func flatbedSource(sources []string) string {
+	if source := findValue(sources, "Flatbed", "Normal", "FlatBed"); source != "" {
+		return source
+	}
+	for _, source := range sources {
+		lower := strings.ToLower(source)
+		if strings.Contains(lower, "flatbed") || strings.Contains(lower, "platen") || strings.Contains(lower, "glass") {
+			return source
+		}
+	}
+	return ""
+}
+
+// nearestResolution returns the offered resolution closest to the one
+// wanted, which is kept when no resolution is listed
+func nearestResolution(offered []int, wanted int) int {
+	if len(offered) == 0 {
+		return wanted
+	}
+	nearest := offered[0]
+	for _, r := range offered {
+		if abs(r-wanted) < abs(nearest-wanted) {
+			nearest = r
+		}
+	}
+	return nearest
+}
+
+// contentLevel is the luminance below which a preview pixel is taken for
+// something on the glass rather than the white lid
+const contentLevel = 200
+
+// contentArea returns the area of what lies on the glass in a preview of
+// the whole glass, with a small margin, or the whole glass when nothing does
+func contentArea(preview image.Image, glass Area) Area {
+	bounds := preview.Bounds()
+	found := image.Rectangle{}
+	// The edges of the glass are often dark, they are left out
+	for y := bounds.Min.Y + 1; y < bounds.Max.Y-1; y++ {
+		for x := bounds.Min.X + 1; x < bounds.Max.X-1; x++ {
+			r, g, b, _ := preview.At(x, y).RGBA()
+			if (299*r+587*g+114*b)/1000>>8 >= contentLevel {
+				continue
+			}
+			pixel := image.Rect(x, y, x+1, y+1)
+			if found.Empty() {
+				found = pixel
+			} else {
+				found = found.Union(pixel)
+			}
+		}
+	}
+	if found.Empty() {
+		return glass
+	}
+
+	const margin = 3 // mm
+	scaleX := glass.Width / float64(bounds.Dx())
+	scaleY := glass.Height / float64(bounds.Dy())
+	left := max(float64(found.Min.X-bounds.Min.X)*scaleX-margin, 0)
+	top := max(float64(found.Min.Y-bounds.Min.Y)*scaleY-margin, 0)
+	right := min(float64(found.Max.X-bounds.Min.X)*scaleX+margin, glass.Width)
+	bottom := min(float64(found.Max.Y-bounds.Min.Y)*scaleY+margin, glass.Height)
+	return Area{Left: left, Top: top, Width: right - left, Height: bottom - top}
+}
@@ -0,0 +1,63 @@
+package scanner
+
+import (
+	"strings"
+
+	"scanexpress/pkg/escl"
+	"scanexpress/pkg/sane"
+)
+
+// FindDevice looks for a local scanner among those currently connected,
+// returning its device identifier. USB scanners get a new bus address when
+// they are plugged in again, so a scanner with the same title is accepted
+// when the device itself is gone.
+func FindDevice(device, title string) (string, bool) {
+	result := ListScanners()
+	if result.Error != nil {
+		return "", false
+	}
+	for _, s := range result.Scanners {
+		if s.Device == device {
+			return s.Device, true
+		}
+	}
+
+	// The backend part of the identifier, e.g. "brother5" of
+	// "brother5:bus1;dev4", must match too
+	backend, _, _ := strings.Cut(device, ":")
+	for _, s := range result.Scanners {
+		if title != "" && s.Title == title && strings.HasPrefix(s.Device, backend+":") {
+			return s.Device, true
+		}
+	}
+	return "", false
+}
+
+// FindDevice reports whether a network scanner answers and still offers the
+// device, returning its device identifier
+func (n Network) FindDevice(device string) (string, bool) {
+	if strings.HasPrefix(device, ESCLDevicePrefix) {
+		_, err := escl.NewClient(strings.TrimPrefix(device, ESCLDevicePrefix)).Capabilities()
+		return device, err == nil
+	}
+
+	host, name, err := parseNetworkDevice(device)
+	if err != nil {
+		return "", false
+	}
+	client, err := sane.Dial(host, n.Username, n.Password)
+	if err != nil {
+		return "", false
+	}
+	defer client.Close()
+	devices, err := client.Devices()
+	if err != nil {
+		return "", false
+	}
+	for _, d := range devices {
+		if d.Name == name {
+			return device, true
+		}
+	}
+	return "", false
+}
@@ -0,0 +1,243 @@
+package scanner
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"image"
+	"image/color"
+	"image/png"
+	"io"
+	"math"
+	"os"
+	"slices"
+
+	"scanexpress/pkg/pdf"
+
+	// Register the JPEG decoder so loadImage can read converted pages
+	_ "image/jpeg"
+)
+
+// grayMatrix is a downscaled luminance copy of a scanned page used by the
+// page analysis heuristics
+type grayMatrix struct {
+	Width  int
+	Height int
+	Pix    []uint8 // Row-major luminance values (0 = black, 255 = white)
+}
+
+// At returns the luminance of the pixel at (x, y)
+func (g grayMatrix) At(x, y int) uint8 {
+	return g.Pix[y*g.Width+x]
+}
+
+// loadImage decodes the image stored at the given path
+func loadImage(path string) (image.Image, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	img, _, err := image.Decode(f)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode %s: %v", path, err)
+	}
+	return img, nil
+}
+
+// savePNG encodes the image as PNG, replacing the file at the given path.
+// The resolution is recorded in a pHYs chunk when dpi is not 0.
+func savePNG(path string, img image.Image, dpi int) error {
+	var b bytes.Buffer
+	if err := png.Encode(&b, img); err != nil {
+		return fmt.Errorf("failed to encode %s: %v", path, err)
+	}
+	data := b.Bytes()
+
+	if dpi > 0 {
+		// The chunk follows the signature and the IHDR chunk
+		const headerEnd = 8 + 12 + 13
+		chunk := make([]byte, 12+9)
+		binary.BigEndian.PutUint32(chunk[0:4], 9)
+		copy(chunk[4:8], "pHYs")
+		ppm := uint32(math.Round(float64(dpi) / 0.0254)) // Pixels per meter
+		binary.BigEndian.PutUint32(chunk[8:12], ppm)
+		binary.BigEndian.PutUint32(chunk[12:16], ppm)
+		chunk[16] = 1 // The unit is the meter
+		binary.BigEndian.PutUint32(chunk[17:21], crc32.ChecksumIEEE(chunk[4:17]))
+		data = slices.Concat(data[:headerEnd], chunk, data[headerEnd:])
+	}
+	return os.WriteFile(path, data, 0644)
+}
+
+// fileDPI returns the resolution recorded in a PNG or JPEG file, 0 when it
+// records none
+func fileDPI(path string) int {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0
+	}
+	defer f.Close()
+
+	// The resolution is recorded before the image data
+	header := make([]byte, 64<<10)
+	n, _ := io.ReadFull(f, header)
+	x, _, ok := pdf.ReadDPI(header[:n])
+	if !ok {
+		return 0
+	}
+	return int(math.Round(x))
+}
+
+// newGrayMatrix converts the image to luminance, downscaling it so that its
+// largest side is at most maxDim pixels
+func newGrayMatrix(img image.Image, maxDim int) grayMatrix {
+	bounds := img.Bounds()
+	step := 1
+	if largest := max(bounds.Dx(), bounds.Dy()); largest > maxDim {
+		step = (largest + maxDim - 1) / maxDim
+	}
+
+	g := grayMatrix{
+		Width:  bounds.Dx() / step,
+		Height: bounds.Dy() / step,
+	}
+	g.Pix = make([]uint8, g.Width*g.Height)
+
+	for y := 0; y < g.Height; y++ {
+		for x := 0; x < g.Width; x++ {
+			c := color.GrayModel.Convert(img.At(bounds.Min.X+x*step, bounds.Min.Y+y*step)).(color.Gray)
+			g.Pix[y*g.Width+x] = c.Y
+		}
+	}
+
+	return g
+}
+
+// otsuThreshold computes the luminance threshold that best separates ink
+// from paper using Otsu's method
+func otsuThreshold(pix []uint8) uint8 {
+	var histogram [256]int
+	for _, p := range pix {
+		histogram[p]++
+	}
+
+	total := len(pix)
+	sum := 0
+	for i, count := range histogram {
+		sum += i * count
+	}
+
+	var (
+		sumBackground    int
+		weightBackground int
+		bestVariance     float64
+		threshold        uint8
+	)
+
+	for i, count := range histogram {
+		weightBackground += count
+		if weightBackground == 0 {
+			continue
+		}
+		weightForeground := total - weightBackground
+		if weightForeground == 0 {
+			break
+		}
+
+		sumBackground += i * count
+		meanBackground := float64(sumBackground) / float64(weightBackground)
+		meanForeground := float64(sum-sumBackground) / float64(weightForeground)
+
+		diff := meanBackground - meanForeground
+		variance := float64(weightBackground) * float64(weightForeground) * diff * diff
+		if variance > bestVariance {
+			bestVariance = variance
+			threshold = uint8(i)
+		}
+	}
+
+	return threshold
+}
+
+// rotateImage rotates the image clockwise by the given number of degrees,
+// which must be a multiple of 90
+func rotateImage(img image.Image, degrees int) image.Image {
+	degrees = ((degrees % 360) + 360) % 360
+	if degrees == 0 {
+		return img
+	}
+
+	bounds := img.Bounds()
+	w, h := bounds.Dx(), bounds.Dy()
+
+	dstBounds := image.Rect(0, 0, w, h)
+	if degrees == 90 || degrees == 270 {
+		dstBounds = image.Rect(0, 0, h, w)
+	}
+
+	var dst interface {
+		image.Image
+		Set(x, y int, c color.Color)
+	}
+	if _, ok := img.(*image.Gray); ok {
+		dst = image.NewGray(dstBounds)
+	} else {
+		dst = image.NewNRGBA(dstBounds)
+	}
+
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			c := img.At(bounds.Min.X+x, bounds.Min.Y+y)
+			switch degrees {
+			case 90:
+				dst.Set(h-1-y, x, c)
+			case 180:
+				dst.Set(w-1-x, h-1-y, c)
+			case 270:
+				dst.Set(y, w-1-x, c)
+			}
+		}
+	}
+
+	return dst
+}
+
+// thumbnailSamples is how many pixels are averaged across each side of the
+// block of the page a thumbnail pixel covers
+const thumbnailSamples = 3
+
+// Thumbnail loads a page image and downscales it so that its largest side is
+// at most maxDim pixels, averaging the pixels of each block
+func Thumbnail(path string, maxDim int) (image.Image, error) {
+	img, err := loadImage(path)
+	if err != nil {
+		return nil, err
+	}
+
+	bounds := img.Bounds()
+	step := 1
+	if largest := max(bounds.Dx(), bounds.Dy()); largest > maxDim {
+		step = (largest + maxDim - 1) / maxDim
+	}
+	thumb := image.NewRGBA(image.Rect(0, 0, max(bounds.Dx()/step, 1), max(bounds.Dy()/step, 1)))
+
+	// Only a few pixels of large blocks are sampled, which is plenty for
+	// a preview and keeps big scans fast
+	stride := max(step/thumbnailSamples, 1)
+	for y := 0; y < thumb.Rect.Dy(); y++ {
+		for x := 0; x < thumb.Rect.Dx(); x++ {
+			var r, g, b, n uint32
+			for dy := 0; dy < step; dy += stride {
+				for dx := 0; dx < step; dx += stride {
+					cr, cg, cb, _ := img.At(bounds.Min.X+x*step+dx, bounds.Min.Y+y*step+dy).RGBA()
+					r, g, b, n = r+cr, g+cg, b+cb, n+1
+				}
+			}
+			thumb.SetRGBA(x, y, color.RGBA{uint8(r / n >> 8), uint8(g / n >> 8), uint8(b / n >> 8), 0xff})
+		}
+	}
+	return thumb, nil
+}
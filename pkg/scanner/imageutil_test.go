@@ -0,0 +1,90 @@
+package scanner
+
+import (
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// testPage returns a page with a gradient in color
+func testPage() image.Image {
+	img := image.NewRGBA(image.Rect(0, 0, 64, 48))
+	for y := 0; y < 48; y++ {
+		for x := 0; x < 64; x++ {
+			img.SetRGBA(x, y, color.RGBA{R: uint8(x * 4), G: uint8(y * 5), B: 200, A: 0xFF})
+		}
+	}
+	return img
+}
+
+func TestSavePNGRecordsDPI(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "page.png")
+	if err := savePNG(file, testPage(), 150); err != nil {
+		t.Fatal(err)
+	}
+	if dpi := fileDPI(file); dpi != 150 {
+		t.Errorf("recorded %d DPI, want 150", dpi)
+	}
+
+	// The chunk keeps the file valid
+	f, err := os.Open(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if _, err := png.Decode(f); err != nil {
+		t.Errorf("invalid PNG: %v", err)
+	}
+
+	if err := savePNG(file, testPage(), 0); err != nil {
+		t.Fatal(err)
+	}
+	if dpi := fileDPI(file); dpi != 0 {
+		t.Errorf("recorded %d DPI, want none", dpi)
+	}
+}
+
+func TestSaveJPEGRecordsDPI(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "page.jpg")
+	if err := saveJPEG(file, testPage(), 80, 600); err != nil {
+		t.Fatal(err)
+	}
+	if dpi := fileDPI(file); dpi != 600 {
+		t.Errorf("recorded %d DPI, want 600", dpi)
+	}
+
+	f, err := os.Open(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if _, err := jpeg.Decode(f); err != nil {
+		t.Errorf("invalid JPEG: %v", err)
+	}
+}
+
+func TestPagesDPI(t *testing.T) {
+	dir := t.TempDir()
+	save := func(name string, dpi int) string {
+		file := filepath.Join(dir, name)
+		if err := savePNG(file, testPage(), dpi); err != nil {
+			t.Fatal(err)
+		}
+		return file
+	}
+	first, second, unknown := save("page_001.png", 200), save("page_002.png", 200), save("page_003.png", 0)
+
+	if dpi, recorded := pagesDPI([]string{first, second}); dpi != 200 || !recorded {
+		t.Errorf("pagesDPI = %d, %v, want 200 recorded by every page", dpi, recorded)
+	}
+	if dpi, recorded := pagesDPI([]string{first, unknown}); dpi != 200 || recorded {
+		t.Errorf("pagesDPI = %d, %v, want 200 for the page without one", dpi, recorded)
+	}
+	if dpi, recorded := pagesDPI([]string{unknown}); dpi != ScanResolution || recorded {
+		t.Errorf("pagesDPI = %d, %v, want ScanResolution", dpi, recorded)
+	}
+}
@@ -0,0 +1,351 @@
+package scanner
+
+import (
+	"fmt"
+	"image"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"scanexpress/pkg/sane"
+)
+
+// NetworkDevicePrefix starts the identifiers of scanners reached through the
+// SANE network protocol, e.g. "sane://pi.local/brother5:bus1;dev4"
+const NetworkDevicePrefix = "sane://"
+
+// Network holds the network scanners: the saned servers scanners are looked
+// for on and the eSCL scanners
+type Network struct {
+	Hosts    []string // saned servers as host or host:port
+	Username string   // Name for saned servers requiring authorization
+	Password string
+	ESCL     []string // Base URLs of eSCL scanners, e.g. "http://printer.local/eSCL"
+}
+
+// Capabilities describes the settings a scanner supports, in the terms the
+// scan options are offered in
+type Capabilities struct {
+	Resolutions []int       // Supported resolutions in DPI, empty when any resolution in a range works
+	ColorModes  []ColorMode // Supported color modes, richest first
+	Feeder      bool        // Has a document feeder
+	Duplex      bool        // The feeder can scan both sides
+	Flatbed     bool        // Has a flatbed glass, see PreviewFlatbed
+}
+
+// IsNetworkDevice reports whether the device is reached through saned or eSCL
+func IsNetworkDevice(device string) bool {
+	return strings.HasPrefix(device, NetworkDevicePrefix) || strings.HasPrefix(device, ESCLDevicePrefix)
+}
+
+// parseNetworkDevice splits a network device identifier into the server and
+// the device name on the server
+func parseNetworkDevice(device string) (host, name string, err error) {
+	host, name, ok := strings.Cut(strings.TrimPrefix(device, NetworkDevicePrefix), "/")
+	if !strings.HasPrefix(device, NetworkDevicePrefix) || !ok || host == "" || name == "" {
+		return "", "", fmt.Errorf("invalid network device %q", device)
+	}
+	return host, name, nil
+}
+
+// Configured reports whether the network device is on one of the saned
+// servers or is one of the eSCL scanners
+func (n Network) Configured(device string) bool {
+	switch Transport(device) {
+	case TransportSANE:
+		host, _, err := parseNetworkDevice(device)
+		return err == nil && slices.Contains(n.Hosts, host)
+	case TransportESCL:
+		url := strings.TrimPrefix(device, ESCLDevicePrefix)
+		return slices.ContainsFunc(n.ESCL, func(configured string) bool {
+			return strings.TrimSuffix(configured, "/") == url
+		})
+	}
+	return false
+}
+
+// ListScanners lists the scanners offered by the saned servers and the
+// eSCL scanners. Scanners that are reachable are returned even when others
+// fail.
+func (n Network) ListScanners() ListScannersResult {
+	var scanners []Scanner
+	var errs []string
+	for _, url := range n.ESCL {
+		scanner, err := esclScanner(url)
+		if err != nil {
+			errs = append(errs, err.Error())
+			continue
+		}
+		scanners = append(scanners, scanner)
+	}
+	for _, host := range n.Hosts {
+		client, err := sane.Dial(host, n.Username, n.Password)
+		if err != nil {
+			errs = append(errs, err.Error())
+			continue
+		}
+		devices, err := client.Devices()
+		client.Close()
+		if err != nil {
+			errs = append(errs, fmt.Sprintf("%s: %v", host, err))
+			continue
+		}
+
+		for _, d := range devices {
+			title := strings.TrimSpace(d.Vendor + " " + d.Model)
+			if title == "" {
+				title = d.Name
+			}
+			scanners = append(scanners, Scanner{
+				Device: NetworkDevicePrefix + host + "/" + d.Name,
+				Title:  fmt.Sprintf("%s on %s", title, host),
+			})
+		}
+	}
+
+	result := ListScannersResult{Scanners: scanners}
+	if len(errs) > 0 {
+		result.Error = fmt.Errorf("failed to list network scanners: %s", strings.Join(errs, "; "))
+	}
+	return result
+}
+
+// Capabilities returns the settings a scanner supports
+func (n Network) Capabilities(device string) (Capabilities, error) {
+	if !IsNetworkDevice(device) {
+		return localCapabilities(device)
+	}
+	if strings.HasPrefix(device, ESCLDevicePrefix) {
+		return esclCapabilities(device)
+	}
+
+	host, name, err := parseNetworkDevice(device)
+	if err != nil {
+		return Capabilities{}, err
+	}
+	client, err := sane.Dial(host, n.Username, n.Password)
+	if err != nil {
+		return Capabilities{}, err
+	}
+	defer client.Close()
+	handle, err := client.Open(name)
+	if err != nil {
+		return Capabilities{}, err
+	}
+	defer handle.Close()
+
+	var caps Capabilities
+	if o, ok := handle.Option("resolution"); ok {
+		for _, r := range o.Numbers {
+			caps.Resolutions = append(caps.Resolutions, int(r))
+		}
+	}
+	if o, ok := handle.Option("mode"); ok {
+		caps.ColorModes = colorModes(o.Strings)
+	}
+	if o, ok := handle.Option("source"); ok {
+		caps.Feeder = feederSource(o.Strings, false) != ""
+		caps.Duplex = feederSource(o.Strings, true) != ""
+		caps.Flatbed = flatbedSource(o.Strings) != ""
+	} else {
+		caps.Flatbed = true
+	}
+	return caps, nil
+}
+
+// ScanPage scans a single page, or both sides of it in duplex mode, from a
+// network scanner and saves it as PNG like ScanPage does
+func (n Network) ScanPage(device string, outputFile string, isDuplex bool, pageNum int) PageScanResult {
+	if strings.HasPrefix(device, ESCLDevicePrefix) {
+		return esclScanPage(device, outputFile, isDuplex, pageNum)
+	}
+
+	host, name, err := parseNetworkDevice(device)
+	if err != nil {
+		return failedPage(pageNum, err)
+	}
+	client, err := sane.Dial(host, n.Username, n.Password)
+	if err != nil {
+		return failedPage(pageNum, err)
+	}
+	defer client.Close()
+
+	handle, err := client.Open(name)
+	if err != nil {
+		return failedPage(pageNum, err)
+	}
+	defer handle.Close()
+
+	resolution, err := configureNetworkScan(handle, isDuplex)
+	if err != nil {
+		return failedPage(pageNum, err)
+	}
+
+	// A duplex page is read as two images, front and back. Stop the feeder
+	// afterwards so that only one sheet is taken.
+	result := saveSides(outputFile, isDuplex, pageNum, resolution, func() (image.Image, error) {
+		img, err := handle.Scan()
+		if sane.IsNoDocs(err) {
+			return nil, nil
+		}
+		return img, err
+	})
+	handle.Cancel()
+	return result
+}
+
+// EndBatch ends the batch of pages ScanPage reads from the document feeder
+// of an eSCL scanner, stopping the scanner when sheets are left. It is
+// called once a session has all of its pages.
+func (n Network) EndBatch(device string) {
+	if strings.HasPrefix(device, ESCLDevicePrefix) {
+		endESCLBatch(device)
+	}
+}
+
+// failedPage returns the result of a page that could not be scanned
+func failedPage(pageNum int, err error) PageScanResult {
+	return PageScanResult{
+		Success: false,
+		Error:   fmt.Errorf("scanning page %d failed: %w", pageNum, err),
+	}
+}
+
+// saveSides reads the images of a page, both sides in duplex mode, and saves
+// them under the names ScanPage uses, recording the resolution they were
+// scanned at. next returns a nil image when the scanner has no more pages,
+// which is allowed for the back of a sheet as blank backs may be skipped.
+func saveSides(outputFile string, isDuplex bool, pageNum, resolution int, next func() (image.Image, error)) PageScanResult {
+	sides := 1
+	if isDuplex {
+		sides = 2
+	}
+
+	var files []string
+	for side := 0; side < sides; side++ {
+		img, err := next()
+		if err != nil {
+			return failedPage(pageNum, err)
+		}
+		if img == nil {
+			if side > 0 {
+				break
+			}
+			return failedPage(pageNum, ErrFeederEmpty)
+		}
+
+		file := outputFile
+		if isDuplex {
+			file = filepath.Join(filepath.Dir(outputFile), fmt.Sprintf("page_%03d_%s.png", pageNum, getSideLabel(side)))
+		}
+		if err := savePNG(file, img, resolution); err != nil {
+			return failedPage(pageNum, err)
+		}
+		files = append(files, file)
+	}
+
+	pageNums := []int{pageNum}
+	if isDuplex {
+		pageNums = []int{pageNum, pageNum + 1}
+	}
+	return PageScanResult{
+		Success:    true,
+		FilePaths:  files,
+		PageNums:   pageNums,
+		Resolution: resolution,
+	}
+}
+
+// configureNetworkScan applies the settings ScanPage passes to scanimage:
+// color at ScanResolution from the document feeder, with deskewing and page
+// size detection where the backend offers them. It returns the resolution
+// set.
+func configureNetworkScan(h *sane.Handle, isDuplex bool) (int, error) {
+	if o, ok := h.Option("mode"); ok && o.Settable() {
+		if mode := findValue(o.Strings, "Color", "color", "24bit Color"); mode != "" {
+			if err := h.Set("mode", mode); err != nil {
+				return 0, err
+			}
+		}
+	}
+
+	if o, ok := h.Option("source"); ok && o.Settable() {
+		if source := feederSource(o.Strings, isDuplex); source != "" {
+			if err := h.Set("source", source); err != nil {
+				return 0, err
+			}
+		}
+	}
+
+	// The resolution is set after the mode and source, which may change
+	// the resolutions available
+	resolution := ScanResolution
+	if o, ok := h.Option("resolution"); ok && o.Settable() {
+		nearest := o.Nearest(ScanResolution)
+		if err := h.Set("resolution", nearest); err != nil {
+			return 0, err
+		}
+		resolution = int(nearest)
+	}
+
+	for _, name := range []string{"AutoDeskew", "AutoDocumentSize"} {
+		if o, ok := h.Option(name); ok && o.Settable() && o.Type == sane.TypeBool {
+			if err := h.Set(name, true); err != nil {
+				return 0, err
+			}
+		}
+	}
+	return resolution, nil
+}
+
+// colorModes maps the scan modes offered by a SANE backend to color modes,
+// richest first
+func colorModes(offered []string) []ColorMode {
+	var modes []ColorMode
+	for _, mode := range []struct {
+		mode  ColorMode
+		names []string
+	}{
+		{ColorModeColor, []string{"Color", "24bit Color"}},
+		{ColorModeGrayscale, []string{"Gray", "Grayscale"}},
+		{ColorModeBlackWhite, []string{"Lineart", "Black & White"}},
+	} {
+		if findValue(offered, mode.names...) != "" {
+			modes = append(modes, mode.mode)
+		}
+	}
+	return modes
+}
+
+// findValue returns the first of the wanted values offered, ignoring case
+func findValue(offered []string, wanted ...string) string {
+	for _, w := range wanted {
+		for _, o := range offered {
+			if strings.EqualFold(o, w) {
+				return o
+			}
+		}
+	}
+	return ""
+}
+
+// feederSource picks the document feeder among the sources of a scanner,
+// preferring the sources scanimage is asked for
+func feederSource(sources []string, isDuplex bool) string {
+	if isDuplex {
+		if source := findValue(sources, "Automatic Document Feeder(left aligned,Duplex)", "ADF Duplex"); source != "" {
+			return source
+		}
+	} else if source := findValue(sources, "Automatic Document Feeder(left aligned)", "ADF", "ADF Front"); source != "" {
+		return source
+	}
+
+	for _, source := range sources {
+		lower := strings.ToLower(source)
+		feeder := strings.Contains(lower, "adf") || strings.Contains(lower, "feeder")
+		if feeder && strings.Contains(lower, "duplex") == isDuplex {
+			return source
+		}
+	}
+	return ""
+}
@@ -0,0 +1,85 @@
+package scanner
+
+import (
+	"fmt"
+	"os/exec"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// allOptions returns the options scanimage lists for a device, with their
+// allowed and current values
+func allOptions(device string) (string, error) {
+	output, err := exec.Command("scanimage", "--device-name="+device, "--all-options").CombinedOutput()
+	if err != nil {
+		return "", fmt.Errorf("failed to read the scanner options: %v - %s", err, strings.TrimSpace(string(output)))
+	}
+	return string(output), nil
+}
+
+// localOptions returns the allowed values of each option scanimage lists,
+// by name without the leading dashes, e.g. "mode" for
+// "    --mode Color|Gray|Lineart [Color]" and "x" for "    -x 0..215.9mm [215.9]"
+func localOptions(output string) map[string][]string {
+	options := make(map[string][]string)
+	for _, line := range strings.Split(output, "\n") {
+		line = strings.TrimSpace(line)
+		name, rest, ok := strings.Cut(strings.TrimLeft(line, "-"), " ")
+		if !ok || !strings.HasPrefix(line, "-") {
+			continue
+		}
+		// The allowed values come before the current value in brackets
+		values, _, _ := strings.Cut(rest, " [")
+		values, _, _ = strings.Cut(values, " (in steps of")
+		options[name] = strings.Split(values, "|")
+	}
+	return options
+}
+
+// localCapabilities reads the settings a local scanner supports from the
+// options scanimage lists
+func localCapabilities(device string) (Capabilities, error) {
+	output, err := allOptions(device)
+	if err != nil {
+		return Capabilities{}, err
+	}
+
+	var caps Capabilities
+	options := localOptions(output)
+	caps.Resolutions = localResolutions(options)
+	if offered, ok := options["mode"]; ok {
+		caps.ColorModes = colorModes(offered)
+	}
+	if offered, ok := options["source"]; ok {
+		caps.Feeder = feederSource(offered, false) != ""
+		caps.Duplex = feederSource(offered, true) != ""
+		caps.Flatbed = flatbedSource(offered) != ""
+	} else {
+		// Scanners with a single source are flatbeds
+		caps.Flatbed = true
+	}
+	return caps, nil
+}
+
+// localResolutions returns the resolutions listed by scanimage, none when
+// a range allows any of them
+func localResolutions(options map[string][]string) []int {
+	offered := options["resolution"]
+	if slices.ContainsFunc(offered, isRange) {
+		return nil
+	}
+	var resolutions []int
+	for _, r := range offered {
+		if dpi, err := strconv.Atoi(strings.TrimSuffix(r, "dpi")); err == nil {
+			resolutions = append(resolutions, dpi)
+		}
+	}
+	return resolutions
+}
+
+// isRange reports whether an allowed value of scanimage is a range, e.g.
+// "0..215.9mm"
+func isRange(value string) bool {
+	return strings.Contains(value, "..")
+}
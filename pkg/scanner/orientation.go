@@ -0,0 +1,250 @@
+package scanner
+
+import (
+	"fmt"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strconv"
+)
+
+// Orientation detection methods
+const (
+	OrientationMethodOCR       = "tesseract"
+	OrientationMethodHeuristic = "text-lines"
+)
+
+// minOSDConfidence is the lowest tesseract orientation confidence we trust
+const minOSDConfidence = 3.0
+
+// minTextLines is the number of text lines the heuristic needs before it
+// makes a decision
+const minTextLines = 3
+
+// OrientationResult holds the result of orientation correction for one page
+type OrientationResult struct {
+	File     string // Path to the page image
+	Rotation int    // Clockwise rotation applied in degrees (0, 90, 180 or 270)
+	Method   string // Detection method used
+	Error    error
+}
+
+// Rotated reports whether the page was turned upright
+func (r OrientationResult) Rotated() bool {
+	return r.Error == nil && r.Rotation != 0
+}
+
+// PageLabel returns a short human-readable label for the page (e.g. "page_003_B")
+func (r OrientationResult) PageLabel() string {
+	base := filepath.Base(r.File)
+	return base[:len(base)-len(filepath.Ext(base))]
+}
+
+// CorrectOrientation detects the orientation of the scanned page and rotates
+// the image file in place so that its text reads upright
+func CorrectOrientation(path string) OrientationResult {
+	result := OrientationResult{File: path}
+
+	img, err := loadImage(path)
+	if err != nil {
+		result.Error = err
+		return result
+	}
+
+	// Prefer tesseract's orientation detection when it is installed
+	rotation, ok := detectOrientationOSD(path)
+	if ok {
+		result.Method = OrientationMethodOCR
+	} else {
+		rotation = detectOrientationHeuristic(newGrayMatrix(img, 1200))
+		result.Method = OrientationMethodHeuristic
+	}
+
+	if rotation == 0 {
+		return result
+	}
+
+	if err := savePNG(path, rotateImage(img, rotation), fileDPI(path)); err != nil {
+		result.Error = fmt.Errorf("failed to rotate %s: %v", filepath.Base(path), err)
+		return result
+	}
+
+	result.Rotation = rotation
+	return result
+}
+
+// detectOrientationOSD runs tesseract's orientation and script detection on
+// the page. It returns false when tesseract is unavailable or unsure.
+func detectOrientationOSD(path string) (int, bool) {
+	if _, err := exec.LookPath("tesseract"); err != nil {
+		return 0, false
+	}
+
+	output, err := exec.Command("tesseract", path, "stdout", "--psm", "0").CombinedOutput()
+	if err != nil {
+		return 0, false
+	}
+
+	// Example output:
+	// Orientation in degrees: 180
+	// Orientation confidence: 12.51
+	degreesMatch := regexp.MustCompile(`Orientation in degrees:\s*(\d+)`).FindSubmatch(output)
+	confidenceMatch := regexp.MustCompile(`Orientation confidence:\s*([\d.]+)`).FindSubmatch(output)
+	if len(degreesMatch) < 2 || len(confidenceMatch) < 2 {
+		return 0, false
+	}
+
+	degrees, err := strconv.Atoi(string(degreesMatch[1]))
+	if err != nil {
+		return 0, false
+	}
+	confidence, err := strconv.ParseFloat(string(confidenceMatch[1]), 64)
+	if err != nil || confidence < minOSDConfidence {
+		return 0, false
+	}
+
+	// tesseract reports how far the text is turned counter-clockwise, which
+	// is the clockwise rotation needed to put it back upright
+	return degrees % 360, true
+}
+
+// detectOrientationHeuristic estimates the clockwise rotation needed to put
+// the page upright from the shape of its text lines.
+//
+// Text lines show up as strong alternations in the ink projection profile
+// along the axis perpendicular to the lines. Within each line, Latin script
+// has more ink above the x-height band (ascenders, capitals) than below it
+// (descenders), which tells which way is up.
+func detectOrientationHeuristic(g grayMatrix) int {
+	if g.Width == 0 || g.Height == 0 {
+		return 0
+	}
+
+	threshold := otsuThreshold(g.Pix)
+	rows := make([]int, g.Height)
+	cols := make([]int, g.Width)
+	for y := 0; y < g.Height; y++ {
+		for x := 0; x < g.Width; x++ {
+			if g.At(x, y) <= threshold {
+				rows[y]++
+				cols[x]++
+			}
+		}
+	}
+
+	// Only consider a quarter turn when the evidence is clearly stronger, a
+	// false positive is worse than leaving the page alone
+	if profileStructure(cols) > 1.5*profileStructure(rows) {
+		// Vertical text lines: the page is turned by a quarter
+		switch ascenderVote(cols) {
+		case 1:
+			// Ascenders face right, the page was turned clockwise
+			return 270
+		case -1:
+			return 90
+		}
+		return 0
+	}
+
+	if ascenderVote(rows) > 0 {
+		// Ascenders below the x-height band, the page is upside down
+		return 180
+	}
+	return 0
+}
+
+// profileStructure measures how sharply a projection profile alternates
+// between ink and gaps, which is high across text lines
+func profileStructure(profile []int) float64 {
+	var energy, variation float64
+	for i, v := range profile {
+		energy += float64(v) * float64(v)
+		if i > 0 {
+			d := float64(v - profile[i-1])
+			variation += d * d
+		}
+	}
+	if energy == 0 {
+		return 0
+	}
+	return variation / energy
+}
+
+// ascenderVote segments the profile into text lines and votes on which side
+// of each line carries the ascenders. It returns -1 when they are mostly on
+// the low-index side, 1 for the high-index side and 0 when undecided.
+func ascenderVote(profile []int) int {
+	lowSide, highSide := 0, 0
+
+	// Ignore specks of dust and scanner noise between lines
+	floor := 0
+	for _, v := range profile {
+		floor = max(floor, v/20)
+	}
+
+	for start := 0; start < len(profile); {
+		if profile[start] <= floor {
+			start++
+			continue
+		}
+		end := start
+		for end < len(profile) && profile[end] > floor {
+			end++
+		}
+
+		if low, high, ok := lineExtents(profile[start:end]); ok {
+			if low > high {
+				lowSide++
+			} else if high > low {
+				highSide++
+			}
+		}
+		start = end
+	}
+
+	total := lowSide + highSide
+	if total < minTextLines {
+		return 0
+	}
+	// Require a clear majority before turning anything
+	switch {
+	case lowSide*3 > total*2:
+		return -1
+	case highSide*3 > total*2:
+		return 1
+	}
+	return 0
+}
+
+// lineExtents returns the ink found on either side of the x-height band of
+// a single text line
+func lineExtents(line []int) (low, high int, ok bool) {
+	if len(line) < 6 {
+		return 0, 0, false
+	}
+
+	peak := 0
+	for _, v := range line {
+		peak = max(peak, v)
+	}
+
+	// The x-height band is where the line holds at least half its peak ink
+	bandStart, bandEnd := -1, -1
+	for i, v := range line {
+		if v*2 >= peak {
+			if bandStart < 0 {
+				bandStart = i
+			}
+			bandEnd = i
+		}
+	}
+
+	for i := 0; i < bandStart; i++ {
+		low += line[i]
+	}
+	for i := bandEnd + 1; i < len(line); i++ {
+		high += line[i]
+	}
+
+	return low, high, low+high > 0
+}
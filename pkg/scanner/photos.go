@@ -0,0 +1,325 @@
+package scanner
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"math"
+	"path/filepath"
+	"slices"
+	"sort"
+)
+
+// Photo detection works on a downscaled copy of the page
+const photoDetectionSize = 800
+
+// photoContrast is how far from the luminance of the lid a pixel must be to
+// be taken for part of a photo
+const photoContrast = 40
+
+// photoGap is how many detection pixels apart parts of a photo can be, e.g.
+// across a light area near its edge, and still be taken for one photo
+const photoGap = 3
+
+// photoQuality is the JPEG quality photos are saved with
+const photoQuality = 92
+
+// SplitPhotos finds the photos laid side by side on a flatbed scan and
+// returns each of them straightened, in reading order. The lid behind the
+// photos must be plain, as it is on most flatbeds.
+func SplitPhotos(page image.Image) []image.Image {
+	g := newGrayMatrix(page, photoDetectionSize)
+	if g.Width < 3 || g.Height < 3 {
+		return nil
+	}
+	scale := float64(page.Bounds().Dx()) / float64(g.Width)
+
+	mask := photoMask(g)
+	var rects []photoRect
+	for _, points := range photoRegions(mask, g.Width, g.Height) {
+		rect := minAreaRect(convexHull(points))
+		rects = append(rects, rect.scaled(scale))
+	}
+
+	// Photos are read by rows, from the top left
+	sort.SliceStable(rects, func(i, j int) bool {
+		a, b := rects[i], rects[j]
+		if math.Abs(a.cy-b.cy) < min(a.height, b.height)/2 {
+			return a.cx < b.cx
+		}
+		return a.cy < b.cy
+	})
+
+	src := image.NewRGBA(image.Rect(0, 0, page.Bounds().Dx(), page.Bounds().Dy()))
+	draw.Draw(src, src.Rect, page, page.Bounds().Min, draw.Src)
+	photos := make([]image.Image, 0, len(rects))
+	for _, rect := range rects {
+		photos = append(photos, rect.extract(src))
+	}
+	return photos
+}
+
+// savePhotos splits the photos of the pages and saves them as JPEG files
+// named after the document in dir, returning their paths
+func savePhotos(pageFiles []string, dir, name string) ([]string, error) {
+	var files []string
+	for _, file := range pageFiles {
+		page, err := loadImage(file)
+		if err != nil {
+			return files, err
+		}
+		dpi := fileDPI(file)
+		for _, photo := range SplitPhotos(page) {
+			path := filepath.Join(dir, fmt.Sprintf("%s_photo_%03d.jpg", name, len(files)+1))
+			if err := saveJPEG(path, photo, photoQuality, dpi); err != nil {
+				return files, err
+			}
+			files = append(files, path)
+		}
+	}
+	return files, nil
+}
+
+// photoMask marks the pixels that stand out from the lid, whose luminance is
+// the median of the pixels along the edges of the page
+func photoMask(g grayMatrix) []bool {
+	var edges []uint8
+	for x := 0; x < g.Width; x++ {
+		edges = append(edges, g.At(x, 1), g.At(x, g.Height-2))
+	}
+	for y := 0; y < g.Height; y++ {
+		edges = append(edges, g.At(1, y), g.At(g.Width-2, y))
+	}
+	slices.Sort(edges)
+	lid := int(edges[len(edges)/2])
+
+	mask := make([]bool, len(g.Pix))
+	for i, p := range g.Pix {
+		mask[i] = abs(int(p)-lid) > photoContrast
+	}
+	return mask
+}
+
+// photoRegions groups the marked pixels of the mask into photos, joining
+// parts up to photoGap pixels apart. Regions too small to be photos, like
+// dust, are dropped. Each region is given by the leftmost and rightmost
+// marked pixels of its rows, which is all its convex hull needs.
+func photoRegions(mask []bool, width, height int) [][]image.Point {
+	// Grow the marked pixels so that close parts touch
+	grown := make([]bool, len(mask))
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			if !mask[y*width+x] {
+				continue
+			}
+			for dy := max(y-photoGap, 0); dy <= min(y+photoGap, height-1); dy++ {
+				for dx := max(x-photoGap, 0); dx <= min(x+photoGap, width-1); dx++ {
+					grown[dy*width+dx] = true
+				}
+			}
+		}
+	}
+
+	minSide := max(width, height) / 25
+	minPixels := width * height / 200
+	visited := make([]bool, len(grown))
+	var regions [][]image.Point
+	for start := range grown {
+		if !grown[start] || visited[start] {
+			continue
+		}
+
+		// Flood fill the region, keeping the extremes of each row
+		left := make(map[int]int)
+		right := make(map[int]int)
+		bounds := image.Rect(width, height, 0, 0)
+		pixels := 0
+		stack := []int{start}
+		visited[start] = true
+		for len(stack) > 0 {
+			i := stack[len(stack)-1]
+			stack = stack[:len(stack)-1]
+			x, y := i%width, i/width
+			if mask[i] {
+				pixels++
+				if l, ok := left[y]; !ok || x < l {
+					left[y] = x
+				}
+				if r, ok := right[y]; !ok || x > r {
+					right[y] = x
+				}
+				bounds = bounds.Union(image.Rect(x, y, x+1, y+1))
+			}
+			for _, d := range [][2]int{{-1, 0}, {1, 0}, {0, -1}, {0, 1}} {
+				nx, ny := x+d[0], y+d[1]
+				if nx < 0 || ny < 0 || nx >= width || ny >= height {
+					continue
+				}
+				if j := ny*width + nx; grown[j] && !visited[j] {
+					visited[j] = true
+					stack = append(stack, j)
+				}
+			}
+		}
+
+		if pixels < minPixels || bounds.Dx() < minSide || bounds.Dy() < minSide {
+			continue
+		}
+		points := make([]image.Point, 0, 2*len(left))
+		for y, x := range left {
+			points = append(points, image.Pt(x, y), image.Pt(right[y], y))
+		}
+		regions = append(regions, points)
+	}
+	return regions
+}
+
+// convexHull returns the convex hull of the points, counterclockwise, with
+// Andrew's monotone chain
+func convexHull(points []image.Point) []image.Point {
+	points = slices.Clone(points)
+	sort.Slice(points, func(i, j int) bool {
+		if points[i].X != points[j].X {
+			return points[i].X < points[j].X
+		}
+		return points[i].Y < points[j].Y
+	})
+	points = slices.Compact(points)
+	if len(points) < 3 {
+		return points
+	}
+
+	cross := func(o, a, b image.Point) int {
+		return (a.X-o.X)*(b.Y-o.Y) - (a.Y-o.Y)*(b.X-o.X)
+	}
+	hull := make([]image.Point, 0, 2*len(points))
+	for _, p := range points {
+		for len(hull) >= 2 && cross(hull[len(hull)-2], hull[len(hull)-1], p) <= 0 {
+			hull = hull[:len(hull)-1]
+		}
+		hull = append(hull, p)
+	}
+	lower := len(hull) + 1
+	for i := len(points) - 2; i >= 0; i-- {
+		for len(hull) >= lower && cross(hull[len(hull)-2], hull[len(hull)-1], points[i]) <= 0 {
+			hull = hull[:len(hull)-1]
+		}
+		hull = append(hull, points[i])
+	}
+	return hull[:len(hull)-1]
+}
+
+// photoRect is a rectangle turned by angle radians around its center, in
+// pixels
+type photoRect struct {
+	cx, cy        float64
+	width, height float64
+	angle         float64
+}
+
+// minAreaRect returns the smallest rectangle holding the convex hull of
+// pixel centers. One of its sides lies along an edge of the hull, so each
+// edge is tried. The rectangle is turned by at most 45°, the way photos
+// are most likely to have been laid.
+func minAreaRect(hull []image.Point) photoRect {
+	best := photoRect{width: math.Inf(1), height: 1}
+	for i := range hull {
+		a, b := hull[i], hull[(i+1)%len(hull)]
+		angle := math.Atan2(float64(b.Y-a.Y), float64(b.X-a.X))
+		if len(hull) == 1 {
+			angle = 0
+		}
+		cos, sin := math.Cos(angle), math.Sin(angle)
+		minU, maxU := math.Inf(1), math.Inf(-1)
+		minV, maxV := math.Inf(1), math.Inf(-1)
+		for _, p := range hull {
+			u := float64(p.X)*cos + float64(p.Y)*sin
+			v := -float64(p.X)*sin + float64(p.Y)*cos
+			minU, maxU = min(minU, u), max(maxU, u)
+			minV, maxV = min(minV, v), max(maxV, v)
+		}
+		// Pixel centers are half a pixel inside the photo
+		width, height := maxU-minU+1, maxV-minV+1
+		if width*height >= best.width*best.height {
+			continue
+		}
+		u, v := (minU+maxU)/2, (minV+maxV)/2
+		best = photoRect{
+			cx:     u*cos - v*sin + 0.5,
+			cy:     u*sin + v*cos + 0.5,
+			width:  width,
+			height: height,
+			angle:  angle,
+		}
+	}
+
+	// Turning the rectangle by a right angle swaps its sides
+	for best.angle > math.Pi/4 {
+		best.angle -= math.Pi / 2
+		best.width, best.height = best.height, best.width
+	}
+	for best.angle <= -math.Pi/4 {
+		best.angle += math.Pi / 2
+		best.width, best.height = best.height, best.width
+	}
+	return best
+}
+
+// scaled returns the rectangle on an image scale times larger, less a
+// pixel of the detection image on each side where the photo edges blend
+// with the lid
+func (r photoRect) scaled(scale float64) photoRect {
+	return photoRect{
+		cx:     r.cx * scale,
+		cy:     r.cy * scale,
+		width:  max((r.width-2)*scale, 1),
+		height: max((r.height-2)*scale, 1),
+		angle:  r.angle,
+	}
+}
+
+// extract copies the rectangle of the image into an upright image,
+// interpolating between the pixels
+func (r photoRect) extract(src *image.RGBA) image.Image {
+	width, height := int(math.Round(r.width)), int(math.Round(r.height))
+	dst := image.NewRGBA(image.Rect(0, 0, width, height))
+	cos, sin := math.Cos(r.angle), math.Sin(r.angle)
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			du := float64(x) + 0.5 - float64(width)/2
+			dv := float64(y) + 0.5 - float64(height)/2
+			dst.SetRGBA(x, y, bilinear(src, r.cx+du*cos-dv*sin-0.5, r.cy+du*sin+dv*cos-0.5))
+		}
+	}
+	return dst
+}
+
+// bilinear returns the color of the image at a point between pixels
+func bilinear(img *image.RGBA, x, y float64) color.RGBA {
+	maxX, maxY := img.Rect.Dx()-1, img.Rect.Dy()-1
+	x = min(max(x, 0), float64(maxX))
+	y = min(max(y, 0), float64(maxY))
+	x0, y0 := int(x), int(y)
+	x1, y1 := min(x0+1, maxX), min(y0+1, maxY)
+	fx, fy := x-float64(x0), y-float64(y0)
+
+	var c [4]float64
+	for _, s := range []struct {
+		x, y   int
+		weight float64
+	}{
+		{x0, y0, (1 - fx) * (1 - fy)},
+		{x1, y0, fx * (1 - fy)},
+		{x0, y1, (1 - fx) * fy},
+		{x1, y1, fx * fy},
+	} {
+		p := img.RGBAAt(s.x, s.y)
+		c[0] += float64(p.R) * s.weight
+		c[1] += float64(p.G) * s.weight
+		c[2] += float64(p.B) * s.weight
+		c[3] += float64(p.A) * s.weight
+	}
+	round := func(v float64) uint8 { return uint8(math.Round(v)) }
+	return color.RGBA{round(c[0]), round(c[1]), round(c[2]), round(c[3])}
+}
@@ -1,14 +1,23 @@
 package scanner
 
 import (
+	"errors"
 	"fmt"
 	"os"
 	"os/exec"
 	"path/filepath"
 	"regexp"
+	"strconv"
 	"strings"
 )
 
+// ScanResolution is the resolution in dots per inch pages are scanned at
+const ScanResolution = 300
+
+// ErrFeederEmpty is the error of pages that could not be scanned because no
+// sheet is left in the document feeder
+var ErrFeederEmpty = errors.New("the document feeder is empty")
+
 // Scanner represents a physical scanner device
 type Scanner struct {
 	Device string // Device identifier (e.g., "brother5:bus1;dev4")
@@ -33,10 +42,11 @@ type ScanConfig struct {
 
 // PageScanResult holds the result of scanning a single page or duplex pages
 type PageScanResult struct {
-	Success   bool
-	Error     error
-	FilePaths []string // List of file paths for the scanned pages
-	PageNums  []int    // List of page numbers for the scanned pages
+	Success    bool
+	Error      error
+	FilePaths  []string // List of file paths for the scanned pages
+	PageNums   []int    // List of page numbers for the scanned pages
+	Resolution int      // Resolution the pages were scanned at, in DPI
 }
 
 // ListScannersResult holds the result of listing scanners
@@ -114,7 +124,7 @@ func ScanPage(device string, outputFile string, isDuplex bool, pageNum int) Page
 			"--device-name="+device,
 			"--format=png",
 			"-b",
-			"--resolution=300",
+			"--resolution="+strconv.Itoa(ScanResolution),
 			"--source="+source,
 			"--AutoDeskew=yes",
 			"--AutoDocumentSize=yes",
@@ -128,7 +138,7 @@ func ScanPage(device string, outputFile string, isDuplex bool, pageNum int) Page
 			"--device-name="+device,
 			"--format=png",
 			"--output-file="+outputFile,
-			"--resolution=300",
+			"--resolution="+strconv.Itoa(ScanResolution),
 			"--source="+source,
 			"--AutoDeskew=yes",
 			"--AutoDocumentSize=yes",
@@ -138,6 +148,13 @@ func ScanPage(device string, outputFile string, isDuplex bool, pageNum int) Page
 	// Run the command
 	output, err := cmd.CombinedOutput()
 	if err != nil {
+		// scanimage reports an empty feeder as SANE_STATUS_NO_DOCS
+		if strings.Contains(string(output), "out of documents") {
+			return PageScanResult{
+				Success: false,
+				Error:   fmt.Errorf("scanning page %d failed: %w", pageNum, ErrFeederEmpty),
+			}
+		}
 		return PageScanResult{
 			Success:   false,
 			Error:     fmt.Errorf("scanning page %d failed: %v - %s", pageNum, err, string(output)),
@@ -184,17 +201,19 @@ func ScanPage(device string, outputFile string, isDuplex bool, pageNum int) Page
 
 		// Success - we've verified output files exist
 		return PageScanResult{
-			Success:   true,
-			FilePaths: files,
-			PageNums:  []int{pageNum, pageNum + 1},
+			Success:    true,
+			FilePaths:  scannedFiles,
+			PageNums:   []int{pageNum, pageNum + 1},
+			Resolution: ScanResolution,
 		}
 	}
 
 	// For non-duplex scanning, just return the specified output file
 	return PageScanResult{
-		Success:   true,
-		FilePaths: []string{outputFile},
-		PageNums:  []int{pageNum},
+		Success:    true,
+		FilePaths:  []string{outputFile},
+		PageNums:   []int{pageNum},
+		Resolution: ScanResolution,
 	}
 }
 
@@ -0,0 +1,69 @@
+package scanner
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"scanexpress/pkg/sane"
+)
+
+// sensorRegex matches the boolean sensor options scanimage lists, e.g.
+// "    --scan[=(yes|no)] [no] [hardware]"
+var sensorRegex = regexp.MustCompile(`^\s*--([\w-]+)\[=\(yes\|no\)\] \[(yes|no)\] \[(?:hardware|read-only)\]`)
+
+// Sensors reads the buttons and other boolean sensors of a scanner, as
+// exposed through its option descriptors, by option name
+func (n Network) Sensors(device string) (map[string]bool, error) {
+	if !IsNetworkDevice(device) {
+		return localSensors(device)
+	}
+	if strings.HasPrefix(device, ESCLDevicePrefix) {
+		return nil, fmt.Errorf("eSCL scanners don't expose their buttons")
+	}
+
+	host, name, err := parseNetworkDevice(device)
+	if err != nil {
+		return nil, err
+	}
+	client, err := sane.Dial(host, n.Username, n.Password)
+	if err != nil {
+		return nil, err
+	}
+	defer client.Close()
+	handle, err := client.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer handle.Close()
+
+	sensors := make(map[string]bool)
+	for _, o := range handle.Options {
+		if o.Type != sane.TypeBool || !o.Sensor() {
+			continue
+		}
+		value, err := handle.Get(o.Name)
+		if err != nil {
+			return nil, err
+		}
+		sensors[o.Name], _ = value.(bool)
+	}
+	return sensors, nil
+}
+
+// localSensors reads the sensors of a local scanner from the options
+// scanimage lists with their current values
+func localSensors(device string) (map[string]bool, error) {
+	output, err := allOptions(device)
+	if err != nil {
+		return nil, err
+	}
+
+	sensors := make(map[string]bool)
+	for _, line := range strings.Split(output, "\n") {
+		if match := sensorRegex.FindStringSubmatch(line); match != nil {
+			sensors[match[1]] = match[2] == "yes"
+		}
+	}
+	return sensors, nil
+}
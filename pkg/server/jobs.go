@@ -0,0 +1,286 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"image/jpeg"
+	"path/filepath"
+	"slices"
+	"sort"
+	"time"
+
+	"scanexpress/pkg/scanner"
+	"scanexpress/pkg/session"
+)
+
+// States of a scan job
+const (
+	JobQueued   = "queued"   // Waiting for the scanner
+	JobRunning  = "running"  // Scanning or processing the document
+	JobReview   = "review"   // Scanned, waiting for the pages to be reviewed
+	JobDone     = "done"     // The document can be downloaded
+	JobFailed   = "failed"   // See Error
+	JobCanceled = "canceled" // Canceled by a client
+)
+
+// maxFinishedJobs bounds how many finished jobs are remembered
+const maxFinishedJobs = 100
+
+// thumbnailSize is the largest side of page thumbnails in pixels
+const thumbnailSize = 320
+
+// Job is a scan requested through the API
+type Job struct {
+	ID         string     `json:"id"`
+	Device     string     `json:"device"`
+	Profile    string     `json:"profile,omitempty"`
+	Duplex     bool       `json:"duplex"`
+	Review     bool       `json:"review"` // Wait for the pages to be reviewed before creating the PDF
+	State      string     `json:"state"`
+	Step       string     `json:"step,omitempty"`    // Step of a running job, e.g. "scanning"
+	Page       int        `json:"page,omitempty"`    // Page being scanned
+	Message    string     `json:"message,omitempty"` // Description of the step
+	Pages      []JobPage  `json:"pages,omitempty"`   // Pages scanned so far
+	PageCount  int        `json:"page_count,omitempty"`
+	Document   string     `json:"document,omitempty"` // File name of the generated PDF
+	Error      string     `json:"error,omitempty"`
+	Deliveries []Delivery `json:"deliveries,omitempty"`
+	Created    time.Time  `json:"created"`
+	Started    *time.Time `json:"started,omitempty"`
+	Finished   *time.Time `json:"finished,omitempty"`
+
+	options    session.Options
+	pdf        string        // Path of the generated PDF
+	thumbnails [][]byte      // JPEG thumbnail of each page
+	reviewed   chan review   // Receives the review of the pages
+	changed    chan struct{} // Closed when the job changes
+	cancel     context.CancelFunc
+}
+
+// JobPage is a scanned page of a job
+type JobPage struct {
+	Number    int    `json:"number"`
+	ColorMode string `json:"color_mode,omitempty"` // "color", "grayscale" or "black & white", known once scanning is done
+}
+
+// Delivery is the outcome of the first attempt to deliver a document
+type Delivery struct {
+	Target   string `json:"target"`
+	Message  string `json:"message,omitempty"`
+	Location string `json:"location,omitempty"`
+	Error    string `json:"error,omitempty"`
+}
+
+// review holds the choices made while reviewing the pages
+type review struct {
+	ColorModes []scanner.ColorMode
+	Recipients []string
+}
+
+// finished reports whether the job has ended
+func (j *Job) finished() bool {
+	return j.State == JobDone || j.State == JobFailed || j.State == JobCanceled
+}
+
+// copy returns a copy of the job that is safe to read without the lock
+func (j *Job) copy() Job {
+	c := *j
+	c.Pages = slices.Clone(j.Pages)
+	c.Deliveries = slices.Clone(j.Deliveries)
+	return c
+}
+
+// newJobID returns a unique job ID
+func newJobID() string {
+	id := make([]byte, 4)
+	rand.Read(id)
+	return time.Now().Format("20060102-150405") + "-" + hex.EncodeToString(id)
+}
+
+// start queues the job and runs it once the scanner is free. Jobs of one
+// scanner scan one at a time, in the order they were created; the scanner is
+// free for the next job while the pages are reviewed.
+func (s *Server) start(job *Job) {
+	ctx, cancel := context.WithCancel(context.Background())
+
+	s.mu.Lock()
+	job.cancel = cancel
+	job.reviewed = make(chan review, 1)
+	job.changed = make(chan struct{})
+	s.jobs[job.ID] = job
+	s.prune()
+	slot, ok := s.devices[job.Device]
+	if !ok {
+		slot = make(chan struct{}, 1)
+		s.devices[job.Device] = slot
+	}
+	s.mu.Unlock()
+
+	progress := func(p session.Progress) {
+		// Thumbnails are made as the pages come, the images are removed
+		// once the PDF is generated
+		var thumbnail []byte
+		if p.File != "" {
+			thumbnail = makeThumbnail(p.File)
+		}
+		s.update(job, func(j *Job) {
+			j.Step, j.Page, j.Message = p.Step, p.Page, p.Message
+			if p.File != "" {
+				j.Pages = append(j.Pages, JobPage{Number: len(j.Pages) + 1})
+				j.thumbnails = append(j.thumbnails, thumbnail)
+			}
+		})
+	}
+
+	opts := job.options
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		defer cancel()
+
+		// Goroutines waiting to send are woken in order
+		select {
+		case slot <- struct{}{}:
+		case <-ctx.Done():
+			s.finish(job, session.Result{}, ctx.Err())
+			return
+		}
+		s.update(job, func(j *Job) {
+			now := time.Now()
+			j.State = JobRunning
+			j.Started = &now
+		})
+		scanned, err := session.Scan(ctx, opts, progress)
+		<-slot
+		if err != nil {
+			s.finish(job, session.Result{}, err)
+			return
+		}
+
+		s.update(job, func(j *Job) {
+			for i, page := range scanned.Pages {
+				if i < len(j.Pages) {
+					j.Pages[i].ColorMode = string(page.ColorMode)
+				}
+			}
+		})
+		if job.Review {
+			s.update(job, func(j *Job) {
+				j.State = JobReview
+				j.Step, j.Page, j.Message = "", 0, "Review the pages"
+			})
+			select {
+			case r := <-job.reviewed:
+				for i, mode := range r.ColorModes {
+					if i < len(scanned.Pages) && mode != scanned.Pages[i].ColorMode {
+						scanned.Pages[i].ColorMode = mode
+						scanned.Pages[i].Chosen = true
+					}
+				}
+				if r.Recipients != nil {
+					opts.Recipients = r.Recipients
+				}
+			case <-ctx.Done():
+				s.finish(job, session.Result{}, ctx.Err())
+				return
+			}
+		}
+
+		result, err := session.Finish(ctx, s.cm, s.queue, opts, scanned, progress)
+		s.finish(job, result, err)
+	}()
+}
+
+// makeThumbnail encodes a small JPEG of a page, nil when it can't be read
+func makeThumbnail(file string) []byte {
+	img, err := scanner.Thumbnail(file, thumbnailSize)
+	if err != nil {
+		return nil
+	}
+	var b bytes.Buffer
+	if err := jpeg.Encode(&b, img, &jpeg.Options{Quality: 80}); err != nil {
+		return nil
+	}
+	return b.Bytes()
+}
+
+// finish records the outcome of a job
+func (s *Server) finish(job *Job, result session.Result, err error) {
+	s.update(job, func(j *Job) {
+		now := time.Now()
+		j.Finished = &now
+		j.Step, j.Page, j.Message = "", 0, ""
+		switch {
+		case errors.Is(err, context.Canceled):
+			j.State = JobCanceled
+		case err != nil:
+			j.State = JobFailed
+			j.Error = err.Error()
+		default:
+			j.State = JobDone
+			j.pdf = result.PDF
+			j.Document = filepath.Base(result.PDF)
+			j.PageCount = result.PageCount
+		}
+		for _, r := range result.Deliveries {
+			d := Delivery{Target: r.Target, Message: r.Message, Location: r.Location}
+			if r.Error != nil {
+				d.Error = r.Error.Error()
+			}
+			j.Deliveries = append(j.Deliveries, d)
+		}
+	})
+}
+
+// update changes a job while holding the lock, and wakes those watching it
+func (s *Server) update(job *Job, change func(*Job)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	change(job)
+	close(job.changed)
+	job.changed = make(chan struct{})
+}
+
+// snapshot returns a copy of a job, and a channel closed when it changes
+func (s *Server) snapshot(id string) (Job, <-chan struct{}, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	job, ok := s.jobs[id]
+	if !ok {
+		return Job{}, nil, false
+	}
+	return job.copy(), job.changed, true
+}
+
+// list returns copies of the jobs, oldest first
+func (s *Server) list() []Job {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	jobs := make([]Job, 0, len(s.jobs))
+	for _, job := range s.jobs {
+		jobs = append(jobs, job.copy())
+	}
+	sort.Slice(jobs, func(i, j int) bool { return jobs[i].Created.Before(jobs[j].Created) })
+	return jobs
+}
+
+// prune forgets the oldest finished jobs beyond maxFinishedJobs. The lock
+// must be held.
+func (s *Server) prune() {
+	var finished []string
+	for id, job := range s.jobs {
+		if job.finished() {
+			finished = append(finished, id)
+		}
+	}
+	if len(finished) <= maxFinishedJobs {
+		return
+	}
+	sort.Slice(finished, func(i, j int) bool { return s.jobs[finished[i]].Created.Before(s.jobs[finished[j]].Created) })
+	for _, id := range finished[:len(finished)-maxFinishedJobs] {
+		delete(s.jobs, id)
+	}
+}
@@ -0,0 +1,525 @@
+// Package server exposes scanning over an HTTP REST API: clients list the
+// scanners and their options, start scan jobs with a profile, follow their
+// progress, review the pages, download the documents and cancel jobs. A
+// small web interface built on the API is served along with it.
+package server
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"scanexpress/pkg/config"
+	"scanexpress/pkg/delivery"
+	"scanexpress/pkg/scanner"
+	"scanexpress/pkg/session"
+)
+
+// Server runs the scan jobs requested through the API
+type Server struct {
+	cm         *config.ConfigManager
+	queue      *delivery.Queue
+	network    scanner.Network
+	token      string // Bearer token required from clients, none when empty
+	saveFolder string
+	autoRotate bool
+	discovery  bool // Whether scanners announced with mDNS are listed
+
+	mu      sync.Mutex
+	jobs    map[string]*Job
+	devices map[string]chan struct{} // Held by the job using each scanner
+	wg      sync.WaitGroup
+}
+
+// New creates a server scanning with the settings of the configuration
+func New(cm *config.ConfigManager, queue *delivery.Queue) (*Server, error) {
+	saved := cm.GetConfig()
+	saveFolder := saved.SaveFolder
+	if saveFolder == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return nil, err
+		}
+		saveFolder = home
+	}
+
+	sane := cm.GetSANEConfig()
+	return &Server{
+		cm:    cm,
+		queue: queue,
+		network: scanner.Network{
+			Hosts:    sane.Hosts,
+			Username: sane.Username,
+			Password: sane.Password,
+			ESCL:     cm.GetESCLScanners(),
+		},
+		token:      cm.GetServerConfig().Token,
+		saveFolder: saveFolder,
+		autoRotate: saved.AutoRotate,
+		discovery:  saved.Discovery,
+		jobs:       make(map[string]*Job),
+		devices:    make(map[string]chan struct{}),
+	}, nil
+}
+
+// Handler returns the HTTP handler of the API and the web interface
+func (s *Server) Handler() http.Handler {
+	api := http.NewServeMux()
+	api.HandleFunc("GET /api/devices", s.listDevices)
+	api.HandleFunc("GET /api/devices/{device}/options", s.deviceOptions)
+	api.HandleFunc("GET /api/profiles", s.listProfiles)
+	api.HandleFunc("GET /api/jobs", s.listJobs)
+	api.HandleFunc("POST /api/jobs", s.createJob)
+	api.HandleFunc("GET /api/jobs/{id}", s.getJob)
+	api.HandleFunc("DELETE /api/jobs/{id}", s.cancelJob)
+	api.HandleFunc("GET /api/jobs/{id}/events", s.jobEvents)
+	api.HandleFunc("GET /api/jobs/{id}/pages/{page}/thumbnail", s.pageThumbnail)
+	api.HandleFunc("POST /api/jobs/{id}/review", s.reviewJob)
+	api.HandleFunc("GET /api/jobs/{id}/document", s.downloadDocument)
+
+	// The web interface holds no data, only the API needs the token
+	mux := http.NewServeMux()
+	mux.Handle("/api/", s.authorize(api))
+	mux.Handle("/", http.FileServerFS(webFiles))
+	return mux
+}
+
+// Close cancels the jobs and waits for them to stop
+func (s *Server) Close() {
+	s.mu.Lock()
+	for _, job := range s.jobs {
+		job.cancel()
+	}
+	s.mu.Unlock()
+	s.wg.Wait()
+}
+
+// authorize requires the bearer token from clients when one is configured.
+// Browsers can't set headers on event streams and images, so the token may
+// also be passed as the token query parameter.
+func (s *Server) authorize(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if s.token != "" {
+			token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+			if !ok {
+				token = r.URL.Query().Get("token")
+			}
+			if subtle.ConstantTimeCompare([]byte(token), []byte(s.token)) != 1 {
+				w.Header().Set("WWW-Authenticate", `Bearer realm="scanexpress"`)
+				writeError(w, http.StatusUnauthorized, errors.New("a valid API token is required"))
+				return
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// device is a scanner as listed by the API
+type device struct {
+	Device    string `json:"device"`
+	Title     string `json:"title"`
+	Transport string `json:"transport"` // "local", "saned" or "eSCL"
+}
+
+// discoveryTime is how long scanners announced with mDNS are looked for
+const discoveryTime = 2 * time.Second
+
+// scanners lists the local scanners, the configured network scanners and,
+// when discovery is on, those announced on the network
+func (s *Server) scanners(ctx context.Context) scanner.ListScannersResult {
+	result := scanner.ListScanners()
+	if len(s.network.Hosts) > 0 || len(s.network.ESCL) > 0 {
+		remote := s.network.ListScanners()
+		result.Scanners = append(result.Scanners, remote.Scanners...)
+		result.Error = errors.Join(result.Error, remote.Error)
+	}
+
+	if s.discovery {
+		ctx, cancel := context.WithTimeout(ctx, discoveryTime)
+		defer cancel()
+		found := make(chan scanner.Scanner)
+		go s.network.Discover(ctx, found)
+		for d := range found {
+			if !slices.ContainsFunc(result.Scanners, func(listed scanner.Scanner) bool { return listed.Device == d.Device }) {
+				result.Scanners = append(result.Scanners, d)
+			}
+		}
+	}
+	return result
+}
+
+// checkDevice makes sure clients only scan from the saved scanner or one the
+// listing returns. The device names the host saned credentials are sent to
+// and where eSCL requests go, so any other is refused.
+func (s *Server) checkDevice(ctx context.Context, device string) error {
+	if device == s.cm.GetConfig().ScannerDevice || s.network.Configured(device) {
+		return nil
+	}
+	for _, d := range s.scanners(ctx).Scanners {
+		if d.Device == device {
+			return nil
+		}
+	}
+	return fmt.Errorf("unknown device %q, see /api/devices", device)
+}
+
+// listDevices lists the local scanners and the network scanners
+func (s *Server) listDevices(w http.ResponseWriter, r *http.Request) {
+	result := s.scanners(r.Context())
+	if len(result.Scanners) == 0 && result.Error != nil {
+		writeError(w, http.StatusBadGateway, result.Error)
+		return
+	}
+
+	devices := make([]device, 0, len(result.Scanners))
+	for _, d := range result.Scanners {
+		devices = append(devices, device{Device: d.Device, Title: d.Title, Transport: scanner.Transport(d.Device)})
+	}
+	writeJSON(w, http.StatusOK, devices)
+}
+
+// options are the settings a scanner supports, as listed by the API
+type options struct {
+	Device      string   `json:"device"`
+	Resolutions []int    `json:"resolutions"` // Empty when any resolution in a range works
+	ColorModes  []string `json:"color_modes"`
+	Feeder      bool     `json:"feeder"`
+	Duplex      bool     `json:"duplex"`
+}
+
+// deviceOptions describes the settings a scanner supports
+func (s *Server) deviceOptions(w http.ResponseWriter, r *http.Request) {
+	name := r.PathValue("device")
+	if err := s.checkDevice(r.Context(), name); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	caps, err := s.network.Capabilities(name)
+	if err != nil {
+		writeError(w, http.StatusBadGateway, err)
+		return
+	}
+
+	o := options{
+		Device:      name,
+		Resolutions: caps.Resolutions,
+		ColorModes:  make([]string, 0, len(caps.ColorModes)),
+		Feeder:      caps.Feeder,
+		Duplex:      caps.Duplex,
+	}
+	if o.Resolutions == nil {
+		o.Resolutions = []int{}
+	}
+	for _, mode := range caps.ColorModes {
+		o.ColorModes = append(o.ColorModes, string(mode))
+	}
+	writeJSON(w, http.StatusOK, o)
+}
+
+// profile is a profile as listed by the API
+type profile struct {
+	Name        string    `json:"name"` // Empty for the top-level settings
+	Tags        []string  `json:"tags"`
+	Email       bool      `json:"email"` // Documents can be emailed
+	AddressBook []contact `json:"address_book,omitempty"`
+}
+
+// contact is an address book entry
+type contact struct {
+	Name  string `json:"name,omitempty"`
+	Email string `json:"email"`
+}
+
+// listProfiles lists the profiles jobs can use, starting with the top-level
+// settings
+func (s *Server) listProfiles(w http.ResponseWriter, r *http.Request) {
+	names := append([]string{""}, s.cm.ProfileNames()...)
+	profiles := make([]profile, 0, len(names))
+	for _, name := range names {
+		p, err := s.cm.GetProfile(name)
+		if err != nil {
+			continue
+		}
+		listed := profile{Name: p.Name, Tags: p.Tags, Email: p.Email.Enabled}
+		if listed.Tags == nil {
+			listed.Tags = []string{}
+		}
+		if p.Email.Enabled {
+			for _, c := range p.Email.AddressBook {
+				listed.AddressBook = append(listed.AddressBook, contact{Name: c.Name, Email: c.Email})
+			}
+		}
+		profiles = append(profiles, listed)
+	}
+	writeJSON(w, http.StatusOK, profiles)
+}
+
+// jobRequest is the body of a job creation
+type jobRequest struct {
+	Device     string   `json:"device"`  // The saved scanner when empty
+	Profile    string   `json:"profile"` // The top-level settings when empty
+	Duplex     bool     `json:"duplex"`
+	MaxPages   int      `json:"max_pages"`  // Sheets to scan at most, 0 to empty the feeder
+	Recipients []string `json:"recipients"` // Email recipients
+	Review     bool     `json:"review"`     // Wait for the pages to be reviewed before creating the PDF
+}
+
+// createJob queues a scan job
+func (s *Server) createJob(w http.ResponseWriter, r *http.Request) {
+	var req jobRequest
+	decoder := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<20))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("invalid job: %v", err))
+		return
+	}
+
+	if req.Device == "" {
+		req.Device = s.cm.GetConfig().ScannerDevice
+	}
+	if req.Device == "" {
+		writeError(w, http.StatusBadRequest, errors.New("no device given and no scanner is saved"))
+		return
+	}
+	if err := s.checkDevice(r.Context(), req.Device); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	if req.MaxPages < 0 {
+		writeError(w, http.StatusBadRequest, errors.New("max_pages can't be negative"))
+		return
+	}
+	profile, err := s.cm.GetProfile(req.Profile)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	if err := session.Check(profile); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	if len(req.Recipients) > 0 && !profile.Email.Enabled {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("email is not enabled in profile %q", profile.Name))
+		return
+	}
+
+	job := &Job{
+		ID:      newJobID(),
+		Device:  req.Device,
+		Profile: profile.Name,
+		Duplex:  req.Duplex,
+		Review:  req.Review,
+		State:   JobQueued,
+		Created: time.Now(),
+		options: session.Options{
+			Device:     req.Device,
+			Network:    s.network,
+			SaveFolder: s.saveFolder,
+			Duplex:     req.Duplex,
+			MaxPages:   req.MaxPages,
+			AutoRotate: s.autoRotate,
+			Profile:    profile,
+			Recipients: req.Recipients,
+		},
+	}
+	s.start(job)
+
+	created, _, _ := s.snapshot(job.ID)
+	w.Header().Set("Location", "/api/jobs/"+job.ID)
+	writeJSON(w, http.StatusAccepted, created)
+}
+
+// listJobs lists the jobs, oldest first
+func (s *Server) listJobs(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, s.list())
+}
+
+// getJob returns the status and progress of a job
+func (s *Server) getJob(w http.ResponseWriter, r *http.Request) {
+	job, _, ok := s.snapshot(r.PathValue("id"))
+	if !ok {
+		writeError(w, http.StatusNotFound, errors.New("no such job"))
+		return
+	}
+	writeJSON(w, http.StatusOK, job)
+}
+
+// jobEvents streams the job as Server-Sent Events, sending it again each
+// time it changes until it is finished
+func (s *Server) jobEvents(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		writeError(w, http.StatusInternalServerError, errors.New("streaming is not supported"))
+		return
+	}
+	job, changed, ok := s.snapshot(r.PathValue("id"))
+	if !ok {
+		writeError(w, http.StatusNotFound, errors.New("no such job"))
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	for {
+		data, err := json.Marshal(job)
+		if err != nil {
+			return
+		}
+		fmt.Fprintf(w, "event: job\ndata: %s\n\n", data)
+		flusher.Flush()
+		if job.finished() {
+			return
+		}
+
+		select {
+		case <-changed:
+		case <-r.Context().Done():
+			return
+		}
+		job, changed, _ = s.snapshot(job.ID)
+	}
+}
+
+// pageThumbnail sends the thumbnail of a scanned page
+func (s *Server) pageThumbnail(w http.ResponseWriter, r *http.Request) {
+	page, err := strconv.Atoi(r.PathValue("page"))
+	s.mu.Lock()
+	var thumbnail []byte
+	job, ok := s.jobs[r.PathValue("id")]
+	if ok && err == nil && page >= 1 && page <= len(job.thumbnails) {
+		thumbnail = job.thumbnails[page-1]
+	}
+	s.mu.Unlock()
+	if thumbnail == nil {
+		writeError(w, http.StatusNotFound, errors.New("no such page"))
+		return
+	}
+
+	w.Header().Set("Content-Type", "image/jpeg")
+	w.Header().Set("Cache-Control", "private, max-age=3600")
+	w.Write(thumbnail)
+}
+
+// reviewRequest is the body of a page review
+type reviewRequest struct {
+	ColorModes []scanner.ColorMode `json:"color_modes"` // Color mode of each page, the detected ones are kept when missing
+	Recipients []string            `json:"recipients"`  // Email recipients, those of the job are kept when missing
+}
+
+// reviewJob accepts the reviewed pages of a job, which then creates the PDF
+func (s *Server) reviewJob(w http.ResponseWriter, r *http.Request) {
+	var req reviewRequest
+	decoder := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<20))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("invalid review: %v", err))
+		return
+	}
+	for _, mode := range req.ColorModes {
+		if !slices.Contains(scanner.ColorModes, mode) {
+			writeError(w, http.StatusBadRequest, fmt.Errorf("invalid color mode %q", mode))
+			return
+		}
+	}
+
+	s.mu.Lock()
+	job, ok := s.jobs[r.PathValue("id")]
+	var status int
+	var err error
+	switch {
+	case !ok:
+		status, err = http.StatusNotFound, errors.New("no such job")
+	case job.State != JobReview:
+		status, err = http.StatusConflict, fmt.Errorf("the job is %s, not waiting for a review", job.State)
+	case len(req.Recipients) > 0 && !job.options.Profile.Email.Enabled:
+		status, err = http.StatusBadRequest, fmt.Errorf("email is not enabled in profile %q", job.Profile)
+	default:
+		// The job goes on right away so that it is reviewed only once
+		job.State = JobRunning
+		job.Message = ""
+		for i, mode := range req.ColorModes {
+			if i < len(job.Pages) {
+				job.Pages[i].ColorMode = string(mode)
+			}
+		}
+		job.reviewed <- review{ColorModes: req.ColorModes, Recipients: req.Recipients}
+		close(job.changed)
+		job.changed = make(chan struct{})
+	}
+	s.mu.Unlock()
+	if err != nil {
+		writeError(w, status, err)
+		return
+	}
+
+	snapshot, _, _ := s.snapshot(job.ID)
+	writeJSON(w, http.StatusAccepted, snapshot)
+}
+
+// cancelJob cancels a job. A queued job stops right away, a running one once
+// the page being scanned is done.
+func (s *Server) cancelJob(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	job, ok := s.jobs[r.PathValue("id")]
+	if ok && !job.finished() {
+		job.cancel()
+	}
+	s.mu.Unlock()
+	if !ok {
+		writeError(w, http.StatusNotFound, errors.New("no such job"))
+		return
+	}
+
+	snapshot, _, _ := s.snapshot(job.ID)
+	writeJSON(w, http.StatusAccepted, snapshot)
+}
+
+// downloadDocument sends the PDF of a finished job
+func (s *Server) downloadDocument(w http.ResponseWriter, r *http.Request) {
+	job, _, ok := s.snapshot(r.PathValue("id"))
+	if !ok {
+		writeError(w, http.StatusNotFound, errors.New("no such job"))
+		return
+	}
+	if job.State != JobDone {
+		writeError(w, http.StatusConflict, fmt.Errorf("the job is %s, it has no document", job.State))
+		return
+	}
+
+	file, err := os.Open(job.pdf)
+	if err != nil {
+		writeError(w, http.StatusGone, errors.New("the document was removed"))
+		return
+	}
+	defer file.Close()
+	info, err := file.Stat()
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/pdf")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", job.Document))
+	http.ServeContent(w, r, job.Document, info.ModTime(), file)
+}
+
+// writeJSON sends a JSON response
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+// writeError sends an error as a JSON response
+func writeError(w http.ResponseWriter, status int, err error) {
+	writeJSON(w, status, map[string]string{"error": err.Error()})
+}
@@ -0,0 +1,90 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"scanexpress/pkg/config"
+	"scanexpress/pkg/scanner"
+)
+
+func TestUnknownDevicesAreRefused(t *testing.T) {
+	cm, err := config.NewConfigManager()
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &Server{
+		cm: cm,
+		network: scanner.Network{
+			Hosts:    []string{"127.0.0.1:1"},
+			Username: "scanner",
+			Password: "hunter2",
+			ESCL:     []string{"http://127.0.0.1:1/eSCL/"},
+		},
+		jobs:    make(map[string]*Job),
+		devices: make(map[string]chan struct{}),
+	}
+	handler := s.Handler()
+
+	// A saned server that would get the credentials, and an HTTP service
+	// that would get the eSCL requests
+	saned, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer saned.Close()
+	contacted := make(chan string, 10)
+	go func() {
+		if conn, err := saned.Accept(); err == nil {
+			contacted <- "saned"
+			conn.Close()
+		}
+	}()
+	metadata := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		contacted <- r.URL.Path
+	}))
+	defer metadata.Close()
+
+	for _, device := range []string{
+		scanner.NetworkDevicePrefix + saned.Addr().String() + "/test:0",
+		scanner.ESCLDevicePrefix + metadata.URL + "/latest/meta-data",
+	} {
+		w := httptest.NewRecorder()
+		body := fmt.Sprintf(`{"device": %q}`, device)
+		handler.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/api/jobs", strings.NewReader(body)))
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("job on %s: status %d, want 400", device, w.Code)
+		}
+
+		w = httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/api/devices/"+url.PathEscape(device)+"/options", nil))
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("options of %s: status %d, want 400", device, w.Code)
+		}
+	}
+	if jobs := s.list(); len(jobs) != 0 {
+		t.Errorf("%d jobs created", len(jobs))
+	}
+	select {
+	case who := <-contacted:
+		t.Errorf("%s was contacted", who)
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	// The configured network scanners are accepted without listing them
+	for _, device := range []string{
+		scanner.NetworkDevicePrefix + "127.0.0.1:1/test:0",
+		scanner.ESCLDevicePrefix + "http://127.0.0.1:1/eSCL",
+	} {
+		if err := s.checkDevice(context.Background(), device); err != nil {
+			t.Errorf("checkDevice(%s): %v", device, err)
+		}
+	}
+}
@@ -0,0 +1,12 @@
+package server
+
+import (
+	"embed"
+	"io/fs"
+)
+
+//go:embed web
+var embedded embed.FS
+
+// webFiles holds the web interface, served from the root
+var webFiles, _ = fs.Sub(embedded, "web")
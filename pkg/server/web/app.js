@@ -0,0 +1,317 @@
+// Web interface of scan serve. It follows the flow of the terminal UI: pick
+// the scanner and the profile, scan, review the pages, then download or
+// deliver the PDF. Everything goes through the REST API under /api.
+"use strict";
+
+const colorModes = ["color", "grayscale", "black & white"];
+
+const $ = (id) => document.getElementById(id);
+
+let profiles = [];
+let job = null;
+let events = null;
+
+// token returns the API token saved in the browser, if any
+function token() {
+  return localStorage.getItem("scanexpress-token") || "";
+}
+
+// withToken adds the token to URLs the browser loads by itself, as event
+// streams and images can't send an Authorization header
+function withToken(url) {
+  return token() ? url + "?token=" + encodeURIComponent(token()) : url;
+}
+
+// api calls an endpoint and returns the decoded JSON body
+async function api(method, path, body) {
+  const headers = {};
+  if (token()) {
+    headers["Authorization"] = "Bearer " + token();
+  }
+  if (body !== undefined) {
+    headers["Content-Type"] = "application/json";
+  }
+  const response = await fetch(path, {
+    method,
+    headers,
+    body: body === undefined ? undefined : JSON.stringify(body),
+  });
+  if (response.status === 401) {
+    show("login");
+    throw new Error("A valid API token is required");
+  }
+  const data = await response.json();
+  if (!response.ok) {
+    throw new Error(data.error || response.statusText);
+  }
+  return data;
+}
+
+// show displays one section and hides the others
+function show(section) {
+  for (const id of ["setup", "job", "login"]) {
+    $(id).hidden = id !== section;
+  }
+}
+
+function showError(err) {
+  $("error").textContent = err ? err.message || String(err) : "";
+  $("error").hidden = !err;
+}
+
+function option(value, label) {
+  const o = document.createElement("option");
+  o.value = value;
+  o.textContent = label;
+  return o;
+}
+
+// loadSetup fills in the scanners and the profiles
+async function loadSetup() {
+  showError(null);
+  $("device").replaceChildren(option("", "Looking for scanners..."));
+  $("start").disabled = true;
+  show("setup");
+
+  try {
+    profiles = await api("GET", "/api/profiles");
+    const selected = $("profile").value;
+    $("profile").replaceChildren(
+      ...profiles.map((p) => option(p.name, p.name || "Default settings")),
+    );
+    $("profile").value = selected;
+
+    const devices = await api("GET", "/api/devices");
+    if (devices.length === 0) {
+      $("device").replaceChildren(option("", "No scanner found"));
+      return;
+    }
+    $("device").replaceChildren(
+      ...devices.map((d) => option(d.device, `${d.title} [${d.transport}]`)),
+    );
+    $("start").disabled = false;
+    await loadOptions();
+  } catch (err) {
+    $("device").replaceChildren(option("", "No scanner found"));
+    showError(err);
+  }
+}
+
+// loadOptions offers duplex only on scanners that support it
+async function loadOptions() {
+  const device = $("device").value;
+  if (!device) {
+    return;
+  }
+  try {
+    const options = await api("GET", `/api/devices/${encodeURIComponent(device)}/options`);
+    $("duplex").disabled = !options.duplex;
+    if (!options.duplex) {
+      $("duplex").checked = false;
+    }
+  } catch (err) {
+    // The scanner may be asleep, let scanning tell
+    $("duplex").disabled = false;
+  }
+}
+
+// startJob starts scanning and follows the job
+async function startJob(event) {
+  event.preventDefault();
+  showError(null);
+  $("start").disabled = true;
+  try {
+    const created = await api("POST", "/api/jobs", {
+      device: $("device").value,
+      profile: $("profile").value,
+      duplex: $("duplex").checked,
+      max_pages: Number($("max-pages").value) || 0,
+      review: true,
+    });
+    $("pages").replaceChildren();
+    $("deliveries").replaceChildren();
+    follow(created);
+  } catch (err) {
+    showError(err);
+  } finally {
+    $("start").disabled = false;
+  }
+}
+
+// follow shows a job and its updates as Server-Sent Events
+function follow(created) {
+  job = created;
+  show("job");
+  render();
+
+  if (events) {
+    events.close();
+  }
+  events = new EventSource(withToken(`/api/jobs/${job.id}/events`));
+  events.addEventListener("job", (e) => {
+    job = JSON.parse(e.data);
+    render();
+    if (["done", "failed", "canceled"].includes(job.state)) {
+      events.close();
+      events = null;
+    }
+  });
+  events.onerror = () => {
+    if (events && events.readyState === EventSource.CLOSED) {
+      showError(new Error("Lost the connection to the server"));
+    }
+  };
+}
+
+const titles = {
+  queued: "Waiting for the scanner",
+  running: "Scanning",
+  review: "Review the pages",
+  done: "Document created",
+  failed: "Scanning failed",
+  canceled: "Canceled",
+};
+
+// render updates the page with the job
+function render() {
+  $("job-title").textContent = titles[job.state] || job.state;
+  if (job.state === "failed") {
+    $("job-message").textContent = job.error;
+  } else if (job.state === "done") {
+    $("job-message").textContent = `${job.document} (${job.page_count} pages)`;
+  } else {
+    $("job-message").textContent = job.message || "";
+  }
+
+  renderPages();
+  renderRecipients();
+
+  const finished = ["done", "failed", "canceled"].includes(job.state);
+  $("review-form").hidden = job.state !== "review";
+  $("done").hidden = job.state !== "done";
+  $("cancel").hidden = finished;
+  $("again").hidden = !finished;
+
+  if (job.state === "done") {
+    $("download").href = withToken(`/api/jobs/${job.id}/document`);
+    $("download").download = job.document;
+    $("deliveries").replaceChildren(
+      ...(job.deliveries || []).map((d) => {
+        const li = document.createElement("li");
+        if (d.error) {
+          li.className = "failed";
+          li.textContent = `${d.target}: ${d.error} (retried later)`;
+        } else {
+          li.textContent = `${d.target}: ${d.message || d.location || "delivered"}`;
+        }
+        return li;
+      }),
+    );
+  }
+}
+
+// renderPages adds the thumbnails of the new pages, with a color mode
+// choice while reviewing
+function renderPages() {
+  const pages = job.pages || [];
+  const grid = $("pages");
+  for (const page of pages.slice(grid.children.length)) {
+    const figure = document.createElement("figure");
+    figure.className = "page";
+    const img = document.createElement("img");
+    img.src = withToken(`/api/jobs/${job.id}/pages/${page.number}/thumbnail`);
+    img.alt = `Page ${page.number}`;
+    const caption = document.createElement("figcaption");
+    caption.textContent = `Page ${page.number}`;
+    const select = document.createElement("select");
+    select.replaceChildren(...colorModes.map((m) => option(m, m)));
+    figure.append(img, caption, select);
+    grid.append(figure);
+  }
+
+  pages.forEach((page, i) => {
+    const select = grid.children[i].querySelector("select");
+    // The detected mode is shown once, then it is the user's choice
+    if (page.color_mode && !select.dataset.detected) {
+      select.value = page.color_mode;
+      select.dataset.detected = "true";
+    }
+    select.hidden = !page.color_mode;
+    select.disabled = job.state !== "review";
+  });
+}
+
+// renderRecipients offers the address book when the profile emails
+// documents
+function renderRecipients() {
+  const profile = profiles.find((p) => p.name === (job.profile || ""));
+  const email = profile && profile.email;
+  $("recipients").hidden = !email;
+  if (!email || $("contacts").children.length > 0) {
+    return;
+  }
+  $("contacts").replaceChildren(
+    ...(profile.address_book || []).map((c) => {
+      const label = document.createElement("label");
+      label.className = "inline";
+      const box = document.createElement("input");
+      box.type = "checkbox";
+      box.value = c.email;
+      label.append(box, c.name ? `${c.name} <${c.email}>` : c.email);
+      return label;
+    }),
+  );
+}
+
+// submitReview sends the color modes and the recipients, the server then
+// creates the PDF
+async function submitReview(event) {
+  event.preventDefault();
+  showError(null);
+  const modes = [...$("pages").querySelectorAll("select")].map((s) => s.value);
+  const recipients = [...$("contacts").querySelectorAll("input:checked")].map((b) => b.value);
+  for (const address of $("other-recipients").value.split(",")) {
+    if (address.trim()) {
+      recipients.push(address.trim());
+    }
+  }
+  try {
+    job = await api("POST", `/api/jobs/${job.id}/review`, {
+      color_modes: modes,
+      recipients,
+    });
+    render();
+  } catch (err) {
+    showError(err);
+  }
+}
+
+async function cancelJob() {
+  try {
+    job = await api("DELETE", `/api/jobs/${job.id}`);
+    render();
+  } catch (err) {
+    showError(err);
+  }
+}
+
+function scanAgain() {
+  job = null;
+  $("contacts").replaceChildren();
+  $("other-recipients").value = "";
+  loadSetup();
+}
+
+$("setup-form").addEventListener("submit", startJob);
+$("device").addEventListener("change", loadOptions);
+$("refresh").addEventListener("click", loadSetup);
+$("review-form").addEventListener("submit", submitReview);
+$("cancel").addEventListener("click", cancelJob);
+$("again").addEventListener("click", scanAgain);
+$("login-form").addEventListener("submit", (event) => {
+  event.preventDefault();
+  localStorage.setItem("scanexpress-token", $("token").value);
+  loadSetup();
+});
+
+loadSetup();
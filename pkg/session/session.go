@@ -0,0 +1,308 @@
+// Package session runs complete scan sessions without the terminal UI: the
+// pages in the document feeder are scanned, the PDF is generated, the hooks
+// are run and the document is queued for delivery
+package session
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"time"
+
+	"scanexpress/pkg/config"
+	"scanexpress/pkg/delivery"
+	"scanexpress/pkg/hooks"
+	"scanexpress/pkg/scanner"
+)
+
+// Options describe a scan session
+type Options struct {
+	Device     string
+	Network    scanner.Network
+	SaveFolder string // Folder the scan directory and the PDF are created in
+	Duplex     bool
+	MaxPages   int // Sheets to scan at most, 0 to scan until the feeder is empty
+	AutoRotate bool
+	Profile    config.Profile
+	Recipients []string // Email recipients, the document is not emailed when empty
+}
+
+// Steps of a session
+const (
+	StepScanning   = "scanning"
+	StepGenerating = "generating"
+	StepHooks      = "hooks"
+	StepDelivering = "delivering"
+)
+
+// Progress reports the step a session is at
+type Progress struct {
+	Step    string
+	Page    int    // Page being scanned
+	File    string // Image of the page, set once it has been scanned
+	Message string // Description of the step, e.g. "Scanning page 2"
+}
+
+// Result describes a finished session
+type Result struct {
+	OutputDir         string // Directory the pages were scanned to
+	PDF               string
+	Photos            []string // Photos split from the pages, when the profile asks for it
+	PageCount         int
+	Rotated           []scanner.OrientationResult // Pages turned upright
+	OrientationErrors []scanner.OrientationResult // Pages whose orientation couldn't be corrected
+	HookResults       []hooks.Result
+	Jobs              []delivery.Job    // Deliveries queued for the document
+	Deliveries        []delivery.Result // Outcome of the first attempt of each delivery
+}
+
+// PDFOptions returns the PDF settings of a profile. Encryption passwords
+// are not part of it, the signing certificate password comes from the
+// environment when set.
+func PDFOptions(profile config.Profile) scanner.PDFOptions {
+	return scanner.PDFOptions{
+		PDFA:        profile.Output.PDFA,
+		SplitPhotos: profile.Output.SplitPhotos,
+		Compression: scanner.CompressionOptions{
+			JPEG:        profile.Output.JPEG,
+			JPEGQuality: profile.Output.JPEGQuality,
+			Grayscale:   profile.Output.Grayscale,
+			Bilevel:     profile.Output.Bilevel,
+		},
+		Encryption: scanner.EncryptionOptions{
+			Enabled:     profile.Encryption.Enabled,
+			Permissions: profile.Encryption.Permissions,
+		},
+		Signing: scanner.SigningOptions{
+			Enabled:      profile.Signing.Enabled,
+			Certificate:  profile.Signing.Certificate,
+			Password:     os.Getenv(config.SigningPasswordEnv),
+			Name:         profile.Signing.Name,
+			Reason:       profile.Signing.Reason,
+			Location:     profile.Signing.Location,
+			TimestampURL: profile.Signing.TimestampURL,
+		},
+	}
+}
+
+// Hooks returns the hook settings of a profile
+func Hooks(profile config.Profile) hooks.Config {
+	return hooks.Config{
+		AfterPage: profile.Hooks.AfterPage,
+		AfterPDF:  profile.Hooks.AfterPDF,
+		Timeout:   time.Duration(profile.Hooks.Timeout) * time.Second,
+	}
+}
+
+// Check reports the settings of a profile that need someone at the keyboard
+func Check(profile config.Profile) error {
+	options := PDFOptions(profile)
+	if options.Encryption.Enabled {
+		return fmt.Errorf("profile %q encrypts documents, which needs a password to be entered", profile.Name)
+	}
+	if options.Signing.Enabled && options.Signing.Password == "" {
+		return fmt.Errorf("profile %q signs documents, set %s to the certificate password", profile.Name, config.SigningPasswordEnv)
+	}
+	return nil
+}
+
+// Page is a scanned page image
+type Page struct {
+	File      string
+	ColorMode scanner.ColorMode // Detected color mode, may be changed before Finish
+	Chosen    bool              // The color mode was changed from the detected one, which applies it whatever the compression
+}
+
+// Scanned holds the pages of a session before the PDF is generated
+type Scanned struct {
+	OutputDir         string // Directory the pages were scanned to
+	Pages             []Page
+	Rotated           []scanner.OrientationResult // Pages turned upright
+	OrientationErrors []scanner.OrientationResult // Pages whose orientation couldn't be corrected
+	HookResults       []hooks.Result
+}
+
+// reporter sends progress to the callback of a session, if any
+type reporter func(Progress)
+
+func (r reporter) report(p Progress, format string, args ...any) {
+	if r != nil {
+		p.Message = fmt.Sprintf(format, args...)
+		r(p)
+	}
+}
+
+// Run scans the pages in the document feeder and turns them into a
+// delivered document. progress, when not nil, is told about each step.
+// Cancelling the context stops the session between two pages.
+func Run(ctx context.Context, cm *config.ConfigManager, queue *delivery.Queue, opts Options, progress func(Progress)) (Result, error) {
+	scanned, err := Scan(ctx, opts, progress)
+	if err != nil {
+		return Result{OutputDir: scanned.OutputDir, HookResults: scanned.HookResults}, err
+	}
+	return Finish(ctx, cm, queue, opts, scanned, progress)
+}
+
+// Scan scans sheet after sheet until the feeder is empty, running the page
+// hooks. Each page is reported through progress once it is scanned.
+func Scan(ctx context.Context, opts Options, progress func(Progress)) (Scanned, error) {
+	r := reporter(progress)
+	if err := Check(opts.Profile); err != nil {
+		return Scanned{}, err
+	}
+
+	var scanned Scanned
+	dir, err := outputDir(opts.SaveFolder)
+	if err != nil {
+		return scanned, err
+	}
+	scanned.OutputDir = dir
+	hookConfig := Hooks(opts.Profile)
+	if scanner.IsNetworkDevice(opts.Device) {
+		defer opts.Network.EndBatch(opts.Device)
+	}
+
+	for pageNum := 1; opts.MaxPages == 0 || pageNum <= opts.MaxPages; pageNum++ {
+		if err := ctx.Err(); err != nil {
+			return scanned, err
+		}
+
+		page := len(scanned.Pages) + 1
+		r.report(Progress{Step: StepScanning, Page: page}, "Scanning page %d", page)
+		outputFile := filepath.Join(scanned.OutputDir, fmt.Sprintf("page_%03d.png", pageNum))
+		var scan scanner.PageScanResult
+		if scanner.IsNetworkDevice(opts.Device) {
+			scan = opts.Network.ScanPage(opts.Device, outputFile, opts.Duplex, pageNum)
+		} else {
+			scan = scanner.ScanPage(opts.Device, outputFile, opts.Duplex, pageNum)
+		}
+		if errors.Is(scan.Error, scanner.ErrFeederEmpty) && len(scanned.Pages) > 0 {
+			break
+		}
+		if !scan.Success {
+			return scanned, scan.Error
+		}
+
+		for _, file := range scan.FilePaths {
+			if opts.AutoRotate {
+				switch orientation := scanner.CorrectOrientation(file); {
+				case orientation.Error != nil:
+					scanned.OrientationErrors = append(scanned.OrientationErrors, orientation)
+				case orientation.Rotated():
+					scanned.Rotated = append(scanned.Rotated, orientation)
+				}
+			}
+			// Unclassified pages are classified again when the PDF is generated
+			mode, _ := scanner.ClassifyPage(file)
+			scanned.Pages = append(scanned.Pages, Page{File: file, ColorMode: mode})
+
+			page := len(scanned.Pages)
+			if len(hookConfig.AfterPage) > 0 {
+				scanned.HookResults = append(scanned.HookResults, hooks.Run(hookConfig, hookPayload(opts, scanned.OutputDir, hooks.EventPage, file, page, page))...)
+			}
+			r.report(Progress{Step: StepScanning, Page: page, File: file}, "Scanned page %d", page)
+		}
+	}
+	return scanned, nil
+}
+
+// Finish generates the PDF from the scanned pages, with the color mode of
+// each, runs the PDF hooks and queues the document for delivery
+func Finish(ctx context.Context, cm *config.ConfigManager, queue *delivery.Queue, opts Options, scanned Scanned, progress func(Progress)) (Result, error) {
+	r := reporter(progress)
+	result := Result{
+		OutputDir:         scanned.OutputDir,
+		Rotated:           scanned.Rotated,
+		OrientationErrors: scanned.OrientationErrors,
+		HookResults:       scanned.HookResults,
+	}
+	title := filepath.Base(scanned.OutputDir)
+
+	pdfOptions := PDFOptions(opts.Profile)
+	pdfOptions.ColorModes = make(map[string]scanner.ColorMode, len(scanned.Pages))
+	pdfOptions.ChosenModes = make(map[string]bool)
+	for _, page := range scanned.Pages {
+		if page.ColorMode != "" {
+			pdfOptions.ColorModes[page.File] = page.ColorMode
+		}
+		if page.Chosen {
+			pdfOptions.ChosenModes[page.File] = true
+		}
+	}
+
+	r.report(Progress{Step: StepGenerating}, "Creating the PDF from %d pages", len(scanned.Pages))
+	generated := scanner.GeneratePDF(scanned.OutputDir, pdfOptions)
+	if !generated.Success {
+		return result, generated.Error
+	}
+	result.PDF = generated.OutputPDF
+	result.Photos = generated.Photos
+	result.PageCount = len(generated.Pages)
+
+	if hookConfig := Hooks(opts.Profile); len(hookConfig.AfterPDF) > 0 {
+		r.report(Progress{Step: StepHooks}, "Running the PDF hooks")
+		result.HookResults = append(result.HookResults, hooks.Run(hookConfig, hookPayload(opts, scanned.OutputDir, hooks.EventPDF, result.PDF, 0, result.PageCount))...)
+	}
+
+	// Queue the deliveries, those failing now are retried later
+	targets := delivery.Targets(opts.Profile)
+	if email := delivery.NewEmail(opts.Profile); email != nil && len(opts.Recipients) > 0 {
+		email.To = opts.Recipients
+		targets = append(targets, email)
+	}
+	doc := delivery.Document{
+		Path:      result.PDF,
+		Title:     title,
+		Tags:      opts.Profile.Tags,
+		Profile:   opts.Profile.Name,
+		PageCount: result.PageCount,
+		Created:   time.Now(),
+	}
+	for _, target := range targets {
+		job, err := queue.Enqueue(target, doc, opts.Profile.Name)
+		if err != nil {
+			return result, err
+		}
+		result.Jobs = append(result.Jobs, job)
+	}
+	if len(result.Jobs) > 0 {
+		r.report(Progress{Step: StepDelivering}, "Delivering the document")
+		result.Deliveries = queue.Process(ctx, result.Jobs, func(job delivery.Job) (delivery.Target, error) {
+			return delivery.ResolveTarget(cm, job)
+		})
+	}
+	return result, nil
+}
+
+// hookPayload describes an event of the session's document to hooks
+func hookPayload(opts Options, outputDir, event, output string, page, pageCount int) hooks.Payload {
+	return hooks.Payload{
+		Event:     event,
+		Output:    output,
+		Page:      page,
+		PageCount: pageCount,
+		Title:     filepath.Base(outputDir),
+		Tags:      opts.Profile.Tags,
+		Profile:   opts.Profile.Name,
+	}
+}
+
+// outputDir creates the directory pages are scanned to. Sessions on other
+// scanners may start in the same second, so the name is made unique.
+func outputDir(saveFolder string) (string, error) {
+	if err := os.MkdirAll(saveFolder, 0755); err != nil {
+		return "", err
+	}
+	base := filepath.Join(saveFolder, "scan_"+time.Now().Format("20060102_150405"))
+	dir := base
+	for i := 2; ; i++ {
+		err := os.Mkdir(dir, 0755)
+		if !errors.Is(err, fs.ErrExist) {
+			return dir, err
+		}
+		dir = fmt.Sprintf("%s_%d", base, i)
+	}
+}
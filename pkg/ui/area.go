@@ -0,0 +1,69 @@
+package ui
+
+import (
+	"image"
+	"image/color"
+	"math"
+
+	"scanexpress/pkg/scanner"
+)
+
+// areaStep is how far a key press moves or resizes the scan area, in mm
+const areaStep = 5.0
+
+// minAreaSize is the smallest side of the scan area, in mm
+const minAreaSize = 10.0
+
+// areaColor outlines the scan area on the flatbed preview, in the color of
+// the selected list items
+var areaColor = color.RGBA{0xd7, 0x5f, 0xd7, 0xff}
+
+// adjustArea moves the edited scan area by dx, dy and resizes it by dw, dh,
+// in mm, keeping it on the glass, then draws it again
+func (m *Model) adjustArea(dx, dy, dw, dh float64) {
+	a := m.EditedArea
+	a.Width = math.Min(math.Max(a.Width+dw, minAreaSize), m.FlatbedSize.Width)
+	a.Height = math.Min(math.Max(a.Height+dh, minAreaSize), m.FlatbedSize.Height)
+	a.Left = math.Min(math.Max(a.Left+dx, 0), m.FlatbedSize.Width-a.Width)
+	a.Top = math.Min(math.Max(a.Top+dy, 0), m.FlatbedSize.Height-a.Height)
+	m.EditedArea = a
+	m.renderArea()
+}
+
+// renderArea draws the edited scan area on the flatbed preview
+func (m *Model) renderArea() {
+	m.AreaView = ""
+	if m.FlatbedImage == nil {
+		return
+	}
+	// The area can still be set from its size when the preview can't be drawn
+	m.AreaView, _ = renderImage(drawArea(m.FlatbedImage, m.EditedArea, m.FlatbedSize), m.Graphics)
+}
+
+// drawArea returns a copy of the preview of the glass with the area
+// outlined and what lies outside of it darkened
+func drawArea(preview image.Image, area, glass scanner.Area) image.Image {
+	bounds := preview.Bounds()
+	scaleX := float64(bounds.Dx()) / glass.Width
+	scaleY := float64(bounds.Dy()) / glass.Height
+	x0 := int(area.Left * scaleX)
+	y0 := int(area.Top * scaleY)
+	x1 := max(int(math.Ceil((area.Left+area.Width)*scaleX))-1, x0)
+	y1 := max(int(math.Ceil((area.Top+area.Height)*scaleY))-1, y0)
+
+	out := image.NewRGBA(image.Rect(0, 0, bounds.Dx(), bounds.Dy()))
+	for y := 0; y < bounds.Dy(); y++ {
+		for x := 0; x < bounds.Dx(); x++ {
+			c := color.RGBAModel.Convert(preview.At(bounds.Min.X+x, bounds.Min.Y+y)).(color.RGBA)
+			inside := x >= x0 && x <= x1 && y >= y0 && y <= y1
+			switch {
+			case inside && (x == x0 || x == x1 || y == y0 || y == y1):
+				c = areaColor
+			case !inside:
+				c.R, c.G, c.B = c.R/3, c.G/3, c.B/3
+			}
+			out.SetRGBA(x, y, c)
+		}
+	}
+	return out
+}
@@ -0,0 +1,103 @@
+package ui
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/charmbracelet/bubbles/filepicker"
+	"github.com/charmbracelet/bubbles/key"
+)
+
+// expandPath expands a leading ~ and the environment variables of a typed
+// path, e.g. ~/Scans or $HOME/Scans
+func expandPath(path string) string {
+	if path == "~" || strings.HasPrefix(path, "~/") {
+		if home, err := os.UserHomeDir(); err == nil {
+			path = home + path[1:]
+		}
+	}
+	return os.ExpandEnv(path)
+}
+
+// folderSuggestions returns the folders the typed path can be completed to,
+// written the way the path was typed so that ~ and variables are kept.
+// Hidden folders are only suggested once their dot is typed.
+func folderSuggestions(typed string) []string {
+	i := strings.LastIndex(typed, "/") + 1
+	dir, prefix := typed[:i], typed[i:]
+	path := expandPath(dir)
+	if path == "" {
+		path = "."
+	}
+	entries, err := os.ReadDir(path)
+	if err != nil {
+		return nil
+	}
+
+	var suggestions []string
+	for _, entry := range entries {
+		name := entry.Name()
+		if strings.HasPrefix(name, ".") && !strings.HasPrefix(prefix, ".") {
+			continue
+		}
+		if !strings.HasPrefix(strings.ToLower(name), strings.ToLower(prefix)) {
+			continue
+		}
+		// Links to folders are followed
+		if info, err := os.Stat(filepath.Join(path, name)); err != nil || !info.IsDir() {
+			continue
+		}
+		suggestions = append(suggestions, dir+name+"/")
+	}
+	return suggestions
+}
+
+// updateFolderSuggestions completes the save folder input from the folders
+// on disk
+func (m *Model) updateFolderSuggestions() {
+	m.FolderInput.SetSuggestions(folderSuggestions(m.FolderInput.Value()))
+}
+
+// nextRecentFolder puts the recent folder after the one in the input into
+// it, going round to the most recent one
+func (m *Model) nextRecentFolder() {
+	if len(m.RecentFolders) == 0 {
+		return
+	}
+	next := (slices.Index(m.RecentFolders, m.FolderInput.Value()) + 1) % len(m.RecentFolders)
+	m.FolderInput.SetValue(m.RecentFolders[next])
+	m.FolderInput.CursorEnd()
+	m.updateFolderSuggestions()
+}
+
+// browseFolder returns a folder picker opened on the typed folder, or on
+// the closest of its parents that exists
+func browseFolder(typed string) filepicker.Model {
+	dir, err := filepath.Abs(expandPath(typed))
+	if err != nil {
+		dir, _ = os.UserHomeDir()
+	}
+	for {
+		if info, err := os.Stat(dir); err == nil && info.IsDir() {
+			break
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			break
+		}
+		dir = parent
+	}
+
+	fp := filepicker.New()
+	fp.CurrentDirectory = dir
+	fp.FileAllowed = false
+	fp.ShowPermissions = false
+	fp.ShowSize = false
+	fp.AutoHeight = false
+	// Esc cancels browsing rather than going to the parent folder
+	fp.KeyMap.Back = key.NewBinding(key.WithKeys("h", "backspace", "left"), key.WithHelp("←/h", "parent folder"))
+	fp.KeyMap.Open = key.NewBinding(key.WithKeys("l", "right", "enter"), key.WithHelp("enter", "open"))
+	return fp
+}
@@ -0,0 +1,221 @@
+package ui
+
+import (
+	"github.com/charmbracelet/bubbles/key"
+)
+
+// keyMap holds the key bindings of the UI
+type keyMap struct {
+	Confirm       key.Binding
+	Back          key.Binding
+	BackFromInput key.Binding // Backspace edits the text inputs
+	Quit          key.Binding
+	Help          key.Binding
+
+	// Lists
+	Navigate key.Binding // Help only, the lists move their cursor themselves
+
+	// Save folder
+	Complete     key.Binding
+	Recent       key.Binding
+	Browse       key.Binding
+	ChooseFolder key.Binding
+
+	// Page count
+	MorePages  key.Binding
+	FewerPages key.Binding
+
+	// Duplex
+	Yes key.Binding
+	No  key.Binding
+
+	// Flatbed scan area
+	Preview    key.Binding
+	Feeder     key.Binding
+	MoveLeft   key.Binding
+	MoveRight  key.Binding
+	MoveUp     key.Binding
+	MoveDown   key.Binding
+	Narrower   key.Binding
+	Wider      key.Binding
+	Shorter    key.Binding
+	Taller     key.Binding
+	Move       key.Binding // Help only, for the four moves
+	Resize     key.Binding // Help only, for the four resizes
+	WholeGlass key.Binding
+
+	// Page review, recipients and passwords
+	ColorMode key.Binding
+	Toggle    key.Binding
+	NextField key.Binding
+	PrevField key.Binding
+
+	// Last screen, any other key leaves it (help only)
+	NextDocument key.Binding
+	AnyKey       key.Binding
+}
+
+// keys are the key bindings of the UI
+var keys = keyMap{
+	Confirm:       key.NewBinding(key.WithKeys("enter"), key.WithHelp("enter", "confirm")),
+	Back:          key.NewBinding(key.WithKeys("esc", "backspace"), key.WithHelp("esc", "back")),
+	BackFromInput: key.NewBinding(key.WithKeys("esc"), key.WithHelp("esc", "back")),
+	Quit:          key.NewBinding(key.WithKeys("ctrl+c"), key.WithHelp("ctrl+c", "quit")),
+	Help:          key.NewBinding(key.WithKeys("?"), key.WithHelp("?", "more keys")),
+
+	Navigate: key.NewBinding(key.WithKeys("up", "down", "k", "j"), key.WithHelp("↑/↓", "choose")),
+
+	Complete:     key.NewBinding(key.WithKeys("tab"), key.WithHelp("tab", "complete")),
+	Recent:       key.NewBinding(key.WithKeys("ctrl+r"), key.WithHelp("ctrl+r", "recent folder")),
+	Browse:       key.NewBinding(key.WithKeys("ctrl+o"), key.WithHelp("ctrl+o", "browse")),
+	ChooseFolder: key.NewBinding(key.WithKeys(" "), key.WithHelp("space", "use this folder")),
+
+	MorePages:  key.NewBinding(key.WithKeys("up", "left", "k", "p"), key.WithHelp("↑/k", "more")),
+	FewerPages: key.NewBinding(key.WithKeys("down", "right", "j", "n"), key.WithHelp("↓/j", "fewer")),
+
+	Yes: key.NewBinding(key.WithKeys("y", "Y"), key.WithHelp("y", "double-sided")),
+	No:  key.NewBinding(key.WithKeys("n", "N"), key.WithHelp("n", "single-sided")),
+
+	Preview:    key.NewBinding(key.WithKeys("p"), key.WithHelp("p", "preview the flatbed")),
+	Feeder:     key.NewBinding(key.WithKeys("f"), key.WithHelp("f", "use the feeder")),
+	MoveLeft:   key.NewBinding(key.WithKeys("left", "h")),
+	MoveRight:  key.NewBinding(key.WithKeys("right", "l")),
+	MoveUp:     key.NewBinding(key.WithKeys("up", "k")),
+	MoveDown:   key.NewBinding(key.WithKeys("down", "j")),
+	Narrower:   key.NewBinding(key.WithKeys("shift+left", "H")),
+	Wider:      key.NewBinding(key.WithKeys("shift+right", "L")),
+	Shorter:    key.NewBinding(key.WithKeys("shift+up", "K")),
+	Taller:     key.NewBinding(key.WithKeys("shift+down", "J")),
+	Move:       key.NewBinding(key.WithKeys("left", "right", "up", "down"), key.WithHelp("←↓↑→/hjkl", "move")),
+	Resize:     key.NewBinding(key.WithKeys("shift+left", "shift+right"), key.WithHelp("shift+←↓↑→/HJKL", "resize")),
+	WholeGlass: key.NewBinding(key.WithKeys("f"), key.WithHelp("f", "whole glass")),
+
+	ColorMode: key.NewBinding(key.WithKeys("c"), key.WithHelp("c", "color mode")),
+	Toggle:    key.NewBinding(key.WithKeys(" ", "x"), key.WithHelp("space", "select")),
+	NextField: key.NewBinding(key.WithKeys("tab", "down"), key.WithHelp("tab", "next field")),
+	PrevField: key.NewBinding(key.WithKeys("shift+tab", "up"), key.WithHelp("shift+tab", "previous field")),
+
+	NextDocument: key.NewBinding(key.WithKeys("n", "N"), key.WithHelp("n", "scan another document")),
+	AnyKey:       key.NewBinding(key.WithKeys("enter"), key.WithHelp("any other key", "exit")),
+}
+
+// described returns a copy of a binding with another description, e.g.
+// "scan" rather than "confirm"
+func described(b key.Binding, desc string) key.Binding {
+	b.SetHelp(b.Help().Key, desc)
+	return b
+}
+
+// helpColumnSize is how many bindings the full help shows per column
+const helpColumnSize = 3
+
+// stateHelp lists the key bindings of a screen for the help footer
+type stateHelp []key.Binding
+
+// ShortHelp returns the bindings on one line
+func (h stateHelp) ShortHelp() []key.Binding { return h }
+
+// FullHelp returns the bindings in columns
+func (h stateHelp) FullHelp() [][]key.Binding {
+	var columns [][]key.Binding
+	for i := 0; i < len(h); i += helpColumnSize {
+		columns = append(columns, h[i:min(i+helpColumnSize, len(h))])
+	}
+	return columns
+}
+
+// helpKeys returns the key bindings of the current state
+func (m Model) helpKeys() stateHelp {
+	var bindings []key.Binding
+	switch m.State {
+	case StateSelectingScanner:
+		bindings = []key.Binding{keys.Navigate, m.List.KeyMap.Filter, described(keys.Confirm, "select"), keys.Quit}
+
+	case StateEnteringSaveFolder:
+		recent := keys.Recent
+		recent.SetEnabled(len(m.RecentFolders) > 0)
+		return stateHelp{keys.Complete, recent, keys.Browse, keys.Confirm, keys.BackFromInput, keys.Quit}
+
+	case StateBrowsingFolders:
+		bindings = []key.Binding{
+			keys.Navigate,
+			m.FolderPicker.KeyMap.Open,
+			m.FolderPicker.KeyMap.Back,
+			keys.ChooseFolder,
+			described(keys.BackFromInput, "cancel"),
+			keys.Quit,
+		}
+
+	case StateCreatingFolder:
+		bindings = []key.Binding{described(keys.Yes, "create it"), described(keys.No, "edit the path"), keys.Quit}
+
+	case StateEnteringPageCount:
+		return stateHelp{keys.MorePages, keys.FewerPages, keys.Confirm, keys.BackFromInput, keys.Quit}
+
+	case StateSelectingDuplexMode:
+		yes := keys.Yes
+		yes.SetEnabled(m.Capabilities == nil || m.Capabilities.Duplex)
+		bindings = []key.Binding{yes, keys.No, keys.Confirm, keys.Back, keys.Quit}
+
+	case StateWaitingForPageScan:
+		preview := keys.Preview
+		preview.SetEnabled(m.Capabilities == nil || m.Capabilities.Flatbed)
+		feeder := keys.Feeder
+		feeder.SetEnabled(m.ScanArea != nil)
+		back := keys.Back
+		back.SetEnabled(len(m.Pages) == 0)
+		bindings = []key.Binding{described(keys.Confirm, "scan"), preview, feeder, back, keys.Quit}
+
+	case StatePreviewingFlatbed:
+		bindings = []key.Binding{described(keys.Back, "cancel"), keys.Quit}
+
+	case StateSelectingArea:
+		bindings = []key.Binding{keys.Move, keys.Resize, keys.WholeGlass, keys.Confirm, described(keys.Back, "cancel"), keys.Quit}
+
+	case StateWaitingForScanner:
+		bindings = []key.Binding{keys.Back, keys.Quit}
+
+	case StateReviewingPages:
+		bindings = []key.Binding{keys.Navigate, keys.ColorMode, described(keys.Confirm, "create the PDF"), keys.Quit}
+
+	case StateEnteringPassword:
+		return stateHelp{keys.NextField, keys.PrevField, keys.Confirm, keys.BackFromInput, keys.Quit}
+
+	case StateSelectingRecipients:
+		if m.EmailWarning != "" {
+			bindings = []key.Binding{described(keys.Confirm, "continue"), keys.Quit}
+		} else {
+			bindings = []key.Binding{keys.Navigate, keys.Toggle, described(keys.Confirm, "send"), keys.Quit}
+		}
+
+	case StateScanComplete:
+		bindings = []key.Binding{keys.NextDocument, keys.AnyKey}
+
+	default:
+		// Screens waiting on the scanner or on background work
+		bindings = []key.Binding{keys.Quit}
+	}
+
+	// The full help only differs when the bindings fill more than a column.
+	// Text inputs, which return earlier, take ? as any other character.
+	if len(bindings) > helpColumnSize {
+		bindings = append(bindings, keys.Help)
+	}
+	return bindings
+}
+
+// hasFullHelp tells whether the help footer can show more than one line
+func (m Model) hasFullHelp() bool {
+	return !m.typing() && len(m.helpKeys().FullHelp()) > 1
+}
+
+// typing tells whether the keys go to a text input
+func (m Model) typing() bool {
+	switch m.State {
+	case StateEnteringSaveFolder, StateEnteringPageCount, StateEnteringPassword:
+		return true
+	case StateSelectingScanner:
+		return m.List.SettingFilter()
+	}
+	return false
+}
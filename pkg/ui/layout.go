@@ -0,0 +1,170 @@
+package ui
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/charmbracelet/lipgloss"
+)
+
+// stateTitles name the screens in the status bar
+var stateTitles = map[int]string{
+	StateListingScanners:     "Looking for scanners",
+	StateSelectingScanner:    "Select a scanner",
+	StateEnteringSaveFolder:  "Save folder",
+	StateBrowsingFolders:     "Browse folders",
+	StateCreatingFolder:      "New folder",
+	StateEnteringPageCount:   "Page count",
+	StateSelectingDuplexMode: "Sides",
+	StatePreviewingFlatbed:   "Flatbed preview",
+	StateSelectingArea:       "Scan area",
+	StateWaitingForScanner:   "Waiting for the scanner",
+	StateReviewingPages:      "Review",
+	StateEnteringPassword:    "Passwords",
+	StateGeneratingPDF:       "Creating the PDF",
+	StateSelectingRecipients: "Recipients",
+	StateRunningHooks:        "Running hooks",
+	StateScanComplete:        "Done",
+}
+
+// listMargin is the room kept below the lists for the lines of their screens
+const listMargin = 2
+
+// layout puts the screen of the state between the header and the status bar
+// and help footer, which stay at the bottom of the terminal once its size is
+// known
+func (m Model) layout(main string) string {
+	footer := m.statusView() + "\n" + m.helpView()
+	if m.Height > 0 {
+		main = fitHeight(main, m.mainHeight())
+	}
+	return m.headerView() + "\n\n" + main + "\n" + footer
+}
+
+// mainHeight returns the number of lines left for the screen of the state
+func (m Model) mainHeight() int {
+	// The header and the blank line after it, and the status bar
+	chrome := 3 + lipgloss.Height(m.helpView())
+	return max(m.Height-chrome, 1)
+}
+
+// resize fits the lists in the screen of the state
+func (m *Model) resize() {
+	if m.Width == 0 || m.Height == 0 {
+		return
+	}
+	height := max(m.mainHeight()-listMargin, 1)
+	m.List.SetSize(m.Width, height)
+	m.RecipientList.SetSize(m.Width, height)
+	// The picker is shown below the folder it browses
+	m.FolderPicker.SetHeight(max(m.mainHeight()-3, 1))
+
+	// The page previews are shown next to the page list
+	pages := m.Width
+	if m.Graphics != GraphicsOff {
+		pages = max(m.Width-previewColumns-2, 20)
+	}
+	m.PageList.SetSize(pages, height)
+}
+
+// helpView shows the keys of the screen, all of them in columns when the
+// full help is on
+func (m Model) helpView() string {
+	if m.Help.ShowAll && m.hasFullHelp() {
+		return m.Help.FullHelpView(m.helpKeys().FullHelp())
+	}
+	return m.Help.ShortHelpView(m.helpKeys().ShortHelp())
+}
+
+// fitHeight pads or cuts the text to the number of lines
+func fitHeight(text string, lines int) string {
+	split := strings.Split(text, "\n")
+	if len(split) > lines {
+		split = split[:lines]
+	}
+	for len(split) < lines {
+		split = append(split, "")
+	}
+	return strings.Join(split, "\n")
+}
+
+// headerView names the scanner, the profile and the folder of the session
+func (m Model) headerView() string {
+	scannerName := m.SelectedTitle
+	if scannerName == "" {
+		scannerName = "No scanner selected"
+	}
+	profile := m.ProfileName
+	if profile == "" {
+		profile = "default"
+	}
+	folder := m.SaveFolder
+	if folder == "" {
+		folder = m.FolderInput.Value()
+	}
+
+	info := strings.Join([]string{scannerName, "profile " + profile, folder}, " · ")
+	header := headerStyle.Render("ScanExpress") + "  " + headerInfoStyle.Render(info)
+	if m.Width > 0 {
+		header = lipgloss.NewStyle().MaxWidth(m.Width).Render(header)
+	}
+	return header
+}
+
+// statusView shows the screen on the left of the status bar and the
+// progress of the session on its right
+func (m Model) statusView() string {
+	title := stateTitles[m.State]
+	switch m.State {
+	case StateWaitingForPageScan:
+		title = fmt.Sprintf("Page %d of %d", m.CurrentPage, m.PageCount)
+	case StateScanningPage:
+		title = fmt.Sprintf("Scanning page %d of %d", m.CurrentPage, m.PageCount)
+	case StateScanComplete:
+		if m.ScanError != nil {
+			title = "Failed"
+		}
+	}
+
+	var progress []string
+	if m.Discovering {
+		progress = append(progress, "looking for network scanners")
+	}
+	if m.State >= StateWaitingForPageScan {
+		progress = append(progress, fmt.Sprintf("%d pages scanned", len(m.Pages)))
+		if m.IsDuplex {
+			progress = append(progress, "double-sided")
+		}
+		if m.ScanArea != nil {
+			progress = append(progress, "flatbed area")
+		}
+	}
+	// Documents after the first of the session are numbered
+	document := len(m.Documents)
+	if m.State != StateScanComplete {
+		document++
+	}
+	if document > 1 {
+		progress = append(progress, fmt.Sprintf("document %d", document))
+	}
+	sending := 0
+	for _, r := range m.DeliveryResults {
+		if r.JobID == "" || !r.Success() {
+			sending++
+		}
+	}
+	if sending > 0 {
+		progress = append(progress, fmt.Sprintf("%d deliveries pending", sending))
+	}
+
+	left := " " + title
+	right := strings.Join(progress, " · ") + " "
+	if m.Width == 0 {
+		return statusStyle.Render(left + "  " + right)
+	}
+	gap := m.Width - lipgloss.Width(left) - lipgloss.Width(right)
+	if gap < 1 {
+		right, gap = "", max(m.Width-lipgloss.Width(left), 0)
+	}
+	return statusStyle.MaxWidth(m.Width).Render(left + strings.Repeat(" ", gap) + right)
+}
@@ -2,10 +2,15 @@ package ui
 
 import (
 	"fmt"
+	"image"
 	"io"
+	"net/mail"
 	"os"
+	"path/filepath"
 	"strings"
 
+	"github.com/charmbracelet/bubbles/filepicker"
+	"github.com/charmbracelet/bubbles/help"
 	"github.com/charmbracelet/bubbles/list"
 	"github.com/charmbracelet/bubbles/spinner"
 	"github.com/charmbracelet/bubbles/textinput"
@@ -13,7 +18,10 @@ import (
 	"github.com/charmbracelet/lipgloss"
 
 	"scanexpress/pkg/config"
+	"scanexpress/pkg/delivery"
+	"scanexpress/pkg/hooks"
 	"scanexpress/pkg/scanner"
+	"scanexpress/pkg/session"
 )
 
 // UI states
@@ -21,14 +29,31 @@ const (
 	StateListingScanners = iota
 	StateSelectingScanner
 	StateEnteringSaveFolder
+	StateBrowsingFolders
+	StateCreatingFolder
 	StateEnteringPageCount
 	StateSelectingDuplexMode
 	StateWaitingForPageScan
+	StatePreviewingFlatbed
+	StateSelectingArea
 	StateScanningPage
+	StateWaitingForScanner
+	StateReviewingPages
+	StateEnteringPassword
 	StateGeneratingPDF
+	StateSelectingRecipients
+	StateRunningHooks
 	StateScanComplete
 )
 
+// Password inputs, in the order they are shown
+const (
+	passwordUser = iota
+	passwordRepeat
+	passwordOwner
+	passwordCertificate
+)
+
 // Model represents the UI state
 type Model struct {
 	Devices        []string
@@ -36,27 +61,98 @@ type Model struct {
 	SelectedDevice string
 	SelectedTitle  string
 	SaveFolder     string
+	Network        scanner.Network       // saned servers and eSCL scanners
+	Capabilities   *scanner.Capabilities // Settings the selected network scanner supports, nil when unknown
+	Discovering    bool                  // Network scanners are still being looked for
+	ResumeState    int                   // State to go back to once a missing scanner returns
+	ScannerCheck   int                   // Latest scanner check, earlier results are stale
+	Graphics       string                // Terminal graphics page previews are drawn with
+	Previews       map[string]string     // Rendered page previews by image file
 	PageCount      int
 	IsDuplex       bool
+	AutoRotate     bool
+	ProfileName    string
+	Tags           []string
+	PDFOptions     scanner.PDFOptions
+	Hooks          hooks.Config
+	Deliveries     []delivery.Target // Where finished documents are sent
+	Queue          *delivery.Queue   // Deliveries waiting to be sent
+	Email          *delivery.Email   // Email settings, nil when email is disabled
+	RecipientList  list.Model
+	EmailWarning   string // Why the document can't be emailed
 	State          int
+	History        []int // Steps Esc goes back to, the latest last
+	Width          int   // Terminal size, 0 until it is known
+	Height         int
+	Help           help.Model
 	List           list.Model
+	PageList       list.Model
 	Spinner        spinner.Model
 	FolderInput    textinput.Model
 	PageCountInput textinput.Model
 
+	// Save folder choice
+	FolderPicker  filepicker.Model // Folders browsed from the save folder input
+	RecentFolders []string         // Save folders used before, the latest first
+	NewFolder     string           // Folder to create once confirmed
+	FolderError   string           // Why the typed folder can't be used
+
+	// Password entry for encrypted and signed documents
+	PasswordInputs []textinput.Model
+	PasswordFocus  int
+	PasswordError  string
+
 	// Scanning state
-	CurrentPage   int
-	ScannedFiles  []string
-	ScanOutputDir string
-	ScanError     error
+	CurrentPage       int
+	ScannedFiles      []string
+	ScanOutputDir     string
+	ScanError         error
+	RotatedPages      []scanner.OrientationResult
+	OrientationErrors []scanner.OrientationResult // Pages whose orientation couldn't be corrected
+	Pages             []PageItem
+
+	// Flatbed scan area, chosen on a low resolution preview of the glass
+	ScanArea     *scanner.Area // Area pages are scanned from, nil for the document feeder
+	EditedArea   scanner.Area  // Area being chosen
+	FlatbedSize  scanner.Area  // Size of the glass
+	FlatbedImage image.Image   // Preview of the glass, downscaled for the terminal
+	AreaView     string        // Rendered preview with the edited area drawn on it
+	AreaError    string        // Why the flatbed couldn't be previewed
 
 	// PDF state
-	GeneratedPDF string
+	GeneratedPDF     string
+	GeneratedPDFSize int64
+	PageEncodings    []scanner.PageCompression
+	Photos           []string // Photos split from the pages
+
+	// Results of the hooks run so far
+	HookResults []hooks.Result
+	PageHooks   int                 // Page hooks still running
+	PendingPDF  *scanner.PDFOptions // PDF waiting for the page hooks to finish
+
+	// Deliveries of the document, sent in the background through the queue
+	DeliveryJobs    []delivery.Job
+	DeliveryResults map[string]delivery.Result // Latest result of each job
+	DeliveryError   error                      // Why the document could not be queued
+
+	// Documents produced in this session, the latest last
+	Documents []SessionDocument
 
 	// Configuration manager
 	ConfigManager *config.ConfigManager
 }
 
+// SessionDocument is a document produced in this session
+type SessionDocument struct {
+	Dir    string // Scan directory of its pages
+	PDF    string // Generated PDF, empty when scanning or generating it failed
+	Size   int64
+	Pages  int
+	Photos int
+	Jobs   []delivery.Job // Deliveries of the PDF
+	Error  error          // Why scanning or generating the PDF failed
+}
+
 // ScanItem represents an item in the scanner list
 type ScanItem struct {
 	Device string
@@ -66,6 +162,67 @@ type ScanItem struct {
 // FilterValue defines how scan items are filtered
 func (i ScanItem) FilterValue() string { return i.Device }
 
+// Label returns the text shown for the scanner, marked with its transport
+func (i ScanItem) Label() string {
+	return fmt.Sprintf("%s [%s]", i.Title, scanner.Transport(i.Device))
+}
+
+// PageItem represents a scanned page in the page review list
+type PageItem struct {
+	File      string
+	ColorMode scanner.ColorMode
+	Detected  scanner.ColorMode // Color mode found by the classifier
+}
+
+// Chosen reports whether the color mode was changed from the detected one,
+// which applies it to the page whatever the compression options
+func (i PageItem) Chosen() bool {
+	return i.ColorMode != i.Detected
+}
+
+// FilterValue defines how page items are filtered
+func (i PageItem) FilterValue() string { return i.File }
+
+// Label returns the text shown for the page in the review list
+func (i PageItem) Label() string {
+	base := filepath.Base(i.File)
+	mode := i.ColorMode
+	if mode == "" {
+		mode = "unknown"
+	}
+	if i.Chosen() {
+		mode += ", chosen"
+	}
+	return fmt.Sprintf("%s  [%s]", strings.TrimSuffix(base, filepath.Ext(base)), mode)
+}
+
+// ContactItem represents an address book entry in the recipient list
+type ContactItem struct {
+	Name     string
+	Email    string
+	Selected bool
+}
+
+// FilterValue defines how contact items are filtered
+func (i ContactItem) FilterValue() string { return i.Name + " " + i.Email }
+
+// Label returns the text shown for the contact in the recipient list
+func (i ContactItem) Label() string {
+	check := "[ ]"
+	if i.Selected {
+		check = "[x]"
+	}
+	if i.Name == "" {
+		return fmt.Sprintf("%s %s", check, i.Email)
+	}
+	return fmt.Sprintf("%s %s <%s>", check, i.Name, i.Email)
+}
+
+// Address returns the contact as an email address
+func (i ContactItem) Address() string {
+	return (&mail.Address{Name: i.Name, Address: i.Email}).String()
+}
+
 // ItemStyle for list items
 var ItemStyle = lipgloss.NewStyle().PaddingLeft(4)
 
@@ -86,12 +243,19 @@ func (d ItemDelegate) Update(_ tea.Msg, _ *list.Model) tea.Cmd { return nil }
 
 // Render list item
 func (d ItemDelegate) Render(w io.Writer, m list.Model, index int, listItem list.Item) {
-	i, ok := listItem.(ScanItem)
-	if !ok {
+	var title string
+	switch i := listItem.(type) {
+	case ScanItem:
+		title = i.Label()
+	case PageItem:
+		title = i.Label()
+	case ContactItem:
+		title = i.Label()
+	default:
 		return
 	}
 
-	str := fmt.Sprintf("%d. %s", index+1, i.Title)
+	str := fmt.Sprintf("%d. %s", index+1, title)
 
 	fn := ItemStyle.Render
 	if index == m.Index() {
@@ -109,9 +273,47 @@ type ScannersListedMsg struct {
 	Error    error
 }
 
+// ScannerDiscoveredMsg is sent for each scanner found on the network
+type ScannerDiscoveredMsg struct {
+	Scanner scanner.Scanner
+	found   <-chan scanner.Scanner
+}
+
+// DiscoveryFinishedMsg is sent when no more network scanners are looked for
+type DiscoveryFinishedMsg struct{}
+
+// ScannerCheckedMsg is sent when the selected scanner has been looked for
+type ScannerCheckedMsg struct {
+	ID        int
+	Device    string // Current identifier of the scanner, when available
+	Available bool
+}
+
+// PreviewRenderedMsg is sent when the preview of a page has been rendered
+type PreviewRenderedMsg struct {
+	File    string
+	Preview string // Empty when the image can't be read
+}
+
+// FlatbedPreviewedMsg is sent when the flatbed glass has been previewed
+type FlatbedPreviewedMsg struct {
+	Image   image.Image  // Preview downscaled for the terminal, nil when not drawn
+	Glass   scanner.Area // Size of the glass
+	Content scanner.Area // Area of what lies on the glass
+	Error   error
+}
+
+// CapabilitiesMsg is sent when the capabilities of a network scanner are known
+type CapabilitiesMsg struct {
+	Device       string
+	Capabilities scanner.Capabilities
+}
+
 // PageScannedMsg is sent when a page has been scanned
 type PageScannedMsg struct {
-	Result scanner.PageScanResult
+	Result       scanner.PageScanResult
+	Orientations []scanner.OrientationResult
+	ColorModes   []scanner.ColorMode // Color mode of each scanned file, in the order of Result.FilePaths
 }
 
 // ScanCompleteMsg is sent when scanning is complete
@@ -127,6 +329,21 @@ type PDFGeneratedMsg struct {
 	Result scanner.PDFGenerationResult
 }
 
+// DeliveredMsg is sent when queued deliveries have been attempted
+type DeliveredMsg struct {
+	Results []delivery.Result
+}
+
+// RetryDeliveriesMsg is sent when the document's failed deliveries are due
+// to be retried
+type RetryDeliveriesMsg struct{}
+
+// HooksFinishedMsg is sent when the hooks of an event have run
+type HooksFinishedMsg struct {
+	Event   string
+	Results []hooks.Result
+}
+
 // NewModel creates a new UI model
 func NewModel(cm *config.ConfigManager) Model {
 	// Setup spinner
@@ -140,6 +357,7 @@ func NewModel(cm *config.ConfigManager) Model {
 	ti.Focus()
 	ti.CharLimit = 256
 	ti.Width = 50
+	ti.ShowSuggestions = true
 
 	// Setup text input for page count
 	pci := textinput.New()
@@ -148,23 +366,75 @@ func NewModel(cm *config.ConfigManager) Model {
 	pci.CharLimit = 3
 	pci.Width = 5
 
+	// Setup masked text inputs for the user password, its confirmation, the
+	// owner password and the signing certificate password
+	passwordInputs := make([]textinput.Model, 4)
+	for i, placeholder := range []string{
+		"Password needed to open the document",
+		"Repeat the password",
+		"Owner password (leave empty for a random one)",
+		"Password of the signing certificate",
+	} {
+		pi := textinput.New()
+		pi.Placeholder = placeholder
+		pi.EchoMode = textinput.EchoPassword
+		pi.EchoCharacter = '•'
+		pi.CharLimit = 127
+		pi.Width = 50
+		passwordInputs[i] = pi
+	}
+
 	// Initialize model
 	m := Model{
-		List:           list.New(make([]list.Item, 0), ItemDelegate{}, 0, 0),
+		Help:           help.New(),
+		List:           list.New(make([]list.Item, 0), ItemDelegate{}, 60, 20),
+		PageList:       list.New(make([]list.Item, 0), ItemDelegate{}, 60, 20),
+		RecipientList:  list.New(make([]list.Item, 0), ItemDelegate{}, 60, 15),
 		State:          StateListingScanners,
 		Spinner:        s,
 		FolderInput:    ti,
 		PageCountInput: pci,
+		PasswordInputs: passwordInputs,
 		ConfigManager:  cm,
+		Queue:          delivery.DefaultQueue(),
 		PageCount:      1,     // Default to 1 page
 		IsDuplex:       false, // Default to single-sided
 		CurrentPage:    0,
 		ScannedFiles:   []string{},
 	}
 	m.List.Title = "Select a Scanner"
+	m.PageList.Title = "Review Pages"
+	m.PageList.SetFilteringEnabled(false)
+	m.RecipientList.Title = "Send To"
+	m.RecipientList.SetFilteringEnabled(false)
+	// The keys are shown in the help footer of the screen, and Esc goes
+	// back rather than quitting
+	for _, l := range []*list.Model{&m.List, &m.PageList, &m.RecipientList} {
+		l.SetShowHelp(false)
+		l.DisableQuitKeybindings()
+	}
 
 	// If we have a saved config, use it for the folder
 	config := cm.GetConfig()
+	m.AutoRotate = config.AutoRotate
+	m.Discovering = config.Discovery
+	m.Graphics = DetectGraphics(config.Preview)
+	m.Previews = make(map[string]string)
+	sane := cm.GetSANEConfig()
+	m.Network = scanner.Network{
+		Hosts:    sane.Hosts,
+		Username: sane.Username,
+		Password: sane.Password,
+		ESCL:     cm.GetESCLScanners(),
+	}
+
+	// Use the default profile, or the top-level settings if it doesn't exist
+	profile, err := cm.GetProfile(config.Profile)
+	if err != nil {
+		profile, _ = cm.GetProfile("")
+	}
+	m.ApplyProfile(profile)
+
 	if config.SaveFolder != "" {
 		m.FolderInput.SetValue(config.SaveFolder)
 	} else {
@@ -174,6 +444,8 @@ func NewModel(cm *config.ConfigManager) Model {
 			m.FolderInput.SetValue(homeDir)
 		}
 	}
+	m.RecentFolders = config.RecentFolders
+	m.updateFolderSuggestions()
 
 	// Set default page count to "1"
 	m.PageCountInput.SetValue("1")
@@ -181,6 +453,47 @@ func NewModel(cm *config.ConfigManager) Model {
 	return m
 }
 
+// ApplyProfile sets the document settings of the model from a profile
+func (m *Model) ApplyProfile(profile config.Profile) {
+	m.ProfileName = profile.Name
+	m.Tags = profile.Tags
+	m.Hooks = session.Hooks(profile)
+
+	m.Deliveries = delivery.Targets(profile)
+	m.Email = delivery.NewEmail(profile)
+	var contacts []list.Item
+	if m.Email != nil {
+		for _, c := range profile.Email.AddressBook {
+			contacts = append(contacts, ContactItem{Name: c.Name, Email: c.Email})
+		}
+	}
+	m.RecipientList.SetItems(contacts)
+	m.PDFOptions = session.PDFOptions(profile)
+}
+
+// activePasswordInputs returns the password inputs needed for the document:
+// the encryption passwords and, unless it comes from the environment, the
+// signing certificate password
+func (m Model) activePasswordInputs() []int {
+	var inputs []int
+	if m.PDFOptions.Encryption.Enabled {
+		inputs = append(inputs, passwordUser, passwordRepeat, passwordOwner)
+	}
+	if m.PDFOptions.Signing.Enabled && m.PDFOptions.Signing.Password == "" {
+		inputs = append(inputs, passwordCertificate)
+	}
+	return inputs
+}
+
+// ToPageListItems converts scanned pages to list items
+func ToPageListItems(pages []PageItem) []list.Item {
+	items := make([]list.Item, len(pages))
+	for i, p := range pages {
+		items[i] = p
+	}
+	return items
+}
+
 // ToListItems converts scanners to list items
 func ToListItems(scanners []scanner.Scanner) []list.Item {
 	items := make([]list.Item, len(scanners))
@@ -195,20 +508,31 @@ func ToListItems(scanners []scanner.Scanner) []list.Item {
 
 // Init is called when the model is initialized
 func (m Model) Init() tea.Cmd {
+	// Deliveries left over from earlier sessions are retried in the background
+	retry := ProcessQueueCmd(m.Queue, m.ConfigManager, nil)
+
 	switch m.State {
 	case StateListingScanners:
 		return tea.Batch(
 			m.Spinner.Tick,
-			ListScannersCmd(),
+			ListScannersCmd(m.Network),
+			DiscoverScannersCmd(m.Network, m.Discovering),
+			retry,
 		)
 
 	case StateEnteringPageCount:
-		return textinput.Blink
+		// The saved scanner may be unplugged or asleep
+		return tea.Batch(
+			textinput.Blink,
+			CapabilitiesCmd(m.Network, m.SelectedDevice),
+			CheckScannerCmd(m.Network, m.SelectedDevice, m.SelectedTitle, m.ScannerCheck, 0),
+			retry,
+		)
 
 	case StateScanningPage:
-		return m.Spinner.Tick
+		return tea.Batch(m.Spinner.Tick, retry)
 
 	default:
-		return nil
+		return retry
 	}
 }
@@ -0,0 +1,275 @@
+package ui
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"os"
+	"strings"
+
+	"github.com/charmbracelet/lipgloss"
+
+	"scanexpress/pkg/scanner"
+)
+
+// Terminal graphics page previews are drawn with
+const (
+	GraphicsAuto   = "auto"   // Detected from the environment
+	GraphicsKitty  = "kitty"  // Kitty graphics protocol
+	GraphicsSixel  = "sixel"  // DEC Sixel
+	GraphicsITerm  = "iterm"  // iTerm2 inline images
+	GraphicsBlocks = "blocks" // Unicode half blocks, for any color terminal
+	GraphicsOff    = "off"
+)
+
+// previewRows is the height of page previews in terminal rows
+const previewRows = 16
+
+// previewColumns is the width of the widest page previews in terminal
+// columns, those of landscape pages
+const previewColumns = previewRows * cellHeight / cellWidth
+
+// Pixel size assumed for a terminal cell. Terminals don't tell it without
+// being queried, and most fonts come close.
+const (
+	cellWidth  = 10
+	cellHeight = 20
+)
+
+// kittyDelete removes the images drawn with the Kitty protocol
+const kittyDelete = "\x1b_Ga=d,q=2\x1b\\"
+
+// kittyChunkSize is the largest payload of a Kitty graphics escape
+const kittyChunkSize = 4096
+
+// DetectGraphics returns the graphics protocol to draw previews with: the
+// one of the setting, or the one the terminal is known to support when it is
+// "auto". Terminals without one get half blocks.
+func DetectGraphics(setting string) string {
+	switch setting {
+	case GraphicsKitty, GraphicsSixel, GraphicsITerm, GraphicsBlocks, GraphicsOff:
+		return setting
+	}
+
+	term := os.Getenv("TERM")
+	program := os.Getenv("TERM_PROGRAM")
+	switch {
+	case term == "" || term == "dumb":
+		return GraphicsOff
+	case os.Getenv("TMUX") != "" || strings.HasPrefix(term, "screen"):
+		// Multiplexers don't pass the escapes through by default
+		return GraphicsBlocks
+	case os.Getenv("KITTY_WINDOW_ID") != "" || term == "xterm-kitty" || term == "xterm-ghostty" || program == "ghostty":
+		return GraphicsKitty
+	case program == "iTerm.app" || program == "WezTerm" || os.Getenv("LC_TERMINAL") == "iTerm2":
+		return GraphicsITerm
+	case strings.HasPrefix(term, "foot") || strings.HasPrefix(term, "mlterm") || strings.Contains(term, "sixel") || program == "mintty":
+		return GraphicsSixel
+	}
+	return GraphicsBlocks
+}
+
+// RenderPreview draws a downscaled page image for the terminal, previewRows
+// rows high, with the graphics protocol
+func RenderPreview(file, graphics string) (string, error) {
+	if graphics == GraphicsOff {
+		return "", nil
+	}
+	img, err := scanner.Thumbnail(file, previewSize(graphics))
+	if err != nil {
+		return "", err
+	}
+	return renderImage(img, graphics)
+}
+
+// previewSize returns the largest side in pixels of the images drawn with
+// the graphics protocol, for them to be previewRows rows high
+func previewSize(graphics string) int {
+	if graphics == GraphicsBlocks {
+		return previewRows * 2
+	}
+	return previewRows * cellHeight
+}
+
+// renderImage draws an image of previewSize pixels for the terminal
+func renderImage(img image.Image, graphics string) (string, error) {
+	switch graphics {
+	case GraphicsBlocks:
+		return halfBlocks(img), nil
+
+	case GraphicsKitty, GraphicsSixel, GraphicsITerm:
+		cols := (img.Bounds().Dx() + cellWidth - 1) / cellWidth
+		rows := (img.Bounds().Dy() + cellHeight - 1) / cellHeight
+
+		var sequence string
+		var err error
+		switch graphics {
+		case GraphicsKitty:
+			sequence, err = kittyImage(img, cols, rows)
+		case GraphicsITerm:
+			sequence, err = itermImage(img, cols, rows)
+		default:
+			sequence = sixelImage(img)
+		}
+		if err != nil {
+			return "", err
+		}
+		return placeImage(sequence, cols, rows), nil
+	}
+	return "", nil
+}
+
+// placeImage lays out an image escape sequence as rows lines of cols cells.
+// The image is drawn from the first line with the cursor put back where it
+// was, and each line then moves the cursor over the image instead of
+// writing spaces, so that text drawn around it doesn't erase it.
+func placeImage(sequence string, cols, rows int) string {
+	skip := fmt.Sprintf("\x1b[%dC", cols)
+	lines := make([]string, rows)
+	for i := range lines {
+		lines[i] = skip
+	}
+	lines[0] = "\x1b7" + sequence + "\x1b8" + skip
+	return strings.Join(lines, "\n")
+}
+
+// kittyImage encodes an image for the Kitty graphics protocol, scaled to
+// cols×rows cells. The images drawn before are removed.
+func kittyImage(img image.Image, cols, rows int) (string, error) {
+	var encoded bytes.Buffer
+	if err := png.Encode(&encoded, img); err != nil {
+		return "", err
+	}
+	data := base64.StdEncoding.EncodeToString(encoded.Bytes())
+
+	var b strings.Builder
+	b.WriteString(kittyDelete)
+	for first := true; first || data != ""; first = false {
+		chunk := data[:min(len(data), kittyChunkSize)]
+		data = data[len(chunk):]
+		more := 0
+		if data != "" {
+			more = 1
+		}
+		if first {
+			// The cursor stays put, C=1, and replies are turned off, q=2
+			fmt.Fprintf(&b, "\x1b_Ga=T,f=100,c=%d,r=%d,C=1,q=2,m=%d;%s\x1b\\", cols, rows, more, chunk)
+		} else {
+			fmt.Fprintf(&b, "\x1b_Gm=%d;%s\x1b\\", more, chunk)
+		}
+	}
+	return b.String(), nil
+}
+
+// itermImage encodes an image as an iTerm2 inline image of cols×rows cells
+func itermImage(img image.Image, cols, rows int) (string, error) {
+	var encoded bytes.Buffer
+	if err := png.Encode(&encoded, img); err != nil {
+		return "", err
+	}
+	return fmt.Sprintf(
+		"\x1b]1337;File=inline=1;size=%d;width=%d;height=%d;preserveAspectRatio=1:%s\a",
+		encoded.Len(), cols, rows, base64.StdEncoding.EncodeToString(encoded.Bytes()),
+	), nil
+}
+
+// sixelLevels is the number of levels of each primary in the sixel palette,
+// 6×6×6 colors fit in the 256 registers most terminals have
+const sixelLevels = 6
+
+// sixelImage encodes an image as DEC Sixel graphics with a fixed color cube
+// palette, which keeps scanned pages readable without quantizing each one
+func sixelImage(img image.Image) string {
+	bounds := img.Bounds()
+	width, height := bounds.Dx(), bounds.Dy()
+	level := func(v uint32) int { return int(v>>8) * (sixelLevels - 1) / 255 }
+	pixels := make([]int, width*height)
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			r, g, b, _ := img.At(bounds.Min.X+x, bounds.Min.Y+y).RGBA()
+			pixels[y*width+x] = (level(r)*sixelLevels+level(g))*sixelLevels + level(b)
+		}
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "\x1bP0;1q\"1;1;%d;%d", width, height)
+	const colors = sixelLevels * sixelLevels * sixelLevels
+	for i := 0; i < colors; i++ {
+		percent := func(l int) int { return l * 100 / (sixelLevels - 1) }
+		fmt.Fprintf(&b, "#%d;2;%d;%d;%d", i, percent(i/(sixelLevels*sixelLevels)), percent(i/sixelLevels%sixelLevels), percent(i%sixelLevels))
+	}
+
+	// Each band of 6 rows is drawn once per color it uses
+	row := make([]byte, width)
+	for top := 0; top < height; top += 6 {
+		used := make(map[int]bool)
+		for y := top; y < min(top+6, height); y++ {
+			for x := 0; x < width; x++ {
+				used[pixels[y*width+x]] = true
+			}
+		}
+		for c := 0; c < colors; c++ {
+			if !used[c] {
+				continue
+			}
+			for x := 0; x < width; x++ {
+				var bits byte
+				for dy := 0; dy < 6 && top+dy < height; dy++ {
+					if pixels[(top+dy)*width+x] == c {
+						bits |= 1 << dy
+					}
+				}
+				row[x] = '?' + bits
+			}
+			fmt.Fprintf(&b, "#%d", c)
+			writeSixelRun(&b, row)
+			b.WriteByte('$')
+		}
+		b.WriteByte('-')
+	}
+	b.WriteString("\x1b\\")
+	return b.String()
+}
+
+// writeSixelRun writes a row of sixels, with repeats for runs
+func writeSixelRun(b *strings.Builder, row []byte) {
+	for i := 0; i < len(row); {
+		j := i
+		for j < len(row) && row[j] == row[i] {
+			j++
+		}
+		if n := j - i; n > 3 {
+			fmt.Fprintf(b, "!%d%c", n, row[i])
+		} else {
+			b.Write(row[i:j])
+		}
+		i = j
+	}
+}
+
+// halfBlocks draws an image with upper half blocks, two pixels per cell:
+// the top one in the foreground color and the bottom one in the background
+func halfBlocks(img image.Image) string {
+	bounds := img.Bounds()
+	hex := func(c color.Color) lipgloss.Color {
+		r, g, b, _ := c.RGBA()
+		return lipgloss.Color(fmt.Sprintf("#%02x%02x%02x", r>>8, g>>8, b>>8))
+	}
+
+	var lines []string
+	for y := bounds.Min.Y; y < bounds.Max.Y; y += 2 {
+		var line strings.Builder
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			style := lipgloss.NewStyle().Foreground(hex(img.At(x, y)))
+			if y+1 < bounds.Max.Y {
+				style = style.Background(hex(img.At(x, y+1)))
+			}
+			line.WriteString(style.Render("▀"))
+		}
+		lines = append(lines, line.String())
+	}
+	return strings.Join(lines, "\n")
+}
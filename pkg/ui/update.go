@@ -1,23 +1,41 @@
 package ui
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"os"
 	"path/filepath"
 	"scanexpress/pkg/config"
+	"scanexpress/pkg/delivery"
+	"scanexpress/pkg/hooks"
 	"scanexpress/pkg/scanner"
+	"slices"
 	"strconv"
+	"strings"
 	"time"
 
+	"github.com/charmbracelet/bubbles/key"
 	"github.com/charmbracelet/bubbles/spinner"
 	"github.com/charmbracelet/bubbles/textinput"
 	tea "github.com/charmbracelet/bubbletea"
 )
 
-// ListScannersCmd returns a command that lists available scanners
-func ListScannersCmd() tea.Cmd {
+// ListScannersCmd returns a command that lists available scanners, the
+// local ones and those shared by the saned servers of the network settings.
+// Errors are only reported when no scanner is found at all.
+func ListScannersCmd(network scanner.Network) tea.Cmd {
 	return func() tea.Msg {
 		result := scanner.ListScanners()
+		if len(network.Hosts) > 0 {
+			remote := network.ListScanners()
+			result.Scanners = append(result.Scanners, remote.Scanners...)
+			if len(result.Scanners) > 0 {
+				result.Error = nil
+			} else {
+				result.Error = errors.Join(result.Error, remote.Error)
+			}
+		}
 		return ScannersListedMsg{
 			Scanners: result.Scanners,
 			Error:    result.Error,
@@ -25,33 +43,291 @@ func ListScannersCmd() tea.Cmd {
 	}
 }
 
+// discoveryTimeout is how long the network is browsed for scanners
+const discoveryTimeout = 5 * time.Second
+
+// DiscoverScannersCmd returns a command that browses the network for
+// scanners with mDNS, streaming each one found as a ScannerDiscoveredMsg
+func DiscoverScannersCmd(network scanner.Network, enabled bool) tea.Cmd {
+	if !enabled {
+		return nil
+	}
+
+	found := make(chan scanner.Scanner)
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), discoveryTimeout)
+		defer cancel()
+		// Discovery is best effort, the configured scanners are listed anyway
+		network.Discover(ctx, found)
+	}()
+	return waitForScannerCmd(found)
+}
+
+// waitForScannerCmd returns a command that waits for the next discovered
+// scanner
+func waitForScannerCmd(found <-chan scanner.Scanner) tea.Cmd {
+	return func() tea.Msg {
+		s, ok := <-found
+		if !ok {
+			return DiscoveryFinishedMsg{}
+		}
+		return ScannerDiscoveredMsg{Scanner: s, found: found}
+	}
+}
+
+// CapabilitiesCmd returns a command that reads the settings a network
+// scanner supports. Nothing is read for local scanners.
+func CapabilitiesCmd(network scanner.Network, device string) tea.Cmd {
+	if !scanner.IsNetworkDevice(device) {
+		return nil
+	}
+	return func() tea.Msg {
+		caps, err := network.Capabilities(device)
+		if err != nil {
+			// Scanning reports the error if the scanner stays unreachable
+			return nil
+		}
+		return CapabilitiesMsg{Device: device, Capabilities: caps}
+	}
+}
+
+// scannerPollInterval is how often a missing scanner is looked for
+const scannerPollInterval = 2 * time.Second
+
+// CheckScannerCmd returns a command that looks for the scanner after the
+// delay, reporting whether it is connected
+func CheckScannerCmd(network scanner.Network, device, title string, id int, delay time.Duration) tea.Cmd {
+	check := func() tea.Msg {
+		var found string
+		var ok bool
+		if scanner.IsNetworkDevice(device) {
+			found, ok = network.FindDevice(device)
+		} else {
+			found, ok = scanner.FindDevice(device, title)
+		}
+		return ScannerCheckedMsg{ID: id, Device: found, Available: ok}
+	}
+	if delay == 0 {
+		return check
+	}
+	return tea.Tick(delay, func(time.Time) tea.Msg { return check() })
+}
+
 // ScanPageCmd returns a command that scans a single page
-func ScanPageCmd(device string, outputFile string, isDuplex bool, pageNum int) tea.Cmd {
+// When autoRotate is set, the scanned images are turned upright before being reported
+// Each scanned image is then classified as color, grayscale or black and white
+// Network devices are scanned through saned or eSCL, the others with scanimage
+// When area is set, the page is scanned from that area of the flatbed
+func ScanPageCmd(network scanner.Network, device string, outputFile string, isDuplex bool, pageNum int, autoRotate bool, area *scanner.Area) tea.Cmd {
 	return func() tea.Msg {
-		result := scanner.ScanPage(device, outputFile, isDuplex, pageNum)
+		var result scanner.PageScanResult
+		if area != nil {
+			result = network.ScanFlatbed(device, outputFile, pageNum, *area)
+		} else if scanner.IsNetworkDevice(device) {
+			result = network.ScanPage(device, outputFile, isDuplex, pageNum)
+		} else {
+			result = scanner.ScanPage(device, outputFile, isDuplex, pageNum)
+		}
+
+		var (
+			orientations []scanner.OrientationResult
+			colorModes   []scanner.ColorMode
+		)
+		if result.Success {
+			for _, file := range result.FilePaths {
+				if autoRotate {
+					orientations = append(orientations, scanner.CorrectOrientation(file))
+				}
+
+				// Unclassified pages are classified again when the PDF is generated
+				mode, _ := scanner.ClassifyPage(file)
+				colorModes = append(colorModes, mode)
+			}
+		}
+
 		return PageScannedMsg{
-			Result: result,
+			Result:       result,
+			Orientations: orientations,
+			ColorModes:   colorModes,
+		}
+	}
+}
+
+// EndBatchCmd returns a command that ends the batch of pages read from the
+// document feeder of a network scanner
+func EndBatchCmd(network scanner.Network, device string) tea.Cmd {
+	return func() tea.Msg {
+		network.EndBatch(device)
+		return nil
+	}
+}
+
+// PreviewCmd returns a command that renders the preview of a page image
+func PreviewCmd(file, graphics string) tea.Cmd {
+	return func() tea.Msg {
+		// Pages that can't be read are shown without a preview
+		preview, _ := RenderPreview(file, graphics)
+		return PreviewRenderedMsg{File: file, Preview: preview}
+	}
+}
+
+// PreviewFlatbedCmd returns a command that scans the whole flatbed at a low
+// resolution and downscales the preview for the terminal
+func PreviewFlatbedCmd(network scanner.Network, device, outputFile, graphics string) tea.Cmd {
+	return func() tea.Msg {
+		glass, content, err := network.PreviewFlatbed(device, outputFile)
+		if err != nil {
+			return FlatbedPreviewedMsg{Error: err}
 		}
+		msg := FlatbedPreviewedMsg{Glass: glass, Content: content}
+		if graphics != GraphicsOff {
+			// The area can still be chosen from its size without the image
+			msg.Image, _ = scanner.Thumbnail(outputFile, previewSize(graphics))
+		}
+		return msg
 	}
 }
 
 // GeneratePDFCmd returns a command that generates a PDF from scanned images
-func GeneratePDFCmd(imageDir string) tea.Cmd {
+func GeneratePDFCmd(imageDir string, options scanner.PDFOptions) tea.Cmd {
 	return func() tea.Msg {
-		result := scanner.GeneratePDF(imageDir)
+		result := scanner.GeneratePDF(imageDir, options)
 		return PDFGeneratedMsg{
 			Result: result,
 		}
 	}
 }
 
-// Update handles state changes for the UI model
+// RunHooksCmd returns a command that runs the hooks of an event
+func RunHooksCmd(config hooks.Config, payload hooks.Payload) tea.Cmd {
+	return func() tea.Msg {
+		return HooksFinishedMsg{
+			Event:   payload.Event,
+			Results: hooks.Run(config, payload),
+		}
+	}
+}
+
+// ProcessQueueCmd returns a command that attempts queued deliveries: the
+// given jobs, or all due jobs when jobs is nil
+func ProcessQueueCmd(queue *delivery.Queue, cm *config.ConfigManager, jobs []delivery.Job) tea.Cmd {
+	return func() tea.Msg {
+		resolve := func(job delivery.Job) (delivery.Target, error) {
+			return delivery.ResolveTarget(cm, job)
+		}
+		return DeliveredMsg{
+			Results: queue.Process(context.Background(), jobs, resolve),
+		}
+	}
+}
+
+// Update handles state changes for the UI model, then lays the lists out
+// for the screen of the new state
 func (m Model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
+	model, cmd := m.update(msg)
+	next := model.(Model)
+	next.resize()
+	return next, cmd
+}
+
+// update handles a message in the current state
+func (m Model) update(msg tea.Msg) (tea.Model, tea.Cmd) {
+	// Page hooks and deliveries run in the background while the session goes
+	// on, so their results are collected in any state
+	switch msg := msg.(type) {
+	case tea.WindowSizeMsg:
+		m.Width, m.Height = msg.Width, msg.Height
+		m.Help.Width = msg.Width
+		return m, nil
+
+	case tea.KeyMsg:
+		// Ctrl+C quits from any screen, Esc goes back a step instead
+		if key.Matches(msg, keys.Quit) {
+			return m, tea.Quit
+		}
+		if key.Matches(msg, keys.Help) && m.hasFullHelp() {
+			m.Help.ShowAll = !m.Help.ShowAll
+			return m, nil
+		}
+
+	case HooksFinishedMsg:
+		m.HookResults = append(m.HookResults, msg.Results...)
+		if msg.Event == hooks.EventPage {
+			m.PageHooks = max(m.PageHooks-1, 0)
+			// The pages are removed once the PDF is generated, so it waits
+			// for the hooks using them
+			if m.PageHooks == 0 && m.PendingPDF != nil {
+				options := *m.PendingPDF
+				m.PendingPDF = nil
+				return m, GeneratePDFCmd(m.ScanOutputDir, options)
+			}
+		}
+		if m.State == StateRunningHooks && msg.Event == hooks.EventPDF {
+			return m.deliverDocument()
+		}
+		return m, nil
+
+	case DeliveredMsg:
+		for _, result := range msg.Results {
+			if _, ok := m.DeliveryResults[result.JobID]; ok {
+				m.DeliveryResults[result.JobID] = result
+			}
+		}
+		return m, m.scheduleDeliveryRetry()
+
+	case ScannerDiscoveredMsg:
+		m.addScanners(msg.Scanner)
+		return m, waitForScannerCmd(msg.found)
+
+	case DiscoveryFinishedMsg:
+		m.Discovering = false
+		if m.State == StateSelectingScanner && len(m.Devices) == 0 {
+			fmt.Printf("Error: No scanners found. Please connect a scanner and try again.\n")
+			return m, tea.Quit
+		}
+		return m, nil
+
+	case ScannerCheckedMsg:
+		if msg.ID != m.ScannerCheck {
+			return m, nil
+		}
+		return m.scannerChecked(msg)
+
+	case PreviewRenderedMsg:
+		m.Previews[msg.File] = msg.Preview
+		return m, nil
+
+	case CapabilitiesMsg:
+		if msg.Device == m.SelectedDevice {
+			m.Capabilities = &msg.Capabilities
+			if !msg.Capabilities.Duplex {
+				m.IsDuplex = false
+			}
+		}
+		return m, nil
+
+	case RetryDeliveriesMsg:
+		jobs := m.pendingDeliveryJobs()
+		now := time.Now()
+		due := make([]delivery.Job, 0, len(jobs))
+		for _, job := range jobs {
+			if job.Due(now) {
+				due = append(due, job)
+			}
+		}
+		if len(due) == 0 {
+			return m, m.scheduleDeliveryRetry()
+		}
+		return m, ProcessQueueCmd(m.Queue, m.ConfigManager, due)
+	}
+
 	switch m.State {
 	case StateListingScanners:
 		switch msg := msg.(type) {
 		case ScannersListedMsg:
-			if msg.Error != nil || len(msg.Scanners) == 0 {
+			// Scanners discovered on the network meanwhile stay listed, and
+			// more may still be found
+			if (msg.Error != nil || len(msg.Scanners) == 0) && len(m.Devices) == 0 && !m.Discovering {
 				fmt.Printf("Error: No scanners found. Please connect a scanner and try again.\n")
 				if msg.Error != nil {
 					fmt.Printf("Details: %v\n", msg.Error)
@@ -59,16 +335,16 @@ func (m Model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 				return m, tea.Quit
 			}
 
-			// Store the scanner list
-			m.Devices = make([]string, len(msg.Scanners))
-			m.Titles = make([]string, len(msg.Scanners))
-			for i, s := range msg.Scanners {
-				m.Devices[i] = s.Device
-				m.Titles[i] = s.Title
+			// Local and configured scanners come first
+			discovered := m.List.Items()
+			m.Devices, m.Titles = nil, nil
+			m.List.SetItems(nil)
+			m.addScanners(msg.Scanners...)
+			for _, item := range discovered {
+				if s, ok := item.(ScanItem); ok {
+					m.addScanners(scanner.Scanner{Device: s.Device, Title: s.Title})
+				}
 			}
-
-			// Set the list items
-			m.List.SetItems(ToListItems(msg.Scanners))
 			m.State = StateSelectingScanner
 			return m, nil
 
@@ -81,15 +357,16 @@ func (m Model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 	case StateSelectingScanner:
 		switch msg := msg.(type) {
 		case tea.KeyMsg:
-			if msg.Type == tea.KeyEnter {
+			if key.Matches(msg, keys.Confirm) {
 				if m.List.SelectedItem() != nil {
 					selected, ok := m.List.SelectedItem().(ScanItem)
 					if ok {
 						m.SelectedDevice = selected.Device
 						m.SelectedTitle = selected.Title
+						m.Capabilities = nil
 						// Move to save folder input state
-						m.State = StateEnteringSaveFolder
-						return m, textinput.Blink
+						m.advance(StateEnteringSaveFolder)
+						return m, tea.Batch(textinput.Blink, CapabilitiesCmd(m.Network, m.SelectedDevice))
 					}
 				}
 			}
@@ -102,48 +379,99 @@ func (m Model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 	case StateEnteringSaveFolder:
 		switch msg := msg.(type) {
 		case tea.KeyMsg:
-			switch msg.Type {
-			case tea.KeyEnter:
-				m.SaveFolder = m.FolderInput.Value()
-
-				// Validate folder path
-				if _, err := os.Stat(m.SaveFolder); os.IsNotExist(err) {
-					// Create the directory if it doesn't exist
-					err := os.MkdirAll(m.SaveFolder, 0755)
-					if err != nil {
-						fmt.Printf("Error creating directory: %v\n", err)
-						return m, tea.Quit
-					}
+			switch {
+			case key.Matches(msg, keys.Confirm):
+				if strings.TrimSpace(m.FolderInput.Value()) == "" {
+					m.FolderError = "Enter the folder to save scans to"
+					return m, nil
 				}
-
-				// Save to config
-				err := m.ConfigManager.SaveConfig(config.Config{
-					ScannerDevice: m.SelectedDevice,
-					ScannerTitle:  m.SelectedTitle,
-					SaveFolder:    m.SaveFolder,
-				})
+				folder, err := filepath.Abs(expandPath(m.FolderInput.Value()))
 				if err != nil {
-					fmt.Printf("Error saving config: %v\n", err)
+					m.FolderError = err.Error()
+					return m, nil
 				}
 
-				// Move to page count input
-				m.State = StateEnteringPageCount
-				return m, textinput.Blink
+				// Ask before creating a folder, the path may be mistyped
+				info, err := os.Stat(folder)
+				switch {
+				case os.IsNotExist(err):
+					m.NewFolder = folder
+					m.State = StateCreatingFolder
+					return m, nil
+				case err != nil:
+					m.FolderError = err.Error()
+					return m, nil
+				case !info.IsDir():
+					m.FolderError = fmt.Sprintf("%s is not a folder", folder)
+					return m, nil
+				}
+				return m, m.useSaveFolder(folder)
 
-			case tea.KeyCtrlC, tea.KeyEsc:
-				return m, tea.Quit
+			case key.Matches(msg, keys.Recent):
+				m.FolderError = ""
+				m.nextRecentFolder()
+				return m, nil
+
+			case key.Matches(msg, keys.Browse):
+				m.FolderError = ""
+				m.FolderPicker = browseFolder(m.FolderInput.Value())
+				m.State = StateBrowsingFolders
+				return m, m.FolderPicker.Init()
+
+			case key.Matches(msg, keys.BackFromInput):
+				m.FolderError = ""
+				return m, m.back()
 			}
 
+			m.FolderError = ""
 			var cmd tea.Cmd
 			m.FolderInput, cmd = m.FolderInput.Update(msg)
+			m.updateFolderSuggestions()
 			return m, cmd
 		}
 
+	case StateBrowsingFolders:
+		if msg, ok := msg.(tea.KeyMsg); ok {
+			switch {
+			case key.Matches(msg, keys.ChooseFolder):
+				m.FolderInput.SetValue(m.FolderPicker.CurrentDirectory)
+				m.FolderInput.CursorEnd()
+				m.updateFolderSuggestions()
+				m.State = StateEnteringSaveFolder
+				return m, textinput.Blink
+
+			case key.Matches(msg, keys.BackFromInput):
+				m.State = StateEnteringSaveFolder
+				return m, textinput.Blink
+			}
+		}
+
+		var cmd tea.Cmd
+		m.FolderPicker, cmd = m.FolderPicker.Update(msg)
+		return m, cmd
+
+	case StateCreatingFolder:
+		if msg, ok := msg.(tea.KeyMsg); ok {
+			switch {
+			case key.Matches(msg, keys.Yes):
+				m.State = StateEnteringSaveFolder
+				if err := os.MkdirAll(m.NewFolder, 0755); err != nil {
+					m.FolderError = fmt.Sprintf("Error creating the folder: %v", err)
+					return m, textinput.Blink
+				}
+				return m, m.useSaveFolder(m.NewFolder)
+
+			case key.Matches(msg, keys.No), key.Matches(msg, keys.BackFromInput):
+				m.State = StateEnteringSaveFolder
+				return m, textinput.Blink
+			}
+		}
+
 	case StateEnteringPageCount:
 		switch msg := msg.(type) {
 		case tea.KeyMsg:
-			switch msg.Type {
-			case tea.KeyEnter:
+			switch {
+			case key.Matches(msg, keys.Confirm):
 				// Convert input to integer
 				pageCount, err := strconv.Atoi(m.PageCountInput.Value())
 				if err != nil || pageCount < 1 {
@@ -153,13 +481,13 @@ func (m Model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 				m.PageCount = pageCount
 
 				// Move to duplex selection
-				m.State = StateSelectingDuplexMode
+				m.advance(StateSelectingDuplexMode)
 				return m, nil
 
-			case tea.KeyCtrlC, tea.KeyEsc:
-				return m, tea.Quit
+			case key.Matches(msg, keys.BackFromInput):
+				return m, m.back()
 
-			case tea.KeyUp, tea.KeyLeft:
+			case key.Matches(msg, keys.MorePages):
 				// Increase page count
 				currentValue := m.PageCountInput.Value()
 				pageCount, err := strconv.Atoi(currentValue)
@@ -169,30 +497,7 @@ func (m Model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 				m.PageCountInput.SetValue(strconv.Itoa(pageCount + 1))
 				return m, nil
 
-			case tea.KeyDown, tea.KeyRight:
-				// Decrease page count
-				currentValue := m.PageCountInput.Value()
-				pageCount, err := strconv.Atoi(currentValue)
-				if err != nil || pageCount <= 1 {
-					pageCount = 2
-				}
-				m.PageCountInput.SetValue(strconv.Itoa(pageCount - 1))
-				return m, nil
-			}
-
-			// Handle additional keyboard shortcuts
-			switch msg.String() {
-			case "k", "p":
-				// Increase page count
-				currentValue := m.PageCountInput.Value()
-				pageCount, err := strconv.Atoi(currentValue)
-				if err != nil || pageCount < 1 {
-					pageCount = 0
-				}
-				m.PageCountInput.SetValue(strconv.Itoa(pageCount + 1))
-				return m, nil
-
-			case "j", "n":
+			case key.Matches(msg, keys.FewerPages):
 				// Decrease page count
 				currentValue := m.PageCountInput.Value()
 				pageCount, err := strconv.Atoi(currentValue)
@@ -211,62 +516,137 @@ func (m Model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 	case StateSelectingDuplexMode:
 		switch msg := msg.(type) {
 		case tea.KeyMsg:
-			switch msg.String() {
-			case "y", "Y":
-				m.IsDuplex = true
+			switch {
+			case key.Matches(msg, keys.Yes):
+				m.IsDuplex = m.Capabilities == nil || m.Capabilities.Duplex
 				return m, nil
 
-			case "n", "N":
+			case key.Matches(msg, keys.No):
 				m.IsDuplex = false
 				return m, nil
 
-			case "enter":
-				// Create timestamp for scan directory
-				timestamp := time.Now().Format("20060102_150405")
-				m.ScanOutputDir = filepath.Join(m.SaveFolder, "scan_"+timestamp)
-
-				// Create scan directory
-				err := os.MkdirAll(m.ScanOutputDir, 0755)
-				if err != nil {
+			case key.Matches(msg, keys.Confirm):
+				if err := m.startDocument(); err != nil {
 					fmt.Printf("Error creating directory: %v\n", err)
 					return m, tea.Quit
 				}
 
-				// Initialize scanning state
-				m.CurrentPage = 1
-				m.ScannedFiles = []string{}
-
 				// Move to waiting for first page
-				m.State = StateWaitingForPageScan
+				m.advance(StateWaitingForPageScan)
 				return m, nil
 
-			case "ctrl+c", "esc":
-				return m, tea.Quit
+			case key.Matches(msg, keys.Back):
+				return m, m.back()
 			}
 		}
 
 	case StateWaitingForPageScan:
 		switch msg := msg.(type) {
 		case tea.KeyMsg:
-			switch msg.Type {
-			case tea.KeyEnter:
+			switch {
+			case key.Matches(msg, keys.Confirm):
 				// Move to scanning state
 				m.State = StateScanningPage
+				m.ScanError = nil
 
-				// Create output file path
-				outputFile := filepath.Join(m.ScanOutputDir, fmt.Sprintf("page_%03d.png", m.CurrentPage))
+				// Make sure the scanner is still there, the scan starts once
+				// it has been found
+				check := m.checkScanner(0)
+				return m, tea.Batch(m.Spinner.Tick, check)
 
-				// Start scan
-				return m, tea.Batch(
-					m.Spinner.Tick,
-					ScanPageCmd(m.SelectedDevice, outputFile, m.IsDuplex, m.CurrentPage),
-				)
+			case key.Matches(msg, keys.Back):
+				// The session can be set up again until a page is scanned
+				if len(m.Pages) > 0 {
+					return m, nil
+				}
+				os.RemoveAll(m.ScanOutputDir)
+				return m, m.back()
 
-			case tea.KeyCtrlC, tea.KeyEsc:
-				return m, tea.Quit
+			case key.Matches(msg, keys.Preview):
+				if m.Capabilities != nil && !m.Capabilities.Flatbed {
+					return m, nil
+				}
+				m.State = StatePreviewingFlatbed
+				m.AreaError = ""
+				outputFile := filepath.Join(m.ScanOutputDir, "preview.png")
+				return m, tea.Batch(m.Spinner.Tick, PreviewFlatbedCmd(m.Network, m.SelectedDevice, outputFile, m.Graphics))
+
+			case key.Matches(msg, keys.Feeder):
+				m.ScanArea = nil
+				return m, nil
+			}
+		}
+
+	case StatePreviewingFlatbed:
+		switch msg := msg.(type) {
+		case spinner.TickMsg:
+			var cmd tea.Cmd
+			m.Spinner, cmd = m.Spinner.Update(msg)
+			return m, cmd
+
+		case FlatbedPreviewedMsg:
+			if msg.Error != nil {
+				m.AreaError = msg.Error.Error()
+				m.State = StateWaitingForPageScan
+				return m, m.previewCmd(m.previewFile())
+			}
+			m.FlatbedImage = msg.Image
+			m.FlatbedSize = msg.Glass
+			m.EditedArea = msg.Content
+			if m.ScanArea != nil {
+				m.EditedArea = *m.ScanArea
+			}
+			m.adjustArea(0, 0, 0, 0)
+			m.State = StateSelectingArea
+			return m, nil
+
+		case tea.KeyMsg:
+			// The preview is left to finish and ignored
+			if key.Matches(msg, keys.Back) {
+				m.State = StateWaitingForPageScan
+				return m, m.previewCmd(m.previewFile())
 			}
 		}
 
+	case StateSelectingArea:
+		switch msg := msg.(type) {
+		case tea.KeyMsg:
+			switch {
+			case key.Matches(msg, keys.MoveLeft):
+				m.adjustArea(-areaStep, 0, 0, 0)
+			case key.Matches(msg, keys.MoveRight):
+				m.adjustArea(areaStep, 0, 0, 0)
+			case key.Matches(msg, keys.MoveUp):
+				m.adjustArea(0, -areaStep, 0, 0)
+			case key.Matches(msg, keys.MoveDown):
+				m.adjustArea(0, areaStep, 0, 0)
+			case key.Matches(msg, keys.Narrower):
+				m.adjustArea(0, 0, -areaStep, 0)
+			case key.Matches(msg, keys.Wider):
+				m.adjustArea(0, 0, areaStep, 0)
+			case key.Matches(msg, keys.Shorter):
+				m.adjustArea(0, 0, 0, -areaStep)
+			case key.Matches(msg, keys.Taller):
+				m.adjustArea(0, 0, 0, areaStep)
+
+			case key.Matches(msg, keys.WholeGlass):
+				m.EditedArea = m.FlatbedSize
+				m.renderArea()
+
+			case key.Matches(msg, keys.Confirm):
+				area := m.EditedArea
+				m.ScanArea = &area
+				m.State = StateWaitingForPageScan
+				return m, m.previewCmd(m.previewFile())
+
+			case key.Matches(msg, keys.Back):
+				// The area chosen before, if any, is kept
+				m.State = StateWaitingForPageScan
+				return m, m.previewCmd(m.previewFile())
+			}
+			return m, nil
+		}
+
 	case StateScanningPage:
 		switch msg := msg.(type) {
 		case spinner.TickMsg:
@@ -279,31 +659,176 @@ func (m Model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 				// Add to scanned files
 				m.ScannedFiles = append(m.ScannedFiles, msg.Result.FilePaths...)
 
+				// Remember which pages were turned upright, and those that
+				// couldn't be
+				for _, orientation := range msg.Orientations {
+					switch {
+					case orientation.Error != nil:
+						m.OrientationErrors = append(m.OrientationErrors, orientation)
+					case orientation.Rotated():
+						m.RotatedPages = append(m.RotatedPages, orientation)
+					}
+				}
+
+				var cmds []tea.Cmd
+				for i, file := range msg.Result.FilePaths {
+					page := PageItem{File: file}
+					if i < len(msg.ColorModes) {
+						page.ColorMode = msg.ColorModes[i]
+						page.Detected = page.ColorMode
+					}
+					m.Pages = append(m.Pages, page)
+
+					if len(m.Hooks.AfterPage) > 0 {
+						m.PageHooks++
+						cmds = append(cmds, RunHooksCmd(m.Hooks, m.hookPayload(hooks.EventPage, file, len(m.Pages), len(m.Pages))))
+					}
+				}
+
 				// Check if we've scanned all pages
 				if m.CurrentPage >= m.PageCount {
-					// Move to PDF generation
-					m.State = StateGeneratingPDF
-					return m, tea.Batch(
-						m.Spinner.Tick,
-						GeneratePDFCmd(m.ScanOutputDir),
-					)
+					// Move to page review
+					m.PageList.SetItems(ToPageListItems(m.Pages))
+					m.State = StateReviewingPages
+					cmds = append(cmds, m.previewCmd(m.previewFile()))
+					if scanner.IsNetworkDevice(m.SelectedDevice) {
+						cmds = append(cmds, EndBatchCmd(m.Network, m.SelectedDevice))
+					}
+					return m, tea.Batch(cmds...)
 				}
 
-				// Move to next page
+				// Move to next page, showing the last one scanned
 				m.CurrentPage++
 				m.State = StateWaitingForPageScan
-				return m, nil
+				cmds = append(cmds, m.previewCmd(m.previewFile()))
+				return m, tea.Batch(cmds...)
 			} else {
-				// Scan failed
+				// Scan failed, which ends the session unless the scanner
+				// went away meanwhile
 				m.ScanError = msg.Result.Error
-				m.State = StateScanComplete
+				check := m.checkScanner(0)
+				return m, check
+			}
+		}
+
+	case StateWaitingForScanner:
+		switch msg := msg.(type) {
+		case spinner.TickMsg:
+			var cmd tea.Cmd
+			m.Spinner, cmd = m.Spinner.Update(msg)
+			return m, cmd
+
+		case tea.KeyMsg:
+			// Stop looking for the scanner and go back to the screen it
+			// was missing on, or to the page that was about to be scanned
+			if key.Matches(msg, keys.Back) {
+				m.ScannerCheck++
+				m.ScanError = nil
+				m.State = m.ResumeState
+				if m.State == StateScanningPage {
+					m.State = StateWaitingForPageScan
+				}
+				return m, m.previewCmd(m.previewFile())
+			}
+		}
+
+	case StateReviewingPages:
+		switch msg := msg.(type) {
+		case tea.KeyMsg:
+			switch {
+			case key.Matches(msg, keys.Confirm):
+				// Use the reviewed color modes for compression
+				m.PDFOptions.ColorModes = make(map[string]scanner.ColorMode, len(m.Pages))
+				m.PDFOptions.ChosenModes = make(map[string]bool)
+				for _, page := range m.Pages {
+					if page.ColorMode != "" {
+						m.PDFOptions.ColorModes[page.File] = page.ColorMode
+					}
+					if page.Chosen() {
+						m.PDFOptions.ChosenModes[page.File] = true
+					}
+				}
+
+				// Ask for the passwords of encrypted and signed documents first
+				if inputs := m.activePasswordInputs(); len(inputs) > 0 {
+					m.advance(StateEnteringPassword)
+					m.PasswordError = ""
+					return m, m.focusPasswordInput(inputs[0])
+				}
+
+				return m, m.generatePDF(m.PDFOptions)
+
+			case key.Matches(msg, keys.ColorMode):
+				// Cycle the color mode of the selected page
+				index := m.PageList.Index()
+				if index >= 0 && index < len(m.Pages) {
+					m.Pages[index].ColorMode = m.Pages[index].ColorMode.Next()
+					cmd := m.PageList.SetItem(index, m.Pages[index])
+					return m, cmd
+				}
 				return m, nil
 			}
+		}
+
+		var cmd tea.Cmd
+		m.PageList, cmd = m.PageList.Update(msg)
+		return m, tea.Batch(cmd, m.previewCmd(m.previewFile()))
 
+	case StateEnteringPassword:
+		switch msg := msg.(type) {
 		case tea.KeyMsg:
-			if msg.Type == tea.KeyCtrlC || msg.Type == tea.KeyEsc {
-				return m, tea.Quit
+			inputs := m.activePasswordInputs()
+			position := 0
+			for i, input := range inputs {
+				if input == m.PasswordFocus {
+					position = i
+				}
 			}
+
+			switch {
+			case key.Matches(msg, keys.NextField):
+				return m, m.focusPasswordInput(inputs[(position+1)%len(inputs)])
+
+			case key.Matches(msg, keys.PrevField):
+				return m, m.focusPasswordInput(inputs[(position+len(inputs)-1)%len(inputs)])
+
+			case key.Matches(msg, keys.Confirm):
+				// Move through the fields before confirming
+				if position < len(inputs)-1 {
+					return m, m.focusPasswordInput(inputs[position+1])
+				}
+
+				options := m.PDFOptions
+				if options.Encryption.Enabled {
+					userPassword := m.PasswordInputs[passwordUser].Value()
+					if userPassword != m.PasswordInputs[passwordRepeat].Value() {
+						m.PasswordError = "The passwords do not match"
+						m.PasswordInputs[passwordRepeat].SetValue("")
+						return m, m.focusPasswordInput(passwordRepeat)
+					}
+					options.Encryption.UserPassword = userPassword
+					options.Encryption.OwnerPassword = m.PasswordInputs[passwordOwner].Value()
+				}
+				if options.Signing.Enabled && options.Signing.Password == "" {
+					options.Signing.Password = m.PasswordInputs[passwordCertificate].Value()
+				}
+
+				// Don't keep the passwords around once the PDF is being generated
+				for i := range m.PasswordInputs {
+					m.PasswordInputs[i].SetValue("")
+				}
+				m.PasswordError = ""
+
+				return m, m.generatePDF(options)
+
+			case key.Matches(msg, keys.BackFromInput):
+				// The passwords stay entered for when the review is confirmed again
+				return m, m.back()
+			}
+
+			var cmd tea.Cmd
+			m.PasswordInputs[m.PasswordFocus], cmd = m.PasswordInputs[m.PasswordFocus].Update(msg)
+			return m, cmd
 		}
 
 	case StateGeneratingPDF:
@@ -316,22 +841,407 @@ func (m Model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 		case PDFGeneratedMsg:
 			if msg.Result.Success {
 				m.GeneratedPDF = msg.Result.OutputPDF
+				m.GeneratedPDFSize = msg.Result.FileSize
+				m.PageEncodings = msg.Result.Pages
+				m.Photos = msg.Result.Photos
+
+				// Offer to email the document first
+				if m.Email != nil && len(m.RecipientList.Items()) > 0 {
+					m.EmailWarning = ""
+					if err := m.Email.CheckSize(m.GeneratedPDF); err != nil {
+						m.EmailWarning = err.Error()
+					}
+					m.State = StateSelectingRecipients
+					return m, nil
+				}
+				return m.runPDFHooks()
 			} else {
 				m.ScanError = msg.Result.Error
 			}
 			// Move to completion state
-			m.State = StateScanComplete
+			m.complete()
 			return m, nil
+		}
 
+	case StateSelectingRecipients:
+		switch msg := msg.(type) {
 		case tea.KeyMsg:
-			if msg.Type == tea.KeyCtrlC || msg.Type == tea.KeyEsc {
-				return m, tea.Quit
+			switch {
+			case key.Matches(msg, keys.Toggle):
+				// Toggle the selected contact
+				index := m.RecipientList.Index()
+				if item, ok := m.RecipientList.SelectedItem().(ContactItem); ok && m.EmailWarning == "" {
+					item.Selected = !item.Selected
+					return m, m.RecipientList.SetItem(index, item)
+				}
+				return m, nil
+
+			case key.Matches(msg, keys.Confirm):
+				// Continue with the hooks, the email is sent with the other deliveries
+				return m.runPDFHooks()
 			}
 		}
 
+		var cmd tea.Cmd
+		m.RecipientList, cmd = m.RecipientList.Update(msg)
+		return m, cmd
+
+	case StateRunningHooks:
+		switch msg := msg.(type) {
+		case spinner.TickMsg:
+			var cmd tea.Cmd
+			m.Spinner, cmd = m.Spinner.Update(msg)
+			return m, cmd
+		}
+
 	case StateScanComplete:
-		return m, tea.Quit
+		// Stay open for background deliveries until a key is pressed
+		if msg, ok := msg.(tea.KeyMsg); ok {
+			if key.Matches(msg, keys.NextDocument) {
+				return m.nextDocument()
+			}
+			return m, tea.Quit
+		}
+	}
+
+	return m, nil
+}
+
+// useSaveFolder saves the scans to the folder, remembers it for the next
+// sessions and goes on to the page count
+func (m *Model) useSaveFolder(folder string) tea.Cmd {
+	m.SaveFolder = folder
+	err := m.ConfigManager.SaveConfig(config.Config{
+		ScannerDevice: m.SelectedDevice,
+		ScannerTitle:  m.SelectedTitle,
+		SaveFolder:    m.SaveFolder,
+	})
+	if err != nil {
+		fmt.Printf("Error saving config: %v\n", err)
+	}
+	m.RecentFolders = m.ConfigManager.GetConfig().RecentFolders
+
+	// Move to page count input
+	m.advance(StateEnteringPageCount)
+	return textinput.Blink
+}
+
+// startDocument creates the scan directory of a new document and clears
+// what was left of the previous one
+func (m *Model) startDocument() error {
+	timestamp := time.Now().Format("20060102_150405")
+	m.ScanOutputDir = filepath.Join(m.SaveFolder, "scan_"+timestamp)
+	if err := os.MkdirAll(m.ScanOutputDir, 0755); err != nil {
+		return err
 	}
 
+	m.CurrentPage = 1
+	m.ScannedFiles = []string{}
+	m.RotatedPages = nil
+	m.OrientationErrors = nil
+	m.Pages = nil
+	m.ScanError = nil
+	m.GeneratedPDF = ""
+	m.GeneratedPDFSize = 0
+	m.PageEncodings = nil
+	m.Photos = nil
+	m.HookResults = nil
+	m.DeliveryJobs = nil
+	m.DeliveryError = nil
+	return nil
+}
+
+// nextDocument starts another document with the same scanner and settings.
+// Esc still goes back through the settings to change them.
+func (m Model) nextDocument() (tea.Model, tea.Cmd) {
+	if err := m.startDocument(); err != nil {
+		fmt.Printf("Error creating directory: %v\n", err)
+		return m, tea.Quit
+	}
+	m.State = StateWaitingForPageScan
+	m.History = []int{StateSelectingScanner, StateEnteringSaveFolder, StateEnteringPageCount, StateSelectingDuplexMode}
 	return m, nil
 }
+
+// complete shows the last screen of the document and adds it to the
+// documents of the session
+func (m *Model) complete() {
+	m.State = StateScanComplete
+	m.Documents = append(m.Documents, SessionDocument{
+		Dir:    m.ScanOutputDir,
+		PDF:    m.GeneratedPDF,
+		Size:   m.GeneratedPDFSize,
+		Pages:  len(m.ScannedFiles),
+		Photos: len(m.Photos),
+		Jobs:   m.DeliveryJobs,
+		Error:  m.ScanError,
+	})
+}
+
+// generatePDF moves to PDF generation, which starts once the page hooks
+// still running are done. The pages are gone once it is done.
+func (m *Model) generatePDF(options scanner.PDFOptions) tea.Cmd {
+	m.State = StateGeneratingPDF
+	m.History = nil
+	if m.PageHooks > 0 {
+		m.PendingPDF = &options
+		return m.Spinner.Tick
+	}
+	return tea.Batch(
+		m.Spinner.Tick,
+		GeneratePDFCmd(m.ScanOutputDir, options),
+	)
+}
+
+// runPDFHooks runs the PDF hooks, or goes on with the deliveries when there
+// are none
+func (m Model) runPDFHooks() (tea.Model, tea.Cmd) {
+	if len(m.Hooks.AfterPDF) == 0 {
+		return m.deliverDocument()
+	}
+
+	m.State = StateRunningHooks
+	return m, tea.Batch(
+		m.Spinner.Tick,
+		RunHooksCmd(m.Hooks, m.hookPayload(hooks.EventPDF, m.GeneratedPDF, 0, len(m.PageEncodings))),
+	)
+}
+
+// selectedRecipients returns the addresses of the contacts chosen in the
+// recipient list
+func (m Model) selectedRecipients() []string {
+	var recipients []string
+	for _, item := range m.RecipientList.Items() {
+		if contact, ok := item.(ContactItem); ok && contact.Selected {
+			recipients = append(recipients, contact.Address())
+		}
+	}
+	return recipients
+}
+
+// deliveryTargets returns the targets of the document, including the email
+// to the chosen recipients
+func (m Model) deliveryTargets() []delivery.Target {
+	targets := append([]delivery.Target(nil), m.Deliveries...)
+	if recipients := m.selectedRecipients(); m.Email != nil && len(recipients) > 0 {
+		email := *m.Email
+		email.To = recipients
+		targets = append(targets, &email)
+	}
+	return targets
+}
+
+// deliverDocument queues the generated PDF for its delivery targets and
+// completes the scan. Deliveries are sent in the background and stay queued
+// until they succeed, so a slow or unreachable server never blocks the
+// session.
+func (m Model) deliverDocument() (tea.Model, tea.Cmd) {
+	m.DeliveryJobs = nil
+	m.DeliveryError = nil
+	// Results of the earlier documents of the session are kept for its summary
+	if m.DeliveryResults == nil {
+		m.DeliveryResults = make(map[string]delivery.Result)
+	}
+
+	doc := delivery.Document{
+		Path:      m.GeneratedPDF,
+		Title:     filepath.Base(m.ScanOutputDir),
+		Tags:      m.Tags,
+		Profile:   m.ProfileName,
+		PageCount: len(m.PageEncodings),
+		Created:   time.Now(),
+	}
+	for _, target := range m.deliveryTargets() {
+		job, err := m.Queue.Enqueue(target, doc, m.ProfileName)
+		if err != nil {
+			m.DeliveryError = err
+			continue
+		}
+		m.DeliveryJobs = append(m.DeliveryJobs, job)
+		m.DeliveryResults[job.ID] = delivery.Result{}
+	}
+	m.complete()
+
+	if len(m.DeliveryJobs) == 0 {
+		return m, nil
+	}
+	return m, ProcessQueueCmd(m.Queue, m.ConfigManager, m.DeliveryJobs)
+}
+
+// pendingDeliveryJobs returns the session's deliveries still in the queue
+// and waiting for a retry
+func (m Model) pendingDeliveryJobs() []delivery.Job {
+	queued, err := m.Queue.List()
+	if err != nil {
+		return nil
+	}
+
+	var pending []delivery.Job
+	for _, job := range queued {
+		if _, ok := m.DeliveryResults[job.ID]; ok && !job.Failed {
+			pending = append(pending, job)
+		}
+	}
+	return pending
+}
+
+// scheduleDeliveryRetry waits for the next retry of the session's failed
+// deliveries while the program is open
+func (m Model) scheduleDeliveryRetry() tea.Cmd {
+	var next time.Time
+	for _, job := range m.pendingDeliveryJobs() {
+		if job.Attempts > 0 && (next.IsZero() || job.NextAttempt.Before(next)) {
+			next = job.NextAttempt
+		}
+	}
+	if next.IsZero() {
+		return nil
+	}
+
+	return tea.Tick(time.Until(next), func(time.Time) tea.Msg {
+		return RetryDeliveriesMsg{}
+	})
+}
+
+// hookPayload describes an event of the current document to hooks
+func (m Model) hookPayload(event, output string, page, pageCount int) hooks.Payload {
+	return hooks.Payload{
+		Event:     event,
+		Output:    output,
+		Page:      page,
+		PageCount: pageCount,
+		Title:     filepath.Base(m.ScanOutputDir),
+		Tags:      m.Tags,
+		Profile:   m.ProfileName,
+	}
+}
+
+// previewFile returns the page image previewed in the current state: the
+// last page scanned while waiting for the next one, the selected page while
+// reviewing
+func (m Model) previewFile() string {
+	switch m.State {
+	case StateWaitingForPageScan:
+		if len(m.Pages) > 0 {
+			return m.Pages[len(m.Pages)-1].File
+		}
+	case StateReviewingPages:
+		if page, ok := m.PageList.SelectedItem().(PageItem); ok {
+			return page.File
+		}
+	}
+	return ""
+}
+
+// previewCmd returns a command rendering the preview of a page image, or
+// nil when it is already rendered or previews are off
+func (m Model) previewCmd(file string) tea.Cmd {
+	if file == "" || m.Graphics == GraphicsOff {
+		return nil
+	}
+	if _, ok := m.Previews[file]; ok {
+		return nil
+	}
+	return PreviewCmd(file, m.Graphics)
+}
+
+// advance moves on to the next step, which Esc goes back from
+func (m *Model) advance(state int) {
+	m.History = append(m.History, m.State)
+	m.State = state
+}
+
+// back returns to the previous step, where what was entered is kept
+func (m *Model) back() tea.Cmd {
+	if len(m.History) == 0 {
+		return nil
+	}
+	m.State = m.History[len(m.History)-1]
+	m.History = m.History[:len(m.History)-1]
+
+	switch m.State {
+	case StateSelectingScanner:
+		// Sessions started with the saved scanner haven't listed the others
+		if len(m.Devices) == 0 {
+			m.State = StateListingScanners
+			m.Discovering = m.ConfigManager.GetConfig().Discovery
+			return tea.Batch(m.Spinner.Tick, ListScannersCmd(m.Network), DiscoverScannersCmd(m.Network, m.Discovering))
+		}
+	case StateEnteringSaveFolder, StateEnteringPageCount:
+		return textinput.Blink
+	case StateReviewingPages:
+		return m.previewCmd(m.previewFile())
+	}
+	return nil
+}
+
+// checkScanner returns a command looking for the selected scanner after the
+// delay, superseding the checks issued before
+func (m *Model) checkScanner(delay time.Duration) tea.Cmd {
+	m.ScannerCheck++
+	return CheckScannerCmd(m.Network, m.SelectedDevice, m.SelectedTitle, m.ScannerCheck, delay)
+}
+
+// scannerChecked goes on with the session once the scanner is known to be
+// connected, or waits for it to come back. The pages scanned so far are kept
+// and the page being scanned is scanned again.
+func (m Model) scannerChecked(msg ScannerCheckedMsg) (tea.Model, tea.Cmd) {
+	if !msg.Available {
+		var cmds []tea.Cmd
+		if m.State != StateWaitingForScanner {
+			m.ResumeState = m.State
+			m.State = StateWaitingForScanner
+			cmds = append(cmds, m.Spinner.Tick)
+		}
+		cmds = append(cmds, m.checkScanner(scannerPollInterval))
+		return m, tea.Batch(cmds...)
+	}
+
+	// USB scanners get a new device identifier when plugged in again
+	m.SelectedDevice = msg.Device
+	var caps tea.Cmd
+	if m.State == StateWaitingForScanner {
+		m.State = m.ResumeState
+		m.ScanError = nil
+		// Network scanners could not be asked for their settings meanwhile
+		if m.Capabilities == nil {
+			caps = CapabilitiesCmd(m.Network, m.SelectedDevice)
+		}
+	}
+	if m.State != StateScanningPage {
+		return m, caps
+	}
+
+	// The scanner is there, so a failed scan had another cause
+	if m.ScanError != nil {
+		m.complete()
+		return m, nil
+	}
+	outputFile := filepath.Join(m.ScanOutputDir, fmt.Sprintf("page_%03d.png", m.CurrentPage))
+	return m, tea.Batch(
+		m.Spinner.Tick,
+		caps,
+		ScanPageCmd(m.Network, m.SelectedDevice, outputFile, m.IsDuplex, m.CurrentPage, m.AutoRotate, m.ScanArea),
+	)
+}
+
+// addScanners adds scanners to the scanner list, skipping those already in it
+func (m *Model) addScanners(scanners ...scanner.Scanner) {
+	for _, s := range scanners {
+		if slices.Contains(m.Devices, s.Device) {
+			continue
+		}
+		m.Devices = append(m.Devices, s.Device)
+		m.Titles = append(m.Titles, s.Title)
+		m.List.InsertItem(len(m.List.Items()), ScanItem{Device: s.Device, Title: s.Title})
+	}
+}
+
+// focusPasswordInput moves the focus to the password input with the given index
+func (m *Model) focusPasswordInput(index int) tea.Cmd {
+	for i := range m.PasswordInputs {
+		m.PasswordInputs[i].Blur()
+	}
+	m.PasswordFocus = index
+	return m.PasswordInputs[index].Focus()
+}
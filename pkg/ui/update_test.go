@@ -0,0 +1,95 @@
+package ui
+
+import (
+	"errors"
+	"image"
+	"image/color"
+	"image/png"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"scanexpress/pkg/hooks"
+	"scanexpress/pkg/scanner"
+)
+
+// writePage writes a scanned page image to the directory
+func writePage(t *testing.T, dir string) string {
+	t.Helper()
+	img := image.NewGray(image.Rect(0, 0, 32, 24))
+	for x := 0; x < 32; x++ {
+		img.SetGray(x, 12, color.Gray{Y: uint8(x * 8)})
+	}
+	path := filepath.Join(dir, "page_1.png")
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if err := png.Encode(f, img); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestPDFWaitsForPageHooks(t *testing.T) {
+	dir := t.TempDir()
+	page := writePage(t, dir)
+	m := Model{
+		ScanOutputDir: dir,
+		Hooks: hooks.Config{
+			AfterPage: []string{`cp "$SCANEXPRESS_OUTPUT" kept.png`},
+			AfterPDF:  []string{"true"},
+		},
+	}
+
+	// The page hook is still running when the review is confirmed
+	m.PageHooks++
+	pageHooks := RunHooksCmd(m.Hooks, m.hookPayload(hooks.EventPage, page, 1, 1))
+	// PDF/A documents are written by the built-in writer, img2pdf isn't needed
+	m.generatePDF(scanner.PDFOptions{PDFA: true})
+	if m.State != StateGeneratingPDF || m.PendingPDF == nil {
+		t.Fatal("PDF generation didn't wait for the page hooks")
+	}
+
+	model, cmd := m.Update(pageHooks())
+	m = model.(Model)
+	if m.PageHooks != 0 || m.PendingPDF != nil || cmd == nil {
+		t.Fatal("PDF generation didn't start once the page hooks finished")
+	}
+	if len(m.HookResults) != 1 || !m.HookResults[0].Success() {
+		t.Fatalf("page hook results %+v", m.HookResults)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "kept.png")); err != nil {
+		t.Errorf("page hook didn't see the page: %v", err)
+	}
+
+	// The PDF hooks come after the document is generated
+	generated, ok := cmd().(PDFGeneratedMsg)
+	if !ok || !generated.Result.Success {
+		t.Fatalf("PDF generation failed: %+v", generated.Result)
+	}
+	model, _ = m.Update(generated)
+	if m = model.(Model); m.State != StateRunningHooks {
+		t.Errorf("state %v after the PDF, want the PDF hooks running", m.State)
+	}
+}
+
+func TestOrientationFailuresAreShown(t *testing.T) {
+	dir := t.TempDir()
+	page := writePage(t, dir)
+	m := Model{State: StateScanningPage, ScanOutputDir: dir, CurrentPage: 1, PageCount: 2}
+
+	model, _ := m.Update(PageScannedMsg{
+		Result:       scanner.PageScanResult{Success: true, FilePaths: []string{page}},
+		Orientations: []scanner.OrientationResult{{File: page, Error: errors.New("unsupported image")}},
+	})
+	m = model.(Model)
+	if len(m.OrientationErrors) != 1 || len(m.RotatedPages) != 0 {
+		t.Fatalf("orientation errors %v, rotated %v", m.OrientationErrors, m.RotatedPages)
+	}
+	if view := m.rotatedPagesView(); !strings.Contains(view, "page_1: unsupported image") {
+		t.Errorf("failure missing from %q", view)
+	}
+}
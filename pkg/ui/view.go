@@ -2,10 +2,36 @@ package ui
 
 import (
 	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/charmbracelet/lipgloss"
+
+	"scanexpress/pkg/delivery"
+	"scanexpress/pkg/hooks"
+)
+
+// Styles of the screen layout
+var (
+	headerStyle     = lipgloss.NewStyle().Bold(true).Foreground(lipgloss.Color("170"))
+	headerInfoStyle = lipgloss.NewStyle().Foreground(lipgloss.Color("245"))
+	statusStyle     = lipgloss.NewStyle().Foreground(lipgloss.Color("252")).Background(lipgloss.Color("236"))
 )
 
-// View renders the current UI state
+// View renders the current UI state: a header naming the scanner, profile
+// and folder, the screen of the state, a status bar and the keys of the screen
 func (m Model) View() string {
+	view := m.layout(m.stateView())
+	// Kitty images stay on screen until they are removed
+	if m.Graphics == GraphicsKitty && !m.showsImage() {
+		return kittyDelete + view
+	}
+	return view
+}
+
+// stateView renders the screen of the current state
+func (m Model) stateView() string {
 	switch m.State {
 	case StateListingScanners:
 		return fmt.Sprintf("%s Looking for scanners...", m.Spinner.View())
@@ -14,16 +40,30 @@ func (m Model) View() string {
 		return m.List.View()
 
 	case StateEnteringSaveFolder:
+		errorMessage := ""
+		if m.FolderError != "" {
+			errorMessage = "\n\n" + m.FolderError
+		}
 		return fmt.Sprintf(
-			"Selected Scanner: %s\n\nSave scans to:\n\n%s\n\n(Press Enter to confirm, edit path to change)",
-			m.SelectedTitle,
+			"Save scans to:\n\n%s%s%s",
 			m.FolderInput.View(),
+			errorMessage,
+			m.recentFoldersView(),
+		)
+
+	case StateBrowsingFolders:
+		return fmt.Sprintf(
+			"Save scans to: %s\n\n%s",
+			m.FolderPicker.CurrentDirectory,
+			m.FolderPicker.View(),
 		)
 
+	case StateCreatingFolder:
+		return fmt.Sprintf("The folder %s doesn't exist yet.\n\nCreate it?", m.NewFolder)
+
 	case StateEnteringPageCount:
 		return fmt.Sprintf(
-			"Selected Scanner: %s\n\nHow many pages to scan?\n\n%s\n\n(Press Enter to confirm)",
-			m.SelectedTitle,
+			"How many pages to scan?\n\n%s",
 			m.PageCountInput.View(),
 		)
 
@@ -32,18 +72,42 @@ func (m Model) View() string {
 		if m.IsDuplex {
 			duplex = "Yes"
 		}
+		if m.Capabilities != nil && !m.Capabilities.Duplex {
+			duplex += " (this scanner can't scan both sides)"
+		}
 		return fmt.Sprintf(
-			"Selected Scanner: %s\n\nNumber of pages: %d\n\nIs this a double-sided (recto-verso) document? %s\n\n(Press y/n to select, Enter to confirm)",
-			m.SelectedTitle,
+			"Number of pages: %d\n\nIs this a double-sided (recto-verso) document? %s",
 			m.PageCount,
 			duplex,
 		)
 
 	case StateWaitingForPageScan:
+		preview := ""
+		if p := m.Previews[m.previewFile()]; p != "" {
+			preview = "\n\nLast scanned page:\n\n" + p
+		}
 		return fmt.Sprintf(
-			"Ready to scan page %d of %d\n\nPlace the document in the scanner and press Enter when ready.",
+			"Ready to scan page %d of %d\n\nPlace the document in the scanner.%s%s",
 			m.CurrentPage,
 			m.PageCount,
+			m.scanAreaView(),
+			preview,
+		)
+
+	case StatePreviewingFlatbed:
+		return fmt.Sprintf("%s Previewing the flatbed...", m.Spinner.View())
+
+	case StateSelectingArea:
+		preview := ""
+		if m.AreaView != "" {
+			preview = "\n\n" + m.AreaView
+		}
+		return fmt.Sprintf(
+			"Scan area: %s (glass %.0f × %.0f mm)%s",
+			m.EditedArea,
+			m.FlatbedSize.Width,
+			m.FlatbedSize.Height,
+			preview,
 		)
 
 	case StateScanningPage:
@@ -54,36 +118,387 @@ func (m Model) View() string {
 			m.PageCount,
 		)
 
+	case StateWaitingForScanner:
+		kept := ""
+		if len(m.ScannedFiles) > 0 {
+			kept = fmt.Sprintf("\n\nThe %d pages scanned so far are kept.", len(m.ScannedFiles))
+		}
+		return fmt.Sprintf(
+			"%s Waiting for %s...\n\nThe scanner is not connected or is asleep. Plug it in or wake it up and the session resumes automatically.%s",
+			m.Spinner.View(),
+			m.SelectedTitle,
+			kept,
+		)
+
+	case StateReviewingPages:
+		pages := m.PageList.View()
+		if p := m.Previews[m.previewFile()]; p != "" {
+			pages = lipgloss.JoinHorizontal(lipgloss.Top, pages, "  ", p)
+		}
+		return pages
+
+	case StateEnteringPassword:
+		errorMessage := ""
+		if m.PasswordError != "" {
+			errorMessage = "\n\n" + m.PasswordError
+		}
+		sections := []string{}
+		if m.PDFOptions.Encryption.Enabled {
+			sections = append(sections, fmt.Sprintf(
+				"The document will be encrypted with AES-256.\n\nUser password:\n%s\n%s\n\nOwner password:\n%s",
+				m.PasswordInputs[passwordUser].View(),
+				m.PasswordInputs[passwordRepeat].View(),
+				m.PasswordInputs[passwordOwner].View(),
+			))
+		}
+		if m.PDFOptions.Signing.Enabled && m.PDFOptions.Signing.Password == "" {
+			sections = append(sections, fmt.Sprintf(
+				"The document will be signed with %s.\n\nCertificate password:\n%s",
+				filepath.Base(m.PDFOptions.Signing.Certificate),
+				m.PasswordInputs[passwordCertificate].View(),
+			))
+		}
+		return fmt.Sprintf(
+			"%s%s",
+			strings.Join(sections, "\n\n"),
+			errorMessage,
+		)
+
 	case StateGeneratingPDF:
+		if m.PendingPDF != nil {
+			return fmt.Sprintf(
+				"%s Waiting for the hooks of %d pages to finish...",
+				m.Spinner.View(),
+				m.PageHooks,
+			)
+		}
 		return fmt.Sprintf(
 			"%s Creating PDF document from %d scanned pages...",
 			m.Spinner.View(),
 			len(m.ScannedFiles),
 		)
 
+	case StateRunningHooks:
+		return fmt.Sprintf(
+			"%s Running post-scan hooks for %s...",
+			m.Spinner.View(),
+			filepath.Base(m.GeneratedPDF),
+		)
+
+	case StateSelectingRecipients:
+		if m.EmailWarning != "" {
+			return fmt.Sprintf(
+				"%s\n\n%s, it can't be emailed.",
+				m.RecipientList.View(),
+				m.EmailWarning,
+			)
+		}
+		return m.RecipientList.View()
+
 	case StateScanComplete:
 		if m.ScanError != nil {
 			return fmt.Sprintf(
-				"Scanning failed at page %d of %d\nError: %v\n\nScanned %d pages successfully.\nFiles are located at: %s\n\nPress Enter to exit.",
+				"Scanning failed at page %d of %d\nError: %v\n\nScanned %d pages successfully.\nFiles are located at: %s%s",
 				m.CurrentPage,
 				m.PageCount,
 				m.ScanError,
 				len(m.ScannedFiles),
 				m.ScanOutputDir,
+				m.documentsView(),
 			)
 		}
 
 		pdfMessage := ""
 		if m.GeneratedPDF != "" {
-			pdfMessage = fmt.Sprintf("\n\nA PDF document was created at: %s", m.GeneratedPDF)
+			kind := "PDF document"
+			switch {
+			case m.PDFOptions.PDFA:
+				kind = "PDF/A-2b document"
+			case m.PDFOptions.Encryption.Enabled:
+				kind = "encrypted (AES-256) PDF document"
+			}
+			if m.PDFOptions.Signing.Enabled {
+				kind = "signed " + kind
+			}
+			pdfMessage = fmt.Sprintf("\n\nA %s was created at: %s (%s)", kind, m.GeneratedPDF, formatFileSize(m.GeneratedPDFSize))
 		}
 
 		return fmt.Sprintf(
-			"Scan completed successfully!\nScanned %d pages.%s\n\nPress Enter to exit.",
+			"Scan completed successfully!\nScanned %d pages.%s%s%s%s%s%s%s",
 			m.PageCount,
 			pdfMessage,
+			m.photosView(),
+			m.rotatedPagesView(),
+			m.pageEncodingsView(),
+			m.hookResultsView(),
+			m.deliveryResultsView(),
+			m.documentsView(),
 		)
 	}
 
 	return ""
 }
+
+// showsImage tells whether the current screen draws a page or flatbed preview
+func (m Model) showsImage() bool {
+	return m.previewFile() != "" || (m.State == StateSelectingArea && m.AreaView != "")
+}
+
+// scanAreaView tells where the next page is scanned from
+func (m Model) scanAreaView() string {
+	var b strings.Builder
+	if m.AreaError != "" {
+		fmt.Fprintf(&b, "\n\n%s", m.AreaError)
+	}
+	switch {
+	case m.ScanArea != nil:
+		fmt.Fprintf(&b, "\n\nScanning %s from the flatbed.", *m.ScanArea)
+	case m.Capabilities == nil || m.Capabilities.Flatbed:
+		b.WriteString("\n\nPreview the flatbed to scan only part of it.")
+	}
+	return b.String()
+}
+
+// recentFoldersView lists the save folders used before
+func (m Model) recentFoldersView() string {
+	if len(m.RecentFolders) == 0 {
+		return ""
+	}
+
+	var b strings.Builder
+	b.WriteString("\n\nRecent folders:")
+	for _, folder := range m.RecentFolders {
+		fmt.Fprintf(&b, "\n  %s", folder)
+	}
+	return b.String()
+}
+
+// photosView lists the photos split from the pages
+func (m Model) photosView() string {
+	if !m.PDFOptions.SplitPhotos || m.GeneratedPDF == "" {
+		return ""
+	}
+	if len(m.Photos) == 0 {
+		return "\n\nNo photos were found on the pages."
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "\n\n%d photos were saved in %s:", len(m.Photos), filepath.Dir(m.Photos[0]))
+	for _, photo := range m.Photos {
+		fmt.Fprintf(&b, "\n  %s", filepath.Base(photo))
+	}
+	return b.String()
+}
+
+// rotatedPagesView lists the pages that were turned upright during
+// scanning, and those whose orientation couldn't be corrected
+func (m Model) rotatedPagesView() string {
+	var b strings.Builder
+	if len(m.RotatedPages) > 0 {
+		pages := make([]string, len(m.RotatedPages))
+		for i, r := range m.RotatedPages {
+			pages[i] = fmt.Sprintf("%s (%d°)", r.PageLabel(), r.Rotation)
+		}
+		fmt.Fprintf(&b, "\n\nRotated upright: %s", strings.Join(pages, ", "))
+	}
+
+	if len(m.OrientationErrors) > 0 {
+		b.WriteString("\n\nOrientation not corrected:")
+		for _, r := range m.OrientationErrors {
+			fmt.Fprintf(&b, "\n  ✗ %s: %v", r.PageLabel(), r.Error)
+		}
+	}
+	return b.String()
+}
+
+// pageEncodingsView lists the encoding chosen for each page when compression
+// is enabled or color modes were chosen
+func (m Model) pageEncodingsView() string {
+	if !m.PDFOptions.Compression.Enabled() && len(m.PDFOptions.ChosenModes) == 0 || len(m.PageEncodings) == 0 {
+		return ""
+	}
+
+	var b strings.Builder
+	b.WriteString("\n\nPage encodings:")
+	for _, page := range m.PageEncodings {
+		base := filepath.Base(page.File)
+		fmt.Fprintf(&b, "\n  %s: %s, %s (%s -> %s)",
+			strings.TrimSuffix(base, filepath.Ext(base)),
+			page.ColorMode,
+			page.Encoding,
+			formatFileSize(page.OriginalSize),
+			formatFileSize(page.Size),
+		)
+	}
+	return b.String()
+}
+
+// maxHookOutputLines limits how much of a hook's output is shown
+const maxHookOutputLines = 5
+
+// hookResultsView shows the exit status and the end of the output of each hook
+func (m Model) hookResultsView() string {
+	if len(m.HookResults) == 0 {
+		return ""
+	}
+
+	// Page hooks finish in any order, show them by page and the PDF hooks last
+	results := append([]hooks.Result(nil), m.HookResults...)
+	sort.SliceStable(results, func(i, j int) bool {
+		if (results[i].Event == hooks.EventPDF) != (results[j].Event == hooks.EventPDF) {
+			return results[j].Event == hooks.EventPDF
+		}
+		return results[i].Page < results[j].Page
+	})
+
+	var b strings.Builder
+	b.WriteString("\n\nHooks:")
+	for _, r := range results {
+		mark := "✓"
+		if !r.Success() {
+			mark = "✗"
+		}
+		status := fmt.Sprintf("exit %d", r.ExitCode)
+		if r.Error != nil {
+			status = r.Error.Error()
+		}
+		fmt.Fprintf(&b, "\n  %s %s: %s (%s)", mark, r.Label(), r.Command, status)
+
+		if r.Output != "" {
+			lines := strings.Split(r.Output, "\n")
+			if len(lines) > maxHookOutputLines {
+				lines = append([]string{"..."}, lines[len(lines)-maxHookOutputLines:]...)
+			}
+			for _, line := range lines {
+				fmt.Fprintf(&b, "\n      %s", line)
+			}
+		}
+	}
+	return b.String()
+}
+
+// deliveryResultsView shows where the document was sent and which
+// deliveries are still being sent or queued for a retry
+func (m Model) deliveryResultsView() string {
+	if len(m.DeliveryJobs) == 0 && m.DeliveryError == nil {
+		return ""
+	}
+
+	var b strings.Builder
+	b.WriteString("\n\nDelivery:")
+	queued := false
+	for _, job := range m.DeliveryJobs {
+		r := m.DeliveryResults[job.ID]
+		switch {
+		case r.JobID == "":
+			fmt.Fprintf(&b, "\n  … %s: sending", job.Target)
+		case r.Success():
+			fmt.Fprintf(&b, "\n  ✓ %s", r)
+		case delivery.IsTemporary(r.Error):
+			fmt.Fprintf(&b, "\n  ✗ %s, queued for retry", r)
+			queued = true
+		default:
+			fmt.Fprintf(&b, "\n  ✗ %s", r)
+		}
+	}
+	if m.DeliveryError != nil {
+		fmt.Fprintf(&b, "\n  ✗ %v", m.DeliveryError)
+	}
+	if queued {
+		b.WriteString("\n\nQueued deliveries are retried while this screen is open and on the next run,\nsee `scanexpress queue` to check on them.")
+	}
+	return b.String()
+}
+
+// documentsView lists the documents of the session once there are several
+func (m Model) documentsView() string {
+	if len(m.Documents) < 2 {
+		return ""
+	}
+
+	var b strings.Builder
+	b.WriteString("\n\nThis session:")
+	for i, doc := range m.Documents {
+		fmt.Fprintf(&b, "\n  %d. %s", i+1, m.documentView(doc))
+	}
+	return b.String()
+}
+
+// SessionSummary lists the documents of the session, for when the program
+// exits. It is empty when no document was finished.
+func (m Model) SessionSummary() string {
+	if len(m.Documents) == 0 {
+		return ""
+	}
+
+	var b strings.Builder
+	queued := 0
+	fmt.Fprintf(&b, "Documents scanned this session: %d\n", len(m.Documents))
+	for i, doc := range m.Documents {
+		fmt.Fprintf(&b, "  %d. %s\n", i+1, m.documentView(doc))
+		_, pending, _ := m.documentDeliveries(doc)
+		queued += pending
+	}
+	if queued > 0 {
+		b.WriteString("Queued deliveries are retried on the next run, see `scanexpress queue` to check on them.\n")
+	}
+	return b.String()
+}
+
+// documentView describes a document of the session on one line
+func (m Model) documentView(doc SessionDocument) string {
+	if doc.Error != nil {
+		return fmt.Sprintf("✗ %s: %d pages scanned, %v", doc.Dir, doc.Pages, doc.Error)
+	}
+
+	line := fmt.Sprintf("✓ %s (%d pages, %s)", doc.PDF, doc.Pages, formatFileSize(doc.Size))
+	if doc.Photos > 0 {
+		line += fmt.Sprintf(", %d photos", doc.Photos)
+	}
+	var deliveries []string
+	sent, pending, failed := m.documentDeliveries(doc)
+	for _, count := range []struct {
+		n    int
+		word string
+	}{{sent, "sent"}, {pending, "pending"}, {failed, "failed"}} {
+		if count.n > 0 {
+			deliveries = append(deliveries, fmt.Sprintf("%d %s", count.n, count.word))
+		}
+	}
+	if len(deliveries) > 0 {
+		line += ", deliveries: " + strings.Join(deliveries, ", ")
+	}
+	return line
+}
+
+// documentDeliveries counts the deliveries of a document that were sent,
+// that are still being sent or queued for a retry, and that failed
+func (m Model) documentDeliveries(doc SessionDocument) (sent, pending, failed int) {
+	for _, job := range doc.Jobs {
+		r := m.DeliveryResults[job.ID]
+		switch {
+		case r.JobID == "" || delivery.IsTemporary(r.Error):
+			pending++
+		case r.Success():
+			sent++
+		default:
+			failed++
+		}
+	}
+	return sent, pending, failed
+}
+
+// formatFileSize formats a size in bytes for display
+func formatFileSize(size int64) string {
+	const unit = 1024
+	if size < unit {
+		return fmt.Sprintf("%d B", size)
+	}
+
+	div, exp := int64(unit), 0
+	for n := size / unit; n >= unit; n /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %cB", float64(size)/float64(div), "KMGTPE"[exp])
+}